@@ -68,7 +68,7 @@ func (daemon *Daemon) Initialise() error {
 func (daemon *Daemon) StartAndBlock() error {
 	daemon.logger.Info("StartAndBlock", "", nil, "going to probe %d URLs", len(daemon.URLAndPassword))
 	for {
-		if misc.EmergencyLockDown {
+		if misc.IsDaemonLockedDown("autounlock") {
 			atomic.StoreInt32(&daemon.loopIsRunning, 0)
 			return misc.ErrEmergencyLockDown
 		}
@@ -97,7 +97,7 @@ func (srv *TCPServer) StartAndBlock() error {
 		return fmt.Errorf("TCPServer.StartAndBlock(%s): failed to listen on port %d - %v", srv.AppName, srv.ListenPort, err)
 	}
 	for {
-		if misc.EmergencyLockDown {
+		if misc.IsDaemonLockedDown(srv.AppName) {
 			srv.logger.Warning("StartAndBlock", srv.AppName, misc.ErrEmergencyLockDown, "")
 			return misc.ErrEmergencyLockDown
 		}
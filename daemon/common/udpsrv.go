@@ -101,7 +101,7 @@ func (srv *UDPServer) StartAndBlock() error {
 	}
 	packet := make([]byte, MaxUDPPacketSize)
 	for {
-		if misc.EmergencyLockDown {
+		if misc.IsDaemonLockedDown(srv.AppName) {
 			srv.logger.Warning("StartAndBlock", srv.AppName, misc.ErrEmergencyLockDown, "")
 			return misc.ErrEmergencyLockDown
 		}
@@ -45,7 +45,7 @@ const (
 )
 
 // fileUploadStorage is the parent directory in which uploaded files are temporarily stored.
-var fileUploadStorage = filepath.Join(os.TempDir(), "laitos-HandleFileUpload")
+var fileUploadStorage = toolbox.FileUploadStorage
 
 // fileUploadCleanUpStartOnce ensures that a background routine that removes expired files periodically is started exactly once.
 var fileUploadCleanUpStartOnce = new(sync.Once)
@@ -121,12 +121,19 @@ func (hand *HandleAppCommand) Handle(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		return
 	}
+	// The client may ask for a machine-readable result
+	cmd, wantEnvelope := toolbox.FindAndRemoveEnvelopePrefix(cmd)
 	result := hand.cmdProc.Process(r.Context(), toolbox.Command{
 		DaemonName: "httpd",
 		ClientTag:  GetRealClientIP(r),
 		Content:    cmd,
 		TimeoutSec: HTTPClienAppCommandTimeout,
 	}, true)
+	if wantEnvelope {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(result.EnvelopeJSON()))
+		return
+	}
 	_, _ = w.Write([]byte(result.CombinedOutput))
 }
 
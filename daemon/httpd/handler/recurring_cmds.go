@@ -11,6 +11,9 @@ import (
 	"github.com/HouzuoGuo/laitos/toolbox"
 )
 
+// ScheduleResultSinkRecurringCommands is the sink name used by scheduled app commands to deliver results to a recurring commands channel.
+const ScheduleResultSinkRecurringCommands = "recurring"
+
 const HandleRecurringCommandsSetupPage = `<html>
 <head>
     <title>Recurring commands setup</title>
@@ -57,6 +60,15 @@ func (notif *HandleRecurringCommands) Initialise(logger lalog.Logger, cmdProc *t
 		go timer.Start()
 		// Because handlers do not have tear-down function, there is no way to stop them. Consider fixing this in the future?
 	}
+	// Let scheduled app commands deliver their results to a channel, the destination is the channel ID.
+	toolbox.RegisterScheduleResultSink(ScheduleResultSinkRecurringCommands, func(destination, _, text string) error {
+		timer, exists := notif.RecurringCommands[destination]
+		if !exists {
+			return fmt.Errorf("HandleRecurringCommands: channel \"%s\" does not exist", destination)
+		}
+		timer.AddArbitraryTextToResult(text)
+		return nil
+	})
 	notif.stripURLPrefixFromResponse = stripURLPrefixFromResponse
 	return nil
 }
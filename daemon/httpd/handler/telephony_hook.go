@@ -0,0 +1,270 @@
+package handler
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/HouzuoGuo/laitos/lalog"
+	"github.com/HouzuoGuo/laitos/misc"
+	"github.com/HouzuoGuo/laitos/toolbox"
+)
+
+// SMSWebhook understands the incoming SMS webhook of a telephony provider, and responds in the provider's format.
+type SMSWebhook interface {
+	// ReadSMS returns the sender's telephone number and the text of an incoming SMS.
+	ReadSMS(r *http.Request) (fromNumber, text string, err error)
+	// Reply responds to the incoming SMS with the text.
+	Reply(w http.ResponseWriter, fromNumber, text string)
+	// Refuse tells the provider that there will not be a reply to the incoming SMS.
+	Refuse(w http.ResponseWriter, reason string)
+}
+
+// CallWebhook understands the incoming voice call webhook of a telephony provider, and responds in the provider's format.
+type CallWebhook interface {
+	// ReadCaller returns the caller's telephone number.
+	ReadCaller(r *http.Request) string
+	// ReadDTMF returns the digits entered by the caller.
+	ReadDTMF(r *http.Request) string
+	// Gather speaks to the caller and then directs the caller's DTMF input to the action URL.
+	Gather(w http.ResponseWriter, actionURL, speech string, timeoutSec int)
+	// Reject rejects the call without picking it up.
+	Reject(w http.ResponseWriter)
+	// Hangup speaks to the caller and then hangs up.
+	Hangup(w http.ResponseWriter, speech string)
+}
+
+// TwilioWebhook understands the form-encoded SMS and call webhooks of Twilio, and responds in TwiML.
+type TwilioWebhook struct {
+}
+
+func (*TwilioWebhook) ReadSMS(r *http.Request) (fromNumber, text string, err error) {
+	// SMS message is in "Body" parameter
+	return r.FormValue("From"), r.FormValue("Body"), nil
+}
+
+func (*TwilioWebhook) Reply(w http.ResponseWriter, _, text string) {
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	_, _ = w.Write([]byte(fmt.Sprintf(xml.Header+`
+<Response><Message>%s</Message></Response>
+`, XMLEscape(text))))
+}
+
+func (*TwilioWebhook) Refuse(w http.ResponseWriter, reason string) {
+	/*
+		Twilio does not have a reject feature for incoming SMS. Use a non-2xx HTTP status code to inform Twilio
+		that an SMS reply isn't available. Twilio operator can inspect these failures from the Twilio console.
+	*/
+	http.Error(w, reason, http.StatusServiceUnavailable)
+}
+
+func (*TwilioWebhook) ReadCaller(r *http.Request) string {
+	return r.FormValue("From")
+}
+
+func (*TwilioWebhook) ReadDTMF(r *http.Request) string {
+	// DTMF input digits are in "Digits" parameter
+	return r.FormValue("Digits")
+}
+
+func (*TwilioWebhook) Gather(w http.ResponseWriter, actionURL, speech string, timeoutSec int) {
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	_, _ = w.Write([]byte(fmt.Sprintf(xml.Header+`
+<Response>
+    <Gather action="%s" method="POST" timeout="%d" finishOnKey="#" numDigits="1000">
+        <Say>%s</Say>
+    </Gather>
+</Response>
+`, actionURL, timeoutSec, XMLEscape(speech))))
+}
+
+func (*TwilioWebhook) Reject(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	_, _ = w.Write([]byte(xml.Header + `<Response><Reject/></Response>`))
+}
+
+func (*TwilioWebhook) Hangup(w http.ResponseWriter, speech string) {
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	_, _ = w.Write([]byte(fmt.Sprintf(xml.Header+`<Response><Say>%s</Say><Hangup/></Response>`, XMLEscape(speech))))
+}
+
+/*
+HTTPGatewayWebhook understands the incoming SMS webhook of an HTTP gateway, such as the self-hosted SMS gateway apps
+running on an Android phone. The webhook carries the sender's telephone number and the text in a JSON object or a
+form. The gateway does not expect a reply in the response, hence the reply is sent via the telephony provider.
+*/
+type HTTPGatewayWebhook struct {
+	NumberKey  string                    // NumberKey is the key of sender's telephone number, e.g. "payload.phoneNumber" for a nested JSON object.
+	MessageKey string                    // MessageKey is the key of the SMS text, e.g. "payload.message" for a nested JSON object.
+	Provider   toolbox.TelephonyProvider // Provider sends the reply SMS.
+	Logger     lalog.Logger
+}
+
+// getJSONValue returns the string value at the dot-separated path of keys in the JSON object.
+func getJSONValue(obj map[string]interface{}, path string) string {
+	keys := strings.Split(path, ".")
+	for i, key := range keys {
+		val, found := obj[key]
+		if !found {
+			return ""
+		}
+		if i == len(keys)-1 {
+			if str, isStr := val.(string); isStr {
+				return str
+			}
+			return ""
+		}
+		if obj, found = val.(map[string]interface{}); !found {
+			return ""
+		}
+	}
+	return ""
+}
+
+func (hook *HTTPGatewayWebhook) ReadSMS(r *http.Request) (fromNumber, text string, err error) {
+	if !strings.Contains(r.Header.Get("Content-Type"), "json") {
+		return r.FormValue(hook.NumberKey), r.FormValue(hook.MessageKey), nil
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return "", "", err
+	}
+	var obj map[string]interface{}
+	if err := json.Unmarshal(body, &obj); err != nil {
+		return "", "", err
+	}
+	return getJSONValue(obj, hook.NumberKey), getJSONValue(obj, hook.MessageKey), nil
+}
+
+func (hook *HTTPGatewayWebhook) Reply(w http.ResponseWriter, fromNumber, text string) {
+	// Send the reply in the background, the gateway may not wait for long.
+	go func() {
+		// The request context ends with the response, hence the reply uses its own deadline.
+		ctx, cancel := context.WithTimeout(context.Background(), TwilioHandlerTimeoutSec*time.Second)
+		defer cancel()
+		if err := hook.Provider.SendSMS(ctx, TwilioHandlerTimeoutSec, fromNumber, toolbox.TruncateSMS(text)); err != nil {
+			hook.Logger.Warning("HTTPGatewayWebhook.Reply", fromNumber, err, "failed to send reply SMS")
+		}
+	}()
+	w.WriteHeader(http.StatusOK)
+}
+
+func (hook *HTTPGatewayWebhook) Refuse(w http.ResponseWriter, reason string) {
+	// Respond with OK regardless, as the gateway may otherwise deliver the incoming SMS again.
+	_, _ = w.Write([]byte(reason))
+}
+
+// HandleSMSHook runs app commands from the incoming SMS of a telephony provider, and replies with the command result.
+type HandleSMSHook struct {
+	Webhook SMSWebhook `json:"-"` // Webhook understands the telephony provider's webhook format.
+
+	senderRateLimit *misc.RateLimit // senderRateLimit prevents excessive SMS replies from being replied to spam numbers
+	logger          lalog.Logger
+	cmdProc         *toolbox.CommandProcessor
+}
+
+func (hand *HandleSMSHook) Initialise(logger lalog.Logger, cmdProc *toolbox.CommandProcessor, _ string) error {
+	if hand.Webhook == nil {
+		return errors.New("HandleSMSHook.Initialise: Webhook must not be nil")
+	}
+	hand.logger = logger
+	hand.cmdProc = cmdProc
+	// Allow maximum of 1 SMS to be received every 5 seconds, per phone number.
+	hand.senderRateLimit = &misc.RateLimit{
+		UnitSecs: TwilioPhoneNumberRateLimitIntervalSec,
+		MaxCount: 1,
+		Logger:   logger,
+	}
+	hand.senderRateLimit.Initialise()
+	return nil
+}
+
+func (hand *HandleSMSHook) Handle(w http.ResponseWriter, r *http.Request) {
+	NoCache(w)
+	phoneNumber, text, err := hand.Webhook.ReadSMS(r)
+	if err != nil {
+		http.Error(w, "failed to read SMS - "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Apply rate limit to the sender
+	hand.logger.Info("HandleSMSHook", phoneNumber, nil, "has received an SMS")
+	if phoneNumber != "" {
+		if !hand.senderRateLimit.Add(phoneNumber, true) {
+			hand.Webhook.Refuse(w, "rate limit is exceeded by sender "+phoneNumber)
+			return
+		}
+	}
+	ret := hand.cmdProc.Process(r.Context(), toolbox.Command{
+		DaemonName: "httpd",
+		ClientTag:  phoneNumber,
+		TimeoutSec: TwilioHandlerTimeoutSec,
+		Content:    text,
+	}, true)
+	if ret.CombinedOutput == toolbox.ErrPINAndShortcutNotFound.Error() {
+		hand.Webhook.Refuse(w, toolbox.ErrPINAndShortcutNotFound.Error())
+		return
+	}
+	hand.Webhook.Reply(w, phoneNumber, ret.CombinedOutput)
+}
+
+func (hand *HandleSMSHook) GetRateLimitFactor() int {
+	return TwilioAPIRateLimitFactor
+}
+
+func (_ *HandleSMSHook) SelfTest() error {
+	return nil
+}
+
+/*
+HandleSMSGatewayHook runs app commands from the incoming SMS of an HTTP gateway, such as the self-hosted SMS gateway
+apps running on an Android phone. The command result is replied via the telephony provider of the ".p" app.
+*/
+type HandleSMSGatewayHook struct {
+	NumberKey  string `json:"NumberKey"`  // NumberKey is the key of sender's telephone number, e.g. "payload.phoneNumber" for a nested JSON object.
+	MessageKey string `json:"MessageKey"` // MessageKey is the key of the SMS text, e.g. "payload.message" for a nested JSON object.
+	/*
+		SecretHeaderName and SecretHeaderValue are optional. If they are configured, the webhook must carry the header
+		with the secret value, otherwise the webhook is rejected.
+	*/
+	SecretHeaderName  string `json:"SecretHeaderName"`
+	SecretHeaderValue string `json:"SecretHeaderValue"`
+
+	HandleSMSHook `json:"-"`
+}
+
+func (hand *HandleSMSGatewayHook) Initialise(logger lalog.Logger, cmdProc *toolbox.CommandProcessor, stripURLPrefixFromResponse string) error {
+	if hand.NumberKey == "" || hand.MessageKey == "" {
+		return errors.New("HandleSMSGatewayHook.Initialise: NumberKey and MessageKey must not be empty")
+	}
+	if (hand.SecretHeaderName == "") != (hand.SecretHeaderValue == "") {
+		return errors.New("HandleSMSGatewayHook.Initialise: SecretHeaderName and SecretHeaderValue must be configured together")
+	}
+	if cmdProc == nil || cmdProc.Features == nil || cmdProc.Features.Twilio.GetProvider() == nil {
+		return errors.New("HandleSMSGatewayHook.Initialise: the app to send SMS must be configured for sending replies")
+	}
+	hand.Webhook = &HTTPGatewayWebhook{
+		NumberKey:  hand.NumberKey,
+		MessageKey: hand.MessageKey,
+		Provider:   cmdProc.Features.Twilio.GetProvider(),
+		Logger:     logger,
+	}
+	return hand.HandleSMSHook.Initialise(logger, cmdProc, stripURLPrefixFromResponse)
+}
+
+func (hand *HandleSMSGatewayHook) Handle(w http.ResponseWriter, r *http.Request) {
+	if hand.SecretHeaderName != "" {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get(hand.SecretHeaderName)), []byte(hand.SecretHeaderValue)) != 1 {
+			hand.logger.Info("HandleSMSGatewayHook", GetRealClientIP(r), nil, "rejected a webhook that does not carry the secret header")
+			http.Error(w, "invalid secret", http.StatusUnauthorized)
+			return
+		}
+	}
+	hand.HandleSMSHook.Handle(w, r)
+}
@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HouzuoGuo/laitos/lalog"
+	"github.com/HouzuoGuo/laitos/toolbox"
+)
+
+func TestGetJSONValue(t *testing.T) {
+	var obj map[string]interface{}
+	if err := json.Unmarshal([]byte(`{"a": "1", "b": {"c": "2", "d": 3}}`), &obj); err != nil {
+		t.Fatal(err)
+	}
+	for path, expected := range map[string]string{"a": "1", "b.c": "2", "b.d": "", "b": "", "a.c": "", "x": "", "": ""} {
+		if val := getJSONValue(obj, path); val != expected {
+			t.Fatal(path, val)
+		}
+	}
+}
+
+func TestHandleSMSGatewayHook(t *testing.T) {
+	// The stand-in gateway receives the reply SMS
+	replies := make(chan map[string]interface{}, 10)
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var obj map[string]interface{}
+		if err := json.Unmarshal(body, &obj); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		replies <- obj
+	}))
+	defer gateway.Close()
+
+	proc := toolbox.GetTestCommandProcessor()
+	hand := &HandleSMSGatewayHook{NumberKey: "payload.phoneNumber", MessageKey: "payload.message"}
+	// The SMS app must be configured for sending replies
+	if err := hand.Initialise(lalog.Logger{}, proc, ""); err == nil {
+		t.Fatal("did not error")
+	}
+	proc.Features.Twilio.HTTPGateway = &toolbox.HTTPGatewayTelephony{SMSURL: gateway.URL}
+	if err := proc.Features.Twilio.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&HandleSMSGatewayHook{}).Initialise(lalog.Logger{}, proc, ""); err == nil {
+		t.Fatal("did not error")
+	}
+	if err := hand.Initialise(lalog.Logger{}, proc, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	post := func(contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/sms-gateway", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		rec := httptest.NewRecorder()
+		hand.Handle(rec, req)
+		return rec
+	}
+	// Malformed JSON
+	if rec := post("application/json", "{"); rec.Code != http.StatusBadRequest {
+		t.Fatal(rec.Code, rec.Body.String())
+	}
+	// Bad password does not get a reply
+	if rec := post("application/json", `{"payload": {"phoneNumber": "+100", "message": "badpin .s echo hi"}}`); rec.Code != http.StatusOK || rec.Body.String() != toolbox.ErrPINAndShortcutNotFound.Error() {
+		t.Fatal(rec.Code, rec.Body.String())
+	}
+	// Run a command from the JSON webhook and receive the reply via the gateway
+	if rec := post("application/json", `{"payload": {"phoneNumber": "+200", "message": "`+toolbox.TestCommandProcessorPIN+` .s echo hi"}}`); rec.Code != http.StatusOK {
+		t.Fatal(rec.Code, rec.Body.String())
+	}
+	select {
+	case reply := <-replies:
+		if reply["to"] != "+200" || reply["message"] != "hi" {
+			t.Fatal(reply)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("did not reply")
+	}
+	// Prevent SMS spam according to the sender's phone number
+	if rec := post("application/json", `{"payload": {"phoneNumber": "+200", "message": "`+toolbox.TestCommandProcessorPIN+` .s echo hi"}}`); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "rate limit is exceeded by") {
+		t.Fatal(rec.Code, rec.Body.String())
+	}
+	// Run a command from the form webhook
+	hand = &HandleSMSGatewayHook{NumberKey: "from", MessageKey: "text"}
+	if err := hand.Initialise(lalog.Logger{}, proc, ""); err != nil {
+		t.Fatal(err)
+	}
+	form := url.Values{"from": {"+300"}, "text": {toolbox.TestCommandProcessorPIN + " .s echo there"}}
+	if rec := post("application/x-www-form-urlencoded", form.Encode()); rec.Code != http.StatusOK {
+		t.Fatal(rec.Code, rec.Body.String())
+	}
+	select {
+	case reply := <-replies:
+		if reply["to"] != "+300" || reply["message"] != "there" {
+			t.Fatal(reply)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("did not reply")
+	}
+	// The secret header name and value must be configured together
+	hand = &HandleSMSGatewayHook{NumberKey: "from", MessageKey: "text", SecretHeaderName: "X-Secret"}
+	if err := hand.Initialise(lalog.Logger{}, proc, ""); err == nil {
+		t.Fatal("did not error")
+	}
+	// The webhook must carry the secret header if it is configured
+	hand = &HandleSMSGatewayHook{NumberKey: "from", MessageKey: "text", SecretHeaderName: "X-Secret", SecretHeaderValue: "sesame"}
+	if err := hand.Initialise(lalog.Logger{}, proc, ""); err != nil {
+		t.Fatal(err)
+	}
+	form = url.Values{"from": {"+400"}, "text": {toolbox.TestCommandProcessorPIN + " .s echo secret"}}
+	if rec := post("application/x-www-form-urlencoded", form.Encode()); rec.Code != http.StatusUnauthorized {
+		t.Fatal(rec.Code, rec.Body.String())
+	}
+	postWithSecret := func(secret string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/sms-gateway", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("X-Secret", secret)
+		rec := httptest.NewRecorder()
+		hand.Handle(rec, req)
+		return rec
+	}
+	if rec := postWithSecret("wrong"); rec.Code != http.StatusUnauthorized {
+		t.Fatal(rec.Code, rec.Body.String())
+	}
+	if rec := postWithSecret("sesame"); rec.Code != http.StatusOK {
+		t.Fatal(rec.Code, rec.Body.String())
+	}
+	select {
+	case reply := <-replies:
+		if reply["to"] != "+400" || reply["message"] != "secret" {
+			t.Fatal(reply)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("did not reply")
+	}
+}
@@ -1,7 +1,6 @@
 package handler
 
 import (
-	"encoding/xml"
 	"errors"
 	"fmt"
 	"net/http"
@@ -41,72 +40,19 @@ const (
 
 // Handle Twilio phone number's SMS hook.
 type HandleTwilioSMSHook struct {
-	senderRateLimit *misc.RateLimit // senderRateLimit prevents excessive SMS replies from being replied to spam numbers
-
-	logger  lalog.Logger
-	cmdProc *toolbox.CommandProcessor
+	HandleSMSHook
 }
 
-func (hand *HandleTwilioSMSHook) Initialise(logger lalog.Logger, cmdProc *toolbox.CommandProcessor, _ string) error {
-	hand.logger = logger
-	hand.cmdProc = cmdProc
-	// Allow maximum of 1 SMS to be received every 5 seconds, per phone number.
-	hand.senderRateLimit = &misc.RateLimit{
-		UnitSecs: TwilioPhoneNumberRateLimitIntervalSec,
-		MaxCount: 1,
-		Logger:   logger,
-	}
-	hand.senderRateLimit.Initialise()
-	return nil
-}
-
-func (hand *HandleTwilioSMSHook) Handle(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
-	NoCache(w)
-	// Apply rate limit to the sender
-	phoneNumber := r.FormValue("From")
-	hand.logger.Info("HandleTwilioSMSHook", phoneNumber, nil, "has received an SMS")
-	if phoneNumber != "" {
-		if !hand.senderRateLimit.Add(phoneNumber, true) {
-			/*
-				Twilio does not have a reject feature for incoming SMS. Use a non-2xx HTTP status code to inform Twilio
-				that an SMS reply isn't available. Twilio operator can inspect these failures from the Twilio console.
-			*/
-			http.Error(w, "rate limit is exceeded by sender "+phoneNumber, http.StatusServiceUnavailable)
-			return
-		}
-	}
-	// SMS message is in "Body" parameter
-	ret := hand.cmdProc.Process(r.Context(), toolbox.Command{
-		DaemonName: "httpd",
-		ClientTag:  phoneNumber,
-		TimeoutSec: TwilioHandlerTimeoutSec,
-		Content:    r.FormValue("Body"),
-	}, true)
-	if ret.CombinedOutput == toolbox.ErrPINAndShortcutNotFound.Error() {
-		/*
-			Twilio does not have a reject feature for incoming SMS. Use a non-2xx HTTP status code to inform Twilio
-			that an SMS reply isn't available. Twilio operator can inspect these failures from the Twilio console.
-		*/
-		http.Error(w, toolbox.ErrPINAndShortcutNotFound.Error(), http.StatusServiceUnavailable)
-		return
-	}
-	// Generate normal XML response
-	_, _ = w.Write([]byte(fmt.Sprintf(xml.Header+`
-<Response><Message>%s</Message></Response>
-`, XMLEscape(ret.CombinedOutput))))
-}
-func (hand *HandleTwilioSMSHook) GetRateLimitFactor() int {
-	return TwilioAPIRateLimitFactor
-}
-func (_ *HandleTwilioSMSHook) SelfTest() error {
-	return nil
+func (hand *HandleTwilioSMSHook) Initialise(logger lalog.Logger, cmdProc *toolbox.CommandProcessor, stripURLPrefixFromResponse string) error {
+	hand.Webhook = &TwilioWebhook{}
+	return hand.HandleSMSHook.Initialise(logger, cmdProc, stripURLPrefixFromResponse)
 }
 
 // Say a greeting in Twilio phone number's telephone call hook.
 type HandleTwilioCallHook struct {
-	CallGreeting     string `json:"CallGreeting"` // a message to speak upon picking up a call
-	CallbackEndpoint string `json:"-"`            // URL (e.g. /handle_my_call) to command handler endpoint (TwilioCallCallback)
+	CallGreeting     string      `json:"CallGreeting"` // a message to speak upon picking up a call
+	CallbackEndpoint string      `json:"-"`            // URL (e.g. /handle_my_call) to command handler endpoint (TwilioCallCallback)
+	Webhook          CallWebhook `json:"-"`            // Webhook understands the telephony provider's webhook format, it defaults to Twilio.
 
 	senderRateLimit            *misc.RateLimit // senderRateLimit prevents excessive calls from being made by spam numbers
 	logger                     lalog.Logger
@@ -118,6 +64,9 @@ func (hand *HandleTwilioCallHook) Initialise(logger lalog.Logger, cmdProc *toolb
 	if hand.CallGreeting == "" || hand.CallbackEndpoint == "" {
 		return errors.New("HandleTwilioCallHook.Initialise: greeting and callback endpoint must not be empty")
 	}
+	if hand.Webhook == nil {
+		hand.Webhook = &TwilioWebhook{}
+	}
 	hand.logger = logger
 	hand.cmdProc = cmdProc
 	// Allows maximum of 1 call to be received every 5 seconds
@@ -132,25 +81,18 @@ func (hand *HandleTwilioCallHook) Initialise(logger lalog.Logger, cmdProc *toolb
 }
 
 func (hand *HandleTwilioCallHook) Handle(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
 	NoCache(w)
 	// Apply rate limit to the caller
-	phoneNumber := r.FormValue("From")
+	phoneNumber := hand.Webhook.ReadCaller(r)
 	hand.logger.Info("HandleTwilioCallHook", phoneNumber, nil, "has received a call")
 	if phoneNumber != "" {
 		if !hand.senderRateLimit.Add(phoneNumber, true) {
-			_, _ = w.Write([]byte(xml.Header + `<Response><Reject/></Response>`))
+			hand.Webhook.Reject(w)
 			return
 		}
 	}
-	// The greeting XML tells Twilio to ask user for DTMF input, and direct the input to another URL endpoint.
-	_, _ = w.Write([]byte(fmt.Sprintf(xml.Header+`
-<Response>
-    <Gather action="%s" method="POST" timeout="60" finishOnKey="#" numDigits="1000">
-        <Say>%s</Say>
-    </Gather>
-</Response>
-`, strings.TrimPrefix(hand.CallbackEndpoint, hand.stripURLPrefixFromResponse), XMLEscape(hand.CallGreeting))))
+	// The greeting asks user for DTMF input, and directs the input to another URL endpoint.
+	hand.Webhook.Gather(w, strings.TrimPrefix(hand.CallbackEndpoint, hand.stripURLPrefixFromResponse), hand.CallGreeting, 60)
 }
 func (hand *HandleTwilioCallHook) GetRateLimitFactor() int {
 	return TwilioAPIRateLimitFactor
@@ -161,7 +103,8 @@ func (_ *HandleTwilioCallHook) SelfTest() error {
 
 // Carry on with command processing in Twilio telephone call conversation.
 type HandleTwilioCallCallback struct {
-	MyEndpoint string `json:"-"` // URL endpoint to the callback itself, including prefix /.
+	MyEndpoint string      `json:"-"` // URL endpoint to the callback itself, including prefix /.
+	Webhook    CallWebhook `json:"-"` // Webhook understands the telephony provider's webhook format, it defaults to Twilio.
 
 	senderRateLimit            *misc.RateLimit // senderRateLimit prevents excessive calls from being made by spam numbers
 	logger                     lalog.Logger
@@ -173,6 +116,9 @@ func (hand *HandleTwilioCallCallback) Initialise(logger lalog.Logger, cmdProc *t
 	if hand.MyEndpoint == "" {
 		return errors.New("HandleTwilioCallCallback.Initialise: MyEndpoint must not be empty")
 	}
+	if hand.Webhook == nil {
+		hand.Webhook = &TwilioWebhook{}
+	}
 	hand.logger = logger
 	hand.cmdProc = cmdProc
 	// Allows maximum of 1 DTMF command to be received every 5 seconds
@@ -187,20 +133,18 @@ func (hand *HandleTwilioCallCallback) Initialise(logger lalog.Logger, cmdProc *t
 }
 
 func (hand *HandleTwilioCallCallback) Handle(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
 	NoCache(w)
 	// Apply rate limit to the caller
-	phoneNumber := r.FormValue("From")
+	phoneNumber := hand.Webhook.ReadCaller(r)
 	hand.logger.Info("HandleTwilioCallCallback", phoneNumber, nil, "has received DTMF command via call")
 	if phoneNumber != "" {
 		if !hand.senderRateLimit.Add(phoneNumber, true) {
-			_, _ = w.Write([]byte(xml.Header + `<Response><Say>You are rate limited.</Say><Hangup/></Response>`))
+			hand.Webhook.Hangup(w, "You are rate limited.")
 			return
 		}
 	}
-	// DTMF input digits are in "Digits" parameter
 	var phoneticSpelling bool
-	dtmfInput := r.FormValue("Digits")
+	dtmfInput := hand.Webhook.ReadDTMF(r)
 	// The magic prefix asks output to be spelt phonetically
 	if strings.HasPrefix(dtmfInput, TwilioPhoneticSpellingMagic) {
 		phoneticSpelling = true
@@ -217,12 +161,8 @@ func (hand *HandleTwilioCallCallback) Handle(w http.ResponseWriter, r *http.Requ
 	if phoneticSpelling {
 		combinedOutput = toolbox.SpellPhonetically(combinedOutput)
 	}
-	combinedOutput = XMLEscape(combinedOutput)
 	// Repeat command output three times and listen for the next input
-	_, _ = w.Write([]byte(fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
-<Response>
-    <Gather action="%s" method="POST" timeout="30" finishOnKey="#" numDigits="1000">
-        <Say>%s.
+	hand.Webhook.Gather(w, strings.TrimPrefix(hand.MyEndpoint, hand.stripURLPrefixFromResponse), fmt.Sprintf(`%s.
 
     repeat again.    
 
@@ -232,10 +172,7 @@ func (hand *HandleTwilioCallCallback) Handle(w http.ResponseWriter, r *http.Requ
 
 %s.
 over.
-</Say>
-    </Gather>
-</Response>
-`, strings.TrimPrefix(hand.MyEndpoint, hand.stripURLPrefixFromResponse), combinedOutput, combinedOutput, combinedOutput)))
+`, combinedOutput, combinedOutput, combinedOutput), 30)
 }
 
 func (hand *HandleTwilioCallCallback) GetRateLimitFactor() int {
@@ -181,7 +181,9 @@ func (daemon *Daemon) Initialise(stripURLPrefixFromRequest string, stripURLPrefi
 		daemon.AllRateLimits[urlLocation] = rl
 		// With the exception of file upload handler, all handlers will be subject to a limited request size.
 		_, unrestrictedRequestSize := hand.(*handler.HandleFileUpload)
-		daemon.mux.Handle(urlLocation, daemon.DecorateWithMiddleware(rl, !unrestrictedRequestSize, hand.Handle))
+		// The app command handler carries the command that lifts emergency lock-down.
+		_, exemptFromFullLockDown := hand.(*handler.HandleAppCommand)
+		daemon.mux.Handle(urlLocation, daemon.decorateWithMiddleware(rl, !unrestrictedRequestSize, exemptFromFullLockDown, hand.Handle))
 		daemon.logger.Info("Initialise", "", nil, "installed web service at location %s", urlLocation)
 	}
 	// Initialise all rate limits
@@ -610,6 +612,16 @@ over.
 	if err != nil || resp.StatusCode != http.StatusOK || string(resp.Body) != "hi" {
 		t.Fatal(err, string(resp.Body))
 	}
+	resp, err = inet.DoHTTP(context.Background(), inet.HTTPRequest{
+		Method: http.MethodPost,
+		Body:   strings.NewReader(url.Values{"cmd": {toolbox.PrefixResultEnvelope + toolbox.TestCommandProcessorPIN + ".s echo hi"}}.Encode())}, addr+httpd.GetHandlerByFactoryType(&handler.HandleAppCommand{}))
+	var envelope toolbox.ResultEnvelope
+	if err != nil || resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/json" {
+		t.Fatal(err, string(resp.Body))
+	}
+	if err := json.Unmarshal(resp.Body, &envelope); err != nil || envelope.Trigger != ".s" || envelope.CombinedOutput != "hi" {
+		t.Fatal(err, string(resp.Body))
+	}
 
 	// Test reports endpoint
 	httpd.Processor.Features.MessageProcessor.StoreReport(context.Background(), toolbox.SubjectReportRequest{
@@ -36,6 +36,15 @@ DecorateWithMiddleware returns an HTTP middleware handler that performs the foll
 - Integrate with AWS x-ray.
 */
 func (daemon *Daemon) DecorateWithMiddleware(rateLimit *misc.RateLimit, restrictedRequestSize bool, next http.HandlerFunc) http.Handler {
+	return daemon.decorateWithMiddleware(rateLimit, restrictedRequestSize, false, next)
+}
+
+/*
+decorateWithMiddleware works like DecorateWithMiddleware. If exemptFromFullLockDown is true, the handler keeps serving
+during a full lock-down, which is for the app command handler to receive the command that lifts lock-down - the command
+processor refuses all other commands in the meantime.
+*/
+func (daemon *Daemon) decorateWithMiddleware(rateLimit *misc.RateLimit, restrictedRequestSize, exemptFromFullLockDown bool, next http.HandlerFunc) http.Handler {
 	decoratedHandler := func(w http.ResponseWriter, r *http.Request) {
 		// Record the duration of request handling in stats
 		beginTimeNano := time.Now().UnixNano()
@@ -46,7 +55,7 @@ func (daemon *Daemon) DecorateWithMiddleware(rateLimit *misc.RateLimit, restrict
 		if restrictedRequestSize {
 			r.Body = http.MaxBytesReader(w, r.Body, MaxRequestBodyBytes)
 		}
-		if misc.EmergencyLockDown {
+		if isLockedDown(r, exemptFromFullLockDown) {
 			/*
 				An error response usually should carry status 5xx in this case, but the intention of
 				emergency stop is to disable the program rather than crashing it and relaunching it.
@@ -83,3 +92,12 @@ func (daemon *Daemon) DecorateWithMiddleware(rateLimit *misc.RateLimit, restrict
 	}
 	return http.HandlerFunc(decoratedHandler)
 }
+
+// isLockedDown returns true if the HTTP daemon should refuse to serve the request due to lock-down.
+func isLockedDown(r *http.Request, exemptFromFullLockDown bool) bool {
+	if exemptFromFullLockDown {
+		return misc.IsDaemonInScopedLockDown("httpd") || (r.TLS == nil && misc.IsDaemonInScopedLockDown("insecurehttpd"))
+	}
+	// Both HTTP daemons share the same handlers, the TLS-free one is distinguished by the lack of TLS.
+	return misc.IsDaemonLockedDown("httpd") || (r.TLS == nil && misc.IsDaemonLockedDown("insecurehttpd"))
+}
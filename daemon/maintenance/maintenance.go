@@ -283,7 +283,7 @@ func (daemon *Daemon) StartAndBlock() error {
 	// Maintenance is run for the very first time soon (2 minutes) after starting up
 	nextRunAt := time.Now().Add(InitialDelaySec * time.Second)
 	for {
-		if misc.EmergencyLockDown {
+		if misc.IsDaemonLockedDown("maintenance") {
 			atomic.StoreInt32(&daemon.loopIsRunning, 0)
 			return misc.ErrEmergencyLockDown
 		}
@@ -41,6 +41,12 @@ type MessageProcessorServer struct {
 	DNSDomainName string `json:"DNSDomainName"`
 	// Password is the password PIN that the server accepts for command execution.
 	Passwords []string `json:"Passwords"`
+	/*
+		SignCommands makes the reports carry a command signature instead of a 2FA code, the server must have enabled
+		SignedCommand filter for the daemon that receives the reports. Unlike a 2FA code, a signature cannot be used
+		again for a different report.
+	*/
+	SignCommands bool `json:"SignCommands"`
 	// HostName is the host name portion of server app command execution URL, it is calculated by Initialise function.
 	HostName string `json:"-"`
 }
@@ -135,6 +141,20 @@ func (daemon *Daemon) getTwoFACode(server *MessageProcessorServer) string {
 	return cmdPassword1 + cmdPassword2
 }
 
+// getReportCommand returns the app command that carries a report to the server, authenticated by 2FA or signature.
+func (daemon *Daemon) getReportCommand(server *MessageProcessorServer, shortenMyHostName bool) string {
+	reportCmd := toolbox.StoreAndForwardMessageProcessorTrigger + daemon.getReportForServer(server.HostName, shortenMyHostName)
+	if server.SignCommands {
+		signedCmd, err := toolbox.SignCommand(server.Passwords[rand.Intn(len(server.Passwords))], reportCmd, time.Now())
+		if err != nil {
+			daemon.logger.Warning("getReportCommand", "", err, "failed to sign the report")
+			return ""
+		}
+		return signedCmd
+	}
+	return daemon.getTwoFACode(server) + reportCmd
+}
+
 func (daemon *Daemon) getReportForServer(serverHostName string, shortenMyHostName bool) string {
 	// Ask local message processor for a pending app command request and/or app command response
 	cmdExchange := daemon.LocalMessageProcessor.StoreReport(context.Background(), toolbox.SubjectReportRequest{SubjectHostName: serverHostName}, serverHostName, "phonehome")
@@ -169,7 +189,7 @@ func (daemon *Daemon) StartAndBlock() error {
 	daemon.logger.Info("StartAndBlock", "", nil, "reporting to %d servers and pausing %d seconds between each",
 		len(daemon.MessageProcessorServers), intervalSecBetweenReports)
 	for {
-		if misc.EmergencyLockDown {
+		if misc.IsDaemonLockedDown("phonehome") {
 			atomic.StoreInt32(&daemon.loopIsRunning, 0)
 			return misc.ErrEmergencyLockDown
 		}
@@ -198,7 +218,7 @@ func (daemon *Daemon) StartAndBlock() error {
 			var reportResponseJSON []byte
 			if srv.DNSDomainName != "" {
 				// Send the latest report via DNS name query
-				reportCmd := daemon.getTwoFACode(srv) + toolbox.StoreAndForwardMessageProcessorTrigger + daemon.getReportForServer(srv.HostName, true)
+				reportCmd := daemon.getReportCommand(srv, true)
 				queryResponse, err := net.LookupTXT(GetDNSQuery(reportCmd, srv.DNSDomainName))
 				if err != nil {
 					daemon.logger.Warning("StartAndBlock", srv.DNSDomainName, err, "failed to send DNS request")
@@ -207,7 +227,7 @@ func (daemon *Daemon) StartAndBlock() error {
 				reportResponseJSON = []byte(strings.Join(queryResponse, ""))
 			} else if srv.HTTPEndpointURL != "" {
 				// Send the latest report via HTTP client
-				reportCmd := daemon.getTwoFACode(srv) + toolbox.StoreAndForwardMessageProcessorTrigger + daemon.getReportForServer(srv.HostName, false)
+				reportCmd := daemon.getReportCommand(srv, false)
 				resp, err := inet.DoHTTP(context.Background(), inet.HTTPRequest{
 					TimeoutSec: 15,
 					MaxBytes:   16 * 1024,
@@ -246,7 +266,11 @@ func (daemon *Daemon) Stop() {
 
 // TestServer implements test cases for the phone-home daemon.
 func TestServer(server *Daemon, t testingstub.T) {
-	// Start a web server that behaves like a message processor server
+	/*
+		Start a web server that behaves like a message processor server. The reports arrive at the server's web server,
+		hence the server processes them under daemon name "httpd", whereas this daemon runs app commands from the server
+		under daemon name "phonehome".
+	*/
 	mux := http.NewServeMux()
 	muxNumRequests := 0
 	muxMessageProcessor := toolbox.MessageProcessor{CmdProcessor: toolbox.GetTestCommandProcessor()}
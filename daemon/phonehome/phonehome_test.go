@@ -22,3 +22,29 @@ func TestPhoneHomeDaemon(t *testing.T) {
 	}
 	TestServer(&daemon, t)
 }
+
+func TestPhoneHomeDaemon_SignCommands(t *testing.T) {
+	srv := &MessageProcessorServer{Passwords: []string{toolbox.TestCommandProcessorPIN}, HTTPEndpointURL: "http://localhost", SignCommands: true}
+	daemon := Daemon{Processor: toolbox.GetTestCommandProcessor(), MessageProcessorServers: []*MessageProcessorServer{srv}}
+	if err := daemon.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	reportCmd := daemon.getReportCommand(srv, false)
+	if !toolbox.RegexSubjectReportUsingSignature.MatchString(reportCmd) {
+		t.Fatal(reportCmd)
+	}
+	// The server verifies the signature using the same password
+	filter := &toolbox.SignedCommand{Enabled: true, PINAndShortcuts: &toolbox.PINAndShortcuts{Passwords: []string{toolbox.TestCommandProcessorPIN}}}
+	cmd, err := filter.Transform(toolbox.Command{Content: reportCmd})
+	if err != nil || !strings.HasPrefix(cmd.Content, toolbox.StoreAndForwardMessageProcessorTrigger) {
+		t.Fatal(cmd, err)
+	}
+	if _, err := filter.Transform(toolbox.Command{Content: reportCmd}); err != toolbox.ErrSignedCommandReplayed {
+		t.Fatal(err)
+	}
+	// Without signature the report is authenticated by 2FA
+	srv.SignCommands = false
+	if reportCmd := daemon.getReportCommand(srv, false); !toolbox.RegexSubjectReportUsing2FA.MatchString(reportCmd) {
+		t.Fatal(reportCmd)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -77,7 +78,7 @@ func (daemon *Daemon) HandleTCPConnection(logger lalog.Logger, ip string, conn *
 	// Allow up to 1MB of commands to be received per connection
 	reader := textproto.NewReader(bufio.NewReader(io.LimitReader(conn, 1*1048576)))
 	for {
-		if misc.EmergencyLockDown {
+		if misc.IsDaemonLockedDown("plainsocket") {
 			logger.Warning("HandleTCPConnection", "", misc.ErrEmergencyLockDown, "")
 			return
 		}
@@ -102,15 +103,16 @@ func (daemon *Daemon) HandleTCPConnection(logger lalog.Logger, ip string, conn *
 			continue
 		}
 		// Process line of command and respond
+		content, wantEnvelope := toolbox.FindAndRemoveEnvelopePrefix(line)
 		result := daemon.Processor.Process(context.TODO(), toolbox.Command{
 			DaemonName: "plainsocket",
 			ClientTag:  ip,
-			Content:    string(line),
+			Content:    content,
 			TimeoutSec: CommandTimeoutSec,
 		}, true)
 		if err := conn.SetWriteDeadline(time.Now().Add(IOTimeoutSec * time.Second)); err != nil {
 			return
-		} else if _, err := conn.Write([]byte(result.CombinedOutput)); err != nil {
+		} else if _, err := conn.Write([]byte(formatResult(result, wantEnvelope))); err != nil {
 			return
 		} else if _, err := conn.Write([]byte("\r\n")); err != nil {
 			return
@@ -118,6 +120,14 @@ func (daemon *Daemon) HandleTCPConnection(logger lalog.Logger, ip string, conn *
 	}
 }
 
+// formatResult returns the combined output of the result, or its JSON envelope if the client asked for it.
+func formatResult(result *toolbox.Result, wantEnvelope bool) string {
+	if wantEnvelope {
+		return result.EnvelopeJSON()
+	}
+	return result.CombinedOutput
+}
+
 // GetUDPStatsCollector returns stats collector for the UDP server of this daemon.
 func (daemon *Daemon) GetUDPStatsCollector() *misc.Stats {
 	return misc.PlainSocketStatsUDP
@@ -128,7 +138,7 @@ func (daemon *Daemon) HandleUDPClient(logger lalog.Logger, ip string, client *ne
 	daemon.Processor.SetLogger(logger)
 	reader := textproto.NewReader(bufio.NewReader(bytes.NewReader(packet)))
 	for {
-		if misc.EmergencyLockDown {
+		if misc.IsDaemonLockedDown("plainsocket") {
 			logger.Warning("HandleUDPClient", "", misc.ErrEmergencyLockDown, "")
 			return
 		}
@@ -150,16 +160,17 @@ func (daemon *Daemon) HandleUDPClient(logger lalog.Logger, ip string, client *ne
 			continue
 		}
 		// Process line of command and respond
+		content, wantEnvelope := toolbox.FindAndRemoveEnvelopePrefix(line)
 		result := daemon.Processor.Process(context.TODO(), toolbox.Command{
 			DaemonName: "plainsocket",
 			ClientTag:  ip,
-			Content:    string(line),
+			Content:    content,
 			TimeoutSec: CommandTimeoutSec,
 		}, true)
 		if err := srv.SetWriteDeadline(time.Now().Add(IOTimeoutSec * time.Second)); err != nil {
 			logger.Warning("HandleUDPClient", ip, err, "failed to write response")
 			return
-		} else if _, err := srv.WriteToUDP([]byte(result.CombinedOutput+"\r\n"), client); err != nil {
+		} else if _, err := srv.WriteToUDP([]byte(formatResult(result, wantEnvelope)+"\r\n"), client); err != nil {
 			logger.Warning("HandleUDPClient", ip, err, "failed to write response")
 			return
 		}
@@ -245,6 +256,19 @@ func TestServer(server *Daemon, t testingstub.T) {
 	if string(goodPINResp) != "hi" {
 		t.Fatal(string(goodPINResp))
 	}
+	// Ask for result in JSON envelope
+	_, err = tcpClient.Write([]byte(".json verysecret .s echo hi\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelopeResp, _, err := reader.ReadLine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var envelope toolbox.ResultEnvelope
+	if err := json.Unmarshal(envelopeResp, &envelope); err != nil || envelope.Trigger != ".s" || envelope.Output != "hi\n" || envelope.CombinedOutput != "hi" {
+		t.Fatal(err, string(envelopeResp))
+	}
 
 	// Prepare for UDP conversations
 	udpClient, err := net.Dial("udp", "127.0.0.1:"+strconv.Itoa(server.UDPPort))
@@ -279,6 +303,18 @@ func TestServer(server *Daemon, t testingstub.T) {
 	if string(goodPINResp) != "hi" {
 		t.Fatal(string(goodPINResp))
 	}
+	// Ask for result in JSON envelope
+	_, err = udpClient.Write([]byte(".json verysecret .s false\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelopeResp, _, err = reader.ReadLine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(envelopeResp, &envelope); err != nil || envelope.Trigger != ".s" || envelope.ExitStatus != 1 || envelope.Error != "exit status 1" {
+		t.Fatal(err, string(envelopeResp))
+	}
 
 	// Daemon should stop within a second
 	server.Stop()
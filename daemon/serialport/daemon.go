@@ -98,7 +98,7 @@ func (daemon *Daemon) StartAndBlock() error {
 	daemon.loopIsRunning = true
 	daemon.logger.Info("StartAndBlock", "", nil, "looking for devices: %s", strings.Join(daemon.DeviceGlobPatterns, " "))
 	for {
-		if misc.EmergencyLockDown {
+		if misc.IsDaemonLockedDown("serialport") {
 			daemon.logger.Warning("StartAndBlock", "", misc.ErrEmergencyLockDown, "")
 			return misc.ErrEmergencyLockDown
 		}
@@ -164,7 +164,7 @@ func (daemon *Daemon) converseWithDevice(devPath string, stopChan chan bool) {
 	// Converse with the device in a background routine, signal stopChan to terminate the conversation in case of IO error.
 	go func() {
 		for {
-			if misc.EmergencyLockDown {
+			if misc.IsDaemonLockedDown("serialport") {
 				stopChan <- true
 				daemon.logger.Warning("HandleTCPConnection", "", misc.ErrEmergencyLockDown, "")
 				return
@@ -93,7 +93,7 @@ func (daemon *Daemon) StartAndBlock() error {
 		tcpServer := &common.TCPServer{
 			ListenAddr:  daemon.Address,
 			ListenPort:  port,
-			AppName:     "simpleipsvc",
+			AppName:     "simpleipsvcd",
 			App:         &TCPService{ResponseFun: daemon.serverResponseFun[port]},
 			LimitPerSec: daemon.PerIPLimit,
 		}
@@ -110,7 +110,7 @@ func (daemon *Daemon) StartAndBlock() error {
 		udpServer := &common.UDPServer{
 			ListenAddr:  daemon.Address,
 			ListenPort:  port,
-			AppName:     "simpleipsvc",
+			AppName:     "simpleipsvcd",
 			App:         &UDPService{ResponseFun: daemon.serverResponseFun[port]},
 			LimitPerSec: daemon.PerIPLimit,
 		}
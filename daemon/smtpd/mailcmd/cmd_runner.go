@@ -114,7 +114,7 @@ Process only one command (if found) in the incoming mail. If reply addresses are
 to the specified addresses. If they are not specified, use the incoming mail sender's address as reply address.
 */
 func (runner *CommandRunner) Process(clientIP string, mailContent []byte, replyAddresses ...string) error {
-	if misc.EmergencyLockDown {
+	if misc.IsDaemonLockedDown("smtpd") {
 		return misc.ErrEmergencyLockDown
 	}
 	var commandIsProcessed bool
@@ -207,7 +207,7 @@ func (daemon *Daemon) HandleTCPConnection(logger lalog.Logger, ip string, client
 
 	smtpConn := smtp.NewConnection(client, daemon.smtpConfig, nil)
 	for {
-		if misc.EmergencyLockDown {
+		if misc.IsDaemonLockedDown("smtpd") {
 			daemon.logger.Warning("HandleConnection", "", misc.ErrEmergencyLockDown, "")
 			return
 		}
@@ -37,7 +37,7 @@ func PipeTCPConnection(fromConn, toConn net.Conn, doWriteRand bool) {
 	}()
 	buf := make([]byte, MaxPacketSize)
 	for {
-		if misc.EmergencyLockDown {
+		if misc.IsDaemonLockedDown("sockd") {
 			lalog.DefaultLogger.Warning("PipeTCPConnection", "", misc.ErrEmergencyLockDown, "")
 			return
 		} else if err := fromConn.SetReadDeadline(time.Now().Add(IOTimeoutSec * time.Second)); err != nil {
@@ -367,7 +367,7 @@ func (daemon *UDPDaemon) PipeUDPConnection(server net.PacketConn, clientAddr *ne
 		_ = client.Close()
 	}()
 	for {
-		if misc.EmergencyLockDown {
+		if misc.IsDaemonLockedDown("sockd") {
 			lalog.DefaultLogger.Warning("PipeTCPConnection", "", misc.ErrEmergencyLockDown, "")
 			return
 		} else if err := client.SetReadDeadline(time.Now().Add(IOTimeoutSec * time.Second)); err != nil {
@@ -21,9 +21,10 @@ import (
 )
 
 const (
-	ChatTypePrivate   = "private" // Name of the private chat type
-	APICallTimeoutSec = 30        // Outgoing API calls are constrained by this timeout
-	CommandTimeoutSec = 30        // Command execution is constrained by this timeout
+	ChatTypePrivate        = "private"  // Name of the private chat type
+	APICallTimeoutSec      = 30         // Outgoing API calls are constrained by this timeout
+	CommandTimeoutSec      = 30         // Command execution is constrained by this timeout
+	ScheduleResultSinkName = "telegram" // Scheduled app commands use this sink name to deliver results to a telegram chat
 
 	/*
 		PollIntervalSecMin and PollIntervalSecMax together determine the range of random number of seconds to wait between
@@ -108,6 +109,14 @@ func (bot *Daemon) Initialise() error {
 	}
 	bot.userRateLimit.Initialise()
 	bot.stop = make(chan bool)
+	// Let scheduled app commands deliver their results to a chat, the destination is the chat ID.
+	toolbox.RegisterScheduleResultSink(ScheduleResultSinkName, func(destination, _, text string) error {
+		chatID, err := strconv.ParseInt(destination, 10, 64)
+		if err != nil {
+			return fmt.Errorf("telegrambot: chat ID \"%s\" is not a number", destination)
+		}
+		return bot.ReplyTo(chatID, text)
+	})
 	return nil
 }
 
@@ -164,7 +173,7 @@ func (bot *Daemon) ProcessMessages(ctx context.Context, updates APIUpdates) {
 		// Find and run command in background
 		go func(ding APIUpdate, beginTimeNano int64) {
 			result := bot.Processor.Process(ctx, toolbox.Command{
-				DaemonName: "telegrambot",
+				DaemonName: "telegram",
 				ClientTag:  ding.Message.Chat.UserName,
 				TimeoutSec: CommandTimeoutSec,
 				Content:    ding.Message.Text,
@@ -192,7 +201,7 @@ func (bot *Daemon) StartAndBlock() error {
 	bot.logger.Info("StartAndBlock", "", nil, "going to poll for messages")
 	lastIdle := time.Now().Unix()
 	for {
-		if misc.EmergencyLockDown {
+		if misc.IsDaemonLockedDown("telegram") {
 			atomic.StoreInt32(&bot.loopIsRunning, 0)
 			bot.logger.Warning("StartAndBlock", "", misc.ErrEmergencyLockDown, "")
 			return misc.ErrEmergencyLockDown
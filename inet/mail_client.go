@@ -1,13 +1,20 @@
 package inet
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"math/rand"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
 	"net"
 	"net/smtp"
+	"net/textproto"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync/atomic"
@@ -201,6 +208,93 @@ func (client *MailClient) Send(subject string, textBody string, recipients ...st
 	return nil
 }
 
+// OutgoingAttachment is a file attached to an outgoing mail.
+type OutgoingAttachment struct {
+	FileName    string `json:"FileName"`    // FileName is the name of the attached file as seen by the recipients.
+	ContentType string `json:"ContentType"` // ContentType is optional, it is guessed from the file name extension by default.
+	Content     []byte `json:"Content"`     // Content is the file content.
+}
+
+/*
+BuildMultipartMail constructs a MIME multipart mail message that consists of the text body followed by the attachments.
+The subject and attachment file names may contain non-ASCII characters.
+*/
+func (client *MailClient) BuildMultipartMail(subject, textBody string, attachments []OutgoingAttachment, recipients ...string) ([]byte, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	// The text body
+	textHeader := textproto.MIMEHeader{}
+	textHeader.Set("Content-Type", "text/plain; charset=utf-8")
+	textHeader.Set("Content-Transfer-Encoding", "quoted-printable")
+	textPart, err := writer.CreatePart(textHeader)
+	if err != nil {
+		return nil, err
+	}
+	qpWriter := quotedprintable.NewWriter(textPart)
+	if _, err := qpWriter.Write([]byte(textBody)); err != nil {
+		return nil, err
+	}
+	if err := qpWriter.Close(); err != nil {
+		return nil, err
+	}
+	// The attachments are encoded in base64 with line breaks every 76 characters
+	for _, attachment := range attachments {
+		contentType := attachment.ContentType
+		if contentType == "" {
+			if contentType = mime.TypeByExtension(filepath.Ext(attachment.FileName)); contentType == "" {
+				contentType = "application/octet-stream"
+			}
+		}
+		attachmentHeader := textproto.MIMEHeader{}
+		attachmentHeader.Set("Content-Type", contentType)
+		attachmentHeader.Set("Content-Transfer-Encoding", "base64")
+		attachmentHeader.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.FileName}))
+		attachmentPart, err := writer.CreatePart(attachmentHeader)
+		if err != nil {
+			return nil, err
+		}
+		encoded := base64.StdEncoding.EncodeToString(attachment.Content)
+		for len(encoded) > 0 {
+			lineLen := 76
+			if lineLen > len(encoded) {
+				lineLen = len(encoded)
+			}
+			if _, err := attachmentPart.Write([]byte(encoded[:lineLen] + "\r\n")); err != nil {
+				return nil, err
+			}
+			encoded = encoded[lineLen:]
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	header := fmt.Sprintf("MIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=%s\r\nFrom: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n",
+		writer.Boundary(), client.MailFrom, strings.Join(recipients, ", "), mime.QEncoding.Encode("utf-8", subject))
+	return append([]byte(header), body.Bytes()...), nil
+}
+
+/*
+SendWithAttachments delivers a mail that carries the attachments to all recipients. If there is no attachment, the mail
+is delivered in plain text just like Send.
+*/
+func (client *MailClient) SendWithAttachments(subject, textBody string, attachments []OutgoingAttachment, recipients ...string) error {
+	if len(attachments) == 0 {
+		return client.Send(subject, textBody, recipients...)
+	}
+	if len(recipients) == 0 {
+		return fmt.Errorf("no recipient specified for mail \"%s\"", subject)
+	}
+	if err := checkNoCRLF(subject); err != nil {
+		return err
+	}
+	message, err := client.BuildMultipartMail(subject, textBody, attachments, recipients...)
+	if err != nil {
+		return err
+	}
+	go client.sendMailWithRetry(client.MailFrom, recipients, message)
+	return nil
+}
+
 // Deliver unmodified mail body to all recipients. Block until mail is sent or an error has occurred.
 func (client *MailClient) SendRaw(fromAddr string, rawMailBody []byte, recipients ...string) error {
 	if len(recipients) == 0 {
@@ -1,7 +1,9 @@
 package inet
 
 import (
+	"bytes"
 	"net"
+	"strings"
 	"testing"
 )
 
@@ -35,3 +37,39 @@ func TestMailer_Send(t *testing.T) {
 		}
 	}
 }
+
+func TestMailClient_BuildMultipartMail(t *testing.T) {
+	m := MailClient{MailFrom: "me@example.com"}
+	attachments := []OutgoingAttachment{
+		{FileName: "notes.txt", Content: []byte("hello attachment")},
+		{FileName: "résumé.bin", ContentType: "application/x-test", Content: bytes.Repeat([]byte{0, 1, 2, 255}, 100)},
+	}
+	message, err := m.BuildMultipartMail("Grüße from laitos", "line 1\nline 2 with a very long text "+strings.Repeat("x", 100), attachments, "a@example.com", "b@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(message), "To: a@example.com, b@example.com\r\n") || !strings.Contains(string(message), "Content-Type: application/x-test\r\n") {
+		t.Fatal(string(message))
+	}
+	// Decode the mail to verify its content
+	decoded, err := DecodeMailMessage(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Subject != "Grüße from laitos" || decoded.FromAddress != "me@example.com" {
+		t.Fatalf("%+v", decoded)
+	}
+	// Quoted-printable encoding turns line breaks into CRLF
+	if decoded.Text != "line 1\r\nline 2 with a very long text "+strings.Repeat("x", 100) {
+		t.Fatalf("%q", decoded.Text)
+	}
+	if len(decoded.Attachments) != 2 ||
+		decoded.Attachments[0] != (MailAttachment{FileName: "notes.txt", Size: 16}) ||
+		decoded.Attachments[1] != (MailAttachment{FileName: "résumé.bin", Size: 400}) {
+		t.Fatalf("%+v", decoded.Attachments)
+	}
+	// A recipient is mandatory
+	if err := m.SendWithAttachments("subject", "body", attachments); err == nil {
+		t.Fatal("did not error")
+	}
+}
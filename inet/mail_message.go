@@ -2,14 +2,19 @@ package inet
 
 import (
 	"bytes"
-	"github.com/HouzuoGuo/laitos/misc"
+	"encoding/base64"
+	"fmt"
+	"html"
 	"io"
 	"mime"
 	"mime/multipart"
 	"mime/quotedprintable"
 	"net/mail"
+	"net/textproto"
 	"regexp"
 	"strings"
+
+	"github.com/HouzuoGuo/laitos/misc"
 )
 
 const (
@@ -133,3 +138,192 @@ func WalkMailMessage(mailMessage []byte, fun func(BasicMail, []byte) (bool, erro
 		return err
 	}
 }
+
+// mailHeaderDecoder decodes RFC 2047 encoded-words found in mail headers, such as "=?UTF-8?B?...?=".
+var mailHeaderDecoder = &mime.WordDecoder{
+	CharsetReader: func(charset string, input io.Reader) (io.Reader, error) {
+		content, err := misc.ReadAllUpTo(input, MaxMailBodySize)
+		if err != nil {
+			return nil, err
+		}
+		text, err := decodeMailCharset(charset, content)
+		return strings.NewReader(text), err
+	},
+}
+
+// DecodeMailHeader decodes the RFC 2047 encoded-words in the mail header value. The value is returned as-is if it cannot be decoded.
+func DecodeMailHeader(value string) string {
+	decoded, err := mailHeaderDecoder.DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
+
+// windows1252Table has the characters of Windows-1252 code points 0x80 - 0x9F, the rest are identical to ISO-8859-1.
+var windows1252Table = []rune("€\u0081‚ƒ„…†‡ˆ‰Š‹Œ\u008dŽ\u008f\u0090‘’“”•–—˜™š›œ\u009džŸ")
+
+/*
+decodeMailCharset converts the text in the character set into UTF-8. In addition to UTF-8 and US-ASCII, it understands
+ISO-8859-1 and Windows-1252, which are by far the most common legacy character sets among mails.
+*/
+func decodeMailCharset(charset string, content []byte) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(charset)) {
+	case "", "utf-8", "utf8", "us-ascii", "ascii":
+		return string(content), nil
+	case "iso-8859-1", "iso8859-1", "latin1":
+		var ret strings.Builder
+		for _, b := range content {
+			ret.WriteRune(rune(b))
+		}
+		return ret.String(), nil
+	case "windows-1252", "cp1252":
+		var ret strings.Builder
+		for _, b := range content {
+			if b >= 0x80 && b < 0xa0 {
+				ret.WriteRune(windows1252Table[b-0x80])
+			} else {
+				ret.WriteRune(rune(b))
+			}
+		}
+		return ret.String(), nil
+	default:
+		return string(content), fmt.Errorf("unsupported character set \"%s\"", charset)
+	}
+}
+
+// MailAttachment is the name and size of an attachment found in a mail message.
+type MailAttachment struct {
+	FileName string // FileName is the name of the attachment file, or a placeholder if the mail does not name it.
+	Size     int    // Size is the size of decoded attachment content in bytes.
+}
+
+// DecodedMail is a mail message rid of MIME encoding, it is suitable for being read by a person.
+type DecodedMail struct {
+	BasicMail
+	Date        string           // Date is the date header of the mail.
+	Text        string           // Text is the plain text body, or the text converted from HTML body if the mail does not have a plain text body.
+	Attachments []MailAttachment // Attachments are the attached files.
+}
+
+// RegexHTMLTag finds HTML tags, they are removed when converting HTML mail body into plain text.
+var RegexHTMLTag = regexp.MustCompile(`(?is)<style.*?</style>|<script.*?</script>|<[^>]*>`)
+
+// RegexConsecutiveBlankLines finds three or more line breaks in a row, possibly separated by white spaces.
+var RegexConsecutiveBlankLines = regexp.MustCompile(`\n\s*\n\s*\n`)
+
+// RegexHTMLLineBreak finds HTML tags that end a line of text.
+var RegexHTMLLineBreak = regexp.MustCompile(`(?i)<br\s*/?>|</p>|</div>|</tr>|</h\d>`)
+
+// htmlToText converts HTML into plain text by removing tags and unescaping entities.
+func htmlToText(content string) string {
+	content = RegexHTMLLineBreak.ReplaceAllString(content, "\n")
+	content = html.UnescapeString(RegexHTMLTag.ReplaceAllString(content, ""))
+	var lines []string
+	for _, line := range strings.Split(content, "\n") {
+		lines = append(lines, strings.TrimSpace(line))
+	}
+	return strings.TrimSpace(RegexConsecutiveBlankLines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
+
+/*
+DecodeMailMessage decodes a mail message into its plain text body and a list of attachments. It walks through nested
+multipart sections, decodes base64 and quoted-printable transfer encoding, and converts the text into UTF-8.
+*/
+func DecodeMailMessage(mailMessage []byte) (ret DecodedMail, err error) {
+	prop, parsedMail, err := ReadMailMessage(mailMessage)
+	if err != nil {
+		return
+	}
+	ret.BasicMail = prop
+	ret.Subject = DecodeMailHeader(prop.Subject)
+	ret.Date = strings.TrimSpace(parsedMail.Header.Get("Date"))
+	var plainText, htmlText []string
+	err = decodeMailPart(textproto.MIMEHeader(parsedMail.Header), parsedMail.Body, 0, func(header textproto.MIMEHeader, mediaType string, params map[string]string, body []byte) {
+		disposition, dispositionParams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+		fileName := dispositionParams["filename"]
+		if fileName == "" {
+			fileName = params["name"]
+		}
+		if disposition == "attachment" || fileName != "" || !strings.HasPrefix(mediaType, "text/") {
+			if fileName == "" {
+				fileName = "(" + mediaType + ")"
+			}
+			ret.Attachments = append(ret.Attachments, MailAttachment{FileName: DecodeMailHeader(fileName), Size: len(body)})
+			return
+		}
+		text, _ := decodeMailCharset(params["charset"], body)
+		if mediaType == "text/html" {
+			htmlText = append(htmlText, htmlToText(text))
+		} else {
+			plainText = append(plainText, strings.TrimSpace(text))
+		}
+	})
+	if len(plainText) > 0 {
+		ret.Text = strings.Join(plainText, "\n\n")
+	} else {
+		ret.Text = strings.Join(htmlText, "\n\n")
+	}
+	return
+}
+
+// decodeMailPart calls the function with each leaf part of the (multipart) mail section, and the decoded body of the part.
+func decodeMailPart(header textproto.MIMEHeader, body io.Reader, depth int, fun func(textproto.MIMEHeader, string, map[string]string, []byte)) error {
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "text/plain"
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		// Treat a malformed content type as plain text so that the content remains readable
+		mediaType, params = "text/plain", map[string]string{}
+	}
+	mediaType = strings.ToLower(mediaType)
+	if strings.HasPrefix(mediaType, "multipart/") && depth < 10 {
+		partReader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := partReader.NextPart()
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			if err := decodeMailPart(part.Header, part, depth+1, fun); err != nil {
+				return err
+			}
+		}
+	}
+	// The multipart reader already decodes quoted-printable parts and removes their encoding header
+	var contentReader = body
+	switch strings.ToLower(strings.TrimSpace(header.Get("Content-Transfer-Encoding"))) {
+	case "quoted-printable":
+		contentReader = quotedprintable.NewReader(body)
+	case "base64":
+		contentReader = base64.NewDecoder(base64.StdEncoding, &base64LineFilter{reader: body})
+	}
+	content, err := misc.ReadAllUpTo(contentReader, MaxMailBodySize)
+	if err != nil {
+		return err
+	}
+	fun(header, mediaType, params, content)
+	return nil
+}
+
+// base64LineFilter removes line breaks and white spaces from base64 text, which are not understood by base64 decoder.
+type base64LineFilter struct {
+	reader io.Reader
+}
+
+func (filter *base64LineFilter) Read(p []byte) (int, error) {
+	n, err := filter.reader.Read(p)
+	kept := 0
+	for _, b := range p[:n] {
+		switch b {
+		case '\r', '\n', ' ', '\t':
+			continue
+		}
+		p[kept] = b
+		kept++
+	}
+	return kept, err
+}
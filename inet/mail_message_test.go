@@ -3,6 +3,7 @@ package inet
 import (
 	"errors"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -219,3 +220,77 @@ hoho` {
 		t.Fatal(err)
 	}
 }
+
+var NestedMultipartMail = []byte("From: \"Alice\" <alice@example.com>\r\n" +
+	"Subject: =?UTF-8?B?SGVsbG8gd8O2cmxk?=\r\n" +
+	"Date: Sat, 05 Mar 2016 12:17:27 +0100\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=\"outer\"\r\n" +
+	"\r\n" +
+	"--outer\r\n" +
+	"Content-Type: multipart/alternative; boundary=\"inner\"\r\n" +
+	"\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/plain; charset=iso-8859-1\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"Gr=FC=DFe aus K=F6ln=\r\n" +
+	", bis bald\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/html; charset=utf-8\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"PHA+R3LDvMOfZTwvcD4=\r\n" +
+	"--inner--\r\n" +
+	"--outer\r\n" +
+	"Content-Type: application/pdf; name=\"report.pdf\"\r\n" +
+	"Content-Disposition: attachment; filename=\"=?UTF-8?Q?r=C3=A9sum=C3=A9.pdf?=\"\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"YWJj\r\n" +
+	"ZGVm\r\n" +
+	"--outer\r\n" +
+	"Content-Type: image/png\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"AAEC\r\n" +
+	"--outer--\r\n")
+
+func TestDecodeMailMessage(t *testing.T) {
+	decoded, err := DecodeMailMessage(NestedMultipartMail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Subject != "Hello wörld" || decoded.FromAddress != "alice@example.com" || decoded.Date != "Sat, 05 Mar 2016 12:17:27 +0100" {
+		t.Fatalf("%+v", decoded)
+	}
+	// Plain text is preferred over HTML
+	if decoded.Text != "Grüße aus Köln, bis bald" {
+		t.Fatalf("%q", decoded.Text)
+	}
+	if !reflect.DeepEqual(decoded.Attachments, []MailAttachment{{FileName: "résumé.pdf", Size: 6}, {FileName: "(image/png)", Size: 3}}) {
+		t.Fatalf("%+v", decoded.Attachments)
+	}
+	// HTML body is converted to text in the absence of plain text
+	decoded, err = DecodeMailMessage([]byte("Subject: hi\r\nContent-Type: text/html; charset=windows-1252\r\n\r\n<html><style>p {}</style><p>caf\xe9 &amp; \x93tea\x94</p><p>bye</p></html>"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Text != "café & “tea”\nbye" {
+		t.Fatalf("%q", decoded.Text)
+	}
+	// Mail without content type is plain text
+	decoded, err = DecodeMailMessage(TextMail)
+	if err != nil || decoded.Subject != "subject1" || len(decoded.Attachments) != 0 || !strings.HasPrefix(decoded.Text, "abcfoobar") {
+		t.Fatalf("%+v %v", decoded, err)
+	}
+}
+
+func TestDecodeMailHeader(t *testing.T) {
+	if decoded := DecodeMailHeader("=?ISO-8859-1?Q?Andr=E9?= Pirard"); decoded != "André Pirard" {
+		t.Fatal(decoded)
+	}
+	if decoded := DecodeMailHeader("plain subject"); decoded != "plain subject" {
+		t.Fatal(decoded)
+	}
+}
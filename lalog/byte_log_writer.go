@@ -94,6 +94,7 @@ func (writer *ByteLogWriter) absorb(in []byte) {
 
 // Retrieve returns a copy of the latest bytes written.
 func (writer *ByteLogWriter) Retrieve(asciiOnly bool) (ret []byte) {
+	writer.mutex.Lock()
 	var bufCopy []byte
 	if writer.everFull {
 		bufCopy = make([]byte, writer.currentSize)
@@ -103,6 +104,7 @@ func (writer *ByteLogWriter) Retrieve(asciiOnly bool) (ret []byte) {
 		bufCopy = make([]byte, writer.latestPos)
 		copy(bufCopy, writer.latestBytes[:writer.latestPos])
 	}
+	writer.mutex.Unlock()
 
 	ret = bufCopy
 	if asciiOnly {
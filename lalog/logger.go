@@ -8,6 +8,7 @@ import (
 	"strings"
 	"time"
 	"unicode"
+	"unicode/utf8"
 )
 
 const (
@@ -185,6 +186,25 @@ func TruncateString(in string, maxLength int) string {
 	return in
 }
 
+/*
+TruncateUTF8 returns the input string as-is if it is less or equal to the desired length in bytes. Otherwise, it cuts
+the string at the desired length without splitting a multi-byte character, hence the return value may be slightly
+shorter than the desired length.
+*/
+func TruncateUTF8(in string, maxLength int) string {
+	if maxLength < 0 {
+		maxLength = 0
+	}
+	if len(in) <= maxLength {
+		return in
+	}
+	end := maxLength
+	for end > 0 && !utf8.RuneStart(in[end]) {
+		end--
+	}
+	return in[:end]
+}
+
 /*
 LintString returns a copy of the input string with unusual characters (such as non-printable characters and record
 separators) replaced by an underscore. Consequently, printable characters such as CJK languages are also replaced.
@@ -147,6 +147,28 @@ func TestLogger_MaybeError(t *testing.T) {
 	logger.MaybeMinorError(errors.New("testError"))
 }
 
+func TestTruncateUTF8(t *testing.T) {
+	if s := TruncateUTF8("abc", -1); s != "" {
+		t.Fatal(s)
+	}
+	if s := TruncateUTF8("abc", 3); s != "abc" {
+		t.Fatal(s)
+	}
+	if s := TruncateUTF8("abc", 2); s != "ab" {
+		t.Fatal(s)
+	}
+	// "é" takes two bytes, it must not be split.
+	if s := TruncateUTF8("aé", 2); s != "a" {
+		t.Fatal(s)
+	}
+	if s := TruncateUTF8("éé", 3); s != "é" {
+		t.Fatal(s)
+	}
+	if s := TruncateUTF8("éé", 4); s != "éé" {
+		t.Fatal(s)
+	}
+}
+
 func TestTruncateString(t *testing.T) {
 	if s := TruncateString("", -1); s != "" {
 		t.Fatal(s)
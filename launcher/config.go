@@ -25,6 +25,7 @@ import (
 	"github.com/HouzuoGuo/laitos/daemon/telegrambot"
 	"github.com/HouzuoGuo/laitos/inet"
 	"github.com/HouzuoGuo/laitos/lalog"
+	"github.com/HouzuoGuo/laitos/misc"
 	"github.com/HouzuoGuo/laitos/toolbox"
 )
 
@@ -60,10 +61,12 @@ type StandardFilters struct {
 	// For input command content
 	TranslateSequences toolbox.TranslateSequences `json:"TranslateSequences"`
 	PINAndShortcuts    toolbox.PINAndShortcuts    `json:"PINAndShortcuts"`
+	SignedCommand      toolbox.SignedCommand      `json:"SignedCommand"`
 
 	// For command execution result
 	NotifyViaEmail toolbox.NotifyViaEmail `json:"NotifyViaEmail"`
 	LintText       toolbox.LintText       `json:"LintText"`
+	EncryptResult  toolbox.EncryptResult  `json:"EncryptResult"`
 }
 
 // Configure path to HTTP handlers and handler themselves.
@@ -109,6 +112,9 @@ type HTTPHandlers struct {
 	TwilioCallEndpoint       string                       `json:"TwilioCallEndpoint"`
 	TwilioCallEndpointConfig handler.HandleTwilioCallHook `json:"TwilioCallEndpointConfig"`
 
+	SMSGatewayEndpoint       string                       `json:"SMSGatewayEndpoint"`
+	SMSGatewayEndpointConfig handler.HandleSMSGatewayHook `json:"SMSGatewayEndpointConfig"`
+
 	AppCommandEndpoint       string `json:"AppCommandEndpoint"`
 	ReportsRetrievalEndpoint string `json:"ReportsRetrievalEndpoint"`
 	ProcessExplorerEndpoint  string `json:"ProcessExplorerEndpoint"`
@@ -164,6 +170,10 @@ type Config struct {
 
 	SupervisorNotificationRecipients []string `json:"SupervisorNotificationRecipients"` // Email addresses of supervisor notification recipients
 
+	AuditJournal  *toolbox.AuditJournal  `json:"AuditJournal"`  // AuditJournal keeps a persistent record of app commands processed by all daemons
+	LockoutLedger *toolbox.LockoutLedger `json:"LockoutLedger"` // LockoutLedger locks out clients that repeatedly fail to authenticate with any daemon
+	LockDown      *misc.LockDownConfig   `json:"LockDown"`      // LockDown persists emergency lock-down and configures the secret that lifts it
+
 	logger                lalog.Logger // logger handles log output from configuration serialisation and initialisation routines.
 	maintenanceInit       *sync.Once
 	dnsDaemonInit         *sync.Once
@@ -186,6 +196,32 @@ func (config *Config) Initialise() error {
 	if config.Features == nil {
 		config.Features = &toolbox.FeatureSet{}
 	}
+	// All command processors share the optional audit journal, which can be inspected by the environment control app.
+	if config.AuditJournal.IsConfigured() {
+		if err := config.AuditJournal.Initialise(); err != nil {
+			return err
+		}
+		config.Features.EnvControl.AuditJournal = config.AuditJournal
+	} else {
+		config.AuditJournal = nil
+	}
+	// All command processors share the optional lockout ledger, so that a client locked out by one daemon is locked out by all.
+	if config.LockoutLedger.IsConfigured() {
+		if err := config.LockoutLedger.Initialise(); err != nil {
+			return err
+		}
+		config.Features.EnvControl.LockoutLedger = config.LockoutLedger
+	} else {
+		config.LockoutLedger = nil
+	}
+	// Emergency lock-down persisted by a previous run remains in effect, it must be honoured before daemons start.
+	if config.LockDown.IsConfigured() {
+		if err := config.LockDown.Initialise(); err != nil {
+			return err
+		}
+	} else {
+		config.LockDown = nil
+	}
 
 	// Initialise the optional AWS kinesis firehose client for a stream to get a copy of every report received by message processor
 	firehoseStreamName := os.Getenv("LAITOS_FORWARD_REPORTS_TO_FIREHOSE_STREAM_NAME")
@@ -214,18 +250,7 @@ func (config *Config) Initialise() error {
 		The command processor is initialised from configuration input.
 	*/
 	if len(config.MessageProcessorFilters.PINAndShortcuts.Passwords) != 0 {
-		messageProcessorCommandProcessor := &toolbox.CommandProcessor{
-			Features: config.Features,
-			CommandFilters: []toolbox.CommandFilter{
-				&config.MessageProcessorFilters.PINAndShortcuts,
-				&config.MessageProcessorFilters.TranslateSequences,
-			},
-			ResultFilters: []toolbox.ResultFilter{
-				&config.MessageProcessorFilters.LintText,
-				&toolbox.SayEmptyOutput{},
-				&config.MessageProcessorFilters.NotifyViaEmail,
-			},
-		}
+		messageProcessorCommandProcessor := config.getCommandProcessor(&config.MessageProcessorFilters)
 		config.Features.MessageProcessor = toolbox.MessageProcessor{
 			OwnerName:                       "app",
 			CmdProcessor:                    messageProcessorCommandProcessor,
@@ -303,6 +328,19 @@ func (config *Config) Initialise() error {
 	config.TelegramFilters.NotifyViaEmail.MailClient = config.MailClient
 	// SendMail feature also shares the common mail client
 	config.Features.SendMail.MailClient = config.MailClient
+	// So does the dead man's switch that may notify trusted contacts via mail
+	config.Features.DeadManSwitch.MailClient = config.MailClient
+	/*
+		Scheduler runs app commands whose password has already been verified at the time they were scheduled, hence its
+		command processor does not use input filters. Results are not linted either, the result sink delivers them in full.
+	*/
+	config.Features.Scheduler.MailClient = config.MailClient
+	config.Features.Scheduler.CmdProcessor = &toolbox.CommandProcessor{
+		Features:       config.Features,
+		CommandFilters: []toolbox.CommandFilter{},
+		ResultFilters:  []toolbox.ResultFilter{},
+		AuditJournal:   config.AuditJournal,
+	}
 	if err := config.Features.Initialise(); err != nil {
 		return err
 	}
@@ -310,6 +348,32 @@ func (config *Config) Initialise() error {
 	return nil
 }
 
+// getCommandProcessor returns a new command processor that uses the standard filters with all configured features.
+func (config *Config) getCommandProcessor(filters *StandardFilters) *toolbox.CommandProcessor {
+	// Unless specified otherwise, encrypted result shares the same length budget as the plain text result.
+	if filters.EncryptResult.MaxLength == 0 {
+		filters.EncryptResult.MaxLength = filters.LintText.MaxLength
+	}
+	// Signed commands are verified using the passwords of PINAndShortcuts
+	filters.SignedCommand.PINAndShortcuts = &filters.PINAndShortcuts
+	return &toolbox.CommandProcessor{
+		Features: config.Features,
+		CommandFilters: []toolbox.CommandFilter{
+			&filters.SignedCommand,
+			&filters.PINAndShortcuts,
+			&filters.TranslateSequences,
+		},
+		ResultFilters: []toolbox.ResultFilter{
+			&filters.LintText,
+			&toolbox.SayEmptyOutput{}, // this is mandatory but not configured by user's config file
+			&filters.EncryptResult,
+			&filters.NotifyViaEmail,
+		},
+		AuditJournal:  config.AuditJournal,
+		LockoutLedger: config.LockoutLedger,
+	}
+}
+
 /*
 DeserialiseFromJSON deserialised configuration of all daemons and toolbox features from JSON input, and then prepares
 itself for daemon operations.
@@ -329,18 +393,7 @@ func (config *Config) DeserialiseFromJSON(in []byte) error {
 func (config *Config) GetDNSD() *dnsd.Daemon {
 	config.dnsDaemonInit.Do(func() {
 		// Assemble DNS command prcessor from features and filters
-		config.DNSDaemon.Processor = &toolbox.CommandProcessor{
-			Features: config.Features,
-			CommandFilters: []toolbox.CommandFilter{
-				&config.DNSFilters.PINAndShortcuts,
-				&config.DNSFilters.TranslateSequences,
-			},
-			ResultFilters: []toolbox.ResultFilter{
-				&config.DNSFilters.LintText,
-				&toolbox.SayEmptyOutput{}, // this is mandatory but not configured by user's config file
-				&config.DNSFilters.NotifyViaEmail,
-			},
-		}
+		config.DNSDaemon.Processor = config.getCommandProcessor(&config.DNSFilters)
 		if err := config.DNSDaemon.Initialise(); err != nil {
 			config.logger.Abort("GetDNSD", "", err, "the daemon failed to initialise")
 			return
@@ -352,18 +405,7 @@ func (config *Config) GetDNSD() *dnsd.Daemon {
 // GetSerialPortDaemon initialises serial port devices daemon instance and returns it.
 func (config *Config) GetSerialPortDaemon() *serialport.Daemon {
 	config.serialPortDaemonInit.Do(func() {
-		config.SerialPortDaemon.Processor = &toolbox.CommandProcessor{
-			Features: config.Features,
-			CommandFilters: []toolbox.CommandFilter{
-				&config.SerialPortFilters.PINAndShortcuts,
-				&config.SerialPortFilters.TranslateSequences,
-			},
-			ResultFilters: []toolbox.ResultFilter{
-				&config.SerialPortFilters.LintText,
-				&toolbox.SayEmptyOutput{}, // this is mandatory but not configured by user's config file
-				&config.SerialPortFilters.NotifyViaEmail,
-			},
-		}
+		config.SerialPortDaemon.Processor = config.getCommandProcessor(&config.SerialPortFilters)
 		if err := config.SerialPortDaemon.Initialise(); err != nil {
 			config.logger.Abort("GetSerialPortDaemon", "", err, "the daemon failed to initialise")
 			return
@@ -413,18 +455,7 @@ func (config *Config) GetMaintenance() *maintenance.Daemon {
 func (config *Config) GetHTTPD() *httpd.Daemon {
 	config.httpDaemonInit.Do(func() {
 		// Assemble command processor from features and filters
-		config.HTTPDaemon.Processor = &toolbox.CommandProcessor{
-			Features: config.Features,
-			CommandFilters: []toolbox.CommandFilter{
-				&config.HTTPFilters.PINAndShortcuts,
-				&config.HTTPFilters.TranslateSequences,
-			},
-			ResultFilters: []toolbox.ResultFilter{
-				&config.HTTPFilters.LintText,
-				&toolbox.SayEmptyOutput{}, // this is mandatory but not configured by user's config file
-				&config.HTTPFilters.NotifyViaEmail,
-			},
-		}
+		config.HTTPDaemon.Processor = config.getCommandProcessor(&config.HTTPFilters)
 		// Make handler factories
 		handlers := httpd.HandlerCollection{}
 		if config.HTTPHandlers.InformationEndpoint != "" {
@@ -559,6 +590,10 @@ func (config *Config) GetHTTPD() *httpd.Daemon {
 			// The callback handler will use the callback point that points to itself to carry on with phone conversation
 			handlers[callbackEndpoint] = &handler.HandleTwilioCallCallback{MyEndpoint: callbackEndpoint}
 		}
+		if config.HTTPHandlers.SMSGatewayEndpoint != "" {
+			smsGatewayConfig := config.HTTPHandlers.SMSGatewayEndpointConfig
+			handlers[config.HTTPHandlers.SMSGatewayEndpoint] = &smsGatewayConfig
+		}
 		if config.HTTPHandlers.AppCommandEndpoint != "" {
 			handlers[config.HTTPHandlers.AppCommandEndpoint] = &handler.HandleAppCommand{}
 		}
@@ -589,18 +624,7 @@ independent mail command runner is useful in certain scenarios, such as integrat
 func (config *Config) GetMailCommandRunner() *mailcmd.CommandRunner {
 	config.mailCommandRunnerInit.Do(func() {
 		// Assemble command processor from features and filters
-		config.MailCommandRunner.Processor = &toolbox.CommandProcessor{
-			Features: config.Features,
-			CommandFilters: []toolbox.CommandFilter{
-				&config.MailFilters.PINAndShortcuts,
-				&config.MailFilters.TranslateSequences,
-			},
-			ResultFilters: []toolbox.ResultFilter{
-				&config.MailFilters.LintText,
-				&toolbox.SayEmptyOutput{}, // this is mandatory but not configured by user's config file
-				&config.MailFilters.NotifyViaEmail,
-			},
-		}
+		config.MailCommandRunner.Processor = config.getCommandProcessor(&config.MailFilters)
 		config.MailCommandRunner.ReplyMailClient = config.MailClient
 	})
 	return config.MailCommandRunner
@@ -625,18 +649,7 @@ func (config *Config) GetMailDaemon() *smtpd.Daemon {
 // GetPhoneHomeDaemon initialises a Phone-Home daemon and returns it.
 func (config *Config) GetPhoneHomeDaemon() *phonehome.Daemon {
 	config.phoneHomeDaemonInit.Do(func() {
-		config.PhoneHomeDaemon.Processor = &toolbox.CommandProcessor{
-			Features: config.Features,
-			CommandFilters: []toolbox.CommandFilter{
-				&config.PhoneHomeFilters.PINAndShortcuts,
-				&config.PhoneHomeFilters.TranslateSequences,
-			},
-			ResultFilters: []toolbox.ResultFilter{
-				&config.PhoneHomeFilters.LintText,
-				&toolbox.SayEmptyOutput{}, // this is mandatory but not configured by user's config file
-				&config.PhoneHomeFilters.NotifyViaEmail,
-			},
-		}
+		config.PhoneHomeDaemon.Processor = config.getCommandProcessor(&config.PhoneHomeFilters)
 		// Call initialise so that daemon is ready to start
 		if err := config.PhoneHomeDaemon.Initialise(); err != nil {
 			config.logger.Abort("GetPhoneHomeDaemon", "", err, "the daemon failed to initialise")
@@ -653,18 +666,7 @@ It will use common mail client for sending outgoing emails.
 func (config *Config) GetPlainSocketDaemon() *plainsocket.Daemon {
 	config.plainSocketDaemonInit.Do(func() {
 		// Assemble command processor from features and filters
-		config.PlainSocketDaemon.Processor = &toolbox.CommandProcessor{
-			Features: config.Features,
-			CommandFilters: []toolbox.CommandFilter{
-				&config.PlainSocketFilters.PINAndShortcuts,
-				&config.PlainSocketFilters.TranslateSequences,
-			},
-			ResultFilters: []toolbox.ResultFilter{
-				&config.PlainSocketFilters.LintText,
-				&toolbox.SayEmptyOutput{}, // this is mandatory but not configured by user's config file
-				&config.PlainSocketFilters.NotifyViaEmail,
-			},
-		}
+		config.PlainSocketDaemon.Processor = config.getCommandProcessor(&config.PlainSocketFilters)
 		// Call initialise so that daemon is ready to start
 		if err := config.PlainSocketDaemon.Initialise(); err != nil {
 			config.logger.Abort("GetPlainSocketDaemon", "", err, "the daemon failed to initialise")
@@ -690,18 +692,7 @@ func (config *Config) GetSockDaemon() *sockd.Daemon {
 func (config *Config) GetTelegramBot() *telegrambot.Daemon {
 	config.telegramBotInit.Do(func() {
 		// Assemble telegram bot from features and filters
-		config.TelegramBot.Processor = &toolbox.CommandProcessor{
-			Features: config.Features,
-			CommandFilters: []toolbox.CommandFilter{
-				&config.TelegramFilters.PINAndShortcuts,
-				&config.TelegramFilters.TranslateSequences,
-			},
-			ResultFilters: []toolbox.ResultFilter{
-				&config.TelegramFilters.LintText,
-				&toolbox.SayEmptyOutput{}, // this is mandatory but not configured by user's config file
-				&config.TelegramFilters.NotifyViaEmail,
-			},
-		}
+		config.TelegramBot.Processor = config.getCommandProcessor(&config.TelegramFilters)
 		if err := config.TelegramBot.Initialise(); err != nil {
 			config.logger.Abort("GetTelegramBot", "", err, "the daemon failed to initialise")
 			return
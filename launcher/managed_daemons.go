@@ -0,0 +1,110 @@
+package launcher
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/HouzuoGuo/laitos/misc"
+)
+
+// listenAddr returns the host:port/protocol notation of a listening address.
+func listenAddr(host string, port int, protocol string) string {
+	return net.JoinHostPort(host, strconv.Itoa(port)) + "/" + protocol
+}
+
+/*
+RegisterManagedDaemon initialises the daemon of the name and registers it as a managed daemon, so that it may be started,
+stopped, and restarted individually. The daemon is not started yet.
+*/
+func (config *Config) RegisterManagedDaemon(daemonName string) (*misc.ManagedDaemon, error) {
+	managed := &misc.ManagedDaemon{Name: daemonName}
+	switch daemonName {
+	case DNSDName:
+		daemon := config.GetDNSD()
+		managed.Addresses = []string{listenAddr(daemon.Address, daemon.TCPPort, "tcp"), listenAddr(daemon.Address, daemon.UDPPort, "udp")}
+		managed.Stats = []*misc.Stats{misc.DNSDStatsTCP, misc.DNSDStatsUDP}
+		managed.StartAndBlock, managed.Stop = daemon.StartAndBlock, daemon.Stop
+	case HTTPDName:
+		daemon := config.GetHTTPD()
+		managed.Addresses = []string{listenAddr(daemon.Address, daemon.Port, "tcp")}
+		managed.Stats = []*misc.Stats{misc.HTTPDStats}
+		managed.StartAndBlock, managed.Stop = daemon.StartAndBlockWithTLS, daemon.StopTLS
+		// The app command endpoint keeps receiving the command that lifts lock-down
+		managed.ServesUnlock = true
+	case InsecureHTTPDName:
+		daemon := config.GetHTTPD()
+		managed.Stats = []*misc.Stats{misc.HTTPDStats}
+		/*
+			There is not an independent port settings for launching both TLS-enabled and TLS-free HTTP servers
+			at the same time. If user really wishes to launch both at the same time, the TLS-free HTTP server
+			will fallback to use port number 80.
+		*/
+		managed.StartAndBlock = func() error {
+			return daemon.StartAndBlockNoTLS(80)
+		}
+		managed.Stop = daemon.StopNoTLS
+		managed.ServesUnlock = true
+	case MaintenanceName:
+		daemon := config.GetMaintenance()
+		managed.StartAndBlock, managed.Stop = daemon.StartAndBlock, daemon.Stop
+		// The maintenance routine may also be triggered on demand by an app command
+		config.Features.EnvControl.RunMaintenance = func() string {
+			out, _ := daemon.Execute()
+			return out
+		}
+	case PhoneHomeName:
+		daemon := config.GetPhoneHomeDaemon()
+		managed.StartAndBlock, managed.Stop = daemon.StartAndBlock, daemon.Stop
+	case PlainSocketName:
+		daemon := config.GetPlainSocketDaemon()
+		managed.Addresses = []string{listenAddr(daemon.Address, daemon.TCPPort, "tcp"), listenAddr(daemon.Address, daemon.UDPPort, "udp")}
+		managed.Stats = []*misc.Stats{misc.PlainSocketStatsTCP, misc.PlainSocketStatsUDP}
+		managed.StartAndBlock, managed.Stop = daemon.StartAndBlock, daemon.Stop
+	case SerialPortDaemonName:
+		daemon := config.GetSerialPortDaemon()
+		managed.Addresses = daemon.DeviceGlobPatterns
+		managed.Stats = []*misc.Stats{misc.SerialDevicesStats}
+		managed.StartAndBlock, managed.Stop = daemon.StartAndBlock, daemon.Stop
+	case SimpleIPSvcName:
+		daemon := config.GetSimpleIPSvcD()
+		for _, port := range []int{daemon.ActiveUsersPort, daemon.DayTimePort, daemon.QOTDPort} {
+			if port > 0 {
+				managed.Addresses = append(managed.Addresses, listenAddr(daemon.Address, port, "tcp"), listenAddr(daemon.Address, port, "udp"))
+			}
+		}
+		managed.Stats = []*misc.Stats{misc.SimpleIPStatsTCP, misc.SimpleIPStatsUDP}
+		managed.StartAndBlock, managed.Stop = daemon.StartAndBlock, daemon.Stop
+	case SMTPDName:
+		daemon := config.GetMailDaemon()
+		managed.Addresses = []string{listenAddr(daemon.Address, daemon.Port, "tcp")}
+		managed.Stats = []*misc.Stats{misc.SMTPDStats}
+		managed.StartAndBlock, managed.Stop = daemon.StartAndBlock, daemon.Stop
+	case SNMPDName:
+		daemon := config.GetSNMPD()
+		managed.Addresses = []string{listenAddr(daemon.Address, daemon.Port, "udp")}
+		managed.Stats = []*misc.Stats{misc.SNMPStats}
+		managed.StartAndBlock, managed.Stop = daemon.StartAndBlock, daemon.Stop
+	case SOCKDName:
+		daemon := config.GetSockDaemon()
+		for _, port := range daemon.TCPPorts {
+			managed.Addresses = append(managed.Addresses, listenAddr(daemon.Address, port, "tcp"))
+		}
+		for _, port := range daemon.UDPPorts {
+			managed.Addresses = append(managed.Addresses, listenAddr(daemon.Address, port, "udp"))
+		}
+		managed.Stats = []*misc.Stats{misc.SOCKDStatsTCP, misc.SOCKDStatsUDP}
+		managed.StartAndBlock, managed.Stop = daemon.StartAndBlock, daemon.Stop
+	case TelegramName:
+		daemon := config.GetTelegramBot()
+		managed.Stats = []*misc.Stats{misc.TelegramBotStats}
+		managed.StartAndBlock, managed.Stop = daemon.StartAndBlock, daemon.Stop
+	case AutoUnlockName:
+		daemon := config.GetAutoUnlock()
+		managed.Stats = []*misc.Stats{misc.AutoUnlockStats}
+		managed.StartAndBlock, managed.Stop = daemon.StartAndBlock, daemon.Stop
+	default:
+		return nil, fmt.Errorf("unknown daemon name \"%s\"", daemonName)
+	}
+	return misc.RegisterDaemon(managed), nil
+}
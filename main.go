@@ -38,6 +38,7 @@ import (
 	"github.com/HouzuoGuo/laitos/launcher/passwdserver"
 	"github.com/HouzuoGuo/laitos/misc"
 	"github.com/HouzuoGuo/laitos/platform"
+	"github.com/HouzuoGuo/laitos/toolbox"
 	"github.com/aws/aws-xray-sdk-go/awsplugins/beanstalk"
 	"github.com/aws/aws-xray-sdk-go/awsplugins/ec2"
 	"github.com/aws/aws-xray-sdk-go/awsplugins/ecs"
@@ -100,6 +101,113 @@ func EncryptFile(filePath string) {
 	}
 }
 
+/*
+EncryptFileWithKeySlots is a distinct routine of laitos main program, it reads one or more passwords from standard input
+and uses them to encrypt the input file in-place, so that the AESDecrypt app may decrypt the file using any of the
+passwords.
+*/
+func EncryptFileWithKeySlots(filePath string) {
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		lalog.DefaultLogger.Abort("EncryptFileWithKeySlots", "main", err, "failed to read file")
+		return
+	}
+	if misc.IsEncryptedWithKeySlots(content) {
+		lalog.DefaultLogger.Abort("EncryptFileWithKeySlots", "main", nil, "the file is already encrypted")
+		return
+	}
+	reader := bufio.NewReader(os.Stdin)
+	passwords := make([][]byte, 0)
+	platform.SetTermEcho(false)
+	for len(passwords) < misc.MaxKeySlots {
+		fmt.Printf("Please enter the password of key slot %d, or an empty line to finish (no echo):\n", len(passwords)+1)
+		password, _, err := reader.ReadLine()
+		if err != nil {
+			platform.SetTermEcho(true)
+			lalog.DefaultLogger.Abort("EncryptFileWithKeySlots", "main", err, "failed to read password")
+			return
+		}
+		password = []byte(strings.TrimSpace(string(password)))
+		if len(password) == 0 {
+			break
+		}
+		passwords = append(passwords, password)
+	}
+	platform.SetTermEcho(true)
+	encrypted, err := misc.EncryptWithKeySlots(content, passwords, misc.KeySlotKDFIterations)
+	if err != nil {
+		lalog.DefaultLogger.Abort("EncryptFileWithKeySlots", "main", err, "failed to encrypt file")
+		return
+	}
+	if err := ioutil.WriteFile(filePath, encrypted, 0600); err != nil {
+		lalog.DefaultLogger.Abort("EncryptFileWithKeySlots", "main", err, "failed to encrypt file")
+		return
+	}
+	lalog.DefaultLogger.Info("EncryptFileWithKeySlots", "main", nil, "successfully encrypted the file with %d key slots", len(passwords))
+}
+
+/*
+DecryptResult is a distinct routine of laitos main program, it reads password from standard input, and then uses it to
+decrypt each line of encrypted app command result that follows.
+*/
+func DecryptResult() {
+	reader := bufio.NewReader(os.Stdin)
+	fmt.Println("Please enter the password that was used to run the app command (no echo):")
+	platform.SetTermEcho(false)
+	password, _, err := reader.ReadLine()
+	platform.SetTermEcho(true)
+	if err != nil {
+		lalog.DefaultLogger.Abort("DecryptResult", "main", err, "failed to read password")
+		return
+	}
+	fmt.Println("Please enter encrypted app command results, one per line:")
+	for {
+		line, err := reader.ReadString('\n')
+		if line = strings.TrimSpace(line); line != "" {
+			if text, decryptErr := toolbox.DecryptResultText(strings.TrimSpace(string(password)), line); decryptErr == nil {
+				fmt.Println(text)
+			} else {
+				fmt.Println("Error: " + decryptErr.Error())
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+/*
+SignCommands is a distinct routine of laitos main program, it reads password from standard input, and then uses it to
+sign each line of app command that follows. The prompts go to standard error, so that scripts may capture the signed
+commands from standard output.
+*/
+func SignCommands() {
+	reader := bufio.NewReader(os.Stdin)
+	fmt.Fprintln(os.Stderr, "Please enter the password to sign app commands with (no echo):")
+	platform.SetTermEcho(false)
+	password, _, err := reader.ReadLine()
+	platform.SetTermEcho(true)
+	if err != nil {
+		lalog.DefaultLogger.Abort("SignCommands", "main", err, "failed to read password")
+		return
+	}
+	fmt.Fprintln(os.Stderr, "Please enter app commands (without password), one per line:")
+	for {
+		line, err := reader.ReadString('\n')
+		if line = strings.TrimSpace(line); line != "" {
+			signedCmd, signErr := toolbox.SignCommand(strings.TrimSpace(string(password)), line, time.Now())
+			if signErr != nil {
+				lalog.DefaultLogger.Abort("SignCommands", "main", signErr, "failed to sign app command")
+				return
+			}
+			fmt.Println(signedCmd)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
 /*
 StartPasswordWebServer is a distinct routine of laitos main program, it starts a simple web server to accept a password
 input in order to decrypt laitos program data and launch the daemons.
@@ -114,7 +222,7 @@ func StartPasswordWebServer(port int, url string) {
 		version, which means the web server often runs into port conflicts when its updated version starts up.
 		AutoRestart function helps to restart the server.
 	*/
-	AutoRestart(logger, "StartPasswordWebServer", ws.Start)
+	misc.AutoRestart(logger, "StartPasswordWebServer", ws.Start)
 	// Wait indefinitely upon success, because this function is the main function of the web server.
 	select {}
 }
@@ -124,6 +232,12 @@ main runs one of several distinct routines according to the presented combinatio
 
 - Maintain encrypted program data files: -datautil=encrypt|decrypt
 
+- Encrypt a file for AESDecrypt app, the file may be decrypted by any of several passwords: -datautil=encryptkeyslots
+
+- Decrypt app command results that were encrypted by EncryptResult filter: -datautil=decryptresult
+
+- Sign app commands for daemons that use SignedCommand filter: -datautil=signcommand
+
 - Launch a simple web server to let user enter program data decryption password, and then proceeds to launch laitos with supervisor:
   -pwdserver -pwdserverport=12345 -pwdserverurl=/my-password-input-page
 	This routine is useful when some program data files such as configuration JSON or TLS certificate key are encrypted.
@@ -164,8 +278,8 @@ func main() {
 	flag.StringVar(&pwdServerURL, passwdserver.CLIFlag+"url", "", "(Optional) password input URL")
 	// Data encryption utility flags
 	var dataUtil, dataUtilFile string
-	flag.StringVar(&dataUtil, "datautil", "", "(Optional) program data encryption utility: encrypt|decrypt")
-	flag.StringVar(&dataUtilFile, "datautilfile", "", "(Optional) program data encryption utility: encrypt/decrypt file location")
+	flag.StringVar(&dataUtil, "datautil", "", "(Optional) program data encryption utility: encrypt|decrypt|encryptkeyslots|decryptresult|signcommand")
+	flag.StringVar(&dataUtilFile, "datautilfile", "", "(Optional) program data encryption utility: encrypt/decrypt/encryptkeyslots file location")
 	// Internal supervisor flag
 	var isSupervisor = true
 	flag.BoolVar(&isSupervisor, launcher.SupervisorFlagName, true, "(Internal use only) launch a supervisor process to auto-restart laitos main process in case of crash")
@@ -182,6 +296,14 @@ func main() {
 	// ========================================================================
 	// Utility routines - maintain encrypted laitos program data, no need to run any daemon.
 	// ========================================================================
+	switch dataUtil {
+	case "decryptresult":
+		DecryptResult()
+		return
+	case "signcommand":
+		SignCommands()
+		return
+	}
 	if dataUtil != "" {
 		if dataUtilFile == "" {
 			logger.Abort("main", "", nil, "please provide data utility target file in parameter \"-datautilfile\"")
@@ -192,8 +314,10 @@ func main() {
 			EncryptFile(dataUtilFile)
 		case "decrypt":
 			DecryptFile(dataUtilFile)
+		case "encryptkeyslots":
+			EncryptFileWithKeySlots(dataUtilFile)
 		default:
-			logger.Abort("main", "", nil, "please provide mode of operation (encrypt|decrypt) for parameter \"-datautil\"")
+			logger.Abort("main", "", nil, "please provide mode of operation (encrypt|decrypt|encryptkeyslots) for parameter \"-datautil\"")
 		}
 		return
 	}
@@ -337,42 +461,23 @@ func main() {
 	CopyNonEssentialUtilitiesInBackground()
 	InstallOptionalLoggerSQSCallback()
 
+	/*
+		Register all daemons before starting any of them, so that the daemons and the maintenance routine may be
+		controlled by app commands as soon as the first daemon starts.
+	*/
+	managedDaemons := make([]*misc.ManagedDaemon, 0, len(daemonNames))
 	for _, daemonName := range daemonNames {
+		managed, err := config.RegisterManagedDaemon(daemonName)
+		if err != nil {
+			logger.Warning("main", daemonName, err, "failed to register the daemon")
+			continue
+		}
+		managedDaemons = append(managedDaemons, managed)
+	}
+	for _, managed := range managedDaemons {
 		// Daemons are started asynchronously and the order does not matter
-		switch daemonName {
-		case launcher.DNSDName:
-			go AutoRestart(logger, daemonName, config.GetDNSD().StartAndBlock)
-		case launcher.HTTPDName:
-			go AutoRestart(logger, daemonName, config.GetHTTPD().StartAndBlockWithTLS)
-		case launcher.InsecureHTTPDName:
-			/*
-				There is not an independent port settings for launching both TLS-enabled and TLS-free HTTP servers
-				at the same time. If user really wishes to launch both at the same time, the TLS-free HTTP server
-				will fallback to use port number 80.
-			*/
-			go AutoRestart(logger, daemonName, func() error {
-				return config.GetHTTPD().StartAndBlockNoTLS(80)
-			})
-		case launcher.MaintenanceName:
-			go AutoRestart(logger, daemonName, config.GetMaintenance().StartAndBlock)
-		case launcher.PhoneHomeName:
-			go AutoRestart(logger, daemonName, config.GetPhoneHomeDaemon().StartAndBlock)
-		case launcher.PlainSocketName:
-			go AutoRestart(logger, daemonName, config.GetPlainSocketDaemon().StartAndBlock)
-		case launcher.SerialPortDaemonName:
-			go AutoRestart(logger, daemonName, config.GetSerialPortDaemon().StartAndBlock)
-		case launcher.SimpleIPSvcName:
-			go AutoRestart(logger, daemonName, config.GetSimpleIPSvcD().StartAndBlock)
-		case launcher.SMTPDName:
-			go AutoRestart(logger, daemonName, config.GetMailDaemon().StartAndBlock)
-		case launcher.SNMPDName:
-			go AutoRestart(logger, daemonName, config.GetSNMPD().StartAndBlock)
-		case launcher.SOCKDName:
-			go AutoRestart(logger, daemonName, config.GetSockDaemon().StartAndBlock)
-		case launcher.TelegramName:
-			go AutoRestart(logger, daemonName, config.GetTelegramBot().StartAndBlock)
-		case launcher.AutoUnlockName:
-			go AutoRestart(logger, daemonName, config.GetAutoUnlock().StartAndBlock)
+		if err := managed.Start(); err != nil {
+			logger.Warning("main", managed.Name, err, "failed to start the daemon")
 		}
 	}
 
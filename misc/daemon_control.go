@@ -0,0 +1,257 @@
+package misc
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HouzuoGuo/laitos/lalog"
+)
+
+// ManagedDaemonStopTimeoutSec is the maximum number of seconds to wait for a daemon to stop.
+const ManagedDaemonStopTimeoutSec = 20
+
+var (
+	// ErrManagedDaemonNotFound is returned when there is not a managed daemon of the requested name.
+	ErrManagedDaemonNotFound = errors.New("the daemon is not launched by this program")
+
+	managedDaemons      = make(map[string]*ManagedDaemon)
+	managedDaemonsMutex = new(sync.Mutex)
+)
+
+/*
+ManagedDaemon is a daemon launched by the main program. The daemon is restarted automatically if it fails, and it may
+be stopped, started, and restarted individually while the program keeps running.
+*/
+type ManagedDaemon struct {
+	Name          string       // Name is the daemon name, e.g. "dnsd".
+	Addresses     []string     // Addresses are the network addresses the daemon listens on, e.g. "0.0.0.0:53/udp".
+	Stats         []*Stats     // Stats are the statistics collected by the daemon.
+	StartAndBlock func() error // StartAndBlock runs the daemon and blocks until it stops.
+	Stop          func()       // Stop tells the daemon to stop, so that StartAndBlock returns.
+	// ServesUnlock is true if the daemon carries the app command that lifts lock-down, it keeps running during a full lock-down.
+	ServesUnlock bool
+
+	running       bool
+	stopRequested bool
+	// stoppedByLockDown is true if the daemon stopped due to lock-down, it is started again when lock-down is lifted.
+	stoppedByLockDown bool
+	startedAt         time.Time
+	lastErr           error
+	done              chan struct{}
+	mutex             *sync.Mutex
+	logger            lalog.Logger
+}
+
+// RegisterDaemon adds the daemon to the table of managed daemons, it replaces a daemon previously registered under the same name.
+func RegisterDaemon(daemon *ManagedDaemon) *ManagedDaemon {
+	daemon.mutex = new(sync.Mutex)
+	daemon.logger = lalog.Logger{ComponentName: "ManagedDaemon", ComponentID: []lalog.LoggerIDField{{Key: "Name", Value: daemon.Name}}}
+	managedDaemonsMutex.Lock()
+	defer managedDaemonsMutex.Unlock()
+	managedDaemons[daemon.Name] = daemon
+	return daemon
+}
+
+// GetManagedDaemon returns the managed daemon of the name, or nil if there is none.
+func GetManagedDaemon(name string) *ManagedDaemon {
+	managedDaemonsMutex.Lock()
+	defer managedDaemonsMutex.Unlock()
+	return managedDaemons[name]
+}
+
+// GetManagedDaemons returns all managed daemons sorted by name.
+func GetManagedDaemons() []*ManagedDaemon {
+	managedDaemonsMutex.Lock()
+	ret := make([]*ManagedDaemon, 0, len(managedDaemons))
+	for _, daemon := range managedDaemons {
+		ret = append(ret, daemon)
+	}
+	managedDaemonsMutex.Unlock()
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+	return ret
+}
+
+// Start runs the daemon in a background goroutine, and restarts it in case of failure.
+func (daemon *ManagedDaemon) Start() error {
+	daemon.mutex.Lock()
+	defer daemon.mutex.Unlock()
+	if daemon.running {
+		return fmt.Errorf("daemon %s is already running", daemon.Name)
+	}
+	daemon.running = true
+	daemon.stopRequested = false
+	daemon.stoppedByLockDown = false
+	daemon.startedAt = time.Now()
+	daemon.lastErr = nil
+	daemon.done = make(chan struct{})
+	go daemon.run(daemon.done)
+	return nil
+}
+
+/*
+AutoRestart runs the input function and restarts it when it returns an error, subjected to increasing delay of up to 60
+seconds between each restart, just like a managed daemon, except that it is not registered among the managed daemons.
+If the input function crashes in a panic, there won't be an auto-restart.
+The function returns to the caller only after the input function returns nil, or emergency lock-down is activated.
+*/
+func AutoRestart(logger lalog.Logger, logActorName string, fun func() error) {
+	daemon := &ManagedDaemon{
+		Name:          logActorName,
+		StartAndBlock: fun,
+		Stop:          func() {},
+		mutex:         new(sync.Mutex),
+		logger:        logger,
+	}
+	// The daemon is freshly made, therefore it cannot be already running.
+	_ = daemon.Start()
+	<-daemon.done
+}
+
+// run calls StartAndBlock repeatedly until it returns successfully, the daemon is told to stop, or lock-down is activated.
+func (daemon *ManagedDaemon) run(done chan struct{}) {
+	defer func() {
+		daemon.mutex.Lock()
+		daemon.running = false
+		daemon.mutex.Unlock()
+		close(done)
+	}()
+	delaySec := 0
+	for {
+		if daemon.isLockedDown() {
+			daemon.logger.Warning("run", "", nil, "emergency lock-down has been activated, no further restart is performed.")
+			daemon.mutex.Lock()
+			daemon.stoppedByLockDown = true
+			daemon.mutex.Unlock()
+			return
+		}
+		err := daemon.StartAndBlock()
+		daemon.mutex.Lock()
+		daemon.lastErr = err
+		stopRequested := daemon.stopRequested
+		daemon.mutex.Unlock()
+		if daemon.isLockedDown() {
+			// Let the check at the top of the loop record the lock-down
+			continue
+		} else if stopRequested {
+			daemon.logger.Info("run", "", err, "the daemon has stopped upon request")
+			return
+		} else if err == nil {
+			daemon.logger.Info("run", "", nil, "the daemon has returned successfully, no further restart is required.")
+			return
+		}
+		if delaySec == 0 {
+			daemon.logger.Warning("run", "", err, "restarting immediately")
+		} else {
+			daemon.logger.Warning("run", "", err, "restarting in %d seconds", delaySec)
+		}
+		time.Sleep(time.Duration(delaySec) * time.Second)
+		if delaySec < 60 {
+			delaySec += 10
+		}
+		daemon.mutex.Lock()
+		stopRequested = daemon.stopRequested
+		daemon.startedAt = time.Now()
+		daemon.mutex.Unlock()
+		if stopRequested {
+			return
+		}
+	}
+}
+
+/*
+StopAndWait tells the daemon to stop and waits for it to stop. Some daemons only notice the instruction to stop when
+they are fully started, therefore the instruction is repeated every second until the daemon stops or the wait times out.
+*/
+func (daemon *ManagedDaemon) StopAndWait() error {
+	daemon.mutex.Lock()
+	if !daemon.running {
+		daemon.mutex.Unlock()
+		return fmt.Errorf("daemon %s is not running", daemon.Name)
+	}
+	daemon.stopRequested = true
+	done := daemon.done
+	daemon.mutex.Unlock()
+	timeout := time.After(ManagedDaemonStopTimeoutSec * time.Second)
+	go func() {
+		// The daemon may be asked to stop by one of its own connections, hence do not wait for Stop to return.
+		for {
+			daemon.Stop()
+			select {
+			case <-done:
+				return
+			case <-timeout:
+				return
+			case <-time.After(1 * time.Second):
+			}
+		}
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-time.After(ManagedDaemonStopTimeoutSec * time.Second):
+		return fmt.Errorf("daemon %s did not stop in %d seconds", daemon.Name, ManagedDaemonStopTimeoutSec)
+	}
+}
+
+// Restart stops the daemon if it is running, and then starts it again.
+func (daemon *ManagedDaemon) Restart() error {
+	if daemon.IsRunning() {
+		if err := daemon.StopAndWait(); err != nil {
+			return err
+		}
+	}
+	return daemon.Start()
+}
+
+// isLockedDown returns true if the daemon should stop running due to lock-down.
+func (daemon *ManagedDaemon) isLockedDown() bool {
+	if daemon.ServesUnlock {
+		return IsDaemonInScopedLockDown(daemon.Name)
+	}
+	return IsDaemonLockedDown(daemon.Name)
+}
+
+// isStoppedByLockDown returns true if the daemon stopped due to lock-down and has not been started since.
+func (daemon *ManagedDaemon) isStoppedByLockDown() bool {
+	daemon.mutex.Lock()
+	defer daemon.mutex.Unlock()
+	return !daemon.running && daemon.stoppedByLockDown
+}
+
+// IsRunning returns true if the daemon has started and has not yet stopped.
+func (daemon *ManagedDaemon) IsRunning() bool {
+	daemon.mutex.Lock()
+	defer daemon.mutex.Unlock()
+	return daemon.running
+}
+
+// String returns the daemon name, status, uptime, addresses, statistics, and the latest error in a line of text.
+func (daemon *ManagedDaemon) String() string {
+	daemon.mutex.Lock()
+	defer daemon.mutex.Unlock()
+	var ret strings.Builder
+	ret.WriteString(daemon.Name)
+	if daemon.running {
+		ret.WriteString(fmt.Sprintf(" up %s", time.Since(daemon.startedAt).Truncate(time.Second)))
+	} else if daemon.stoppedByLockDown {
+		ret.WriteString(" stopped by lock-down")
+	} else {
+		ret.WriteString(" stopped")
+	}
+	if len(daemon.Addresses) > 0 {
+		ret.WriteString(" on " + strings.Join(daemon.Addresses, ","))
+	}
+	for _, stats := range daemon.Stats {
+		ret.WriteString(" " + stats.Format(1000000000.0, 2))
+	}
+	if daemon.lastErr != nil {
+		ret.WriteString(" last error: " + daemon.lastErr.Error())
+	}
+	return ret.String()
+}
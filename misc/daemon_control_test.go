@@ -0,0 +1,154 @@
+package misc
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/HouzuoGuo/laitos/lalog"
+)
+
+// testDaemon runs until it is told to stop, it fails in the first round if failFirst is set.
+type testDaemon struct {
+	stop      chan struct{}
+	rounds    int32
+	failFirst bool
+}
+
+func (daemon *testDaemon) StartAndBlock() error {
+	if atomic.AddInt32(&daemon.rounds, 1) == 1 && daemon.failFirst {
+		return errors.New("first round fails")
+	}
+	<-daemon.stop
+	return nil
+}
+
+func (daemon *testDaemon) Stop() {
+	select {
+	case daemon.stop <- struct{}{}:
+	default:
+	}
+}
+
+func TestManagedDaemon(t *testing.T) {
+	daemon := &testDaemon{stop: make(chan struct{}), failFirst: true}
+	managed := RegisterDaemon(&ManagedDaemon{
+		Name:          "test-daemon",
+		Addresses:     []string{"127.0.0.1:12345/tcp"},
+		Stats:         []*Stats{NewStats()},
+		StartAndBlock: daemon.StartAndBlock,
+		Stop:          daemon.Stop,
+	})
+	if GetManagedDaemon("test-daemon") != managed || GetManagedDaemon("does-not-exist") != nil {
+		t.Fatal("failed to find daemon")
+	}
+	if daemons := GetManagedDaemons(); len(daemons) != 1 || daemons[0] != managed {
+		t.Fatal(daemons)
+	}
+	if err := managed.StopAndWait(); err == nil {
+		t.Fatal("should not be able to stop a daemon that is not running")
+	}
+	// The daemon is restarted immediately after the first failure
+	if err := managed.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := managed.Start(); err == nil {
+		t.Fatal("should not be able to start the daemon twice")
+	}
+	time.Sleep(500 * time.Millisecond)
+	if !managed.IsRunning() || atomic.LoadInt32(&daemon.rounds) != 2 {
+		t.Fatal(daemon.rounds)
+	}
+	if status := managed.String(); !strings.Contains(status, "test-daemon up") || !strings.Contains(status, "127.0.0.1:12345/tcp") ||
+		!strings.Contains(status, "(0)") || !strings.Contains(status, "first round fails") {
+		t.Fatal(status)
+	}
+	// Stop the daemon
+	if err := managed.StopAndWait(); err != nil {
+		t.Fatal(err)
+	}
+	if managed.IsRunning() || !strings.Contains(managed.String(), "test-daemon stopped") {
+		t.Fatal(managed.String())
+	}
+	// Restart a stopped daemon, and then restart it while it runs
+	if err := managed.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	if err := managed.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	if !managed.IsRunning() || atomic.LoadInt32(&daemon.rounds) != 4 {
+		t.Fatal(daemon.rounds)
+	}
+	if err := managed.StopAndWait(); err != nil {
+		t.Fatal(err)
+	}
+	managedDaemonsMutex.Lock()
+	delete(managedDaemons, "test-daemon")
+	managedDaemonsMutex.Unlock()
+}
+
+func TestAutoRestart(t *testing.T) {
+	// The sample function returns error three times and then returns nothing.
+	sampleRound := 0
+	sampleFun := func() error {
+		if sampleRound <= 2 {
+			sampleRound++
+			return fmt.Errorf("round %d", sampleRound)
+		}
+		return nil
+	}
+	var returnedFromRestart bool
+	go func() {
+		AutoRestart(lalog.Logger{}, "sample", sampleFun)
+		returnedFromRestart = true
+	}()
+	// Round 0 quits with an error and it is immediately restarted
+	time.Sleep(1 * time.Second)
+	// Round 1 quits with an error
+	if sampleRound != 2 {
+		t.Fatal(sampleRound)
+	}
+	// Round 2 is started after 10 seconds
+	time.Sleep(10 * time.Second)
+	// Round 2 quits with an error
+	if sampleRound != 3 {
+		t.Fatal(sampleRound)
+	}
+	// Round 3 is started after 20 seconds
+	time.Sleep(20 * time.Second)
+	if sampleRound != 3 {
+		t.Fatal(sampleRound)
+	}
+	// Round 3 quits successfuly, no further restart is required.
+	if !returnedFromRestart {
+		t.Fatal("did not return")
+	}
+}
+
+func TestAutoRestartDuringLockDown(t *testing.T) {
+	sampleFun := func() error {
+		return errors.New("sample function error")
+	}
+	var returnedFromRestart bool
+	go func() {
+		AutoRestart(lalog.Logger{}, "sample", sampleFun)
+		returnedFromRestart = true
+	}()
+	// Turn on emergency lock-down
+	EmergencyLockDown = true
+	defer func() {
+		EmergencyLockDown = false
+	}()
+	// AutoRestart keeps the sample function ablive, but it shall quit after emergency lock-down.
+	// Wait past the second restart
+	time.Sleep(15 * time.Second)
+	if !returnedFromRestart {
+		t.Fatal("did not return")
+	}
+}
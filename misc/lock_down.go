@@ -0,0 +1,241 @@
+package misc
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrBadUnlockSecret is returned when the secret given to lift lock-down is incorrect.
+	ErrBadUnlockSecret = errors.New("incorrect unlock secret")
+
+	// lockDownConfig is the lock-down persistence and unlock configuration, it is nil if unconfigured.
+	lockDownConfig *LockDownConfig
+	// lockDownDaemons are the names of daemons that are locked down in a scoped lock-down.
+	lockDownDaemons []string
+	// lockDownTriggers are the app triggers that are locked down in a scoped lock-down.
+	lockDownTriggers []string
+	// lockDownSince is the time at which the latest lock-down began.
+	lockDownSince time.Time
+	lockDownMutex = new(sync.Mutex)
+)
+
+/*
+LockDownState is the emergency lock-down persisted on disk. A full lock-down disables all daemons and apps, whereas a
+scoped lock-down only disables the listed daemons and apps.
+*/
+type LockDownState struct {
+	Full     bool      `json:"Full"`
+	Daemons  []string  `json:"Daemons"`
+	Triggers []string  `json:"Triggers"`
+	Since    time.Time `json:"Since"`
+}
+
+// String returns a human-readable description of the lock-down.
+func (state LockDownState) String() string {
+	if state.Full {
+		return fmt.Sprintf("full lock-down since %s", state.Since.Format(time.RFC3339))
+	}
+	if len(state.Daemons) == 0 && len(state.Triggers) == 0 {
+		return "not locked down"
+	}
+	return fmt.Sprintf("lock-down of daemons [%s] and apps [%s] since %s",
+		strings.Join(state.Daemons, ","), strings.Join(state.Triggers, ","), state.Since.Format(time.RFC3339))
+}
+
+// LockDownConfig tells where to persist emergency lock-down, and the secret that lifts lock-down.
+type LockDownConfig struct {
+	// StateFilePath is the path to the file that keeps the lock-down state, so that lock-down survives restarts.
+	StateFilePath string `json:"StateFilePath"`
+	// UnlockSecret is the secret that lifts lock-down, it should be different from all password PINs.
+	UnlockSecret string `json:"UnlockSecret"`
+}
+
+// IsConfigured returns true only if lock-down persistence or unlock secret is configured.
+func (conf *LockDownConfig) IsConfigured() bool {
+	return conf != nil && (conf.StateFilePath != "" || conf.UnlockSecret != "")
+}
+
+/*
+Initialise remembers the configuration for the program to use, and honours the lock-down persisted by a previous run
+of the program. Call this function before starting daemons.
+*/
+func (conf *LockDownConfig) Initialise() error {
+	lockDownMutex.Lock()
+	defer lockDownMutex.Unlock()
+	lockDownConfig = conf
+	if conf.StateFilePath == "" {
+		return nil
+	}
+	content, err := ioutil.ReadFile(conf.StateFilePath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("LockDownConfig.Initialise: failed to read state file - %v", err)
+	}
+	var state LockDownState
+	if err := json.Unmarshal(content, &state); err != nil {
+		return fmt.Errorf("LockDownConfig.Initialise: failed to deserialise state file - %v", err)
+	}
+	EmergencyLockDown = state.Full
+	lockDownDaemons, lockDownTriggers, lockDownSince = state.Daemons, state.Triggers, state.Since
+	if state.Full || len(state.Daemons) > 0 || len(state.Triggers) > 0 {
+		logger.Warning("Initialise", "", nil, "the %s persisted by a previous run is in effect", state.String())
+	}
+	return nil
+}
+
+// saveLockDownState writes the lock-down state into the state file if it is configured. The caller must lock the mutex.
+func saveLockDownState() error {
+	if lockDownConfig == nil || lockDownConfig.StateFilePath == "" {
+		return nil
+	}
+	state := getLockDownState()
+	if !state.Full && len(state.Daemons) == 0 && len(state.Triggers) == 0 {
+		if err := os.Remove(lockDownConfig.StateFilePath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	content, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmpPath := lockDownConfig.StateFilePath + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, content, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, lockDownConfig.StateFilePath)
+}
+
+// getLockDownState returns the current lock-down state. The caller must lock the mutex.
+func getLockDownState() LockDownState {
+	return LockDownState{
+		Full:     EmergencyLockDown,
+		Daemons:  append([]string{}, lockDownDaemons...),
+		Triggers: append([]string{}, lockDownTriggers...),
+		Since:    lockDownSince,
+	}
+}
+
+// GetLockDownState returns the current lock-down state.
+func GetLockDownState() LockDownState {
+	lockDownMutex.Lock()
+	defer lockDownMutex.Unlock()
+	return getLockDownState()
+}
+
+/*
+TriggerScopedLockDown disables the daemons and apps (identified by trigger prefix, e.g. ".s") in addition to the
+ones that are already locked down, while the other daemons and apps keep running. The lock-down is persisted if
+configured.
+*/
+func TriggerScopedLockDown(daemonNames, triggers []string) error {
+	lockDownMutex.Lock()
+	defer lockDownMutex.Unlock()
+	logger.Warning("TriggerScopedLockDown", "", nil, "daemons %v and apps %v will be disabled ASAP", daemonNames, triggers)
+	lockDownDaemons = appendIfMissing(lockDownDaemons, daemonNames...)
+	lockDownTriggers = appendIfMissing(lockDownTriggers, triggers...)
+	lockDownSince = time.Now()
+	return saveLockDownState()
+}
+
+// appendIfMissing appends the elements that do not yet exist in the slice.
+func appendIfMissing(slice []string, elems ...string) []string {
+	for _, elem := range elems {
+		var exists bool
+		for _, existing := range slice {
+			if existing == elem {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			slice = append(slice, elem)
+		}
+	}
+	return slice
+}
+
+/*
+LiftLockDown cancels the full and scoped lock-down if the unlock secret is correct, removes the persisted lock-down,
+and starts the managed daemons that stopped due to the lock-down.
+*/
+func LiftLockDown(secret string) error {
+	lockDownMutex.Lock()
+	if lockDownConfig == nil || lockDownConfig.UnlockSecret == "" {
+		lockDownMutex.Unlock()
+		return errors.New("unlock secret is not configured, lock-down can only be lifted by removing the state file and restarting the program")
+	}
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(lockDownConfig.UnlockSecret)) != 1 {
+		lockDownMutex.Unlock()
+		logger.Warning("LiftLockDown", "", nil, "refused an attempt to lift lock-down with an incorrect secret")
+		return ErrBadUnlockSecret
+	}
+	EmergencyLockDown = false
+	lockDownDaemons, lockDownTriggers = nil, nil
+	err := saveLockDownState()
+	lockDownMutex.Unlock()
+	logger.Warning("LiftLockDown", "", err, "lock-down has been lifted")
+	for _, daemon := range GetManagedDaemons() {
+		if daemon.isStoppedByLockDown() {
+			if startErr := daemon.Start(); startErr != nil {
+				logger.Warning("LiftLockDown", daemon.Name, startErr, "failed to start the daemon")
+			}
+		}
+	}
+	return err
+}
+
+// IsDaemonLockedDown returns true if the daemon should stop functioning or refuse to serve due to lock-down.
+func IsDaemonLockedDown(daemonName string) bool {
+	if EmergencyLockDown {
+		return true
+	}
+	lockDownMutex.Lock()
+	defer lockDownMutex.Unlock()
+	for _, name := range lockDownDaemons {
+		if name == daemonName {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+IsDaemonInScopedLockDown returns true only if the daemon is among the daemons disabled by a scoped lock-down. A daemon
+that carries the app command lifting lock-down uses it in place of IsDaemonLockedDown, so that it keeps serving the
+unlock command during a full lock-down.
+*/
+func IsDaemonInScopedLockDown(daemonName string) bool {
+	lockDownMutex.Lock()
+	defer lockDownMutex.Unlock()
+	for _, name := range lockDownDaemons {
+		if name == daemonName {
+			return true
+		}
+	}
+	return false
+}
+
+// IsTriggerLockedDown returns true if the app of the trigger prefix should refuse to run due to lock-down.
+func IsTriggerLockedDown(trigger string) bool {
+	if EmergencyLockDown {
+		return true
+	}
+	lockDownMutex.Lock()
+	defer lockDownMutex.Unlock()
+	for _, name := range lockDownTriggers {
+		if name == trigger {
+			return true
+		}
+	}
+	return false
+}
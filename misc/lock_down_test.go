@@ -0,0 +1,200 @@
+package misc
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLockDown(t *testing.T) {
+	dir, err := ioutil.TempDir("", "laitos-TestLockDown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	stateFile := filepath.Join(dir, "lockdown.json")
+	defer func() {
+		EmergencyLockDown = false
+		lockDownDaemons, lockDownTriggers, lockDownConfig = nil, nil, nil
+	}()
+
+	conf := &LockDownConfig{StateFilePath: stateFile, UnlockSecret: "unlock-secret"}
+	if err := conf.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	if IsDaemonLockedDown("httpd") || IsTriggerLockedDown(".s") {
+		t.Fatal("should not be locked down")
+	}
+	// Scoped lock-down only affects the listed daemons and apps
+	if err := TriggerScopedLockDown([]string{"telegram"}, []string{".s"}); err != nil {
+		t.Fatal(err)
+	}
+	if !IsDaemonLockedDown("telegram") || IsDaemonLockedDown("httpd") || !IsTriggerLockedDown(".s") || IsTriggerLockedDown(".e") || EmergencyLockDown {
+		t.Fatal("scoped lock-down is incorrect")
+	}
+	if _, err := os.Stat(stateFile); err != nil {
+		t.Fatal(err)
+	}
+	// The lock-down is honoured after a restart
+	lockDownDaemons, lockDownTriggers = nil, nil
+	if err := conf.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	if state := GetLockDownState(); state.Full || len(state.Daemons) != 1 || len(state.Triggers) != 1 || time.Since(state.Since) > time.Minute {
+		t.Fatalf("%+v", state)
+	}
+	// A full lock-down affects everything
+	TriggerEmergencyLockDown()
+	EmergencyLockDown = false
+	if err := conf.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	if !EmergencyLockDown || !IsDaemonLockedDown("httpd") || !IsTriggerLockedDown(".e") {
+		t.Fatal("full lock-down is incorrect")
+	}
+	// Only the correct secret lifts lock-down
+	if err := LiftLockDown("incorrect"); err != ErrBadUnlockSecret {
+		t.Fatal(err)
+	}
+	if err := LiftLockDown("unlock-secret"); err != nil {
+		t.Fatal(err)
+	}
+	if EmergencyLockDown || IsDaemonLockedDown("telegram") || IsTriggerLockedDown(".s") {
+		t.Fatal("did not lift lock-down")
+	}
+	if _, err := os.Stat(stateFile); !os.IsNotExist(err) {
+		t.Fatal("did not remove state file", err)
+	}
+	// Without an unlock secret, lock-down cannot be lifted
+	if err := (&LockDownConfig{}).Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	if err := LiftLockDown(""); err == nil {
+		t.Fatal("did not error")
+	}
+}
+
+func TestLockDown_ManagedDaemon(t *testing.T) {
+	defer func() {
+		lockDownMutex.Lock()
+		lockDownDaemons, lockDownTriggers, lockDownConfig = nil, nil, nil
+		lockDownMutex.Unlock()
+	}()
+	if err := (&LockDownConfig{UnlockSecret: "unlock-secret"}).Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{}, 1)
+	daemon := RegisterDaemon(&ManagedDaemon{
+		Name: "TestLockDown_ManagedDaemon",
+		StartAndBlock: func() error {
+			<-stop
+			return ErrEmergencyLockDown
+		},
+		Stop: func() {
+			select {
+			case stop <- struct{}{}:
+			default:
+			}
+		},
+	})
+	if err := daemon.Start(); err != nil {
+		t.Fatal(err)
+	}
+	// The daemon stops due to lock-down and does not restart
+	if err := TriggerScopedLockDown([]string{daemon.Name}, nil); err != nil {
+		t.Fatal(err)
+	}
+	stop <- struct{}{}
+	time.Sleep(1 * time.Second)
+	if daemon.IsRunning() || !daemon.isStoppedByLockDown() {
+		t.Fatal("daemon should have stopped due to lock-down")
+	}
+	// Lifting lock-down starts the daemon again
+	if err := LiftLockDown("unlock-secret"); err != nil {
+		t.Fatal(err)
+	}
+	if !daemon.IsRunning() {
+		t.Fatal("daemon should have started")
+	}
+	if err := daemon.StopAndWait(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLockDown_PersistedFullLockDown(t *testing.T) {
+	dir, err := ioutil.TempDir("", "laitos-TestLockDown_PersistedFullLockDown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func() {
+		lockDownMutex.Lock()
+		EmergencyLockDown = false
+		lockDownDaemons, lockDownTriggers, lockDownConfig = nil, nil, nil
+		lockDownMutex.Unlock()
+	}()
+	// A previous run of the program left a full lock-down behind
+	stateFile := filepath.Join(dir, "lockdown.json")
+	if err := ioutil.WriteFile(stateFile, []byte(`{"Full": true}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&LockDownConfig{StateFilePath: stateFile, UnlockSecret: "unlock-secret"}).Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	if !EmergencyLockDown {
+		t.Fatal("did not honour the persisted lock-down")
+	}
+	newDaemon := func(name string, servesUnlock bool) *ManagedDaemon {
+		stop := make(chan struct{}, 1)
+		return RegisterDaemon(&ManagedDaemon{
+			Name:         name,
+			ServesUnlock: servesUnlock,
+			StartAndBlock: func() error {
+				<-stop
+				return nil
+			},
+			Stop: func() {
+				select {
+				case stop <- struct{}{}:
+				default:
+				}
+			},
+		})
+	}
+	unlockDaemon := newDaemon("TestLockDown_PersistedFullLockDown-unlock", true)
+	otherDaemon := newDaemon("TestLockDown_PersistedFullLockDown-other", false)
+	for _, daemon := range []*ManagedDaemon{unlockDaemon, otherDaemon} {
+		if err := daemon.Start(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(1 * time.Second)
+	// Only the daemon that serves the unlock command is running
+	if !unlockDaemon.IsRunning() || otherDaemon.IsRunning() || !otherDaemon.isStoppedByLockDown() {
+		t.Fatal("incorrect daemon status under lock-down", unlockDaemon.String(), otherDaemon.String())
+	}
+	// Unlock starts the other daemon and removes the persisted lock-down
+	if err := LiftLockDown("unlock-secret"); err != nil {
+		t.Fatal(err)
+	}
+	if !otherDaemon.IsRunning() || EmergencyLockDown {
+		t.Fatal("did not lift lock-down")
+	}
+	if _, err := os.Stat(stateFile); !os.IsNotExist(err) {
+		t.Fatal("did not remove state file", err)
+	}
+	// A scoped lock-down that lists the daemon stops it nonetheless
+	if err := TriggerScopedLockDown([]string{unlockDaemon.Name}, nil); err != nil {
+		t.Fatal(err)
+	}
+	unlockDaemon.Stop()
+	time.Sleep(1 * time.Second)
+	if unlockDaemon.IsRunning() || !unlockDaemon.isStoppedByLockDown() {
+		t.Fatal("daemon should have stopped due to scoped lock-down")
+	}
+	if err := otherDaemon.StopAndWait(); err != nil {
+		t.Fatal(err)
+	}
+}
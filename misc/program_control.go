@@ -21,7 +21,10 @@ var (
 		If the flag is false, then none of the AWS integration features will be activated.
 	*/
 	EnableAWSIntegration bool
-	// EmergencyLockDown is a flag checked by features and daemons, they should stop functioning or refuse to serve when the flag is true.
+	/*
+		EmergencyLockDown is a flag checked by features and daemons, they should stop functioning or refuse to serve when the
+		flag is true. Daemons and features should use IsDaemonLockedDown and IsTriggerLockedDown to also respect scoped lock-down.
+	*/
 	EmergencyLockDown bool
 	// ErrEmergencyLockDown is returned by some daemons to inform user that lock-down is in effect.
 	ErrEmergencyLockDown = errors.New("LOCKED DOWN")
@@ -47,11 +50,18 @@ var (
 /*
 TriggerEmergencyLockDown turns on EmergencyLockDown flag, so that features and daemons will immediately (or very soon)
 stop functioning or refuse to serve more requests. The program process will keep running (i.e. not going to crash).
-Once the function is called, there is no way to cancel lock-down status other than restarting the program.
+The lock-down is persisted if configured, so that it remains in effect after restarting the program, and it can only
+be lifted by LiftLockDown with the unlock secret.
 */
 func TriggerEmergencyLockDown() {
 	logger.Warning("TriggerEmergencyLockDown", "", nil, "toolbox features and daemons will be disabled ASAP")
+	lockDownMutex.Lock()
+	defer lockDownMutex.Unlock()
 	EmergencyLockDown = true
+	lockDownSince = time.Now()
+	if err := saveLockDownState(); err != nil {
+		logger.Warning("TriggerEmergencyLockDown", "", err, "failed to persist the lock-down")
+	}
 }
 
 // TriggerEmergencyStop crashes the program with an abort signal in 10 seconds.
@@ -4,7 +4,10 @@ import (
 	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
@@ -123,9 +126,17 @@ func Encrypt(filePath string, key []byte) error {
 	if encrypted {
 		return fmt.Errorf("Encrypt: input file \"%s\" is already encrypted", filePath)
 	}
+	return EncryptToFile(filePath, content, key)
+}
+
+/*
+EncryptToFile encrypts the content via AES and writes it to the file, overwriting the file if it already exists. The
+file can be decrypted by Decrypt function.
+*/
+func EncryptToFile(filePath string, content, key []byte) error {
 	// Generate a random IV
 	iv := make([]byte, EncryptionIVSizeBytes)
-	_, err = rand.Read(iv)
+	_, err := rand.Read(iv)
 	if err != nil {
 		return fmt.Errorf("failed to acquire random numbers - %v", err)
 	}
@@ -183,3 +194,188 @@ func Decrypt(filePath string, key string) (content []byte, err error) {
 	content, err = ioutil.ReadAll(cipherReader)
 	return
 }
+
+/*
+PBKDF2SHA256 derives a key of the desired length from the password and salt, using PBKDF2 (RFC 2898) with HMAC-SHA256 as
+the pseudorandom function.
+*/
+func PBKDF2SHA256(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	numBlocks := (keyLen + prf.Size() - 1) / prf.Size()
+	ret := make([]byte, 0, numBlocks*prf.Size())
+	blockIndex := make([]byte, 4)
+	u := make([]byte, 0, prf.Size())
+	for block := 1; block <= numBlocks; block++ {
+		// U1 = PRF(password, salt || INT(block))
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(blockIndex, uint32(block))
+		prf.Write(blockIndex)
+		u = prf.Sum(u[:0])
+		t := make([]byte, len(u))
+		copy(t, u)
+		// T = U1 ^ U2 ^ ... ^ Uc
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		ret = append(ret, t...)
+	}
+	return ret[:keyLen]
+}
+
+const (
+	// KeySlotFileHeader is a piece of plain text prepended to files encrypted by EncryptWithKeySlots.
+	KeySlotFileHeader = "laitos-aes-gcm-v1\n"
+	// KeySlotKDFIterations is the default number of PBKDF2 iterations that derive a key slot's key from its password.
+	KeySlotKDFIterations = 100000
+	/*
+		MinKeySlotKDFIterations and MaxKeySlotKDFIterations are the range of PBKDF2 iterations accepted from an encrypted
+		file. The iterations are read from the file itself, the range prevents a crafted file from weakening the key
+		derivation or from making it run for a very long time.
+	*/
+	MinKeySlotKDFIterations = 1000
+	MaxKeySlotKDFIterations = 10 * KeySlotKDFIterations
+	// MaxKeySlots is the maximum number of passwords that may decrypt a file encrypted by EncryptWithKeySlots.
+	MaxKeySlots = 8
+	// keySlotSaltSizeBytes is the size of the random salt of each key slot.
+	keySlotSaltSizeBytes = 16
+	// keySlotDataKeySizeBytes is the size of the random AES-256 key that encrypts the content.
+	keySlotDataKeySizeBytes = 32
+)
+
+// ErrKeySlotPassword is returned when none of the key slots can be decrypted by the password.
+var ErrKeySlotPassword = errors.New("incorrect password or corrupted file")
+
+// ErrKeySlotIterations is returned when the number of PBKDF2 iterations is out of the acceptable range.
+var ErrKeySlotIterations = fmt.Errorf("the number of key derivation iterations must be between %d and %d", MinKeySlotKDFIterations, MaxKeySlotKDFIterations)
+
+// newKeySlotGCM returns an AES-GCM cipher using the key.
+func newKeySlotGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+/*
+EncryptWithKeySlots encrypts the content via AES-256-GCM using a random data key, and stores the data key in one key
+slot per password, so that any of the passwords may decrypt the content. Each key slot's key is derived from its
+password via PBKDF2-HMAC-SHA256. The output format is:
+header | iterations (uint32) | number of slots (uint8) | slots (salt | nonce | sealed data key) | nonce | sealed content
+*/
+func EncryptWithKeySlots(content []byte, passwords [][]byte, iterations int) ([]byte, error) {
+	if len(passwords) == 0 || len(passwords) > MaxKeySlots {
+		return nil, fmt.Errorf("EncryptWithKeySlots: there must be between 1 and %d passwords", MaxKeySlots)
+	}
+	if iterations < 1 {
+		iterations = KeySlotKDFIterations
+	}
+	if iterations < MinKeySlotKDFIterations || iterations > MaxKeySlotKDFIterations {
+		return nil, ErrKeySlotIterations
+	}
+	var ret bytes.Buffer
+	ret.WriteString(KeySlotFileHeader)
+	if err := binary.Write(&ret, binary.BigEndian, uint32(iterations)); err != nil {
+		return nil, err
+	}
+	ret.WriteByte(byte(len(passwords)))
+	dataKey := make([]byte, keySlotDataKeySizeBytes)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, fmt.Errorf("failed to acquire random numbers - %v", err)
+	}
+	for _, password := range passwords {
+		salt := make([]byte, keySlotSaltSizeBytes)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, fmt.Errorf("failed to acquire random numbers - %v", err)
+		}
+		slotGCM, err := newKeySlotGCM(PBKDF2SHA256(password, salt, iterations, keySlotDataKeySizeBytes))
+		if err != nil {
+			return nil, err
+		}
+		nonce := make([]byte, slotGCM.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, fmt.Errorf("failed to acquire random numbers - %v", err)
+		}
+		ret.Write(salt)
+		ret.Write(nonce)
+		ret.Write(slotGCM.Seal(nil, nonce, dataKey, []byte(KeySlotFileHeader)))
+	}
+	dataGCM, err := newKeySlotGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, dataGCM.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to acquire random numbers - %v", err)
+	}
+	ret.Write(nonce)
+	ret.Write(dataGCM.Seal(nil, nonce, content, []byte(KeySlotFileHeader)))
+	return ret.Bytes(), nil
+}
+
+// IsEncryptedWithKeySlots returns true only if the content appears to have been encrypted by EncryptWithKeySlots.
+func IsEncryptedWithKeySlots(content []byte) bool {
+	return bytes.HasPrefix(content, []byte(KeySlotFileHeader))
+}
+
+// DecryptWithKeySlots tries the password on each key slot of the encrypted content, and returns the decrypted content.
+func DecryptWithKeySlots(encrypted, password []byte) ([]byte, error) {
+	if !IsEncryptedWithKeySlots(encrypted) {
+		return nil, errors.New("DecryptWithKeySlots: the input does not appear to have been encrypted by laitos with key slots")
+	}
+	reader := bytes.NewReader(encrypted[len(KeySlotFileHeader):])
+	var iterations uint32
+	if err := binary.Read(reader, binary.BigEndian, &iterations); err != nil {
+		return nil, ErrKeySlotPassword
+	}
+	if iterations < MinKeySlotKDFIterations || iterations > MaxKeySlotKDFIterations {
+		return nil, ErrKeySlotIterations
+	}
+	numSlots, err := reader.ReadByte()
+	if err != nil || numSlots == 0 || numSlots > MaxKeySlots {
+		return nil, ErrKeySlotPassword
+	}
+	// AES-GCM nonce is 12 bytes long and the authentication tag is 16 bytes long
+	const nonceSize, tagSize = 12, 16
+	var dataKey []byte
+	slot := make([]byte, keySlotSaltSizeBytes+nonceSize+keySlotDataKeySizeBytes+tagSize)
+	for i := 0; i < int(numSlots); i++ {
+		if _, err := io.ReadFull(reader, slot); err != nil {
+			return nil, ErrKeySlotPassword
+		}
+		if dataKey != nil {
+			// Skip the remaining slots
+			continue
+		}
+		salt, nonce, sealedKey := slot[:keySlotSaltSizeBytes], slot[keySlotSaltSizeBytes:keySlotSaltSizeBytes+nonceSize], slot[keySlotSaltSizeBytes+nonceSize:]
+		slotGCM, err := newKeySlotGCM(PBKDF2SHA256(password, salt, int(iterations), keySlotDataKeySizeBytes))
+		if err != nil {
+			return nil, err
+		}
+		if key, err := slotGCM.Open(nil, nonce, sealedKey, []byte(KeySlotFileHeader)); err == nil {
+			dataKey = key
+		}
+	}
+	if dataKey == nil {
+		return nil, ErrKeySlotPassword
+	}
+	dataGCM, err := newKeySlotGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	sealedContent, err := ioutil.ReadAll(reader)
+	if err != nil || len(sealedContent) < nonceSize {
+		return nil, ErrKeySlotPassword
+	}
+	content, err := dataGCM.Open(nil, sealedContent[:nonceSize], sealedContent[nonceSize:], []byte(KeySlotFileHeader))
+	if err != nil {
+		return nil, ErrKeySlotPassword
+	}
+	return content, nil
+}
@@ -1,6 +1,8 @@
 package misc
 
 import (
+	"encoding/binary"
+	"encoding/hex"
 	"io"
 	"io/ioutil"
 	"os"
@@ -173,3 +175,59 @@ func TestEncryptDecrypt(t *testing.T) {
 		t.Fatal(err, isEncrypted, contents)
 	}
 }
+
+func TestPBKDF2SHA256(t *testing.T) {
+	// Test vectors of PBKDF2-HMAC-SHA256 from RFC 7914 section 11 and the commonly used SHA256 variant of RFC 6070
+	if key := hex.EncodeToString(PBKDF2SHA256([]byte("passwd"), []byte("salt"), 1, 64)); key != "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783" {
+		t.Fatal(key)
+	}
+	if key := hex.EncodeToString(PBKDF2SHA256([]byte("password"), []byte("salt"), 4096, 32)); key != "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a" {
+		t.Fatal(key)
+	}
+}
+
+func TestEncryptDecryptWithKeySlots(t *testing.T) {
+	if _, err := EncryptWithKeySlots([]byte("content"), nil, 0); err == nil {
+		t.Fatal("should have failed")
+	}
+	for _, iterations := range []int{MinKeySlotKDFIterations - 1, MaxKeySlotKDFIterations + 1} {
+		if _, err := EncryptWithKeySlots([]byte("content"), [][]byte{[]byte("pass1")}, iterations); err != ErrKeySlotIterations {
+			t.Fatal(iterations, err)
+		}
+	}
+	encrypted, err := EncryptWithKeySlots([]byte("secret content"), [][]byte{[]byte("pass1"), []byte("pass2")}, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncryptedWithKeySlots(encrypted) || strings.Contains(string(encrypted), "secret") {
+		t.Fatal(string(encrypted))
+	}
+	// Either password decrypts the content
+	for _, password := range []string{"pass1", "pass2"} {
+		if content, err := DecryptWithKeySlots(encrypted, []byte(password)); err != nil || string(content) != "secret content" {
+			t.Fatal(err, string(content))
+		}
+	}
+	if _, err := DecryptWithKeySlots(encrypted, []byte("pass3")); err != ErrKeySlotPassword {
+		t.Fatal(err)
+	}
+	// The iterations read from a crafted file must be within range, they are checked before deriving any key.
+	for _, iterations := range []uint32{1, MaxKeySlotKDFIterations + 1, 1<<32 - 1} {
+		crafted := append([]byte{}, encrypted...)
+		binary.BigEndian.PutUint32(crafted[len(KeySlotFileHeader):], iterations)
+		if _, err := DecryptWithKeySlots(crafted, []byte("pass1")); err != ErrKeySlotIterations {
+			t.Fatal(iterations, err)
+		}
+	}
+	// Tampered content fails authentication
+	encrypted[len(encrypted)-1] ^= 1
+	if _, err := DecryptWithKeySlots(encrypted, []byte("pass1")); err != ErrKeySlotPassword {
+		t.Fatal(err)
+	}
+	if _, err := DecryptWithKeySlots(encrypted[:len(KeySlotFileHeader)+3], []byte("pass1")); err != ErrKeySlotPassword {
+		t.Fatal(err)
+	}
+	if _, err := DecryptWithKeySlots([]byte("not encrypted"), []byte("pass1")); err == nil {
+		t.Fatal("should have failed")
+	}
+}
@@ -3,8 +3,9 @@
 package platform
 
 import (
+	"context"
 	"errors"
-	"io/ioutil"
+	"io"
 	"os"
 	"os/exec"
 	"syscall"
@@ -27,12 +28,31 @@ func GetRootDiskUsageKB() (usedKB, freeKB, totalKB int) {
 }
 
 /*
-InvokeProgram launches an external program with time constraints. The external program inherits laitos' environment
-mixed with additional input environment variables. The additional variables take precedence over inherited ones.
+InvokeProgramContext launches an external program with time constraints, and kills the program if the context is
+cancelled before the program exits. The program output is copied to outSink as it becomes available.
+The external program inherits laitos' environment mixed with additional input environment variables. The additional
+variables take precedence over inherited ones.
 Returns stdout+stderr output combined, and error if there is any. The maximum amount of output returned is capped to
 MaxExternalProgramOutputBytes.
 */
-func InvokeProgram(envVars []string, timeoutSec int, program string, args ...string) (out string, err error) {
+func InvokeProgramContext(ctx context.Context, outSink io.Writer, envVars []string, timeoutSec int, program string, args ...string) (out string, err error) {
+	return invokeProgram(ctx, outSink, envVars, nil, timeoutSec, program, args...)
+}
+
+/*
+InvokeProgramSandbox launches an external program just like InvokeProgramContext, but the program runs in the sandbox
+with a clean environment instead of inheriting laitos' environment. The program does not start running until all of
+the sandbox restrictions are in place.
+*/
+func InvokeProgramSandbox(ctx context.Context, outSink io.Writer, sandbox *ProcessSandbox, timeoutSec int, program string, args ...string) (out string, err error) {
+	if sandbox == nil {
+		return "", errors.New("programming mistake - sandbox is missing")
+	}
+	return invokeProgram(ctx, outSink, nil, sandbox, timeoutSec, program, args...)
+}
+
+// invokeProgram launches an external program, optionally in the sandbox, and waits for it to exit.
+func invokeProgram(ctx context.Context, outSink io.Writer, envVars []string, sandbox *ProcessSandbox, timeoutSec int, program string, args ...string) (out string, err error) {
 	if timeoutSec < 1 {
 		return "", errors.New("invalid time limit")
 	}
@@ -51,19 +71,42 @@ func InvokeProgram(envVars []string, timeoutSec int, program string, args ...str
 		combinedEnv = append(combinedEnv, envVars...)
 	}
 	// Collect stdout and stderr all together in a single buffer
-	outBuf := lalog.NewByteLogWriter(ioutil.Discard, MaxExternalProgramOutputBytes)
+	maxOutputBytes := MaxExternalProgramOutputBytes
+	if sandbox != nil && sandbox.MaxOutputBytes > 0 && sandbox.MaxOutputBytes < maxOutputBytes {
+		maxOutputBytes = sandbox.MaxOutputBytes
+	}
+	outBuf := lalog.NewByteLogWriter(outSink, maxOutputBytes)
 	proc := exec.Command(program, args...)
 	proc.Env = combinedEnv
 	proc.Stdout = outBuf
 	proc.Stderr = outBuf
 	// Use process group so that child processes are also killed upon time out, Windows does not require this.
-	proc.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	SetProcessGroup(proc)
+	var sandboxGate io.WriteCloser
+	if sandbox != nil {
+		if err = sandbox.prepare(proc); err != nil {
+			return
+		}
+		if sandboxGate, err = proc.StdinPipe(); err != nil {
+			return
+		}
+	}
 	// Start external process
 	unixSecAtStart := time.Now().Unix()
 	timeLimitExceeded := time.After(time.Duration(timeoutSec) * time.Second)
 	if err = proc.Start(); err != nil {
 		return
 	}
+	if sandbox != nil {
+		// Let the program run only after the sandbox restrictions are in place
+		if err = sandbox.apply(proc.Process.Pid); err != nil {
+			_ = sandboxGate.Close()
+			_ = proc.Wait()
+			return
+		}
+		_, _ = sandboxGate.Write([]byte("\n"))
+		_ = sandboxGate.Close()
+	}
 	// Wait for the process to finish
 	processExitChan := make(chan error, 1)
 	go func() {
@@ -97,6 +140,15 @@ processMonitorLoop:
 			err = errors.New("time limit exceeded")
 			minuteTicker.Stop()
 			break processMonitorLoop
+		case <-ctx.Done():
+			// Forcibly kill the process upon cancellation
+			logger.Warning("InvokeProgram", program, nil, "killing the program due to cancellation")
+			if proc.Process != nil && !KillProcess(proc.Process) {
+				logger.Warning("InvokeProgram", program, nil, "failed to kill after cancellation")
+			}
+			err = ctx.Err()
+			minuteTicker.Stop()
+			break processMonitorLoop
 		case exitErr := <-processExitChan:
 			// Normal or abnormal exit that is not a time out
 			err = exitErr
@@ -141,6 +193,11 @@ func KillProcess(proc *os.Process) (success bool) {
 	return
 }
 
+// SetProcessGroup makes the program start in a new process group, so that KillProcess will kill its child processes too.
+func SetProcessGroup(proc *exec.Cmd) {
+	proc.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
 // LockMemory locks program memory to prevent swapping, protecting sensitive user data.
 func LockMemory() {
 	// Lock all program memory into main memory to prevent sensitive data from leaking into swap.
@@ -1,8 +1,9 @@
 package platform
 
 import (
+	"context"
 	"errors"
-	"io/ioutil"
+	"io"
 	"os"
 	"os/exec"
 	"strconv"
@@ -16,15 +17,22 @@ func GetRootDiskUsageKB() (usedKB, freeKB, totalKB int) {
 	return 0, 0, 0
 }
 
+// InvokeProgramSandbox returns an error as the process sandbox is not supported on Windows.
+func InvokeProgramSandbox(_ context.Context, _ io.Writer, _ *ProcessSandbox, _ int, _ string, _ ...string) (string, error) {
+	return "", errors.New("process sandbox is only supported on Linux")
+}
+
 /*
-InvokeProgram launches an external program with time constraints. The external program inherits laitos' environment
-mixed with additional input environment variables. The additional variables take precedence over inherited ones.
+InvokeProgramContext launches an external program with time constraints, and kills the program if the context is
+cancelled before the program exits. The program output is copied to outSink as it becomes available.
+The external program inherits laitos' environment mixed with additional input environment variables. The additional
+variables take precedence over inherited ones.
 Once the external program is launched, its scheduling priority is lowered to "below normal", as a safety measure,
 because Windows is pretty bad keeping up when system is busy.
 Returns stdout+stderr output combined, and error if there is any. The maximum amount of output returned is capped to
 MaxExternalProgramOutputBytes.
 */
-func InvokeProgram(envVars []string, timeoutSec int, program string, args ...string) (out string, err error) {
+func InvokeProgramContext(ctx context.Context, outSink io.Writer, envVars []string, timeoutSec int, program string, args ...string) (out string, err error) {
 	if timeoutSec < 1 {
 		return "", errors.New("invalid time limit")
 	}
@@ -37,7 +45,7 @@ func InvokeProgram(envVars []string, timeoutSec int, program string, args ...str
 		combinedEnv = append(combinedEnv, envVars...)
 	}
 	// Collect stdout and stderr all together in a single buffer
-	outBuf := lalog.NewByteLogWriter(ioutil.Discard, MaxExternalProgramOutputBytes)
+	outBuf := lalog.NewByteLogWriter(outSink, MaxExternalProgramOutputBytes)
 	proc := exec.Command(program, args...)
 	proc.Env = combinedEnv
 	proc.Stdout = outBuf
@@ -86,6 +94,15 @@ processMonitorLoop:
 			err = errors.New("time limit exceeded")
 			minuteTicker.Stop()
 			break processMonitorLoop
+		case <-ctx.Done():
+			// Forcibly kill the process upon cancellation
+			logger.Warning("InvokeProgram", program, nil, "killing the program due to cancellation")
+			if proc.Process != nil && !KillProcess(proc.Process) {
+				logger.Warning("InvokeProgram", program, nil, "failed to kill after cancellation")
+			}
+			err = ctx.Err()
+			minuteTicker.Stop()
+			break processMonitorLoop
 		case exitErr := <-processExitChan:
 			// Normal or abnormal exit that is not a time out
 			err = exitErr
@@ -127,6 +144,10 @@ func KillProcess(proc *os.Process) (success bool) {
 	return
 }
 
+// SetProcessGroup does nothing on Windows, because KillProcess kills the process tree regardless.
+func SetProcessGroup(_ *exec.Cmd) {
+}
+
 // LockMemory locks program memory to prevent swapping, protecting sensitive user data.
 func LockMemory() {
 	logger.Warning("LockMemory", "", nil, "memory locking is not supported on Windows, your private information may leak onto disk.")
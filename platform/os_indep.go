@@ -1,6 +1,9 @@
 package platform
 
 import (
+	"context"
+	"io"
+	"io/ioutil"
 	"os"
 
 	"github.com/HouzuoGuo/laitos/lalog"
@@ -28,3 +31,21 @@ MaxExternalProgramOutputBytes.
 func InvokeShell(timeoutSec int, interpreter string, content string) (out string, err error) {
 	return InvokeProgram(nil, timeoutSec, interpreter, "-c", content)
 }
+
+/*
+InvokeShellContext launches an external shell process just like InvokeShell, and additionally kills the shell process if
+the context is cancelled before it exits. The shell output is copied to outSink as it becomes available.
+*/
+func InvokeShellContext(ctx context.Context, outSink io.Writer, timeoutSec int, interpreter string, content string) (out string, err error) {
+	return InvokeProgramContext(ctx, outSink, nil, timeoutSec, interpreter, "-c", content)
+}
+
+/*
+InvokeProgram launches an external program with time constraints. The external program inherits laitos' environment
+mixed with additional input environment variables. The additional variables take precedence over inherited ones.
+Returns stdout+stderr output combined, and error if there is any. The maximum amount of output returned is capped to
+MaxExternalProgramOutputBytes.
+*/
+func InvokeProgram(envVars []string, timeoutSec int, program string, args ...string) (out string, err error) {
+	return InvokeProgramContext(context.Background(), ioutil.Discard, envVars, timeoutSec, program, args...)
+}
@@ -1,8 +1,12 @@
 package platform
 
 import (
+	"bytes"
+	"context"
+	"io/ioutil"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestInvokeShell(t *testing.T) {
@@ -18,3 +22,23 @@ func TestInvokeShell(t *testing.T) {
 		}
 	}
 }
+
+func TestInvokeShellContext(t *testing.T) {
+	if HostIsWindows() {
+		t.Skip("this test requires a unix shell")
+	}
+	// Output is copied to the sink as it becomes available
+	var sink bytes.Buffer
+	out, err := InvokeShellContext(context.Background(), &sink, 1, "/bin/sh", "echo hello")
+	if err != nil || out != "hello\n" || sink.String() != "hello\n" {
+		t.Fatal(err, out, sink.String())
+	}
+	// Cancellation kills the process
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	start := time.Now()
+	out, err = InvokeShellContext(ctx, ioutil.Discard, 10, "/bin/sh", "echo begin; sleep 5; echo end")
+	if err != context.DeadlineExceeded || out != "begin\n" || time.Since(start) > 4*time.Second {
+		t.Fatal(err, out, time.Since(start))
+	}
+}
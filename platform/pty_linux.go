@@ -0,0 +1,66 @@
+package platform
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"unsafe"
+)
+
+// openPTY opens a new pseudo terminal pair, the terminal does not echo input and does not translate output line breaks.
+func openPTY() (master, slave *os.File, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	var unlock int32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); errno != 0 {
+		_ = master.Close()
+		return nil, nil, fmt.Errorf("failed to unlock pseudo terminal - %v", errno)
+	}
+	var ptyNum uint32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&ptyNum))); errno != 0 {
+		_ = master.Close()
+		return nil, nil, fmt.Errorf("failed to get pseudo terminal number - %v", errno)
+	}
+	slave, err = os.OpenFile(fmt.Sprintf("/dev/pts/%d", ptyNum), os.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		_ = master.Close()
+		return nil, nil, err
+	}
+	term := &syscall.Termios{}
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, slave.Fd(), syscall.TCGETS, uintptr(unsafe.Pointer(term))); errno == 0 {
+		term.Lflag &^= syscall.ECHO
+		term.Oflag &^= syscall.ONLCR
+		_, _, _ = syscall.Syscall(syscall.SYS_IOCTL, slave.Fd(), syscall.TCSETS, uintptr(unsafe.Pointer(term)))
+	}
+	return master, slave, nil
+}
+
+/*
+StartProgramInPTY starts the program in a new session, with a pseudo terminal as its controlling terminal and standard
+input/output. The program does not inherit laitos' environment so that secrets in the environment do not leak into the
+program, it only gets the common PATH and the input environment variables. The caller reads the program output from and
+writes input into the returned terminal, should use KillProcess to stop the program, and must Wait for the program to
+reap it after it exits.
+*/
+func StartProgramInPTY(envVars []string, workingDir string, program string, args ...string) (*os.File, *exec.Cmd, error) {
+	master, slave, err := openPTY()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer slave.Close()
+	proc := exec.Command(program, args...)
+	proc.Env = append([]string{"PATH=" + CommonPATH}, envVars...)
+	proc.Dir = workingDir
+	proc.Stdin = slave
+	proc.Stdout = slave
+	proc.Stderr = slave
+	proc.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true}
+	if err := proc.Start(); err != nil {
+		_ = master.Close()
+		return nil, nil, err
+	}
+	return master, proc, nil
+}
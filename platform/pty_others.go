@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package platform
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// StartProgramInPTY is only supported on Linux.
+func StartProgramInPTY(envVars []string, workingDir string, program string, args ...string) (*os.File, *exec.Cmd, error) {
+	return nil, nil, errors.New("pseudo terminal is only supported on Linux")
+}
@@ -0,0 +1,58 @@
+package platform
+
+import (
+	"fmt"
+	"os/user"
+)
+
+/*
+sandboxGateScript holds the sandboxed program until its restrictions are in place. The shell reads a line from the gate
+(stdin), then replaces itself with the program. If the gate is closed without a line, the program does not run at all.
+*/
+const sandboxGateScript = `read -r _ && exec "$0" "$@" < /dev/null`
+
+// ProcessSandbox restricts the environment, privileges, and resource usage of an external program.
+type ProcessSandbox struct {
+	// WorkingDirectory is the fixed working directory of the program.
+	WorkingDirectory string `json:"WorkingDirectory"`
+	// Environment is the list of "KEY=value" environment variables, the program does not inherit laitos' environment.
+	Environment []string `json:"Environment"`
+	// RunAsUser is the name of an optional user account to run the program as, it requires laitos to run as root.
+	RunAsUser string `json:"RunAsUser"`
+	// MaxCPUSec is the maximum amount of CPU time in seconds the program may consume.
+	MaxCPUSec int `json:"MaxCPUSec"`
+	// MaxMemoryMB is the maximum size of virtual memory in MB of each process.
+	MaxMemoryMB int `json:"MaxMemoryMB"`
+	// MaxProcesses is the maximum number of processes owned by the user, it does not restrict root.
+	MaxProcesses int `json:"MaxProcesses"`
+	// MaxOutputBytes is the maximum number of bytes of the latest program output to keep.
+	MaxOutputBytes int `json:"MaxOutputBytes"`
+	// UseNamespaces runs the program in new PID, mount, UTS, IPC, and network namespaces, it requires root.
+	UseNamespaces bool `json:"UseNamespaces"`
+	// CgroupDir is an optional cgroup (v2) directory, prepared in advance, into which the program is placed.
+	CgroupDir string `json:"CgroupDir"`
+}
+
+// lookupUser returns the user account to run the program as, or nil if the program runs as laitos' own user.
+func (sandbox *ProcessSandbox) lookupUser() (*user.User, error) {
+	if sandbox.RunAsUser == "" {
+		return nil, nil
+	}
+	u, err := user.Lookup(sandbox.RunAsUser)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find user \"%s\" - %v", sandbox.RunAsUser, err)
+	}
+	return u, nil
+}
+
+// getEnv returns a clean set of environment variables for the program, it consists of common PATH, HOME, and USER.
+func (sandbox *ProcessSandbox) getEnv(u *user.User) []string {
+	ret := []string{"PATH=" + CommonPATH}
+	if u != nil {
+		ret = append(ret, "HOME="+u.HomeDir, "USER="+u.Username, "LOGNAME="+u.Username)
+	} else if sandbox.WorkingDirectory != "" {
+		ret = append(ret, "HOME="+sandbox.WorkingDirectory)
+	}
+	// The environment variables of sandbox configuration take precedence
+	return append(ret, sandbox.Environment...)
+}
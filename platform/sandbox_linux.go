@@ -0,0 +1,84 @@
+//go:build linux
+// +build linux
+
+package platform
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+// rlimitNProc is the resource number of RLIMIT_NPROC, which is missing from syscall package.
+const rlimitNProc = 6
+
+/*
+prepare modifies the program command to run in the sandbox - with a clean environment, an optional alternative user,
+and optional new namespaces. The program is held behind a gate that reads from stdin, until apply is called.
+*/
+func (sandbox *ProcessSandbox) prepare(proc *exec.Cmd) error {
+	if proc.SysProcAttr == nil {
+		proc.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	u, err := sandbox.lookupUser()
+	if err != nil {
+		return err
+	}
+	if u != nil {
+		uid, err := strconv.ParseUint(u.Uid, 10, 32)
+		if err != nil {
+			return fmt.Errorf("user \"%s\" has an unusual UID - %v", u.Username, err)
+		}
+		gid, err := strconv.ParseUint(u.Gid, 10, 32)
+		if err != nil {
+			return fmt.Errorf("user \"%s\" has an unusual GID - %v", u.Username, err)
+		}
+		cred := &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}
+		groupIDs, _ := u.GroupIds()
+		for _, groupID := range groupIDs {
+			if group, err := strconv.ParseUint(groupID, 10, 32); err == nil {
+				cred.Groups = append(cred.Groups, uint32(group))
+			}
+		}
+		proc.SysProcAttr.Credential = cred
+	}
+	proc.Env = sandbox.getEnv(u)
+	proc.Dir = sandbox.WorkingDirectory
+	if sandbox.UseNamespaces {
+		proc.SysProcAttr.Cloneflags = syscall.CLONE_NEWNS | syscall.CLONE_NEWPID | syscall.CLONE_NEWUTS | syscall.CLONE_NEWIPC | syscall.CLONE_NEWNET
+	}
+	proc.Args = append([]string{"/bin/sh", "-c", sandboxGateScript, proc.Path}, proc.Args[1:]...)
+	proc.Path = "/bin/sh"
+	return nil
+}
+
+// apply places the resource limits and cgroup on the process that is held behind the gate.
+func (sandbox *ProcessSandbox) apply(pid int) error {
+	for _, limit := range []struct {
+		name     string
+		resource int
+		value    uint64
+	}{
+		{"CPU time", syscall.RLIMIT_CPU, uint64(sandbox.MaxCPUSec)},
+		{"memory", syscall.RLIMIT_AS, uint64(sandbox.MaxMemoryMB) * 1048576},
+		{"number of processes", rlimitNProc, uint64(sandbox.MaxProcesses)},
+	} {
+		if limit.value == 0 {
+			continue
+		}
+		rlimit := syscall.Rlimit{Cur: limit.value, Max: limit.value}
+		if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRLIMIT64, uintptr(pid), uintptr(limit.resource), uintptr(unsafe.Pointer(&rlimit)), 0, 0, 0); errno != 0 {
+			return fmt.Errorf("failed to limit %s - %v", limit.name, errno)
+		}
+	}
+	if sandbox.CgroupDir != "" {
+		if err := ioutil.WriteFile(filepath.Join(sandbox.CgroupDir, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+			return fmt.Errorf("failed to place the process into cgroup - %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package platform
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInvokeProgramSandbox(t *testing.T) {
+	dir, err := ioutil.TempDir("", "laitos-TestInvokeProgramSandbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("LAITOS_TEST_SECRET", "secret")
+	defer os.Unsetenv("LAITOS_TEST_SECRET")
+
+	// Clean environment and fixed working directory
+	sandbox := &ProcessSandbox{WorkingDirectory: dir, Environment: []string{"GREETING=hello"}}
+	out, err := InvokeProgramSandbox(context.Background(), ioutil.Discard, sandbox, 10, "/bin/sh", "-c", `pwd; echo "$GREETING $LAITOS_TEST_SECRET $HOME"`)
+	if err != nil || out != dir+"\nhello  "+dir+"\n" {
+		t.Fatalf("%v %q", err, out)
+	}
+	// The exit status of the program is preserved
+	if _, err := InvokeProgramSandbox(context.Background(), ioutil.Discard, sandbox, 10, "/bin/sh", "-c", "exit 3"); err == nil || !strings.Contains(err.Error(), "3") {
+		t.Fatal(err)
+	}
+	// Limit CPU time
+	sandbox = &ProcessSandbox{MaxCPUSec: 1}
+	start := time.Now()
+	if _, err := InvokeProgramSandbox(context.Background(), ioutil.Discard, sandbox, 20, "/bin/sh", "-c", "while true; do :; done"); err == nil || time.Since(start) > 10*time.Second {
+		t.Fatal(err, time.Since(start))
+	}
+	// Limit output size, only the latest output is kept.
+	sandbox = &ProcessSandbox{MaxOutputBytes: 10}
+	if out, err := InvokeProgramSandbox(context.Background(), ioutil.Discard, sandbox, 10, "/bin/sh", "-c", "echo 0123456789abcdefghij"); err != nil || out != "bcdefghij\n" {
+		t.Fatalf("%v %q", err, out)
+	}
+	// Sandbox cgroup directory must exist
+	sandbox = &ProcessSandbox{CgroupDir: "/this/does/not/exist"}
+	if _, err := InvokeProgramSandbox(context.Background(), ioutil.Discard, sandbox, 10, "/bin/sh", "-c", "touch "+dir+"/should-not-exist"); err == nil {
+		t.Fatal("did not error")
+	}
+	if _, err := os.Stat(dir + "/should-not-exist"); !os.IsNotExist(err) {
+		t.Fatal("the program should not have run")
+	}
+	if os.Getuid() != 0 {
+		return
+	}
+	// Run as another user
+	sandbox = &ProcessSandbox{RunAsUser: "nobody"}
+	if out, err := InvokeProgramSandbox(context.Background(), ioutil.Discard, sandbox, 10, "/bin/sh", "-c", "id -un"); err != nil || out != "nobody\n" {
+		t.Fatalf("%v %q", err, out)
+	}
+	// Limiting resources of another user requires CAP_SYS_RESOURCE, which is often missing from a container.
+	sandbox = &ProcessSandbox{RunAsUser: "nobody", MaxProcesses: 100}
+	if out, err := InvokeProgramSandbox(context.Background(), ioutil.Discard, sandbox, 10, "/bin/sh", "-c", "id -un"); err != nil && !strings.Contains(err.Error(), "not permitted") || err == nil && out != "nobody\n" {
+		t.Fatalf("%v %q", err, out)
+	}
+	// Run in new namespaces, the program becomes PID 1.
+	sandbox = &ProcessSandbox{UseNamespaces: true}
+	if out, err := InvokeProgramSandbox(context.Background(), ioutil.Discard, sandbox, 10, "/bin/sh", "-c", "echo $$"); err != nil {
+		t.Log("namespaces are not available in this environment", err)
+	} else if out != "1\n" {
+		t.Fatalf("%q", out)
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+// +build !linux
+
+package platform
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// prepare returns an error as the process sandbox relies on features specific to Linux.
+func (sandbox *ProcessSandbox) prepare(_ *exec.Cmd) error {
+	return errors.New("process sandbox is only supported on Linux")
+}
+
+// apply does nothing as the process sandbox relies on features specific to Linux.
+func (sandbox *ProcessSandbox) apply(_ int) error {
+	return nil
+}
@@ -13,6 +13,8 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+
+	"github.com/HouzuoGuo/laitos/misc"
 )
 
 var (
@@ -21,56 +23,149 @@ var (
 	ErrBadAESDecryptParam     = errors.New(`example: shortcut key to_search`)
 )
 
-const OpensslSaltedContentOffset = 16 // openssl writes down irrelevant salt in position 8:16
+const (
+	OpensslSaltedContentOffset = 16 // openssl writes down irrelevant salt in position 8:16
+	// OpensslSaltedHeader is the magic string at the beginning of a file encrypted by openssl using password and salt.
+	OpensslSaltedHeader = "Salted__"
+	// OpensslDefaultPBKDF2Iterations is the number of iterations used by "openssl enc -pbkdf2" when "-iter" is not specified.
+	OpensslDefaultPBKDF2Iterations = 10000
+
+	// AESFormatOpensslLegacy is the format of "openssl enc -aes256", its IV and key prefix are given in configuration.
+	AESFormatOpensslLegacy = "openssl"
+	// AESFormatOpensslPBKDF2 is the format of "openssl enc -aes256 -pbkdf2", its key and IV are derived from passphrase.
+	AESFormatOpensslPBKDF2 = "openssl-pbkdf2"
+	// AESFormatLaitosGCM is the format of laitos "-datautil encryptkeyslots", any of its key slots' passphrase decrypts the file.
+	AESFormatLaitosGCM = "laitos-gcm"
+)
 
 /*
-Attributes about an AES-256-CBC encrypted file.
-The file must be encrypted by openssl using password and password salt, e.g.:
-openssl enc -aes256 -in <file_to_encrypt> -out <encrypted_file>
+Attributes about an AES-256 encrypted file. The "openssl" format (default) is encrypted by
+"openssl enc -aes256 -in <file_to_encrypt> -out <encrypted_file>", its IV and encryption key prefix are given in
+configuration. The "openssl-pbkdf2" format is encrypted by "openssl enc -aes256 -pbkdf2 -iter N ...", its key and IV
+are derived from passphrase. The "laitos-gcm" format is encrypted by laitos "-datautil encryptkeyslots", it may be
+decrypted by the passphrase of any of its key slots.
+The file is read from disk upon each decryption, its content is never retained in memory.
 */
 type AESEncryptedFile struct {
-	FilePath     string `json:"FilePath"`     // Path to the AES-256-CBC file encrypted by openssl-enc with salt
-	FileContent  []byte `json:"-"`            // Encrypted file content
-	HexIV        string `json:"HexIV"`        // Hex-encoded AES IV
-	IV           []byte `json:"-"`            // IV in bytes
-	HexKeyPrefix string `json:"HexKeyPrefix"` // Hex-encoded encryption key, to be prepended to the key given in the command.
-	KeyPrefix    []byte `json:"-"`            // Key prefix in bytes
+	FilePath         string `json:"FilePath"`         // Path to the encrypted file
+	Format           string `json:"Format"`           // Format is one of "openssl" (default), "openssl-pbkdf2", and "laitos-gcm".
+	HexIV            string `json:"HexIV"`            // Hex-encoded AES IV, used only by the "openssl" format.
+	IV               []byte `json:"-"`                // IV in bytes
+	HexKeyPrefix     string `json:"HexKeyPrefix"`     // Hex-encoded encryption key, to be prepended to the key given in the command.
+	KeyPrefix        []byte `json:"-"`                // Key prefix in bytes
+	PassphrasePrefix string `json:"PassphrasePrefix"` // PassphrasePrefix is optionally prepended to the passphrase given in the command, used by the other formats.
+	PBKDF2Iterations int    `json:"PBKDF2Iterations"` // PBKDF2Iterations is the "-iter" used by openssl to encrypt the "openssl-pbkdf2" file.
 }
 
-// Initialise reads encrypted file into memory.
+// Initialise validates the configuration and makes sure that the encrypted file is readable.
 func (file *AESEncryptedFile) Initialise() error {
-	if file.HexIV == "" || file.FilePath == "" || file.HexKeyPrefix == "" {
-		return fmt.Errorf("AESEncryptedFile.Initialise: file \"%s\" is missing configuration", file.FilePath)
+	if file.FilePath == "" {
+		return fmt.Errorf("AESEncryptedFile.Initialise: file path is missing from configuration")
 	}
-	var err error
-	if file.FileContent, err = ioutil.ReadFile(file.FilePath); err != nil {
+	if file.Format == "" {
+		file.Format = AESFormatOpensslLegacy
+	}
+	switch file.Format {
+	case AESFormatOpensslLegacy:
+		if file.HexIV == "" || file.HexKeyPrefix == "" {
+			return fmt.Errorf("AESEncryptedFile.Initialise: file \"%s\" is missing configuration", file.FilePath)
+		}
+		var err error
+		if file.IV, err = hex.DecodeString(file.HexIV); err != nil {
+			return fmt.Errorf("AESEncryptedFile.Initialise: failed to decode IV of file \"%s\" - %v", file.FilePath, err)
+		}
+		if file.KeyPrefix, err = hex.DecodeString(file.HexKeyPrefix); err != nil {
+			return fmt.Errorf("AESEncryptedFile.Initialise: failed to decide key prefix of file \"%s\" - %v", file.FilePath, err)
+		}
+	case AESFormatOpensslPBKDF2:
+		if file.PBKDF2Iterations < 1 {
+			file.PBKDF2Iterations = OpensslDefaultPBKDF2Iterations
+		}
+	case AESFormatLaitosGCM:
+	default:
+		return fmt.Errorf("AESEncryptedFile.Initialise: file \"%s\" has unknown format \"%s\"", file.FilePath, file.Format)
+	}
+	if _, err := file.readFile(); err != nil {
 		return fmt.Errorf("AESEncryptedFile.Initialise: failed to read AES encrypted file \"%s\" - %v", file.FilePath, err)
 	}
-	if len(file.FileContent) <= OpensslSaltedContentOffset {
-		return fmt.Errorf("AESEncryptedFile.Initialise: \"%s\" does not appear to be a file encrypted by openssl", file.FilePath)
+	return nil
+}
+
+// readFile reads the encrypted file content and checks its format.
+func (file *AESEncryptedFile) readFile() ([]byte, error) {
+	content, err := ioutil.ReadFile(file.FilePath)
+	if err != nil {
+		return nil, err
+	}
+	switch file.Format {
+	case AESFormatLaitosGCM:
+		if !misc.IsEncryptedWithKeySlots(content) {
+			return nil, fmt.Errorf("\"%s\" does not appear to be a file encrypted by laitos", file.FilePath)
+		}
+	default:
+		if len(content) <= OpensslSaltedContentOffset || (file.Format == AESFormatOpensslPBKDF2 && string(content[:len(OpensslSaltedHeader)]) != OpensslSaltedHeader) {
+			return nil, fmt.Errorf("\"%s\" does not appear to be a file encrypted by openssl", file.FilePath)
+		}
 	}
-	if file.IV, err = hex.DecodeString(file.HexIV); err != nil {
-		return fmt.Errorf("AESEncryptedFile.Initialise: failed to decode IV of file \"%s\" - %v", file.FilePath, err)
+	return content, nil
+}
+
+/*
+Decrypt reads the encrypted file from disk and decrypts it in its entirety. The key is given in the app command - it is
+the hex-encoded key suffix for the "openssl" format, or the passphrase (suffix) for the other formats.
+*/
+func (file *AESEncryptedFile) Decrypt(key string) (plainContent []byte, err error) {
+	fileContent, err := file.readFile()
+	if err != nil {
+		return nil, err
 	}
-	if file.KeyPrefix, err = hex.DecodeString(file.HexKeyPrefix); err != nil {
-		return fmt.Errorf("AESEncryptedFile.Initialise: failed to decide key prefix of file \"%s\" - %v", file.FilePath, err)
+	switch file.Format {
+	case AESFormatOpensslPBKDF2:
+		// openssl derives both key and IV from the passphrase using PBKDF2-HMAC-SHA256
+		keyIV := misc.PBKDF2SHA256([]byte(file.PassphrasePrefix+key), fileContent[len(OpensslSaltedHeader):OpensslSaltedContentOffset], file.PBKDF2Iterations, 32+aes.BlockSize)
+		plainContent, err = decryptAESCBC(keyIV[:32], keyIV[32:], fileContent[OpensslSaltedContentOffset:])
+		if err != nil {
+			return nil, err
+		}
+		// Remove PKCS#7 padding, incorrect padding is the sign of an incorrect passphrase.
+		if len(plainContent) == 0 {
+			return nil, errors.New("incorrect passphrase")
+		}
+		padLen := int(plainContent[len(plainContent)-1])
+		if padLen < 1 || padLen > aes.BlockSize || padLen > len(plainContent) ||
+			!bytes.Equal(plainContent[len(plainContent)-padLen:], bytes.Repeat([]byte{byte(padLen)}, padLen)) {
+			return nil, errors.New("incorrect passphrase")
+		}
+		return plainContent[:len(plainContent)-padLen], nil
+	case AESFormatLaitosGCM:
+		return misc.DecryptWithKeySlots(fileContent, []byte(file.PassphrasePrefix+key))
+	default:
+		// Use combination of configured key and input suffix key to decrypt the entire file
+		keySuffix, err := hex.DecodeString(key)
+		if err != nil {
+			return nil, errors.New("failed to decode hex key")
+		}
+		keyTogether := make([]byte, len(file.KeyPrefix)+len(keySuffix))
+		copy(keyTogether, file.KeyPrefix[:])
+		copy(keyTogether[len(file.KeyPrefix):], keySuffix[:])
+		return decryptAESCBC(keyTogether, file.IV, fileContent[OpensslSaltedContentOffset:])
 	}
-	return nil
 }
 
-// Decrypt uses combination of encryption key from configuration and parameter to decrypt the entire file.
-func (file *AESEncryptedFile) Decrypt(keySuffix []byte) (plainContent []byte, err error) {
-	keyTogether := make([]byte, len(file.KeyPrefix)+len(keySuffix))
-	copy(keyTogether, file.KeyPrefix[:])
-	copy(keyTogether[len(file.KeyPrefix):], keySuffix[:])
-	aesCipher, err := aes.NewCipher(keyTogether)
+// decryptAESCBC decrypts the cipher text using AES-CBC, the padding is retained in the returned plain text.
+func decryptAESCBC(key, iv, cipherText []byte) ([]byte, error) {
+	aesCipher, err := aes.NewCipher(key)
 	if err != nil {
-		return
+		return nil, err
 	}
-	decryptor := cipher.NewCBCDecrypter(aesCipher, file.IV)
-	plainContent = make([]byte, len(file.FileContent))
-	decryptor.CryptBlocks(plainContent, file.FileContent[16:])
-	return
+	if len(iv) != aes.BlockSize {
+		return nil, fmt.Errorf("IV must be %d bytes long", aes.BlockSize)
+	}
+	// Discard the incomplete block at the end, if any.
+	cipherText = cipherText[:len(cipherText)/aes.BlockSize*aes.BlockSize]
+	plainContent := make([]byte, len(cipherText))
+	cipher.NewCBCDecrypter(aesCipher, iv).CryptBlocks(plainContent, cipherText)
+	return plainContent, nil
 }
 
 const AESDecryptTrigger = ".a" // AESDecryptTrigger is the trigger prefix string of AESDecrypt feature.
@@ -119,18 +214,13 @@ func (crypt *AESDecrypt) Execute(ctx context.Context, cmd Command) (ret *Result)
 		return &Result{Error: ErrBadAESDecryptParam}
 	}
 	shortcutName := params[1]
-	hexKeySuffix := params[2]
-	// Use combination of configured key and input suffix key to decrypt the entire file
-	keySuffix, err := hex.DecodeString(hexKeySuffix)
-	if err != nil {
-		return &Result{Error: errors.New("failed to decode hex key")}
-	}
+	key := params[2]
 	searchString := strings.ToLower(params[3])
 	file, found := crypt.EncryptedFiles[shortcutName]
 	if !found {
 		return &Result{Error: errors.New("cannot find " + shortcutName)}
 	}
-	plainContent, err := file.Decrypt(keySuffix)
+	plainContent, err := file.Decrypt(key)
 	if err != nil {
 		return &Result{Error: err}
 	}
@@ -2,8 +2,13 @@ package toolbox
 
 import (
 	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/HouzuoGuo/laitos/misc"
 )
 
 func TestAESDecrypt_Execute(t *testing.T) {
@@ -64,3 +69,68 @@ func TestAESDecrypt_Execute(t *testing.T) {
 		t.Fatal(ret)
 	}
 }
+
+func TestAESDecrypt_OpensslPBKDF2AndLaitosGCM(t *testing.T) {
+	dir, err := ioutil.TempDir("", "laitos-TestAESDecrypt_OpensslPBKDF2AndLaitosGCM")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// printf 'abc\ndef\nghi\n' | openssl enc -aes256 -pbkdf2 -iter 1000 -pass pass:secret123
+	opensslPath := filepath.Join(dir, "openssl")
+	if err := ioutil.WriteFile(opensslPath, []byte{
+		0x53, 0x61, 0x6c, 0x74, 0x65, 0x64, 0x5f, 0x5f, 0xdf, 0xef, 0x67, 0xb8, 0x80, 0xfd, 0xb8, 0x5e,
+		0x6d, 0x27, 0x1a, 0xe3, 0xad, 0x15, 0xa4, 0xa2, 0x6b, 0x4e, 0x8a, 0xd3, 0xb0, 0x58, 0x9e, 0x7f,
+	}, 0600); err != nil {
+		t.Fatal(err)
+	}
+	// Two key slots
+	laitosPath := filepath.Join(dir, "laitos")
+	encrypted, err := misc.EncryptWithKeySlots([]byte("abc\ndef\nghi\n"), [][]byte{[]byte("secret123"), []byte("secret456")}, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(laitosPath, encrypted, 0600); err != nil {
+		t.Fatal(err)
+	}
+	decrypt := AESDecrypt{EncryptedFiles: map[string]*AESEncryptedFile{
+		"unknown": {FilePath: opensslPath, Format: "does not exist"},
+	}}
+	if err := decrypt.Initialise(); err == nil {
+		t.Fatal("did not error")
+	}
+	// The format does not match the file
+	decrypt.EncryptedFiles = map[string]*AESEncryptedFile{
+		"mismatch": {FilePath: opensslPath, Format: AESFormatLaitosGCM},
+	}
+	if err := decrypt.Initialise(); err == nil {
+		t.Fatal("did not error")
+	}
+	decrypt.EncryptedFiles = map[string]*AESEncryptedFile{
+		"openssl": {FilePath: opensslPath, Format: AESFormatOpensslPBKDF2, PassphrasePrefix: "secret", PBKDF2Iterations: 1000},
+		"laitos":  {FilePath: laitosPath, Format: AESFormatLaitosGCM, PassphrasePrefix: "secret"},
+	}
+	if err := decrypt.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	if err := decrypt.SelfTest(); err != nil {
+		t.Fatal(err)
+	}
+	for _, cmd := range []string{"openssl 123 D", "laitos 123 D", "laitos 456 D"} {
+		if ret := decrypt.Execute(context.Background(), Command{TimeoutSec: 10, Content: cmd}); ret.Error != nil || ret.Output != "1 def" {
+			t.Fatal(cmd, ret)
+		}
+	}
+	for _, cmd := range []string{"openssl 789 D", "laitos 789 D"} {
+		if ret := decrypt.Execute(context.Background(), Command{TimeoutSec: 10, Content: cmd}); ret.Error == nil {
+			t.Fatal("should have failed", cmd, ret)
+		}
+	}
+	// The file is read from disk upon each decryption
+	if err := os.Remove(laitosPath); err != nil {
+		t.Fatal(err)
+	}
+	if ret := decrypt.Execute(context.Background(), Command{TimeoutSec: 10, Content: "laitos 123 D"}); ret.Error == nil {
+		t.Fatal("should have failed")
+	}
+}
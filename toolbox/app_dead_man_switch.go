@@ -0,0 +1,315 @@
+package toolbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HouzuoGuo/laitos/inet"
+	"github.com/HouzuoGuo/laitos/lalog"
+	"github.com/HouzuoGuo/laitos/misc"
+)
+
+const (
+	// DeadManSwitchTrigger is the trigger prefix string of DeadManSwitch app.
+	DeadManSwitchTrigger = ".d"
+	// DeadManSwitchCheckIntervalSec is the interval at which the switch looks for escalation steps that are due.
+	DeadManSwitchCheckIntervalSec = 1
+	// DeadManSwitchDefaultStartupGraceSec is the default number of seconds to wait after start-up before taking any step.
+	DeadManSwitchDefaultStartupGraceSec = 5 * 60
+	// DeadManSwitchActionNotify is the escalation action that delivers a message to the destinations via a sink.
+	DeadManSwitchActionNotify = "notify"
+	// DeadManSwitchActionLockDown is the escalation action that triggers emergency lock-down.
+	DeadManSwitchActionLockDown = "lockdown"
+	// DeadManSwitchActionKill is the escalation action that triggers emergency kill, destroying data on the computer.
+	DeadManSwitchActionKill = "kill"
+)
+
+// ErrBadDeadManSwitchChoice reminds user of the proper syntax to invoke DeadManSwitch app.
+var ErrBadDeadManSwitchChoice = errors.New(`(status) | extend DURATION e.g. extend 72h`)
+
+// DeadManSwitchStep is an action taken by the dead man's switch when the operator misses the check-in deadline.
+type DeadManSwitchStep struct {
+	// AfterMissedSec is the number of seconds after the missed deadline to take the action.
+	AfterMissedSec int `json:"AfterMissedSec"`
+	// Action is "notify", "lockdown", or "kill".
+	Action string `json:"Action"`
+	// Sink is the name of the channel that delivers the notification, e.g. "mail", "sms", "telegram", or "log".
+	Sink string `json:"Sink"`
+	// Destinations are the recipients of the notification, e.g. email addresses or telephone numbers.
+	Destinations []string `json:"Destinations"`
+	// Text is the notification message, the time of last check-in is appended to it.
+	Text string `json:"Text"`
+	// Attachments are the paths of files attached to the notification, they are only delivered by the "mail" sink.
+	Attachments []string `json:"Attachments"`
+}
+
+// deadManSwitchState is the content of the file that keeps the check-in deadline and escalation progress.
+type deadManSwitchState struct {
+	LastCheckIn time.Time `json:"LastCheckIn"`
+	Deadline    time.Time `json:"Deadline"`
+	StepsTaken  int       `json:"StepsTaken"` // StepsTaken is the number of escalation steps taken since the deadline was missed.
+	LastStepAt  time.Time `json:"LastStepAt"` // LastStepAt is the time at which the latest escalation step was taken.
+}
+
+/*
+DeadManSwitch expects the operator to check in by running any app command with a password before a deadline. If the
+operator misses the deadline, the switch escalates through the configured steps, such as sending a reminder, notifying
+trusted contacts, and eventually triggering emergency lock-down or kill. The deadline and escalation progress are kept
+in a file so that they survive program restarts.
+*/
+type DeadManSwitch struct {
+	// CheckInIntervalSec is the number of seconds the operator has to check in before the deadline is missed.
+	CheckInIntervalSec int `json:"CheckInIntervalSec"`
+	// StateFilePath is the location of the file that keeps the deadline and escalation progress.
+	StateFilePath string `json:"StateFilePath"`
+	// Escalation are the steps to take after the deadline is missed, in ascending order of AfterMissedSec.
+	Escalation []*DeadManSwitchStep `json:"Escalation"`
+	/*
+		StartupGraceSec is the number of seconds to wait after start-up before taking any escalation step. It gives the
+		operator a chance to check in after laitos has been offline for a while, before the overdue steps are taken.
+	*/
+	StartupGraceSec int `json:"StartupGraceSec"`
+
+	// MailClient delivers notifications for the "mail" sink.
+	MailClient inet.MailClient `json:"-"`
+
+	state  deadManSwitchState
+	mutex  *sync.Mutex
+	stop   chan struct{}
+	logger lalog.Logger
+}
+
+func (dms *DeadManSwitch) IsConfigured() bool {
+	return dms.CheckInIntervalSec > 0 && dms.StateFilePath != ""
+}
+
+func (dms *DeadManSwitch) SelfTest() error {
+	if !dms.IsConfigured() {
+		return ErrIncompleteConfig
+	}
+	dms.mutex.Lock()
+	defer dms.mutex.Unlock()
+	return dms.save()
+}
+
+/*
+Initialise validates the escalation steps, loads the deadline from the state file, and starts watching for the deadline
+in the background. Calling the function again stops the previous background routine.
+*/
+func (dms *DeadManSwitch) Initialise() error {
+	dms.logger = lalog.Logger{ComponentName: "DeadManSwitch", ComponentID: []lalog.LoggerIDField{{Key: "Path", Value: dms.StateFilePath}}}
+	for i, step := range dms.Escalation {
+		switch step.Action {
+		case DeadManSwitchActionNotify:
+			if step.Sink == "" || step.Sink != ScheduleSinkLog && len(step.Destinations) == 0 {
+				return fmt.Errorf("DeadManSwitch.Initialise: notification step %d must specify sink and destinations", i)
+			}
+			if len(step.Attachments) > 0 && step.Sink != ScheduleSinkMail {
+				return fmt.Errorf("DeadManSwitch.Initialise: notification step %d may only use attachments with the mail sink", i)
+			}
+		case DeadManSwitchActionLockDown, DeadManSwitchActionKill:
+		default:
+			return fmt.Errorf("DeadManSwitch.Initialise: step %d has unknown action \"%s\"", i, step.Action)
+		}
+		if step.AfterMissedSec < 0 || i > 0 && step.AfterMissedSec < dms.Escalation[i-1].AfterMissedSec {
+			return fmt.Errorf("DeadManSwitch.Initialise: AfterMissedSec of step %d must not be negative or less than the previous step", i)
+		}
+	}
+	if dms.StartupGraceSec < 1 {
+		dms.StartupGraceSec = DeadManSwitchDefaultStartupGraceSec
+	}
+	if dms.stop != nil {
+		close(dms.stop)
+	}
+	dms.mutex = new(sync.Mutex)
+	dms.state = deadManSwitchState{}
+	if content, err := ioutil.ReadFile(dms.StateFilePath); err == nil {
+		if err := json.Unmarshal(content, &dms.state); err != nil {
+			return fmt.Errorf("DeadManSwitch.Initialise: failed to deserialise %s - %v", dms.StateFilePath, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("DeadManSwitch.Initialise: failed to read %s - %v", dms.StateFilePath, err)
+	}
+	// The first deadline begins from the moment the switch is configured
+	if dms.state.Deadline.IsZero() {
+		dms.state.LastCheckIn = time.Now()
+		dms.state.Deadline = dms.state.LastCheckIn.Add(time.Duration(dms.CheckInIntervalSec) * time.Second)
+		if err := dms.save(); err != nil {
+			return fmt.Errorf("DeadManSwitch.Initialise: failed to write %s - %v", dms.StateFilePath, err)
+		}
+	}
+	dms.stop = make(chan struct{})
+	go dms.runLoop(dms.stop, time.Now().Add(time.Duration(dms.StartupGraceSec)*time.Second))
+	return nil
+}
+
+func (dms *DeadManSwitch) Trigger() Trigger {
+	return DeadManSwitchTrigger
+}
+
+// save writes the state into the state file. Caller must hold the mutex.
+func (dms *DeadManSwitch) save() error {
+	serialised, err := json.Marshal(dms.state)
+	if err != nil {
+		return err
+	}
+	tmpPath := dms.StateFilePath + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, serialised, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, dms.StateFilePath)
+}
+
+/*
+CheckIn postpones the deadline to a full check-in interval from now, unless it has been extended beyond that, and
+cancels the escalation in progress. The command processor calls the function for every command authenticated by password.
+*/
+func (dms *DeadManSwitch) CheckIn() {
+	dms.mutex.Lock()
+	defer dms.mutex.Unlock()
+	now := time.Now()
+	dms.state.LastCheckIn = now
+	if deadline := now.Add(time.Duration(dms.CheckInIntervalSec) * time.Second); deadline.After(dms.state.Deadline) {
+		dms.state.Deadline = deadline
+	}
+	if dms.state.StepsTaken > 0 {
+		dms.logger.Info("CheckIn", "", nil, "the operator has checked in, escalation is cancelled after %d steps", dms.state.StepsTaken)
+	}
+	dms.state.StepsTaken = 0
+	dms.state.LastStepAt = time.Time{}
+	if err := dms.save(); err != nil {
+		dms.logger.Warning("CheckIn", "", err, "failed to write state file")
+	}
+}
+
+func (dms *DeadManSwitch) Execute(ctx context.Context, cmd Command) *Result {
+	params := strings.Fields(strings.ToLower(cmd.Content))
+	switch {
+	case len(params) == 0 || len(params) == 1 && params[0] == "status":
+		return &Result{Output: dms.status()}
+	case len(params) == 2 && params[0] == "extend":
+		grace, err := time.ParseDuration(params[1])
+		if err != nil || grace <= 0 {
+			return &Result{Error: ErrBadDeadManSwitchChoice}
+		}
+		dms.mutex.Lock()
+		dms.state.Deadline = dms.state.Deadline.Add(grace)
+		err = dms.save()
+		dms.mutex.Unlock()
+		if err != nil {
+			return &Result{Error: err}
+		}
+		return &Result{Output: dms.status()}
+	default:
+		return &Result{Error: ErrBadDeadManSwitchChoice}
+	}
+}
+
+// status returns the time of last check-in, the deadline, and the escalation progress.
+func (dms *DeadManSwitch) status() string {
+	dms.mutex.Lock()
+	defer dms.mutex.Unlock()
+	const timeFormat = "2006-01-02 15:04:05"
+	remaining := time.Until(dms.state.Deadline).Truncate(time.Second)
+	ret := fmt.Sprintf("last check-in %s, deadline %s (in %s)",
+		dms.state.LastCheckIn.Format(timeFormat), dms.state.Deadline.Format(timeFormat), remaining)
+	if remaining < 0 {
+		ret = fmt.Sprintf("last check-in %s, deadline %s missed %s ago, %d of %d escalation steps taken",
+			dms.state.LastCheckIn.Format(timeFormat), dms.state.Deadline.Format(timeFormat), -remaining, dms.state.StepsTaken, len(dms.Escalation))
+	}
+	return ret
+}
+
+/*
+runLoop takes the escalation steps that are due, until the stop channel is closed. No step is taken before the
+notBefore time. The steps that have become overdue (e.g. while laitos was offline) are not taken all at once, instead
+each step waits for the same interval after the previous step as the difference between their AfterMissedSec.
+*/
+func (dms *DeadManSwitch) runLoop(stop chan struct{}, notBefore time.Time) {
+	ticker := time.NewTicker(DeadManSwitchCheckIntervalSec * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		if time.Now().Before(notBefore) {
+			continue
+		}
+		dms.mutex.Lock()
+		if dms.state.StepsTaken >= len(dms.Escalation) {
+			dms.mutex.Unlock()
+			continue
+		}
+		step := dms.Escalation[dms.state.StepsTaken]
+		dueAt := dms.state.Deadline.Add(time.Duration(step.AfterMissedSec) * time.Second)
+		if dms.state.StepsTaken > 0 {
+			interval := time.Duration(step.AfterMissedSec-dms.Escalation[dms.state.StepsTaken-1].AfterMissedSec) * time.Second
+			if intervalDueAt := dms.state.LastStepAt.Add(interval); intervalDueAt.After(dueAt) {
+				dueAt = intervalDueAt
+			}
+		}
+		now := time.Now()
+		if now.Before(dueAt) {
+			dms.mutex.Unlock()
+			continue
+		}
+		dms.state.StepsTaken++
+		dms.state.LastStepAt = now
+		lastCheckIn := dms.state.LastCheckIn
+		// Save the progress before taking the step, so that the step is not taken again should the program crash.
+		if err := dms.save(); err != nil {
+			dms.logger.Warning("runLoop", "", err, "failed to write state file")
+		}
+		dms.mutex.Unlock()
+		dms.takeStep(step, lastCheckIn)
+	}
+}
+
+// takeStep carries out the escalation action.
+func (dms *DeadManSwitch) takeStep(step *DeadManSwitchStep, lastCheckIn time.Time) {
+	dms.logger.Warning("takeStep", step.Action, nil, "the operator has not checked in since %s", lastCheckIn.Format(time.RFC3339))
+	switch step.Action {
+	case DeadManSwitchActionNotify:
+		title := "dead man's switch"
+		text := fmt.Sprintf("%s - last check-in %s", step.Text, lastCheckIn.Format(time.RFC3339))
+		// The notification is still delivered should the attachments become unavailable
+		attachments, err := readAttachmentFiles(step.Attachments)
+		if err != nil {
+			dms.logger.Warning("takeStep", "", err, "failed to read the attachments of notification")
+		}
+		for _, dest := range step.Destinations {
+			var err error
+			switch step.Sink {
+			case ScheduleSinkLog:
+				dms.logger.Info("takeStep", dest, nil, "%s", text)
+			case ScheduleSinkMail:
+				err = dms.MailClient.SendWithAttachments(inet.OutgoingMailSubjectKeyword+"-"+title, text, attachments, dest)
+			default:
+				if sink := getScheduleResultSink(step.Sink); sink == nil {
+					err = fmt.Errorf("notification sink \"%s\" is not available", step.Sink)
+				} else {
+					err = sink(dest, title, text)
+				}
+			}
+			if err != nil {
+				dms.logger.Warning("takeStep", dest, err, "failed to deliver notification via %s", step.Sink)
+			}
+		}
+		if step.Sink == ScheduleSinkLog && len(step.Destinations) == 0 {
+			dms.logger.Info("takeStep", "", nil, "%s", text)
+		}
+	case DeadManSwitchActionLockDown:
+		misc.TriggerEmergencyLockDown()
+	case DeadManSwitchActionKill:
+		misc.TriggerEmergencyKill()
+	}
+}
@@ -0,0 +1,166 @@
+package toolbox
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeadManSwitch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "laitos-TestDeadManSwitch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	delivered := make(chan string, 10)
+	RegisterScheduleResultSink("test-deadman", func(destination, title, text string) error {
+		delivered <- destination + "|" + text
+		return nil
+	})
+
+	dms := &DeadManSwitch{
+		CheckInIntervalSec: 2,
+		StateFilePath:      filepath.Join(dir, "deadman"),
+		StartupGraceSec:    1,
+		Escalation: []*DeadManSwitchStep{
+			{AfterMissedSec: 0, Action: DeadManSwitchActionNotify, Sink: "test-deadman", Destinations: []string{"me"}, Text: "reminder"},
+			{AfterMissedSec: 2, Action: DeadManSwitchActionNotify, Sink: "test-deadman", Destinations: []string{"a", "b"}, Text: "contacts"},
+		},
+	}
+	if !dms.IsConfigured() {
+		t.Fatal("not configured")
+	}
+	// Bad escalation steps
+	for _, bad := range [][]*DeadManSwitchStep{
+		{{Action: "explode"}},
+		{{Action: DeadManSwitchActionNotify, Sink: "test-deadman"}},
+		{{AfterMissedSec: 2, Action: DeadManSwitchActionLockDown}, {AfterMissedSec: 1, Action: DeadManSwitchActionKill}},
+		{{Action: DeadManSwitchActionNotify, Sink: "test-deadman", Destinations: []string{"me"}, Attachments: []string{"/etc/hostname"}}},
+	} {
+		if err := (&DeadManSwitch{CheckInIntervalSec: 1, StateFilePath: dms.StateFilePath, Escalation: bad}).Initialise(); err == nil {
+			t.Fatalf("should have failed: %+v", bad[0])
+		}
+	}
+	if err := dms.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	if err := dms.SelfTest(); err != nil {
+		t.Fatal(err)
+	}
+	if result := dms.Execute(context.Background(), Command{Content: "status"}); result.Error != nil || !strings.Contains(result.Output, "deadline") {
+		t.Fatal(result)
+	}
+	for _, bad := range []string{"a", "extend", "extend abc", "extend -1h"} {
+		if result := dms.Execute(context.Background(), Command{Content: bad}); result.Error == nil {
+			t.Fatal("should have failed", bad)
+		}
+	}
+	// Miss the deadline, the reminder arrives first and then the contacts are notified.
+	select {
+	case msg := <-delivered:
+		if !strings.HasPrefix(msg, "me|reminder") {
+			t.Fatal(msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("did not remind")
+	}
+	// Restart the switch, it resumes the escalation from where it left off.
+	if err := dms.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	for _, dest := range []string{"a", "b"} {
+		select {
+		case msg := <-delivered:
+			if !strings.HasPrefix(msg, dest+"|contacts") {
+				t.Fatal(msg)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("did not notify contacts")
+		}
+	}
+	if result := dms.Execute(context.Background(), Command{}); !strings.Contains(result.Output, "2 of 2 escalation steps taken") {
+		t.Fatal(result)
+	}
+	// Check in and extend the grace period, no further notification will arrive.
+	dms.CheckIn()
+	if result := dms.Execute(context.Background(), Command{Content: "extend 1h"}); result.Error != nil || !strings.Contains(result.Output, "(in 1h0m") {
+		t.Fatal(result)
+	}
+	select {
+	case msg := <-delivered:
+		t.Fatal("should not have notified", msg)
+	case <-time.After(3 * time.Second):
+	}
+	// Command processor checks in for commands authenticated by password
+	dms.mutex.Lock()
+	dms.state.LastCheckIn = time.Time{}
+	dms.mutex.Unlock()
+	proc := GetTestCommandProcessor()
+	proc.Features.LookupByTrigger[DeadManSwitchTrigger] = dms
+	if result := proc.Process(context.Background(), Command{Content: "badpin .d", TimeoutSec: 10}, true); result.Error == nil {
+		t.Fatal(result)
+	}
+	dms.mutex.Lock()
+	if !dms.state.LastCheckIn.IsZero() {
+		t.Fatal("should not have checked in")
+	}
+	dms.mutex.Unlock()
+	if result := proc.Process(context.Background(), Command{Content: TestCommandProcessorPIN + " .d", TimeoutSec: 10}, true); result.Error != nil {
+		t.Fatal(result)
+	}
+	dms.mutex.Lock()
+	defer dms.mutex.Unlock()
+	if time.Since(dms.state.LastCheckIn) > time.Minute {
+		t.Fatal("did not check in")
+	}
+}
+
+func TestDeadManSwitch_OverdueSteps(t *testing.T) {
+	dir, err := ioutil.TempDir("", "laitos-TestDeadManSwitch_OverdueSteps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	delivered := make(chan time.Time, 10)
+	RegisterScheduleResultSink("test-deadman-overdue", func(destination, title, text string) error {
+		delivered <- time.Now()
+		return nil
+	})
+	// The deadline was missed long ago while laitos was offline
+	statePath := filepath.Join(dir, "deadman")
+	if err := ioutil.WriteFile(statePath, []byte(`{"Deadline": "2001-02-03T04:05:06Z"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var steps []*DeadManSwitchStep
+	for _, afterMissedSec := range []int{0, 2, 4} {
+		steps = append(steps, &DeadManSwitchStep{AfterMissedSec: afterMissedSec, Action: DeadManSwitchActionNotify, Sink: "test-deadman-overdue", Destinations: []string{"me"}})
+	}
+	dms := &DeadManSwitch{CheckInIntervalSec: 3600, StateFilePath: statePath, StartupGraceSec: 2, Escalation: steps}
+	if err := dms.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	// No step is taken during the start-up grace period
+	select {
+	case <-delivered:
+		t.Fatal("should not have taken a step during the grace period")
+	case <-time.After(1500 * time.Millisecond):
+	}
+	// The overdue steps are taken one at a time, with the same intervals in between as their AfterMissedSec.
+	var previous time.Time
+	for i := 0; i < 3; i++ {
+		select {
+		case at := <-delivered:
+			if !previous.IsZero() && at.Sub(previous) < 2*time.Second-DeadManSwitchCheckIntervalSec*time.Second/2 {
+				t.Fatal("steps were taken too soon", i, at.Sub(previous))
+			}
+			previous = at
+		case <-time.After(5 * time.Second):
+			t.Fatal("did not take step", i)
+		}
+	}
+	close(dms.stop)
+}
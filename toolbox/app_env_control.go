@@ -5,6 +5,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"regexp"
 	"runtime"
 	"runtime/pprof"
 	"strconv"
@@ -15,10 +16,28 @@ import (
 	"github.com/HouzuoGuo/laitos/platform"
 )
 
-var ErrBadEnvInfoChoice = errors.New(`lock | stop | kill | log | warn | runtime | stack | tune`)
+var ErrBadEnvInfoChoice = errors.New(`lock [daemon|.trigger ...] | unlock secret | stop | kill | log | warn | runtime | stack | tune | audit [N] | lockout [clear [client]] | daemons | daemon start|stop|restart name | selftest | maintain`)
+
+/*
+RegexEnvControlUnlock matches the app command that lifts emergency lock-down and does nothing else. The command content
+must have already been stripped of password PIN by command filters.
+*/
+var RegexEnvControlUnlock = regexp.MustCompile(`^\s*` + regexp.QuoteMeta(EnvControlTrigger) + `\s*(?i:unlock)\s+\S+\s*$`)
+
+const (
+	// EnvControlTrigger is the trigger prefix string of EnvControl feature.
+	EnvControlTrigger = ".e"
+	// EnvControlDefaultAuditRecords is the number of audit journal records to retrieve when the "audit" command does not specify a number.
+	EnvControlDefaultAuditRecords = 10
+)
 
 // Retrieve environment information and trigger emergency stop upon request.
 type EnvControl struct {
+	AuditJournal  *AuditJournal  `json:"-"` // AuditJournal is the optional command processor audit journal to read records from.
+	LockoutLedger *LockoutLedger `json:"-"` // LockoutLedger is the optional command processor lockout ledger to inspect and clear.
+	FeatureSet    *FeatureSet    `json:"-"` // FeatureSet is the collection of apps to self-test on demand, including this app.
+	// RunMaintenance runs the system maintenance routine on demand and returns its report, it is only available when the maintenance daemon is launched.
+	RunMaintenance func() string `json:"-"`
 }
 
 func (info *EnvControl) IsConfigured() bool {
@@ -34,13 +53,41 @@ func (info *EnvControl) Initialise() error {
 }
 
 func (info *EnvControl) Trigger() Trigger {
-	return ".e"
+	return EnvControlTrigger
 }
 
 func (info *EnvControl) Execute(ctx context.Context, cmd Command) *Result {
 	if errResult := cmd.Trim(); errResult != nil {
 		return errResult
 	}
+	if params := strings.Fields(strings.ToLower(cmd.Content)); len(params) > 0 && params[0] == "audit" {
+		numRecords := EnvControlDefaultAuditRecords
+		if len(params) > 1 {
+			var err error
+			if numRecords, err = strconv.Atoi(params[1]); err != nil || numRecords < 1 {
+				return &Result{Error: ErrBadEnvInfoChoice}
+			}
+		}
+		return info.getAuditRecords(numRecords)
+	}
+	if params := strings.Fields(cmd.Content); len(params) > 0 && strings.ToLower(params[0]) == "lockout" {
+		return info.controlLockout(params[1:])
+	}
+	if params := strings.Fields(cmd.Content); len(params) > 0 && strings.ToLower(params[0]) == "daemon" {
+		return info.controlDaemon(params[1:])
+	}
+	if params := strings.Fields(cmd.Content); len(params) > 1 && strings.ToLower(params[0]) == "lock" {
+		return info.lockDown(params[1:])
+	}
+	if params := strings.Fields(cmd.Content); len(params) > 0 && strings.ToLower(params[0]) == "unlock" {
+		if len(params) != 2 {
+			return &Result{Error: ErrBadEnvInfoChoice}
+		}
+		if err := misc.LiftLockDown(params[1]); err != nil {
+			return &Result{Error: err}
+		}
+		return &Result{Output: "OK - lock-down has been lifted"}
+	}
 	switch strings.ToLower(cmd.Content) {
 	case "lock":
 		misc.TriggerEmergencyLockDown()
@@ -62,11 +109,129 @@ func (info *EnvControl) Execute(ctx context.Context, cmd Command) *Result {
 		return &Result{Output: GetGoroutineStacktraces()}
 	case "tune":
 		return &Result{Output: TuneLinux()}
+	case "daemons":
+		var out bytes.Buffer
+		if state := misc.GetLockDownState(); state.Full || len(state.Daemons) > 0 || len(state.Triggers) > 0 {
+			out.WriteString(state.String())
+			out.WriteRune('\n')
+		}
+		for _, daemon := range misc.GetManagedDaemons() {
+			out.WriteString(daemon.String())
+			out.WriteRune('\n')
+		}
+		return &Result{Output: out.String()}
+	case "selftest":
+		if info.FeatureSet == nil {
+			return &Result{Error: errors.New("app self test is not available")}
+		}
+		if err := info.FeatureSet.SelfTest(); err != nil {
+			return &Result{Error: err}
+		}
+		return &Result{Output: "OK - all apps passed self test"}
+	case "maintain":
+		if info.RunMaintenance == nil {
+			return &Result{Error: errors.New("maintenance daemon is not launched")}
+		}
+		return &Result{Output: info.RunMaintenance()}
 	default:
 		return &Result{Error: ErrBadEnvInfoChoice}
 	}
 }
 
+/*
+lockDown disables the daemons (e.g. "telegram") and apps (identified by trigger prefix, e.g. ".s") while the other
+daemons and apps keep running.
+*/
+func (info *EnvControl) lockDown(names []string) *Result {
+	var daemonNames, triggers []string
+	for _, name := range names {
+		if strings.HasPrefix(name, ".") {
+			triggers = append(triggers, name)
+		} else {
+			daemonNames = append(daemonNames, name)
+		}
+	}
+	if err := misc.TriggerScopedLockDown(daemonNames, triggers); err != nil {
+		return &Result{Error: fmt.Errorf("lock-down is in effect but failed to persist - %v", err)}
+	}
+	return &Result{Output: "OK - " + misc.GetLockDownState().String()}
+}
+
+// getAuditRecords verifies the audit journal and returns its latest records, the latest record comes first.
+func (info *EnvControl) getAuditRecords(n int) *Result {
+	if !info.AuditJournal.IsConfigured() {
+		return &Result{Error: errors.New("audit journal is not configured")}
+	}
+	var out bytes.Buffer
+	numRecords, verifyErr := info.AuditJournal.Verify()
+	if verifyErr == nil {
+		out.WriteString(fmt.Sprintf("%d records, chain OK\n", numRecords))
+	} else {
+		out.WriteString(fmt.Sprintf("%d records, %v\n", numRecords, verifyErr))
+	}
+	records, err := info.AuditJournal.GetLatest(n)
+	if err != nil {
+		return &Result{Error: err, Output: out.String()}
+	}
+	for _, rec := range records {
+		out.WriteString(rec.String())
+		out.WriteRune('\n')
+	}
+	return &Result{Output: out.String()}
+}
+
+/*
+controlLockout lists the clients and network prefixes that recently failed to authenticate, or clears the lockout of
+a client (all clients if unspecified) when the first parameter is "clear".
+*/
+func (info *EnvControl) controlLockout(params []string) *Result {
+	if !info.LockoutLedger.IsConfigured() {
+		return &Result{Error: errors.New("lockout ledger is not configured")}
+	}
+	if len(params) == 0 {
+		var out bytes.Buffer
+		for _, status := range info.LockoutLedger.GetStatus() {
+			out.WriteString(status.String())
+			out.WriteRune('\n')
+		}
+		return &Result{Output: out.String()}
+	}
+	if strings.ToLower(params[0]) != "clear" || len(params) > 2 {
+		return &Result{Error: ErrBadEnvInfoChoice}
+	}
+	var key string
+	if len(params) == 2 {
+		key = params[1]
+	}
+	return &Result{Output: fmt.Sprintf("cleared %d", info.LockoutLedger.Clear(key))}
+}
+
+// controlDaemon stops, starts, or restarts an individual daemon launched by this program.
+func (info *EnvControl) controlDaemon(params []string) *Result {
+	if len(params) != 2 {
+		return &Result{Error: ErrBadEnvInfoChoice}
+	}
+	daemon := misc.GetManagedDaemon(params[1])
+	if daemon == nil {
+		return &Result{Error: misc.ErrManagedDaemonNotFound}
+	}
+	var err error
+	switch strings.ToLower(params[0]) {
+	case "start":
+		err = daemon.Start()
+	case "stop":
+		err = daemon.StopAndWait()
+	case "restart":
+		err = daemon.Restart()
+	default:
+		return &Result{Error: ErrBadEnvInfoChoice}
+	}
+	if err != nil {
+		return &Result{Error: err}
+	}
+	return &Result{Output: daemon.String()}
+}
+
 // Return latest log entry of all kinds in a multi-line text, one log entry per line. Latest log entry comes first.
 func GetLatestLog() string {
 	buf := new(bytes.Buffer)
@@ -56,3 +56,60 @@ func TestEnvControl_Execute(t *testing.T) {
 	}
 	misc.EmergencyLockDown = false
 }
+
+func TestEnvControl_Daemons(t *testing.T) {
+	stop := make(chan struct{}, 1)
+	misc.RegisterDaemon(&misc.ManagedDaemon{
+		Name:          "env-control-test",
+		Addresses:     []string{"127.0.0.1:23456/udp"},
+		StartAndBlock: func() error { <-stop; return nil },
+		Stop: func() {
+			select {
+			case stop <- struct{}{}:
+			default:
+			}
+		},
+	})
+	info := EnvControl{}
+	if ret := info.Execute(context.Background(), Command{Content: "daemon start does-not-exist"}); ret.Error != misc.ErrManagedDaemonNotFound {
+		t.Fatal(ret)
+	}
+	if ret := info.Execute(context.Background(), Command{Content: "daemon start"}); ret.Error != ErrBadEnvInfoChoice {
+		t.Fatal(ret)
+	}
+	if ret := info.Execute(context.Background(), Command{Content: "daemon start env-control-test"}); ret.Error != nil || !strings.Contains(ret.Output, "env-control-test up") {
+		t.Fatal(ret)
+	}
+	if ret := info.Execute(context.Background(), Command{Content: "daemons"}); ret.Error != nil || !strings.Contains(ret.Output, "env-control-test up 0s on 127.0.0.1:23456/udp") {
+		t.Fatal(ret)
+	}
+	if ret := info.Execute(context.Background(), Command{Content: "daemon restart env-control-test"}); ret.Error != nil || !strings.Contains(ret.Output, "env-control-test up") {
+		t.Fatal(ret)
+	}
+	if ret := info.Execute(context.Background(), Command{Content: "daemon stop env-control-test"}); ret.Error != nil || !strings.Contains(ret.Output, "env-control-test stopped") {
+		t.Fatal(ret)
+	}
+	if ret := info.Execute(context.Background(), Command{Content: "daemon stop env-control-test"}); ret.Error == nil {
+		t.Fatal("should not be able to stop a stopped daemon")
+	}
+	// Self test and maintenance
+	if ret := info.Execute(context.Background(), Command{Content: "selftest"}); ret.Error == nil {
+		t.Fatal("did not error")
+	}
+	if ret := info.Execute(context.Background(), Command{Content: "maintain"}); ret.Error == nil {
+		t.Fatal("did not error")
+	}
+	fs := &FeatureSet{}
+	if err := fs.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	// Only test the apps that do not require Internet connectivity
+	fs.LookupByTrigger = map[Trigger]Feature{fs.EnvControl.Trigger(): &fs.EnvControl, fs.Shell.Trigger(): &fs.Shell}
+	fs.EnvControl.RunMaintenance = func() string { return "maintenance report" }
+	if ret := fs.EnvControl.Execute(context.Background(), Command{Content: "selftest"}); ret.Error != nil || !strings.Contains(ret.Output, "OK") {
+		t.Fatal(ret)
+	}
+	if ret := fs.EnvControl.Execute(context.Background(), Command{Content: "maintain"}); ret.Error != nil || ret.Output != "maintenance report" {
+		t.Fatal(ret)
+	}
+}
@@ -4,14 +4,17 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	cryptoRand "crypto/rand"
 	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
+	"math/big"
 	"math/rand"
 	"net"
 	"net/textproto"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -21,15 +24,26 @@ import (
 )
 
 const (
-	MailboxList    = "l" // Prefix string to trigger listing messages.
-	MailboxRead    = "r" // Prefix string to trigger reading message body.
-	IMAPTimeoutSec = 30  // IMAPTimeoutSec is the IO timeout (in seconds) used for each IMAP conversation.
+	MailboxList       = "l" // Prefix string to trigger listing messages.
+	MailboxRead       = "r" // Prefix string to trigger reading message body.
+	MailboxListUnread = "u" // Prefix string to trigger listing unread messages.
+	MailboxSearch     = "s" // Prefix string to trigger searching for messages.
+	MailboxMarkRead   = "m" // Prefix string to trigger marking messages as read.
+	MailboxDelete     = "d" // Prefix string to trigger deleting a message.
+	MailboxMove       = "v" // Prefix string to trigger moving a message to another mailbox.
+	IMAPTimeoutSec    = 30  // IMAPTimeoutSec is the IO timeout (in seconds) used for each IMAP conversation.
+
+	IMAPMaxSearchResults      = 20  // IMAPMaxSearchResults is the maximum number of messages listed in search result.
+	IMAPDefaultUnreadCount    = 10  // IMAPDefaultUnreadCount is the default number of unread messages to list.
+	IMAPConfirmationExpirySec = 300 // IMAPConfirmationExpirySec is the number of seconds a deletion or move confirmation code remains valid.
 )
 
 var (
 	RegexMailboxAndNumber     = regexp.MustCompile(`(\w+)[^\w]+(\d+)`)            // Capture one mailbox shortcut name and a number
 	RegexMailboxAndTwoNumbers = regexp.MustCompile(`(\w+)[^\w]+(\d+)[^\d]+(\d+)`) // Capture one mailbox shortcut name and two numbers
-	ErrBadMailboxParam        = fmt.Errorf("%s box skip# count# | %s box to-read#", MailboxList, MailboxRead)
+	RegexConfirmationCode     = regexp.MustCompile(`^\d{6}$`)                     // Capture a deletion or move confirmation code
+	ErrBadMailboxParam        = fmt.Errorf("%s box skip# count# | %s box to-read# | %s box [skip# count#] | %s box from/subject/since/before/on/unread ... | %s box num# ... | %s box num# [code] | %s box num# folder [code]",
+		MailboxList, MailboxRead, MailboxListUnread, MailboxSearch, MailboxMarkRead, MailboxDelete, MailboxMove)
 )
 
 // IMAPSConnection is an established TLS client connection that is ready for IMAP conversations.
@@ -153,7 +167,13 @@ func (conn *IMAPSConnection) GetHeaders(from, to int) (ret map[int]string, err e
 		err = errors.New("invalid message number range")
 		return
 	}
-	_, body, err := conn.Converse(fmt.Sprintf("FETCH %d:%d BODY.PEEK[HEADER]", from, to))
+	return conn.GetHeadersOfSet(fmt.Sprintf("%d:%d", from, to))
+}
+
+// GetHeadersOfSet retrieves mail headers of the message sequence set, such as "1:3" or "2,5,7".
+func (conn *IMAPSConnection) GetHeadersOfSet(sequenceSet string) (ret map[int]string, err error) {
+	ret = make(map[int]string)
+	_, body, err := conn.Converse(fmt.Sprintf("FETCH %s BODY.PEEK[HEADER]", sequenceSet))
 	if err != nil {
 		return
 	}
@@ -212,6 +232,145 @@ func (conn *IMAPSConnection) GetMessage(num int) (message string, err error) {
 	return
 }
 
+// Search returns the message numbers that match the IMAP search criteria (e.g. `FROM "alice" UNSEEN`).
+func (conn *IMAPSConnection) Search(criteria string) (nums []int, err error) {
+	_, body, err := conn.Converse("SEARCH " + criteria)
+	if err != nil {
+		return
+	}
+	return parseIMAPSearchResponse(body), nil
+}
+
+// SearchUID returns the unique identifiers of messages that match the IMAP search criteria (e.g. `DELETED`).
+func (conn *IMAPSConnection) SearchUID(criteria string) (uids []int, err error) {
+	_, body, err := conn.Converse("UID SEARCH " + criteria)
+	if err != nil {
+		return
+	}
+	return parseIMAPSearchResponse(body), nil
+}
+
+// parseIMAPSearchResponse returns the numbers among the untagged SEARCH response lines.
+func parseIMAPSearchResponse(body string) []int {
+	nums := make([]int, 0)
+	for _, line := range strings.Split(body, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "*" || strings.ToUpper(fields[1]) != "SEARCH" {
+			continue
+		}
+		for _, field := range fields[2:] {
+			if num, err := strconv.Atoi(field); err == nil {
+				nums = append(nums, num)
+			}
+		}
+	}
+	return nums
+}
+
+// MarkAsRead sets the seen flag on the messages.
+func (conn *IMAPSConnection) MarkAsRead(nums ...int) error {
+	if len(nums) == 0 {
+		return errors.New("message number is missing")
+	}
+	_, _, err := conn.Converse(fmt.Sprintf("STORE %s +FLAGS.SILENT (\\Seen)", imapSequenceSet(nums)))
+	return err
+}
+
+// GetUID returns the unique identifier of the message, which does not change when other messages are deleted.
+func (conn *IMAPSConnection) GetUID(num int) (int, error) {
+	if num < 1 {
+		return 0, errors.New("message number must be positive")
+	}
+	_, body, err := conn.Converse(fmt.Sprintf("FETCH %d (UID)", num))
+	if err != nil {
+		return 0, err
+	}
+	match := regexp.MustCompile(`(?i)UID (\d+)`).FindStringSubmatch(body)
+	if len(match) != 2 {
+		return 0, fmt.Errorf("cannot find message %d", num)
+	}
+	return strconv.Atoi(match[1])
+}
+
+/*
+prepareExpungeUID finds out how to expunge only the message identified by its unique identifier. It returns true if the
+server supports UID EXPUNGE (UIDPLUS extension). Otherwise, a plain EXPUNGE would also purge the messages that other
+clients have flagged as deleted, hence an error is returned if there is any.
+*/
+func (conn *IMAPSConnection) prepareExpungeUID(uid int) (uidPlus bool, err error) {
+	if uidPlus, err = conn.HasCapability("UIDPLUS"); err != nil || uidPlus {
+		return
+	}
+	deleted, err := conn.SearchUID("DELETED")
+	if err != nil {
+		return false, err
+	}
+	for _, deletedUID := range deleted {
+		if deletedUID != uid {
+			return false, errors.New("the server does not support UIDPLUS, and expunging would also purge other messages flagged as deleted")
+		}
+	}
+	return false, nil
+}
+
+// expungeUID flags the message identified by its unique identifier as deleted and then expunges it.
+func (conn *IMAPSConnection) expungeUID(uid int, uidPlus bool) error {
+	if _, _, err := conn.Converse(fmt.Sprintf("UID STORE %d +FLAGS.SILENT (\\Deleted)", uid)); err != nil {
+		return err
+	}
+	if uidPlus {
+		_, _, err := conn.Converse(fmt.Sprintf("UID EXPUNGE %d", uid))
+		return err
+	}
+	_, _, err := conn.Converse("EXPUNGE")
+	return err
+}
+
+/*
+DeleteByUID deletes the message identified by its unique identifier, without purging the other messages that are
+flagged as deleted.
+*/
+func (conn *IMAPSConnection) DeleteByUID(uid int) error {
+	uidPlus, err := conn.prepareExpungeUID(uid)
+	if err != nil {
+		return err
+	}
+	return conn.expungeUID(uid, uidPlus)
+}
+
+/*
+MoveByUID moves the message identified by its unique identifier to another mailbox. If the server does not support the
+MOVE command, the message will be copied to the mailbox and then deleted.
+*/
+func (conn *IMAPSConnection) MoveByUID(uid int, mailboxName string) error {
+	if _, _, err := conn.Converse(fmt.Sprintf("UID MOVE %d %s", uid, imapQuote(mailboxName))); err == nil {
+		return nil
+	}
+	// Make sure the message can be deleted before copying it, so that it is not left behind as a duplicate.
+	uidPlus, err := conn.prepareExpungeUID(uid)
+	if err != nil {
+		return err
+	}
+	if _, _, err := conn.Converse(fmt.Sprintf("UID COPY %d %s", uid, imapQuote(mailboxName))); err != nil {
+		return err
+	}
+	return conn.expungeUID(uid, uidPlus)
+}
+
+// imapQuote returns the string in IMAP quoted form.
+func imapQuote(str string) string {
+	return `"` + strings.Replace(strings.Replace(str, `\`, `\\`, -1), `"`, `\"`, -1) + `"`
+}
+
+// imapSequenceSet returns the message numbers in IMAP sequence set form, e.g. "1,3,5".
+func imapSequenceSet(nums []int) string {
+	strs := make([]string, len(nums))
+	for i, num := range nums {
+		strs[i] = strconv.Itoa(num)
+	}
+	return strings.Join(strs, ",")
+}
+
 // Retrieve emails via IMAPS.
 type IMAPS struct {
 	Host               string `json:"Host"`               // Server name or IP address of IMAPS server
@@ -220,6 +379,8 @@ type IMAPS struct {
 	InsecureSkipVerify bool   `json:"InsecureSkipVerify"` // Do not verify server name against its certificate
 	AuthUsername       string `json:"AuthUsername"`       // Username for plain authentication
 	AuthPassword       string `json:"AuthPassword"`       // Password for plain authentication
+
+	Notify *IMAPNotification `json:"Notify"` // Notify optionally pushes a summary of new mails through a sink such as SMS.
 }
 
 // Return a random 10 characters long string of numbers to
@@ -271,9 +432,22 @@ func (mbox *IMAPS) ConnectLoginSelect() (conn *IMAPSConnection, err error) {
 	return
 }
 
+// imapPendingAction is a deletion or move of a message that awaits confirmation.
+type imapPendingAction struct {
+	account     string    // account is the name of IMAP account.
+	num         int       // num is the message number at the time of request.
+	uid         int       // uid is the unique identifier of the message.
+	mailboxName string    // mailboxName is the destination of the move, or empty for deletion.
+	expiry      time.Time // expiry is the time after which the confirmation code is no longer valid.
+}
+
 // Correspond IMAP account connection details to account names.
 type IMAPAccounts struct {
 	Accounts map[string]*IMAPS `json:"Accounts"` // IMAP account name vs account connectivity details
+
+	pendingActions map[string]imapPendingAction // pendingActions are deletions and moves that await confirmation, keyed by confirmation code.
+	pendingMutex   *sync.Mutex
+	stopWatchers   chan struct{} // stopWatchers is closed to stop the background watchers of new mails.
 }
 
 var TestIMAPAccounts = IMAPAccounts{} // Account details are set by init_feature_test.go
@@ -309,6 +483,8 @@ func (imap *IMAPAccounts) SelfTest() error {
 }
 
 func (imap *IMAPAccounts) Initialise() error {
+	imap.pendingActions = make(map[string]imapPendingAction)
+	imap.pendingMutex = new(sync.Mutex)
 	// Use default port number 993 and default mailbox name INBOX
 	for _, account := range imap.Accounts {
 		if account.Port < 1 {
@@ -318,7 +494,8 @@ func (imap *IMAPAccounts) Initialise() error {
 			account.MailboxName = "INBOX"
 		}
 	}
-	return nil
+	// Watch for new mails in the background
+	return imap.startWatchers()
 }
 
 func (imap *IMAPAccounts) Trigger() Trigger {
@@ -380,9 +557,18 @@ func (imap *IMAPAccounts) ListMails(cmd Command) *Result {
 	if err != nil {
 		return &Result{Error: err}
 	}
-	var output bytes.Buffer
+	nums := make([]int, 0, toNum-fromNum+1)
 	for i := toNum; i >= fromNum; i-- {
-		header, found := headers[i]
+		nums = append(nums, i)
+	}
+	return &Result{Output: summariseHeaders(nums, headers)}
+}
+
+// summariseHeaders returns one line of message number, sender, and subject for each of the messages in the order of nums.
+func summariseHeaders(nums []int, headers map[int]string) string {
+	var output bytes.Buffer
+	for _, num := range nums {
+		header, found := headers[num]
 		if !found {
 			continue
 		}
@@ -391,9 +577,290 @@ func (imap *IMAPAccounts) ListMails(cmd Command) *Result {
 		if err != nil {
 			continue
 		}
-		output.WriteString(fmt.Sprintf("%d %s %s\n", i, prop.FromAddress, prop.Subject))
+		output.WriteString(fmt.Sprintf("%d %s %s\n", num, prop.FromAddress, inet.DecodeMailHeader(prop.Subject)))
+	}
+	return output.String()
+}
+
+// listMessagesOfNumbers returns the summary of the most recent messages among the message numbers.
+func listMessagesOfNumbers(conn *IMAPSConnection, nums []int, skip, count int) *Result {
+	// Higher message number is more recent
+	sort.Sort(sort.Reverse(sort.IntSlice(nums)))
+	if skip >= len(nums) {
+		return &Result{Output: fmt.Sprintf("%d found\n", len(nums))}
+	}
+	chosen := nums[skip:]
+	if len(chosen) > count {
+		chosen = chosen[:count]
+	}
+	headers, err := conn.GetHeadersOfSet(imapSequenceSet(chosen))
+	if err != nil {
+		return &Result{Error: err}
+	}
+	return &Result{Output: fmt.Sprintf("%d found\n", len(nums)) + summariseHeaders(chosen, headers)}
+}
+
+// connectAccount connects to the IMAP account by its name, which is the first of the fields.
+func (imap *IMAPAccounts) connectAccount(fields []string) (account *IMAPS, conn *IMAPSConnection, err error) {
+	if len(fields) < 1 {
+		return nil, nil, ErrBadMailboxParam
+	}
+	account, found := imap.Accounts[fields[0]]
+	if !found {
+		return nil, nil, fmt.Errorf("IMAPAccounts: cannot find mailbox \"%s\"", fields[0])
+	}
+	conn, err = account.ConnectLoginSelect()
+	return
+}
+
+// ListUnreadMails lists the most recent unread messages.
+func (imap *IMAPAccounts) ListUnreadMails(cmd Command) *Result {
+	fields := strings.Fields(cmd.Content)
+	skip, count := 0, IMAPDefaultUnreadCount
+	if len(fields) == 3 {
+		var err1, err2 error
+		skip, err1 = strconv.Atoi(fields[1])
+		count, err2 = strconv.Atoi(fields[2])
+		if err1 != nil || err2 != nil || skip < 0 || count < 1 {
+			return &Result{Error: ErrBadMailboxParam}
+		}
+		if count > 200 {
+			count = 200
+		}
+	} else if len(fields) != 1 {
+		return &Result{Error: ErrBadMailboxParam}
+	}
+	_, conn, err := imap.connectAccount(fields)
+	if err != nil {
+		return &Result{Error: err}
+	}
+	defer conn.LogoutDisconnect()
+	nums, err := conn.Search("UNSEEN")
+	if err != nil {
+		return &Result{Error: err}
+	}
+	return listMessagesOfNumbers(conn, nums, skip, count)
+}
+
+/*
+BuildIMAPSearchCriteria turns human-friendly search terms into IMAP search criteria. The terms are any combination of
+"from TEXT", "subject TEXT", "since YYYY-MM-DD", "before YYYY-MM-DD", "on YYYY-MM-DD", and "unread".
+*/
+func BuildIMAPSearchCriteria(terms []string) (string, error) {
+	var criteria []string
+	var keyword string
+	var value []string
+	finishTerm := func() error {
+		if keyword == "" {
+			return nil
+		}
+		if len(value) == 0 {
+			return fmt.Errorf("search term \"%s\" is missing a value", keyword)
+		}
+		text := strings.Join(value, " ")
+		switch keyword {
+		case "from", "subject":
+			criteria = append(criteria, strings.ToUpper(keyword)+" "+imapQuote(text))
+		default:
+			date, err := time.Parse("2006-01-02", text)
+			if err != nil {
+				return fmt.Errorf("date \"%s\" must be in the format of YYYY-MM-DD", text)
+			}
+			criteria = append(criteria, strings.ToUpper(keyword)+" "+date.Format("2-Jan-2006"))
+		}
+		keyword, value = "", nil
+		return nil
+	}
+	for _, term := range terms {
+		switch lowerTerm := strings.ToLower(term); lowerTerm {
+		case "from", "subject", "since", "before", "on":
+			if err := finishTerm(); err != nil {
+				return "", err
+			}
+			keyword = lowerTerm
+		case "unread":
+			if err := finishTerm(); err != nil {
+				return "", err
+			}
+			criteria = append(criteria, "UNSEEN")
+		default:
+			if keyword == "" {
+				return "", fmt.Errorf("unknown search term \"%s\"", term)
+			}
+			value = append(value, term)
+		}
+	}
+	if err := finishTerm(); err != nil {
+		return "", err
+	}
+	if len(criteria) == 0 {
+		return "", ErrBadMailboxParam
+	}
+	ret := strings.Join(criteria, " ")
+	// Search text outside of US-ASCII requires the character set to be specified
+	for _, r := range ret {
+		if r > 127 {
+			return "CHARSET UTF-8 " + ret, nil
+		}
 	}
-	return &Result{Output: output.String()}
+	return ret, nil
+}
+
+// SearchMails lists the most recent messages that match the search terms.
+func (imap *IMAPAccounts) SearchMails(cmd Command) *Result {
+	fields := strings.Fields(cmd.Content)
+	if len(fields) < 2 {
+		return &Result{Error: ErrBadMailboxParam}
+	}
+	criteria, err := BuildIMAPSearchCriteria(fields[1:])
+	if err != nil {
+		return &Result{Error: err}
+	}
+	_, conn, err := imap.connectAccount(fields)
+	if err != nil {
+		return &Result{Error: err}
+	}
+	defer conn.LogoutDisconnect()
+	nums, err := conn.Search(criteria)
+	if err != nil {
+		return &Result{Error: err}
+	}
+	return listMessagesOfNumbers(conn, nums, 0, IMAPMaxSearchResults)
+}
+
+// MarkMailsRead marks one or more messages as read.
+func (imap *IMAPAccounts) MarkMailsRead(cmd Command) *Result {
+	fields := strings.Fields(cmd.Content)
+	if len(fields) < 2 {
+		return &Result{Error: ErrBadMailboxParam}
+	}
+	nums := make([]int, 0, len(fields)-1)
+	for _, field := range fields[1:] {
+		num, err := strconv.Atoi(field)
+		if err != nil || num < 1 {
+			return &Result{Error: ErrBadMailboxParam}
+		}
+		nums = append(nums, num)
+	}
+	_, conn, err := imap.connectAccount(fields)
+	if err != nil {
+		return &Result{Error: err}
+	}
+	defer conn.LogoutDisconnect()
+	if err := conn.MarkAsRead(nums...); err != nil {
+		return &Result{Error: err}
+	}
+	return &Result{Output: fmt.Sprintf("OK - marked %d message(s) as read", len(nums))}
+}
+
+// newConfirmationCode returns a random 6-digit code that is not used by other pending actions. The caller must hold the mutex.
+func (imap *IMAPAccounts) newConfirmationCode() (string, error) {
+	for {
+		num, err := cryptoRand.Int(cryptoRand.Reader, big.NewInt(900000))
+		if err != nil {
+			return "", err
+		}
+		code := strconv.FormatInt(100000+num.Int64(), 10)
+		if _, exists := imap.pendingActions[code]; !exists {
+			return code, nil
+		}
+	}
+}
+
+/*
+DeleteOrMoveMail deletes a message (mailboxName is empty) or moves it to another mailbox. The first request responds
+with a confirmation code, and the action is carried out only when the request is repeated with the code.
+*/
+func (imap *IMAPAccounts) DeleteOrMoveMail(cmd Command, isMove bool) *Result {
+	fields := strings.Fields(cmd.Content)
+	minFields := 2
+	if isMove {
+		minFields = 3
+	}
+	if len(fields) < minFields {
+		return &Result{Error: ErrBadMailboxParam}
+	}
+	num, err := strconv.Atoi(fields[1])
+	if err != nil || num < 1 {
+		return &Result{Error: ErrBadMailboxParam}
+	}
+	// The confirmation code comes last
+	var code string
+	if len(fields) > minFields && RegexConfirmationCode.MatchString(fields[len(fields)-1]) {
+		code = fields[len(fields)-1]
+		fields = fields[:len(fields)-1]
+	}
+	var mailboxName string
+	if isMove {
+		mailboxName = strings.Join(fields[2:], " ")
+	} else if len(fields) != 2 {
+		return &Result{Error: ErrBadMailboxParam}
+	}
+	if code != "" {
+		imap.pendingMutex.Lock()
+		action, found := imap.pendingActions[code]
+		delete(imap.pendingActions, code)
+		imap.pendingMutex.Unlock()
+		if !found || time.Now().After(action.expiry) || action.account != fields[0] || action.num != num || action.mailboxName != mailboxName {
+			return &Result{Error: errors.New("the confirmation code is invalid or expired")}
+		}
+		_, conn, err := imap.connectAccount(fields)
+		if err != nil {
+			return &Result{Error: err}
+		}
+		defer conn.LogoutDisconnect()
+		if isMove {
+			err = conn.MoveByUID(action.uid, mailboxName)
+		} else {
+			err = conn.DeleteByUID(action.uid)
+		}
+		if err != nil {
+			return &Result{Error: err}
+		}
+		if isMove {
+			return &Result{Output: fmt.Sprintf("OK - moved message %d to %s", num, mailboxName)}
+		}
+		return &Result{Output: fmt.Sprintf("OK - deleted message %d", num)}
+	}
+	// Identify the message by its UID, so that the confirmation cannot act on a different message
+	_, conn, err := imap.connectAccount(fields)
+	if err != nil {
+		return &Result{Error: err}
+	}
+	defer conn.LogoutDisconnect()
+	uid, err := conn.GetUID(num)
+	if err != nil {
+		return &Result{Error: err}
+	}
+	headers, err := conn.GetHeadersOfSet(strconv.Itoa(num))
+	if err != nil {
+		return &Result{Error: err}
+	}
+	imap.pendingMutex.Lock()
+	defer imap.pendingMutex.Unlock()
+	// Forget the expired actions
+	for existingCode, action := range imap.pendingActions {
+		if time.Now().After(action.expiry) {
+			delete(imap.pendingActions, existingCode)
+		}
+	}
+	code, err = imap.newConfirmationCode()
+	if err != nil {
+		return &Result{Error: err}
+	}
+	imap.pendingActions[code] = imapPendingAction{
+		account:     fields[0],
+		num:         num,
+		uid:         uid,
+		mailboxName: mailboxName,
+		expiry:      time.Now().Add(IMAPConfirmationExpirySec * time.Second),
+	}
+	confirmCmd := MailboxDelete
+	if isMove {
+		confirmCmd = MailboxMove
+	}
+	return &Result{Output: fmt.Sprintf("To confirm, within %d minutes send: %s %s %s\n%s",
+		IMAPConfirmationExpirySec/60, confirmCmd, strings.Join(fields, " "), code, summariseHeaders([]int{num}, headers))}
 }
 
 func (imap *IMAPAccounts) ReadMessage(cmd Command) *Result {
@@ -424,24 +891,20 @@ func (imap *IMAPAccounts) ReadMessage(cmd Command) *Result {
 	if err != nil {
 		return &Result{Error: err}
 	}
-	// If mail is multi-part, prefer to retrieve the plain text mail body.
-	var anyText, plainText string
-	err = inet.WalkMailMessage([]byte(entireMessage), func(prop inet.BasicMail, body []byte) (bool, error) {
-		if !strings.Contains(prop.ContentType, "plain") {
-			anyText = string(body)
-		} else {
-			plainText = string(body)
-		}
-		return true, nil
-	})
-	if err != nil {
+	// Decode MIME parts and prefer the plain text mail body
+	decoded, err := inet.DecodeMailMessage([]byte(entireMessage))
+	if err != nil && decoded.Text == "" {
 		return &Result{Error: err}
 	}
-	if plainText == "" {
-		return &Result{Output: anyText}
-	} else {
-		return &Result{Output: plainText}
+	output := decoded.Text
+	if len(decoded.Attachments) > 0 {
+		attachments := make([]string, len(decoded.Attachments))
+		for i, attachment := range decoded.Attachments {
+			attachments[i] = fmt.Sprintf("%s (%dKB)", attachment.FileName, (attachment.Size+1023)/1024)
+		}
+		output += "\n\nAttachments: " + strings.Join(attachments, ", ")
 	}
+	return &Result{Output: output}
 }
 
 func (imap *IMAPAccounts) Execute(ctx context.Context, cmd Command) (ret *Result) {
@@ -452,6 +915,16 @@ func (imap *IMAPAccounts) Execute(ctx context.Context, cmd Command) (ret *Result
 		ret = imap.ListMails(cmd)
 	} else if cmd.FindAndRemovePrefix(MailboxRead) {
 		ret = imap.ReadMessage(cmd)
+	} else if cmd.FindAndRemovePrefix(MailboxListUnread) {
+		ret = imap.ListUnreadMails(cmd)
+	} else if cmd.FindAndRemovePrefix(MailboxSearch) {
+		ret = imap.SearchMails(cmd)
+	} else if cmd.FindAndRemovePrefix(MailboxMarkRead) {
+		ret = imap.MarkMailsRead(cmd)
+	} else if cmd.FindAndRemovePrefix(MailboxDelete) {
+		ret = imap.DeleteOrMoveMail(cmd, false)
+	} else if cmd.FindAndRemovePrefix(MailboxMove) {
+		ret = imap.DeleteOrMoveMail(cmd, true)
 	} else {
 		ret = &Result{Error: ErrBadMailboxParam}
 	}
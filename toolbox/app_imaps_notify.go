@@ -0,0 +1,328 @@
+package toolbox
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/HouzuoGuo/laitos/inet"
+	"github.com/HouzuoGuo/laitos/lalog"
+)
+
+const (
+	// IMAPIdleRenewSec is the number of seconds after which an IDLE command is renewed, RFC 2177 recommends less than 29 minutes.
+	IMAPIdleRenewSec = 25 * 60
+	// IMAPDefaultPollIntervalSec is the default interval of checking for new mails when the server does not support IDLE.
+	IMAPDefaultPollIntervalSec = 300
+	// IMAPNotifyMaxRetryIntervalSec is the maximum interval between reconnection attempts after the watcher loses connection.
+	IMAPNotifyMaxRetryIntervalSec = 10 * 60
+	// IMAPNotifyMaxMails is the maximum number of new mails summarised in a notification.
+	IMAPNotifyMaxMails = 10
+)
+
+var (
+	// RegexIMAPUIDNext finds the UIDNEXT number in the response of STATUS command.
+	RegexIMAPUIDNext = regexp.MustCompile(`(?i)UIDNEXT (\d+)`)
+	// RegexIMAPExists finds the untagged EXISTS response that announces a change in the number of messages.
+	RegexIMAPExists = regexp.MustCompile(`(?i)^\*\s+\d+\s+EXISTS`)
+)
+
+// IMAPNotification configures the push notification of new mails that arrive in an IMAP account.
+type IMAPNotification struct {
+	// Sink is the name of the channel through which notifications are delivered, e.g. "sms", "telegram", "recurring", or "log".
+	Sink string `json:"Sink"`
+	// Destination is the recipient of the sink, e.g. a telephone number, a telegram chat ID, or a recurring commands channel ID.
+	Destination string `json:"Destination"`
+	// FromContains lists the texts of which any one must be found in the sender. Leave empty to accept all senders.
+	FromContains []string `json:"FromContains"`
+	// SubjectContains lists the texts of which any one must be found in the subject. Leave empty to accept all subjects.
+	SubjectContains []string `json:"SubjectContains"`
+	// PollIntervalSec is the interval of checking for new mails, it is only used if the server does not support IDLE.
+	PollIntervalSec int `json:"PollIntervalSec"`
+}
+
+// Matches returns true only if the sender and subject satisfy the notification rules, the comparison is case insensitive.
+func (notify *IMAPNotification) Matches(from, subject string) bool {
+	containsAny := func(text string, candidates []string) bool {
+		if len(candidates) == 0 {
+			return true
+		}
+		for _, candidate := range candidates {
+			if strings.Contains(strings.ToLower(text), strings.ToLower(candidate)) {
+				return true
+			}
+		}
+		return false
+	}
+	return containsAny(from, notify.FromContains) && containsAny(subject, notify.SubjectContains)
+}
+
+// Deliver sends the notification text through the sink.
+func (notify *IMAPNotification) Deliver(logger lalog.Logger, title, text string) error {
+	if notify.Sink == ScheduleSinkLog {
+		logger.Info("Deliver", "", nil, "%s", text)
+		return nil
+	}
+	sink := getScheduleResultSink(notify.Sink)
+	if sink == nil {
+		return fmt.Errorf("notification sink \"%s\" is not available", notify.Sink)
+	}
+	return sink(notify.Destination, title, text)
+}
+
+// HasCapability returns true if the server advertises the capability (e.g. "IDLE") in response to CAPABILITY command.
+func (conn *IMAPSConnection) HasCapability(capability string) (bool, error) {
+	_, body, err := conn.Converse("CAPABILITY")
+	if err != nil {
+		return false, err
+	}
+	for _, word := range strings.Fields(strings.ToUpper(body)) {
+		if word == strings.ToUpper(capability) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// GetUIDNext returns the unique identifier that will be assigned to the next new message in the mailbox.
+func (conn *IMAPSConnection) GetUIDNext(mailboxName string) (int, error) {
+	_, body, err := conn.Converse(fmt.Sprintf("STATUS %s (UIDNEXT)", imapQuote(mailboxName)))
+	if err != nil {
+		return 0, err
+	}
+	match := RegexIMAPUIDNext.FindStringSubmatch(body)
+	if len(match) != 2 {
+		return 0, fmt.Errorf("STATUS command did not return UIDNEXT - %s", body)
+	}
+	return strconv.Atoi(match[1])
+}
+
+/*
+Idle sends IDLE command and waits until the server announces a change in the mailbox, the timeout elapses, or the stop
+channel is closed, then it ends the IDLE command. It returns true if the server announced a change.
+*/
+func (conn *IMAPSConnection) Idle(timeout time.Duration, stop <-chan struct{}) (changed bool, err error) {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	if conn.tlsConn == nil {
+		return false, errors.New("programming mistake - IMAPS connection is missing")
+	}
+	challenge := randomChallenge()
+	_ = conn.tlsConn.SetDeadline(time.Now().Add(time.Duration(IMAPTimeoutSec) * time.Second))
+	if _, err = conn.tlsConn.Write([]byte(challenge + " IDLE\r\n")); err != nil {
+		conn.disconnect()
+		return
+	}
+	reader := bufio.NewReader(io.LimitReader(conn.tlsConn, 32*1048576))
+	// Wait for the server to acknowledge with a continuation request
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		conn.disconnect()
+		return
+	} else if !strings.HasPrefix(line, "+") {
+		return false, fmt.Errorf("server refused IDLE command - %s", strings.TrimSpace(line))
+	}
+	// Read untagged responses in short intervals, so that the stop channel is checked regularly.
+	endTime := time.Now().Add(timeout)
+	var partialLine string
+	for !changed && time.Now().Before(endTime) {
+		select {
+		case <-stop:
+			endTime = time.Now()
+			continue
+		default:
+		}
+		_ = conn.tlsConn.SetReadDeadline(time.Now().Add(1 * time.Second))
+		line, err = reader.ReadString('\n')
+		partialLine += line
+		if netErr, isNetErr := err.(net.Error); isNetErr && netErr.Timeout() {
+			continue
+		} else if err != nil {
+			conn.disconnect()
+			return
+		}
+		if RegexIMAPExists.MatchString(partialLine) {
+			changed = true
+		}
+		partialLine = ""
+	}
+	// End IDLE and wait for the tagged response
+	_ = conn.tlsConn.SetDeadline(time.Now().Add(time.Duration(IMAPTimeoutSec) * time.Second))
+	if _, err = conn.tlsConn.Write([]byte("DONE\r\n")); err != nil {
+		conn.disconnect()
+		return
+	}
+	for {
+		line, err = reader.ReadString('\n')
+		partialLine += line
+		if err != nil {
+			conn.disconnect()
+			return
+		}
+		if RegexIMAPExists.MatchString(partialLine) {
+			changed = true
+		}
+		if strings.HasPrefix(partialLine, challenge) {
+			return
+		}
+		partialLine = ""
+	}
+}
+
+// imapWatcher holds a connection to an IMAP account and notifies the sink of the new mails that match the rules.
+type imapWatcher struct {
+	name    string
+	account *IMAPS
+	logger  lalog.Logger
+	uidNext int // uidNext is the UID of the first message that has not been inspected.
+}
+
+// checkNewMails notifies the sink of the mails that arrived since the previous check.
+func (watcher *imapWatcher) checkNewMails(conn *IMAPSConnection) error {
+	uidNext, err := conn.GetUIDNext(watcher.account.MailboxName)
+	if err != nil {
+		return err
+	}
+	if watcher.uidNext == 0 || uidNext <= watcher.uidNext {
+		// Upon the first check, only remember the UID without notifying of the existing mails.
+		watcher.uidNext = uidNext
+		return nil
+	}
+	nums, err := conn.Search(fmt.Sprintf("UID %d:%d", watcher.uidNext, uidNext-1))
+	if err != nil {
+		return err
+	}
+	watcher.uidNext = uidNext
+	if len(nums) == 0 {
+		return nil
+	}
+	headers, err := conn.GetHeadersOfSet(imapSequenceSet(nums))
+	if err != nil {
+		return err
+	}
+	var summaries []string
+	for _, num := range nums {
+		header, found := headers[num]
+		if !found {
+			continue
+		}
+		prop, _, err := inet.ReadMailMessage([]byte(header + "\r\n\r\n"))
+		if err != nil {
+			continue
+		}
+		subject := inet.DecodeMailHeader(prop.Subject)
+		if watcher.account.Notify.Matches(prop.FromAddress, subject) && len(summaries) < IMAPNotifyMaxMails {
+			summaries = append(summaries, fmt.Sprintf("%d %s %s", num, prop.FromAddress, subject))
+		}
+	}
+	if len(summaries) == 0 {
+		return nil
+	}
+	title := fmt.Sprintf("new mail in %s", watcher.name)
+	text := fmt.Sprintf("%s:\n%s", title, strings.Join(summaries, "\n"))
+	if err := watcher.account.Notify.Deliver(watcher.logger, title, text); err != nil {
+		watcher.logger.Warning("checkNewMails", watcher.name, err, "failed to deliver notification to %s:%s", watcher.account.Notify.Sink, watcher.account.Notify.Destination)
+	}
+	return nil
+}
+
+// watchOnce connects to the account and checks for new mails until an IO error occurs or the stop channel is closed.
+func (watcher *imapWatcher) watchOnce(stop <-chan struct{}) error {
+	conn, err := watcher.account.ConnectLoginSelect()
+	if err != nil {
+		return err
+	}
+	defer conn.LogoutDisconnect()
+	canIdle, err := conn.HasCapability("IDLE")
+	if err != nil {
+		return err
+	}
+	pollInterval := time.Duration(watcher.account.Notify.PollIntervalSec) * time.Second
+	for {
+		if err := watcher.checkNewMails(conn); err != nil {
+			return err
+		}
+		if canIdle {
+			if _, err := conn.Idle(IMAPIdleRenewSec*time.Second, stop); err != nil {
+				return err
+			}
+		} else {
+			select {
+			case <-time.After(pollInterval):
+			case <-stop:
+			}
+		}
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+	}
+}
+
+// watch keeps watching the account for new mails, it reconnects upon IO error, until the stop channel is closed.
+func (watcher *imapWatcher) watch(stop <-chan struct{}) {
+	retryInterval := time.Duration(IMAPTimeoutSec) * time.Second
+	for {
+		err := watcher.watchOnce(stop)
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		watcher.logger.Warning("watch", watcher.name, err, "lost connection, will retry in %s", retryInterval)
+		select {
+		case <-time.After(retryInterval):
+		case <-stop:
+			return
+		}
+		if retryInterval *= 2; retryInterval > IMAPNotifyMaxRetryIntervalSec*time.Second {
+			retryInterval = IMAPNotifyMaxRetryIntervalSec * time.Second
+		}
+	}
+}
+
+// startWatchers starts watching the accounts that have notification configured. Previously started watchers are stopped.
+func (imap *IMAPAccounts) startWatchers() error {
+	imap.StopWatchers()
+	// Validate all notifications before starting any watcher, so that a configuration error does not leave watchers behind.
+	for name, account := range imap.Accounts {
+		if account.Notify == nil {
+			continue
+		}
+		if account.Notify.Sink == "" {
+			return fmt.Errorf("IMAPAccounts.Initialise: notification of account \"%s\" must specify a sink", name)
+		}
+		if account.Notify.PollIntervalSec < 1 {
+			account.Notify.PollIntervalSec = IMAPDefaultPollIntervalSec
+		}
+	}
+	stop := make(chan struct{})
+	for name, account := range imap.Accounts {
+		if account.Notify == nil {
+			continue
+		}
+		watcher := &imapWatcher{
+			name:    name,
+			account: account,
+			logger:  lalog.Logger{ComponentName: "IMAPAccounts", ComponentID: []lalog.LoggerIDField{{Key: "Account", Value: name}}},
+		}
+		go watcher.watch(stop)
+	}
+	imap.stopWatchers = stop
+	return nil
+}
+
+// StopWatchers stops the background watchers of new mails. It is safe to call even if no watcher is running.
+func (imap *IMAPAccounts) StopWatchers() {
+	if imap.stopWatchers != nil {
+		close(imap.stopWatchers)
+		imap.stopWatchers = nil
+	}
+}
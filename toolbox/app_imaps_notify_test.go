@@ -0,0 +1,82 @@
+package toolbox
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIMAPNotification_Matches(t *testing.T) {
+	notify := IMAPNotification{}
+	if !notify.Matches("a@example.com", "hi") {
+		t.Fatal("should have matched")
+	}
+	notify.FromContains = []string{"ALICE", "bob@"}
+	notify.SubjectContains = []string{"urgent"}
+	if !notify.Matches("bob@example.com", "Urgent: call me") || !notify.Matches("alice@example.com", "not urgent") {
+		t.Fatal("should have matched")
+	}
+	if notify.Matches("carol@example.com", "urgent") || notify.Matches("alice@example.com", "hello") {
+		t.Fatal("should not have matched")
+	}
+}
+
+func TestIMAPAccounts_Notify(t *testing.T) {
+	notifications := make(chan string, 10)
+	RegisterScheduleResultSink("test-imap-notify", func(destination, title, text string) error {
+		notifications <- destination + "|" + title + "|" + text
+		return nil
+	})
+	for _, supportIdle := range []bool{true, false} {
+		server := startFakeIMAPServer(t, "From: alice@example.com\nSubject: old mail\n\nhello\n")
+		server.supportIdle = supportIdle
+		accounts := IMAPAccounts{Accounts: map[string]*IMAPS{"a": {
+			Host: "127.0.0.1", Port: server.port(), InsecureSkipVerify: true, AuthUsername: "u", AuthPassword: "p",
+			Notify: &IMAPNotification{Sink: "test-imap-notify", Destination: "me", FromContains: []string{"alice"}, PollIntervalSec: 1},
+		}}}
+		if err := accounts.Initialise(); err != nil {
+			t.Fatal(err)
+		}
+		// Wait for the watcher to learn about the existing mail
+		for start := time.Now(); ; time.Sleep(100 * time.Millisecond) {
+			server.mutex.Lock()
+			requests := strings.Join(server.requests, "\n")
+			server.mutex.Unlock()
+			if strings.Contains(requests, "STATUS") && (!supportIdle || strings.Contains(requests, "IDLE")) {
+				break
+			}
+			if time.Since(start) > 5*time.Second {
+				t.Fatal("watcher did not start", requests)
+			}
+		}
+		server.addMessages("From: bob@example.com\nSubject: ignored\n\nhello\n", "From: Alice <alice@example.com>\nSubject: =?UTF-8?Q?new_caf=C3=A9?=\n\nhello\n")
+		select {
+		case notification := <-notifications:
+			if notification != "me|new mail in a|new mail in a:\n3 alice@example.com new café" {
+				t.Fatal(supportIdle, notification)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("did not receive notification", supportIdle)
+		}
+		// Initialising the app again replaces the watchers that were started earlier
+		previousStop := accounts.stopWatchers
+		if err := accounts.Initialise(); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-previousStop:
+		default:
+			t.Fatal("previous watchers are still running")
+		}
+		accounts.StopWatchers()
+		if accounts.stopWatchers != nil {
+			t.Fatal("watchers are still running")
+		}
+		server.listener.Close()
+	}
+	// A notification must specify its sink
+	accounts := IMAPAccounts{Accounts: map[string]*IMAPS{"a": {Host: "127.0.0.1", AuthUsername: "u", AuthPassword: "p", Notify: &IMAPNotification{}}}}
+	if err := accounts.Initialise(); err == nil || accounts.stopWatchers != nil {
+		t.Fatal("should have failed")
+	}
+}
@@ -1,9 +1,22 @@
 package toolbox
 
 import (
+	"bufio"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	cryptoRand "crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"math/big"
+	"net"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/HouzuoGuo/laitos/inet"
 )
@@ -135,3 +148,403 @@ func TestIMAPAccounts_Execute(t *testing.T) {
 		t.Fatal(ret)
 	}
 }
+
+// fakeIMAPMessage is a message stored in fakeIMAPServer.
+type fakeIMAPMessage struct {
+	uid     int
+	content string
+	seen    bool
+	deleted bool
+}
+
+/*
+fakeIMAPServer is a minimal IMAP server over TLS that understands just enough commands for the IMAP app to work with.
+It remembers all commands it has received.
+*/
+type fakeIMAPServer struct {
+	listener       net.Listener
+	supportMove    bool
+	supportIdle    bool
+	supportUIDPlus bool
+	mailboxes      map[string][]*fakeIMAPMessage
+	nextUID        int
+	requests       []string
+	idlers         []chan struct{} // idlers are notified of new messages while their connection is idling.
+	mutex          sync.Mutex
+}
+
+// addMessages places new messages in the inbox and notifies the idling connections.
+func (server *fakeIMAPServer) addMessages(contents ...string) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	for _, content := range contents {
+		server.mailboxes["INBOX"] = append(server.mailboxes["INBOX"], &fakeIMAPMessage{uid: server.nextUID, content: strings.Replace(content, "\n", "\r\n", -1)})
+		server.nextUID++
+	}
+	for _, idler := range server.idlers {
+		close(idler)
+	}
+	server.idlers = nil
+}
+
+func startFakeIMAPServer(t *testing.T, messages ...string) *fakeIMAPServer {
+	// Generate a self-signed certificate for the TLS listener
+	key, err := ecdsa.GenerateKey(elliptic.P256(), cryptoRand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour)}
+	certDER, err := x509.CreateCertificate(cryptoRand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{certDER}, PrivateKey: key}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &fakeIMAPServer{listener: listener, mailboxes: map[string][]*fakeIMAPMessage{"INBOX": {}, "Archive": {}}}
+	server.nextUID = 100
+	server.addMessages(messages...)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+func (server *fakeIMAPServer) port() int {
+	return server.listener.Addr().(*net.TCPAddr).Port
+}
+
+// lastRequest returns the last request received before LOGOUT.
+func (server *fakeIMAPServer) lastRequest() string {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return server.requests[len(server.requests)-2]
+}
+
+// sequenceSet returns the 1-based message numbers of a sequence set such as "1:3" or "2,5".
+func (server *fakeIMAPServer) sequenceSet(set string, total int) (nums []int) {
+	for _, part := range strings.Split(set, ",") {
+		bounds := strings.Split(part, ":")
+		from, _ := strconv.Atoi(bounds[0])
+		to := from
+		if len(bounds) == 2 {
+			to, _ = strconv.Atoi(bounds[1])
+		}
+		for num := from; num <= to && num <= total; num++ {
+			nums = append(nums, num)
+		}
+	}
+	return
+}
+
+func (server *fakeIMAPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	writeMutex := new(sync.Mutex)
+	write := func(str string) {
+		writeMutex.Lock()
+		defer writeMutex.Unlock()
+		_, _ = conn.Write([]byte(str))
+	}
+	write("* OK fake IMAP server ready\r\n")
+	mailbox := "INBOX"
+	var idleTag string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		if strings.TrimSpace(line) == "DONE" {
+			write(idleTag + " OK IDLE terminated\r\n")
+			continue
+		}
+		fields := strings.SplitN(strings.TrimSpace(line), " ", 2)
+		tag, request := fields[0], fields[1]
+		server.mutex.Lock()
+		server.requests = append(server.requests, request)
+		messages := server.mailboxes[mailbox]
+		var resp strings.Builder
+		status := "OK done"
+		words := strings.Fields(request)
+		switch strings.ToUpper(words[0]) {
+		case "IDLE":
+			idleTag = tag
+			idler := make(chan struct{})
+			server.idlers = append(server.idlers, idler)
+			write("+ idling\r\n")
+			go func() {
+				<-idler
+				server.mutex.Lock()
+				num := len(server.mailboxes["INBOX"])
+				server.mutex.Unlock()
+				write(fmt.Sprintf("* %d EXISTS\r\n", num))
+			}()
+			server.mutex.Unlock()
+			continue
+		case "CAPABILITY":
+			capabilities := "IMAP4rev1"
+			if server.supportIdle {
+				capabilities += " IDLE"
+			}
+			if server.supportUIDPlus {
+				capabilities += " UIDPLUS"
+			}
+			resp.WriteString("* CAPABILITY " + capabilities + "\r\n")
+		case "STATUS":
+			resp.WriteString(fmt.Sprintf("* STATUS INBOX (UIDNEXT %d)\r\n", server.nextUID))
+		case "LOGIN", "NOOP":
+		case "LOGOUT":
+			resp.WriteString("* BYE\r\n")
+		case "SELECT", "EXAMINE":
+			resp.WriteString(fmt.Sprintf("* %d EXISTS\r\n", len(messages)))
+		case "FETCH":
+			for _, num := range server.sequenceSet(words[1], len(messages)) {
+				msg := messages[num-1]
+				switch {
+				case words[2] == "(UID)":
+					resp.WriteString(fmt.Sprintf("* %d FETCH (UID %d)\r\n", num, msg.uid))
+				case words[2] == "BODY.PEEK[HEADER]":
+					header := msg.content[:strings.Index(msg.content, "\r\n\r\n")+4]
+					resp.WriteString(fmt.Sprintf("* %d FETCH (BODY[HEADER] {%d}\r\n%s)\r\n", num, len(header), header))
+				case words[2] == "BODY[]":
+					msg.seen = true
+					resp.WriteString(fmt.Sprintf("* %d FETCH (BODY[] {%d}\r\n%s\r\n)\r\n", num, len(msg.content), msg.content))
+				}
+			}
+		case "STORE":
+			for _, num := range server.sequenceSet(words[1], len(messages)) {
+				messages[num-1].seen = true
+			}
+		case "SEARCH":
+			// Only understand UNSEEN and a single quoted FROM/SUBJECT text
+			criteria := strings.Join(words[1:], " ")
+			var found []string
+			var uidFrom, uidTo int
+			if uidRange := regexp.MustCompile(`UID (\d+):(\d+)`).FindStringSubmatch(criteria); len(uidRange) == 3 {
+				uidFrom, _ = strconv.Atoi(uidRange[1])
+				uidTo, _ = strconv.Atoi(uidRange[2])
+			}
+			for i, msg := range messages {
+				match := true
+				if uidTo > 0 && (msg.uid < uidFrom || msg.uid > uidTo) {
+					match = false
+				}
+				if strings.Contains(criteria, "UNSEEN") && msg.seen {
+					match = false
+				}
+				if quoted := regexp.MustCompile(`(FROM|SUBJECT) "([^"]*)"`).FindStringSubmatch(criteria); len(quoted) == 3 && !strings.Contains(strings.ToLower(msg.content), strings.ToLower(quoted[2])) {
+					match = false
+				}
+				if match {
+					found = append(found, strconv.Itoa(i+1))
+				}
+			}
+			resp.WriteString("* SEARCH " + strings.Join(found, " ") + "\r\n")
+		case "UID":
+			if strings.ToUpper(words[1]) == "SEARCH" {
+				// Only understand DELETED
+				var found []string
+				for _, msg := range messages {
+					if msg.deleted {
+						found = append(found, strconv.Itoa(msg.uid))
+					}
+				}
+				resp.WriteString("* SEARCH " + strings.Join(found, " ") + "\r\n")
+				break
+			}
+			uid, _ := strconv.Atoi(words[2])
+			var msg *fakeIMAPMessage
+			for _, m := range messages {
+				if m.uid == uid {
+					msg = m
+				}
+			}
+			switch subCommand := strings.ToUpper(words[1]); {
+			case msg == nil:
+				status = "NO no such message"
+			case subCommand == "STORE":
+				msg.deleted = true
+			case subCommand == "COPY", subCommand == "MOVE" && server.supportMove:
+				dest := strings.Trim(strings.Join(words[3:], " "), `"`)
+				copied := *msg
+				server.mailboxes[dest] = append(server.mailboxes[dest], &copied)
+				if subCommand == "MOVE" {
+					msg.deleted = true
+					words[0] = "EXPUNGE"
+				}
+			case subCommand == "EXPUNGE" && server.supportUIDPlus:
+				remaining := make([]*fakeIMAPMessage, 0)
+				for _, m := range messages {
+					if m != msg || !msg.deleted {
+						remaining = append(remaining, m)
+					}
+				}
+				server.mailboxes[mailbox] = remaining
+			default:
+				status = "BAD unknown command"
+			}
+		case "EXPUNGE":
+		default:
+			status = "BAD unknown command"
+		}
+		if strings.ToUpper(words[0]) == "EXPUNGE" {
+			remaining := make([]*fakeIMAPMessage, 0)
+			for _, msg := range messages {
+				if !msg.deleted {
+					remaining = append(remaining, msg)
+				}
+			}
+			server.mailboxes[mailbox] = remaining
+		}
+		server.mutex.Unlock()
+		write(resp.String() + tag + " " + status + "\r\n")
+	}
+}
+
+func TestIMAPAccounts_FakeServer(t *testing.T) {
+	server := startFakeIMAPServer(t,
+		"From: Alice <alice@example.com>\nSubject: =?UTF-8?Q?Caf=C3=A9?= menu\n\nsee you at the cafe\n",
+		"From: bob@example.com\nSubject: report\nMIME-Version: 1.0\nContent-Type: multipart/mixed; boundary=b\n\n--b\nContent-Type: text/plain\nContent-Transfer-Encoding: base64\n\nSGVyZSBpcyB0aGUgcmVwb3J0\n--b\nContent-Type: application/pdf\nContent-Disposition: attachment; filename=report.pdf\nContent-Transfer-Encoding: base64\n\nYWJj\n--b--\n",
+		"From: alice@example.com\nSubject: lunch\n\nnoon?\n",
+	)
+	defer server.listener.Close()
+	accounts := IMAPAccounts{Accounts: map[string]*IMAPS{"a": {Host: "127.0.0.1", Port: server.port(), InsecureSkipVerify: true, AuthUsername: "u", AuthPassword: "p"}}}
+	if err := accounts.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	if err := accounts.SelfTest(); err != nil {
+		t.Fatal(err)
+	}
+	execute := func(content string) *Result {
+		return accounts.Execute(context.Background(), Command{TimeoutSec: 10, Content: content})
+	}
+	// List all, the most recent message comes first, and the encoded subject is decoded.
+	if ret := execute("l a 0 10"); ret.Error != nil || ret.Output != "3 alice@example.com lunch\n2 bob@example.com report\n1 alice@example.com Café menu\n" {
+		t.Fatal(ret)
+	}
+	// Read a multipart message
+	if ret := execute("r a 2"); ret.Error != nil || ret.Output != "Here is the report\n\nAttachments: report.pdf (1KB)" {
+		t.Fatal(ret)
+	}
+	// The message read is no longer unread
+	if ret := execute("u a"); ret.Error != nil || ret.Output != "2 found\n3 alice@example.com lunch\n1 alice@example.com Café menu\n" {
+		t.Fatal(ret)
+	}
+	if ret := execute("u a 1 1"); ret.Error != nil || ret.Output != "2 found\n1 alice@example.com Café menu\n" {
+		t.Fatal(ret)
+	}
+	if ret := execute("m a 1 3"); ret.Error != nil || ret.Output != "OK - marked 2 message(s) as read" || server.lastRequest() != "STORE 1,3 +FLAGS.SILENT (\\Seen)" {
+		t.Fatal(ret, server.lastRequest())
+	}
+	if ret := execute("u a"); ret.Error != nil || ret.Output != "0 found\n" {
+		t.Fatal(ret)
+	}
+	// Search
+	if ret := execute("s a from alice since 2021-01-31"); ret.Error != nil || ret.Output != "2 found\n3 alice@example.com lunch\n1 alice@example.com Café menu\n" {
+		t.Fatal(ret)
+	}
+	if ret := execute("s a subject lunch"); ret.Error != nil || ret.Output != "1 found\n3 alice@example.com lunch\n" {
+		t.Fatal(ret)
+	}
+	for _, bad := range []string{"s a", "s a lunch", "s a since yesterday", "s a from", "m a", "m a x", "d a", "d a x", "v a 1", "u a 1", "d does-not-exist 1"} {
+		if ret := execute(bad); ret.Error == nil {
+			t.Fatal("should have failed", bad)
+		}
+	}
+	// Delete requires a confirmation code
+	ret := execute("d a 1")
+	code := regexp.MustCompile(`d a 1 (\d{6})`).FindStringSubmatch(ret.Output)
+	if ret.Error != nil || len(code) != 2 || !strings.Contains(ret.Output, "Café menu") {
+		t.Fatal(ret)
+	}
+	if ret := execute("d a 2 " + code[1]); ret.Error == nil {
+		t.Fatal("should not have accepted code of another message")
+	}
+	// The code is spent even if it was used incorrectly
+	if ret := execute("d a 1 " + code[1]); ret.Error == nil {
+		t.Fatal("should not have accepted a spent code")
+	}
+	ret = execute("d a 1")
+	code = regexp.MustCompile(`d a 1 (\d{6})`).FindStringSubmatch(ret.Output)
+	if ret := execute("d a 1 " + code[1]); ret.Error != nil || ret.Output != "OK - deleted message 1" {
+		t.Fatal(ret)
+	}
+	if ret := execute("l a 0 10"); ret.Error != nil || ret.Output != "2 alice@example.com lunch\n1 bob@example.com report\n" {
+		t.Fatal(ret)
+	}
+	// Move falls back to copy and delete when the server does not support MOVE
+	ret = execute("v a 1 Archive")
+	code = regexp.MustCompile(`v a 1 Archive (\d{6})`).FindStringSubmatch(ret.Output)
+	if ret.Error != nil || len(code) != 2 {
+		t.Fatal(ret)
+	}
+	if ret := execute("v a 1 Archive " + code[1]); ret.Error != nil || ret.Output != "OK - moved message 1 to Archive" {
+		t.Fatal(ret)
+	}
+	server.mutex.Lock()
+	server.supportMove = true
+	server.mutex.Unlock()
+	ret = execute("v a 1 Old Stuff")
+	code = regexp.MustCompile(`v a 1 Old Stuff (\d{6})`).FindStringSubmatch(ret.Output)
+	if ret := execute("v a 1 Old Stuff " + code[1]); ret.Error != nil || ret.Output != "OK - moved message 1 to Old Stuff" {
+		t.Fatal(ret)
+	}
+	server.mutex.Lock()
+	if len(server.mailboxes["INBOX"]) != 0 || len(server.mailboxes["Archive"]) != 1 || len(server.mailboxes["Old Stuff"]) != 1 {
+		t.Fatalf("%+v", server.mailboxes)
+	}
+	server.mutex.Unlock()
+
+	// Another client has flagged a message as deleted but has not expunged it yet
+	server.addMessages("From: carol@example.com\nSubject: keep\n\nkeep me\n", "From: dave@example.com\nSubject: drop\n\ndrop me\n")
+	server.mutex.Lock()
+	server.mailboxes["INBOX"][0].deleted = true
+	server.mutex.Unlock()
+	// Without UIDPLUS, an expunge would purge the other client's message too, hence the deletion is refused.
+	ret = execute("d a 2")
+	code = regexp.MustCompile(`d a 2 (\d{6})`).FindStringSubmatch(ret.Output)
+	if ret := execute("d a 2 " + code[1]); ret.Error == nil {
+		t.Fatal("should have refused to expunge")
+	}
+	server.mutex.Lock()
+	server.supportMove = false
+	server.mutex.Unlock()
+	ret = execute("v a 2 Archive")
+	code = regexp.MustCompile(`v a 2 Archive (\d{6})`).FindStringSubmatch(ret.Output)
+	if ret := execute("v a 2 Archive " + code[1]); ret.Error == nil {
+		t.Fatal("should have refused to expunge")
+	}
+	server.mutex.Lock()
+	if len(server.mailboxes["INBOX"]) != 2 || len(server.mailboxes["Archive"]) != 1 {
+		t.Fatalf("%+v", server.mailboxes)
+	}
+	server.supportUIDPlus = true
+	server.mutex.Unlock()
+	// UID EXPUNGE leaves the other client's message alone
+	ret = execute("d a 2")
+	code = regexp.MustCompile(`d a 2 (\d{6})`).FindStringSubmatch(ret.Output)
+	if ret := execute("d a 2 " + code[1]); ret.Error != nil || ret.Output != "OK - deleted message 2" {
+		t.Fatal(ret)
+	}
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if len(server.mailboxes["INBOX"]) != 1 || !strings.Contains(server.mailboxes["INBOX"][0].content, "keep me") {
+		t.Fatalf("%+v", server.mailboxes)
+	}
+}
+
+func TestBuildIMAPSearchCriteria(t *testing.T) {
+	if criteria, err := BuildIMAPSearchCriteria([]string{"FROM", "Alice", "Smith", "subject", `say "hi"`, "unread", "before", "2021-03-04", "on", "2021-03-01"}); err != nil || criteria != `FROM "Alice Smith" SUBJECT "say \"hi\"" UNSEEN BEFORE 4-Mar-2021 ON 1-Mar-2021` {
+		t.Fatal(criteria, err)
+	}
+	if criteria, err := BuildIMAPSearchCriteria([]string{"subject", "café"}); err != nil || criteria != `CHARSET UTF-8 SUBJECT "café"` {
+		t.Fatal(criteria, err)
+	}
+}
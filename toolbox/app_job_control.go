@@ -0,0 +1,68 @@
+package toolbox
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// JobControlTrigger is the trigger prefix string of JobControl app.
+const JobControlTrigger = ".o"
+
+// ErrBadJobControlChoice reminds user of the proper syntax to invoke JobControl app.
+var ErrBadJobControlChoice = errors.New(`(list) | ID | cancel ID`)
+
+/*
+JobControl lists background jobs, retrieves their output, and cancels them. Background jobs are started by prefixing an
+app command with PrefixCommandBackground, via any daemon. A job may only be inspected and cancelled using the password
+that started it.
+*/
+type JobControl struct {
+}
+
+// IsConfigured always returns true because configuration is not required for this app.
+func (jc *JobControl) IsConfigured() bool {
+	return true
+}
+
+// SelfTest always returns nil because there is nothing to test.
+func (jc *JobControl) SelfTest() error {
+	return nil
+}
+
+// Initialise does nothing because initialisation is not required for this app.
+func (jc *JobControl) Initialise() error {
+	return nil
+}
+
+// Trigger returns the trigger prefix string ".o", which stands for "output".
+func (jc *JobControl) Trigger() Trigger {
+	return JobControlTrigger
+}
+
+// Execute lists all jobs if the command is empty, retrieves output of a job by its ID, or cancels a job.
+func (jc *JobControl) Execute(ctx context.Context, cmd Command) *Result {
+	params := strings.Fields(cmd.Content)
+	owner := cmd.resultKey.principal()
+	switch {
+	case len(params) == 0:
+		status := BackgroundJobs.GetStatus(owner)
+		if len(status) == 0 {
+			return &Result{Output: "there are no background jobs"}
+		}
+		return &Result{Output: strings.Join(status, "\n")}
+	case len(params) == 1:
+		result, err := BackgroundJobs.GetResult(params[0], owner)
+		if err != nil {
+			return &Result{Error: err}
+		}
+		return result
+	case len(params) == 2 && strings.ToLower(params[0]) == "cancel":
+		if err := BackgroundJobs.Cancel(params[1], owner); err != nil {
+			return &Result{Error: err}
+		}
+		return &Result{Output: "OK - the job has been asked to cancel"}
+	default:
+		return &Result{Error: ErrBadJobControlChoice}
+	}
+}
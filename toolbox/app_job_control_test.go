@@ -0,0 +1,114 @@
+package toolbox
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJobControl_Execute(t *testing.T) {
+	proc := GetTestCommandProcessor()
+	jc := proc.Features.JobControl
+	if !jc.IsConfigured() {
+		t.Fatal("not configured")
+	}
+	if err := jc.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	if err := jc.SelfTest(); err != nil {
+		t.Fatal(err)
+	}
+	if result := jc.Execute(context.Background(), Command{Content: "a b c"}); result.Error != ErrBadJobControlChoice {
+		t.Fatal(result)
+	}
+	if result := jc.Execute(context.Background(), Command{Content: "does-not-exist"}); result.Error == nil {
+		t.Fatal("should have failed")
+	}
+	// Start a quick job and a slow job
+	quickResult := proc.Process(context.Background(), Command{Content: TestCommandProcessorPIN + " .bg .s echo quick", TimeoutSec: 1}, false)
+	if quickResult.Error != nil || len(quickResult.Output) != 4 {
+		t.Fatal(quickResult)
+	}
+	slowResult := proc.Process(context.Background(), Command{Content: TestCommandProcessorPIN + " .bg .s echo slow; sleep 10; echo late", TimeoutSec: 1}, false)
+	if slowResult.Error != nil || len(slowResult.Output) != 4 {
+		t.Fatal(slowResult)
+	}
+	time.Sleep(2 * time.Second)
+	owner := newResultEncryptionKey(TestCommandProcessorPIN)
+	// Jobs started by a password are not visible to other passwords
+	other := newResultEncryptionKey("another password")
+	if result := jc.Execute(context.Background(), Command{resultKey: other, Content: ""}); result.Error != nil || result.Output != "there are no background jobs" {
+		t.Fatal(result)
+	}
+	if result := jc.Execute(context.Background(), Command{resultKey: other, Content: quickResult.Output}); result.Error == nil || result.Output != "" {
+		t.Fatal(result)
+	}
+	if result := jc.Execute(context.Background(), Command{resultKey: other, Content: "cancel " + slowResult.Output}); result.Error == nil {
+		t.Fatal(result)
+	}
+	// Retrieve the output of the quick job, which has finished.
+	if result := jc.Execute(context.Background(), Command{resultKey: owner, Content: quickResult.Output}); result.Error != nil || result.Output != "quick\n" {
+		t.Fatal(result)
+	}
+	// Retrieve partial output of the slow job, which is still running.
+	if result := jc.Execute(context.Background(), Command{resultKey: owner, Content: slowResult.Output}); result.Error == nil || result.Output != "slow\n" {
+		t.Fatal(result)
+	}
+	// List the jobs
+	if result := jc.Execute(context.Background(), Command{resultKey: owner, Content: ""}); result.Error != nil ||
+		!strings.Contains(result.Output, quickResult.Output+" done .s") || !strings.Contains(result.Output, slowResult.Output+" running .s") {
+		t.Fatal(result)
+	}
+	// Cancel the slow job
+	if result := jc.Execute(context.Background(), Command{resultKey: owner, Content: "cancel " + slowResult.Output}); result.Error != nil {
+		t.Fatal(result)
+	}
+	if result := jc.Execute(context.Background(), Command{resultKey: owner, Content: "cancel " + quickResult.Output}); result.Error == nil {
+		t.Fatal("should not be able to cancel a completed job")
+	}
+	time.Sleep(3 * time.Second)
+	if result := jc.Execute(context.Background(), Command{resultKey: owner, Content: slowResult.Output}); result.Error != context.Canceled || strings.Contains(result.Output, "late") {
+		t.Fatal(result)
+	}
+	// A command that does not come with a password, such as a shortcut, may not start a job.
+	proc.CommandFilters[0] = &PINAndShortcuts{Passwords: []string{TestCommandProcessorPIN}, Shortcuts: map[string]string{"bgecho": ".bg .s echo shortcut"}}
+	if result := proc.Process(context.Background(), Command{Content: "bgecho", TimeoutSec: 1}, false); result.Error != ErrBackgroundJobWithoutPassword {
+		t.Fatal(result)
+	}
+}
+
+func TestBackgroundJobTable(t *testing.T) {
+	table := NewBackgroundJobTable(2)
+	shell := &Shell{}
+	if err := shell.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	job1, err := table.Start(shell, ".s", Command{Content: "sleep 3", TimeoutSec: 10}, "sleep 3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	job2, err := table.Start(shell, ".s", Command{Content: "sleep 3", TimeoutSec: 10}, "sleep 3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Both jobs are still running, there is no room for a third.
+	if _, err := table.Start(shell, ".s", Command{Content: "true", TimeoutSec: 10}, "true"); err != ErrTooManyBackgroundJobs {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Second)
+	// The oldest completed job makes room for the new job
+	job3, err := table.Start(shell, ".s", Command{Content: "true", TimeoutSec: 10}, "true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := table.GetResult(job1.ID, ""); err == nil {
+		t.Fatal("oldest job should have been evicted")
+	}
+	if _, err := table.GetResult(job2.ID, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := table.GetResult(job3.ID, ""); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,350 @@
+package toolbox
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HouzuoGuo/laitos/misc"
+)
+
+const (
+	// NoteVaultTrigger is the trigger prefix string of NoteVault app.
+	NoteVaultTrigger = ".n"
+	// NoteVaultFileHeader is prepended to the vault file as a clue to file readers.
+	NoteVaultFileHeader = "laitos-note-vault-v1\n"
+	// NoteVaultSaltSizeBytes is the size of random salt used in key derivation.
+	NoteVaultSaltSizeBytes = 16
+	// NoteVaultKDFIterations is the number of PBKDF2 iterations that derive the encryption key from passphrase.
+	NoteVaultKDFIterations = 100000
+	// NoteVaultMinPassphraseLength is the minimum length of passphrase for creating a new vault.
+	NoteVaultMinPassphraseLength = 8
+	// MaxNoteVaultEntries is the maximum number of notes and secrets in a vault.
+	MaxNoteVaultEntries = 5000
+)
+
+var (
+	// ErrBadNoteVaultChoice reminds user of the proper syntax to invoke NoteVault app.
+	ErrBadNoteVaultChoice = errors.New(`passphrase add [#tag..] note | set name [#tag..] secret | get ID|name | search text | del ID|name | list [#tag]`)
+	// ErrNoteVaultPassphrase is returned when the vault cannot be decrypted by the passphrase.
+	ErrNoteVaultPassphrase = errors.New("incorrect passphrase or corrupted vault")
+)
+
+// NoteVaultEntry is either a note identified by its ID, or a secret identified by both its ID and name.
+type NoteVaultEntry struct {
+	ID       int      `json:"ID"`
+	Name     string   `json:"Name"` // Name is the name of a secret, it is empty for a note.
+	Tags     []string `json:"Tags"`
+	Text     string   `json:"Text"`
+	Modified int64    `json:"Modified"` // Modified is the unix timestamp in seconds of the most recent modification.
+}
+
+// String returns the entry text prefixed by its ID, name, and tags.
+func (entry *NoteVaultEntry) String() string {
+	var ret bytes.Buffer
+	ret.WriteString("#" + strconv.Itoa(entry.ID))
+	if entry.Name != "" {
+		ret.WriteString(" " + entry.Name)
+	}
+	for _, tag := range entry.Tags {
+		ret.WriteString(" #" + tag)
+	}
+	ret.WriteString(": " + entry.Text)
+	return ret.String()
+}
+
+// hasTag returns true only if the entry has the tag.
+func (entry *NoteVaultEntry) hasTag(tag string) bool {
+	for _, entryTag := range entry.Tags {
+		if entryTag == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// noteVaultContent is the plain text content of the vault file.
+type noteVaultContent struct {
+	LastID  int               `json:"LastID"` // LastID is the ID of most recently added entry, IDs are never reused.
+	Entries []*NoteVaultEntry `json:"Entries"`
+}
+
+/*
+NoteVault stores short notes and named secrets in a file encrypted by AES-GCM. The encryption key is derived from a
+passphrase supplied in each app command, the passphrase itself is never stored.
+*/
+type NoteVault struct {
+	// FilePath is the location of the encrypted vault file, it is created by the first "add" or "set" command.
+	FilePath string `json:"FilePath"`
+
+	mutex *sync.Mutex
+}
+
+func (vault *NoteVault) IsConfigured() bool {
+	return vault.FilePath != ""
+}
+
+func (vault *NoteVault) SelfTest() error {
+	if !vault.IsConfigured() {
+		return ErrIncompleteConfig
+	}
+	if _, err := os.Stat(vault.FilePath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("NoteVault.SelfTest: vault file \"%s\" is no longer readable - %v", vault.FilePath, err)
+	}
+	return nil
+}
+
+func (vault *NoteVault) Initialise() error {
+	vault.mutex = new(sync.Mutex)
+	return nil
+}
+
+func (vault *NoteVault) Trigger() Trigger {
+	return NoteVaultTrigger
+}
+
+// deriveNoteVaultKey derives the AES-256 key from the passphrase and salt.
+func deriveNoteVaultKey(passphrase string, salt []byte) []byte {
+	return misc.PBKDF2SHA256([]byte(passphrase), salt, NoteVaultKDFIterations, 32)
+}
+
+// newNoteVaultGCM returns the AES-GCM cipher keyed by the passphrase and salt.
+func newNoteVaultGCM(passphrase string, salt []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(deriveNoteVaultKey(passphrase, salt))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+/*
+load decrypts and returns the vault content, along with the salt of the key. If the vault file does not exist yet, it
+returns empty content and a nil salt.
+*/
+func (vault *NoteVault) load(passphrase string) (content noteVaultContent, salt []byte, err error) {
+	fileContent, err := ioutil.ReadFile(vault.FilePath)
+	if os.IsNotExist(err) {
+		return content, nil, nil
+	} else if err != nil {
+		return
+	}
+	if !bytes.HasPrefix(fileContent, []byte(NoteVaultFileHeader)) {
+		err = fmt.Errorf("\"%s\" is not a note vault", vault.FilePath)
+		return
+	}
+	fileContent = fileContent[len(NoteVaultFileHeader):]
+	if len(fileContent) < NoteVaultSaltSizeBytes {
+		err = ErrNoteVaultPassphrase
+		return
+	}
+	salt, fileContent = fileContent[:NoteVaultSaltSizeBytes], fileContent[NoteVaultSaltSizeBytes:]
+	gcm, err := newNoteVaultGCM(passphrase, salt)
+	if err != nil {
+		return
+	}
+	if len(fileContent) < gcm.NonceSize() {
+		err = ErrNoteVaultPassphrase
+		return
+	}
+	plainContent, err := gcm.Open(nil, fileContent[:gcm.NonceSize()], fileContent[gcm.NonceSize():], []byte(NoteVaultFileHeader))
+	if err != nil {
+		err = ErrNoteVaultPassphrase
+		return
+	}
+	err = json.Unmarshal(plainContent, &content)
+	return
+}
+
+// save encrypts the vault content and writes it to the vault file. A nil salt creates a new vault.
+func (vault *NoteVault) save(passphrase string, salt []byte, content noteVaultContent) error {
+	if salt == nil {
+		if len(passphrase) < NoteVaultMinPassphraseLength {
+			return fmt.Errorf("the passphrase of a new vault must be at least %d characters long", NoteVaultMinPassphraseLength)
+		}
+		salt = make([]byte, NoteVaultSaltSizeBytes)
+		if _, err := rand.Read(salt); err != nil {
+			return err
+		}
+	}
+	gcm, err := newNoteVaultGCM(passphrase, salt)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	plainContent, err := json.Marshal(content)
+	if err != nil {
+		return err
+	}
+	var fileContent bytes.Buffer
+	fileContent.WriteString(NoteVaultFileHeader)
+	fileContent.Write(salt)
+	fileContent.Write(nonce)
+	fileContent.Write(gcm.Seal(nil, nonce, plainContent, []byte(NoteVaultFileHeader)))
+	// Write to a temporary file first so that a failed write does not corrupt the vault
+	tmpPath := vault.FilePath + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, fileContent.Bytes(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, vault.FilePath)
+}
+
+// splitNoteVaultTags removes the leading #tag words from the words, and returns the tags (without #) and the remaining text.
+func splitNoteVaultTags(words []string) (tags []string, text string) {
+	tags = make([]string, 0)
+	for len(words) > 0 && len(words[0]) > 1 && words[0][0] == '#' {
+		tags = append(tags, strings.ToLower(words[0][1:]))
+		words = words[1:]
+	}
+	return tags, strings.Join(words, " ")
+}
+
+// findNoteVaultEntry returns the index of entry identified by the ID or name, or -1 if the entry is not found.
+func findNoteVaultEntry(entries []*NoteVaultEntry, idOrName string) int {
+	id, _ := strconv.Atoi(strings.TrimPrefix(idOrName, "#"))
+	for i, entry := range entries {
+		if entry.ID == id || entry.Name != "" && strings.EqualFold(entry.Name, idOrName) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (vault *NoteVault) Execute(ctx context.Context, cmd Command) *Result {
+	if errResult := cmd.Trim(); errResult != nil {
+		return errResult
+	}
+	params := strings.Fields(cmd.Content)
+	if len(params) < 2 {
+		return &Result{Error: ErrBadNoteVaultChoice}
+	}
+	passphrase, action, params := params[0], strings.ToLower(params[1]), params[2:]
+	vault.mutex.Lock()
+	defer vault.mutex.Unlock()
+	content, salt, err := vault.load(passphrase)
+	if err != nil {
+		return &Result{Error: err}
+	}
+	switch {
+	case action == "add" && len(params) > 0:
+		tags, text := splitNoteVaultTags(params)
+		if text == "" {
+			return &Result{Error: ErrBadNoteVaultChoice}
+		}
+		if len(content.Entries) >= MaxNoteVaultEntries {
+			return &Result{Error: fmt.Errorf("the vault may store at most %d entries", MaxNoteVaultEntries)}
+		}
+		content.LastID++
+		entry := &NoteVaultEntry{ID: content.LastID, Tags: tags, Text: text, Modified: time.Now().Unix()}
+		content.Entries = append(content.Entries, entry)
+		if err := vault.save(passphrase, salt, content); err != nil {
+			return &Result{Error: err}
+		}
+		return &Result{Output: "OK - added #" + strconv.Itoa(entry.ID)}
+	case action == "set" && len(params) > 1:
+		name := params[0]
+		if _, err := strconv.Atoi(strings.TrimPrefix(name, "#")); err == nil || name[0] == '#' {
+			return &Result{Error: errors.New("the name of a secret may not be a number or begin with #")}
+		}
+		tags, text := splitNoteVaultTags(params[1:])
+		if text == "" {
+			return &Result{Error: ErrBadNoteVaultChoice}
+		}
+		// Setting an existing secret replaces its tags and text while keeping its ID
+		var entry *NoteVaultEntry
+		if i := findNoteVaultEntry(content.Entries, name); i != -1 {
+			entry = content.Entries[i]
+		} else {
+			if len(content.Entries) >= MaxNoteVaultEntries {
+				return &Result{Error: fmt.Errorf("the vault may store at most %d entries", MaxNoteVaultEntries)}
+			}
+			content.LastID++
+			entry = &NoteVaultEntry{ID: content.LastID, Name: name}
+			content.Entries = append(content.Entries, entry)
+		}
+		entry.Tags, entry.Text, entry.Modified = tags, text, time.Now().Unix()
+		if err := vault.save(passphrase, salt, content); err != nil {
+			return &Result{Error: err}
+		}
+		return &Result{Output: fmt.Sprintf("OK - set #%d %s", entry.ID, entry.Name)}
+	case action == "get" && len(params) == 1:
+		i := findNoteVaultEntry(content.Entries, params[0])
+		if i == -1 {
+			return &Result{Error: fmt.Errorf("\"%s\" is not found", params[0])}
+		}
+		return &Result{Output: content.Entries[i].String()}
+	case action == "del" && len(params) == 1:
+		i := findNoteVaultEntry(content.Entries, params[0])
+		if i == -1 {
+			return &Result{Error: fmt.Errorf("\"%s\" is not found", params[0])}
+		}
+		deleted := content.Entries[i]
+		content.Entries = append(content.Entries[:i], content.Entries[i+1:]...)
+		if err := vault.save(passphrase, salt, content); err != nil {
+			return &Result{Error: err}
+		}
+		return &Result{Output: "OK - deleted #" + strconv.Itoa(deleted.ID)}
+	case action == "search" && len(params) > 0:
+		// Case insensitive search among names, tags, and text. The most recently modified entry comes first.
+		searchString := strings.ToLower(strings.Join(params, " "))
+		matches := make([]*NoteVaultEntry, 0)
+		for _, entry := range content.Entries {
+			if strings.Contains(strings.ToLower(entry.String()), searchString) {
+				matches = append(matches, entry)
+			}
+		}
+		return &Result{Output: formatNoteVaultEntries(matches)}
+	case action == "list" && len(params) == 0:
+		// Count the entries of each tag
+		tagCount := make(map[string]int)
+		for _, entry := range content.Entries {
+			for _, tag := range entry.Tags {
+				tagCount[tag]++
+			}
+		}
+		tags := make([]string, 0, len(tagCount))
+		for tag, count := range tagCount {
+			tags = append(tags, fmt.Sprintf("#%s(%d)", tag, count))
+		}
+		sort.Strings(tags)
+		return &Result{Output: fmt.Sprintf("%d entries, tags: %s", len(content.Entries), strings.Join(tags, " "))}
+	case action == "list" && len(params) == 1:
+		tag := strings.ToLower(strings.TrimPrefix(params[0], "#"))
+		matches := make([]*NoteVaultEntry, 0)
+		for _, entry := range content.Entries {
+			if entry.hasTag(tag) {
+				matches = append(matches, entry)
+			}
+		}
+		return &Result{Output: formatNoteVaultEntries(matches)}
+	default:
+		return &Result{Error: ErrBadNoteVaultChoice}
+	}
+}
+
+// formatNoteVaultEntries returns the number of entries followed by the entries, the most recently modified entry comes first.
+func formatNoteVaultEntries(entries []*NoteVaultEntry) string {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Modified > entries[j].Modified || entries[i].Modified == entries[j].Modified && entries[i].ID > entries[j].ID
+	})
+	lines := make([]string, 0, len(entries)+1)
+	lines = append(lines, strconv.Itoa(len(entries)))
+	for _, entry := range entries {
+		lines = append(lines, entry.String())
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,110 @@
+package toolbox
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNoteVault_Execute(t *testing.T) {
+	dir, err := ioutil.TempDir("", "laitos-TestNoteVault_Execute")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	vault := NoteVault{}
+	if vault.IsConfigured() {
+		t.Fatal("should not be configured")
+	}
+	vault.FilePath = filepath.Join(dir, "vault")
+	if !vault.IsConfigured() {
+		t.Fatal("should be configured")
+	}
+	if err := vault.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	if err := vault.SelfTest(); err != nil {
+		t.Fatal(err)
+	}
+	const pass = "correct-horse"
+	// Bad input
+	for _, bad := range []string{"", pass, pass + " add", pass + " add #tag", pass + " set name", pass + " set 123 abc", pass + " get", pass + " list a b", pass + " unknown"} {
+		if result := vault.Execute(context.Background(), Command{Content: bad}); result.Error == nil {
+			t.Fatal("should have failed", bad)
+		}
+	}
+	// A new vault requires a long passphrase
+	if result := vault.Execute(context.Background(), Command{Content: "short add hello"}); result.Error == nil {
+		t.Fatal("should have failed")
+	}
+	// An empty vault
+	if result := vault.Execute(context.Background(), Command{Content: pass + " list"}); result.Error != nil || result.Output != "0 entries, tags: " {
+		t.Fatal(result)
+	}
+	// Add notes and secrets
+	if result := vault.Execute(context.Background(), Command{Content: pass + " add #Shopping #home milk and eggs"}); result.Error != nil || result.Output != "OK - added #1" {
+		t.Fatal(result)
+	}
+	if result := vault.Execute(context.Background(), Command{Content: pass + " add #home call plumber"}); result.Error != nil || result.Output != "OK - added #2" {
+		t.Fatal(result)
+	}
+	if result := vault.Execute(context.Background(), Command{Content: pass + " set wifi #home hunter2"}); result.Error != nil || result.Output != "OK - set #3 wifi" {
+		t.Fatal(result)
+	}
+	// Replace the secret
+	if result := vault.Execute(context.Background(), Command{Content: pass + " set WIFI #home #net hunter3"}); result.Error != nil || result.Output != "OK - set #3 wifi" {
+		t.Fatal(result)
+	}
+	// The vault file does not reveal plain text
+	if content, err := ioutil.ReadFile(vault.FilePath); err != nil || !strings.HasPrefix(string(content), NoteVaultFileHeader) || strings.Contains(string(content), "hunter") {
+		t.Fatal(err, string(content))
+	}
+	// Wrong passphrase
+	if result := vault.Execute(context.Background(), Command{Content: "wrong-passphrase get wifi"}); result.Error != ErrNoteVaultPassphrase {
+		t.Fatal(result)
+	}
+	// Get by name and ID
+	if result := vault.Execute(context.Background(), Command{Content: pass + " get wifi"}); result.Error != nil || result.Output != "#3 wifi #home #net: hunter3" {
+		t.Fatal(result)
+	}
+	if result := vault.Execute(context.Background(), Command{Content: pass + " get #1"}); result.Error != nil || result.Output != "#1 #shopping #home: milk and eggs" {
+		t.Fatal(result)
+	}
+	if result := vault.Execute(context.Background(), Command{Content: pass + " get 100"}); result.Error == nil {
+		t.Fatal("should have failed")
+	}
+	// Search is case insensitive, the most recently modified entry comes first
+	if result := vault.Execute(context.Background(), Command{Content: pass + " search HOME"}); result.Error != nil || result.Output != "3\n#3 wifi #home #net: hunter3\n#2 #home: call plumber\n#1 #shopping #home: milk and eggs" {
+		t.Fatal(result)
+	}
+	if result := vault.Execute(context.Background(), Command{Content: pass + " search and eggs"}); result.Error != nil || result.Output != "1\n#1 #shopping #home: milk and eggs" {
+		t.Fatal(result)
+	}
+	// List tags and list by tag
+	if result := vault.Execute(context.Background(), Command{Content: pass + " list"}); result.Error != nil || result.Output != "3 entries, tags: #home(3) #net(1) #shopping(1)" {
+		t.Fatal(result)
+	}
+	if result := vault.Execute(context.Background(), Command{Content: pass + " list #net"}); result.Error != nil || result.Output != "1\n#3 wifi #home #net: hunter3" {
+		t.Fatal(result)
+	}
+	// Delete by ID and name
+	if result := vault.Execute(context.Background(), Command{Content: pass + " del 1"}); result.Error != nil || result.Output != "OK - deleted #1" {
+		t.Fatal(result)
+	}
+	if result := vault.Execute(context.Background(), Command{Content: pass + " del wifi"}); result.Error != nil || result.Output != "OK - deleted #3" {
+		t.Fatal(result)
+	}
+	if result := vault.Execute(context.Background(), Command{Content: pass + " del wifi"}); result.Error == nil {
+		t.Fatal("should have failed")
+	}
+	// IDs are never reused
+	if result := vault.Execute(context.Background(), Command{Content: pass + " add another"}); result.Error != nil || result.Output != "OK - added #4" {
+		t.Fatal(result)
+	}
+	if result := vault.Execute(context.Background(), Command{Content: pass + " list"}); result.Error != nil || result.Output != "2 entries, tags: #home(1)" {
+		t.Fatal(result)
+	}
+}
@@ -0,0 +1,168 @@
+package toolbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/HouzuoGuo/laitos/lalog"
+	"github.com/HouzuoGuo/laitos/platform"
+)
+
+/*
+PluginRequest is the JSON object written to the standard input of a plugin executable, the plugin reads it in full
+before it begins to work on the app command.
+*/
+type PluginRequest struct {
+	Content    string `json:"Content"`    // Content is the app command content without the trigger prefix.
+	TimeoutSec int    `json:"TimeoutSec"` // TimeoutSec is the number of seconds before the plugin is killed.
+	ClientTag  string `json:"ClientTag"`  // ClientTag identifies the origin of the app command, such as an IP address.
+	DaemonName string `json:"DaemonName"` // DaemonName is the name of the daemon that received the app command.
+	SelfTest   bool   `json:"SelfTest"`   // SelfTest is true if the plugin shall validate its own configuration instead.
+}
+
+/*
+PluginResponse is the JSON object that a plugin executable writes to its standard output before it exits. An empty
+Error indicates success.
+*/
+type PluginResponse struct {
+	Output string `json:"Output"`
+	Error  string `json:"Error"`
+}
+
+// MaxPluginStderrBytes is the maximum number of bytes from plugin's standard error to keep for diagnosis.
+const MaxPluginStderrBytes = 4 * 1024
+
+/*
+Plugin is an app implemented by an external executable program, which is run once for every app command. laitos sends
+the app command to the program in a PluginRequest via standard input, and the program replies with a PluginResponse via
+standard output. Anything written to standard error is considered progress report, and is kept as partial output of a
+background job.
+*/
+type Plugin struct {
+	TriggerPrefix string   `json:"Trigger"`    // TriggerPrefix is the app trigger prefix string, e.g. ".xweather".
+	Executable    string   `json:"Executable"` // Executable is the absolute path or name of the plugin program.
+	Args          []string `json:"Args"`       // Args are passed to the plugin program as its command line arguments.
+}
+
+func (plugin *Plugin) IsConfigured() bool {
+	return plugin.TriggerPrefix != "" && plugin.Executable != ""
+}
+
+func (plugin *Plugin) SelfTest() error {
+	if !plugin.IsConfigured() {
+		return ErrIncompleteConfig
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), platform.CommonOSCmdTimeoutSec*time.Second)
+	defer cancel()
+	if _, err := plugin.invoke(ctx, PluginRequest{TimeoutSec: platform.CommonOSCmdTimeoutSec, SelfTest: true}); err != nil {
+		return fmt.Errorf("Plugin.SelfTest: %v", err)
+	}
+	return nil
+}
+
+func (plugin *Plugin) Initialise() error {
+	if !plugin.IsConfigured() {
+		return ErrIncompleteConfig
+	}
+	if len(plugin.TriggerPrefix) < 2 || plugin.TriggerPrefix[0] != '.' || strings.ContainsAny(plugin.TriggerPrefix, " \t\r\n") {
+		return fmt.Errorf("Plugin.Initialise: trigger \"%s\" must begin with a dot and may not contain space", plugin.TriggerPrefix)
+	}
+	execPath, err := exec.LookPath(plugin.Executable)
+	if err != nil {
+		return fmt.Errorf("Plugin.Initialise: cannot find executable of plugin \"%s\" - %v", plugin.TriggerPrefix, err)
+	}
+	plugin.Executable = execPath
+	return nil
+}
+
+func (plugin *Plugin) Trigger() Trigger {
+	return Trigger(plugin.TriggerPrefix)
+}
+
+func (plugin *Plugin) Execute(ctx context.Context, cmd Command) *Result {
+	timeoutCtx, cancel := context.WithTimeout(ctx, time.Duration(cmd.TimeoutSec)*time.Second)
+	defer cancel()
+	resp, err := plugin.invoke(timeoutCtx, PluginRequest{
+		Content:    cmd.Content,
+		TimeoutSec: cmd.TimeoutSec,
+		ClientTag:  cmd.ClientTag,
+		DaemonName: cmd.DaemonName,
+	})
+	return &Result{Error: err, Output: resp.Output}
+}
+
+/*
+invoke runs the plugin program with the request as its input, and returns the response decoded from its output. If the
+plugin responds with an error, the function returns the error alongside the response.
+*/
+func (plugin *Plugin) invoke(ctx context.Context, req PluginRequest) (resp PluginResponse, err error) {
+	reqJSON, err := json.Marshal(req)
+	if err != nil {
+		return
+	}
+	stdout := &pluginOutputBuffer{maxBytes: platform.MaxExternalProgramOutputBytes}
+	stderr := lalog.NewByteLogWriter(GetJobOutputWriter(ctx), MaxPluginStderrBytes)
+	proc := exec.Command(plugin.Executable, plugin.Args...)
+	proc.Stdin = bytes.NewReader(reqJSON)
+	proc.Stdout = stdout
+	proc.Stderr = stderr
+	// Kill child processes of the plugin too when the plugin runs out of time
+	platform.SetProcessGroup(proc)
+	if err = proc.Start(); err != nil {
+		err = fmt.Errorf("failed to start plugin - %v", err)
+		return
+	}
+	processExitChan := make(chan error, 1)
+	go func() {
+		processExitChan <- proc.Wait()
+	}()
+	select {
+	case err = <-processExitChan:
+	case <-ctx.Done():
+		platform.KillProcess(proc.Process)
+		err = ctx.Err()
+	}
+	if err != nil {
+		err = fmt.Errorf("plugin exited abnormally - %v: %s", err, strings.TrimSpace(string(stderr.Retrieve(false))))
+		return
+	}
+	if stdout.exceeded {
+		err = fmt.Errorf("plugin response exceeded the maximum size of %d bytes", stdout.maxBytes)
+		return
+	}
+	if err = json.Unmarshal(stdout.buf.Bytes(), &resp); err != nil {
+		err = fmt.Errorf("plugin responded with malformed JSON - %v", err)
+		return
+	}
+	if resp.Error != "" {
+		err = errors.New(resp.Error)
+	}
+	return
+}
+
+/*
+pluginOutputBuffer keeps the plugin output up to the maximum size, and remembers whether the plugin has produced more
+output than that. The excess output is discarded.
+*/
+type pluginOutputBuffer struct {
+	maxBytes int
+	buf      bytes.Buffer
+	exceeded bool
+}
+
+// Write keeps the output that fits in the maximum size, it always succeeds so that the plugin does not get stuck writing.
+func (out *pluginOutputBuffer) Write(p []byte) (int, error) {
+	if room := out.maxBytes - out.buf.Len(); len(p) > room {
+		out.buf.Write(p[:room])
+		out.exceeded = true
+	} else {
+		out.buf.Write(p)
+	}
+	return len(p), nil
+}
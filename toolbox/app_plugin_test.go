@@ -0,0 +1,111 @@
+package toolbox
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/HouzuoGuo/laitos/platform"
+)
+
+// testPluginScript is a plugin program that responds to app commands according to their content.
+const testPluginScript = `#!/bin/sh
+read -r req
+echo "working on it" >&2
+case "$req" in
+	*'"SelfTest":true'*) echo '{"Output":"","Error":""}';;
+	*'"Content":"fail"'*) echo '{"Output":"partial","Error":"plugin failed"}';;
+	*'"Content":"bad"'*) echo 'this is not JSON';;
+	*'"Content":"flood"'*) head -c 2000000 /dev/zero | tr '\0' a;;
+	*'"Content":"crash"'*) exit 1;;
+	*'"Content":"sleep"'*) sleep 10;;
+	*) echo '{"Output":"hello","Error":""}';;
+esac
+`
+
+func TestPlugin_Execute(t *testing.T) {
+	if platform.HostIsWindows() {
+		t.Skip("this test is not applicable on Windows")
+	}
+	dir, err := ioutil.TempDir("", "laitos-TestPlugin_Execute")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	scriptPath := filepath.Join(dir, "plugin.sh")
+	if err := ioutil.WriteFile(scriptPath, []byte(testPluginScript), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	plugin := Plugin{}
+	if plugin.IsConfigured() {
+		t.Fatal("should not be configured")
+	}
+	plugin = Plugin{TriggerPrefix: "xyz", Executable: scriptPath}
+	if err := plugin.Initialise(); err == nil {
+		t.Fatal("did not error")
+	}
+	plugin = Plugin{TriggerPrefix: ".xyz", Executable: filepath.Join(dir, "does-not-exist")}
+	if err := plugin.Initialise(); err == nil {
+		t.Fatal("did not error")
+	}
+	plugin = Plugin{TriggerPrefix: ".xyz", Executable: scriptPath}
+	if err := plugin.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	if err := plugin.SelfTest(); err != nil {
+		t.Fatal(err)
+	}
+	if plugin.Trigger() != ".xyz" {
+		t.Fatal(plugin.Trigger())
+	}
+
+	if ret := plugin.Execute(context.Background(), Command{TimeoutSec: 5, Content: "hi"}); ret.Error != nil || ret.Output != "hello" {
+		t.Fatal(ret)
+	}
+	if ret := plugin.Execute(context.Background(), Command{TimeoutSec: 5, Content: "fail"}); ret.Error == nil || ret.Error.Error() != "plugin failed" || ret.Output != "partial" {
+		t.Fatal(ret)
+	}
+	if ret := plugin.Execute(context.Background(), Command{TimeoutSec: 5, Content: "bad"}); ret.Error == nil || !strings.Contains(ret.Error.Error(), "malformed") {
+		t.Fatal(ret)
+	}
+	if ret := plugin.Execute(context.Background(), Command{TimeoutSec: 5, Content: "flood"}); ret.Error == nil || !strings.Contains(ret.Error.Error(), "exceeded the maximum size") {
+		t.Fatal(ret)
+	}
+	if ret := plugin.Execute(context.Background(), Command{TimeoutSec: 5, Content: "crash"}); ret.Error == nil || !strings.Contains(ret.Error.Error(), "working on it") {
+		t.Fatal(ret)
+	}
+	if ret := plugin.Execute(context.Background(), Command{TimeoutSec: 1, Content: "sleep"}); ret.Error == nil || !strings.Contains(ret.Error.Error(), context.DeadlineExceeded.Error()) {
+		t.Fatal(ret)
+	}
+
+	// Plugins become available to command processor alongside the built-in apps
+	features := FeatureSet{Plugins: []Plugin{{TriggerPrefix: ".xyz", Executable: scriptPath}}}
+	if err := features.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	if err := features.LookupByTrigger[".xyz"].SelfTest(); err != nil {
+		t.Fatal(err)
+	}
+	proc := CommandProcessor{Features: &features, CommandFilters: []CommandFilter{&PINAndShortcuts{Passwords: []string{"pin"}}}, ResultFilters: []ResultFilter{&LintText{MaxLength: 35}}}
+	if ret := proc.Process(context.Background(), Command{TimeoutSec: 5, Content: "pin .xyz hi"}, true); ret.Error != nil || ret.CombinedOutput != "hello" {
+		t.Fatal(ret)
+	}
+
+	// Plugin triggers may not conflict with those of the built-in apps, the magic prefixes, or other plugins
+	for _, trigger := range []string{".s", ".sx", ".p", ".bgx", ".chainx", ".then", ".xy"} {
+		features = FeatureSet{Plugins: []Plugin{{TriggerPrefix: ".xyz", Executable: scriptPath}, {TriggerPrefix: trigger, Executable: scriptPath}}}
+		if err := features.Initialise(); err == nil || !strings.Contains(err.Error(), "conflicts") {
+			t.Fatal(trigger, err)
+		}
+	}
+	if err := checkTriggerConflict(".xyz", []Trigger{".s", ".xy1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkTriggerConflict(".xyz", []Trigger{".x"}); err == nil {
+		t.Fatal(err)
+	}
+}
@@ -3,9 +3,13 @@ package toolbox
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"encoding/xml"
 	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
@@ -15,11 +19,19 @@ import (
 	"github.com/HouzuoGuo/laitos/inet"
 )
 
-const RSSDownloadTimeoutSec = 10 // RSSDownloadTimeoutSec is the IO timeout used for testing RSS sources.
+const (
+	RSSDownloadTimeoutSec = 10 // RSSDownloadTimeoutSec is the IO timeout used for testing RSS sources.
+	// RSSUnreadKeyword is the command parameter that asks for the items that have not been read by the client.
+	RSSUnreadKeyword = "new"
+	// RSSReadItemsExpirySec is the duration for which an item is remembered as read by a client.
+	RSSReadItemsExpirySec = 30 * 24 * 3600
+	// RSSMaxReadItemsClients is the maximum number of client tags to remember read items for.
+	RSSMaxReadItemsClients = 1000
+)
 
 var (
 	// ErrBadRSSParam is the error response for incorrectly entering numeric parameters for retrieving RSS feeds.
-	ErrBadRSSParam = errors.New("example: .s skip# count#")
+	ErrBadRSSParam = errors.New("example: .r [new] [+include] [-exclude] skip# count#")
 
 	// DefaultRSSSources is a list of RSS of news headlines published by major news agencies around the world.
 	DefaultRSSSources = []string{
@@ -32,14 +44,25 @@ var (
 		// "Top news" from CNBC
 		"https://www.cnbc.com/id/100003114/device/rss/rss.html",
 	}
+
+	// RegexOneNumber captures the first number.
+	RegexOneNumber = regexp.MustCompile(`(\d+)`)
 )
 
 type RSS struct {
 	/*
-		Sources are URLs pointing toward RSS feeds in XML format. If left unspecified, the built-in list of sources that
+		Sources are URLs pointing toward RSS, Atom, or JSON feeds. If left unspecified, the built-in list of sources that
 		point to news headlines will be used.
 	*/
 	Sources []string `json:"Sources"`
+	// IncludeKeywords are the keywords of which at least one must appear in an item's title or description, if specified.
+	IncludeKeywords []string `json:"IncludeKeywords"`
+	// ExcludeKeywords are the keywords that must not appear in an item's title or description.
+	ExcludeKeywords []string `json:"ExcludeKeywords"`
+
+	cache     *rssFeedCache
+	readItems map[string]map[string]time.Time // readItems are the keys of items that were read by each client tag, and when.
+	mutex     *sync.Mutex
 }
 
 func (rss *RSS) IsConfigured() bool {
@@ -62,6 +85,9 @@ func (rss *RSS) Initialise() error {
 		rss.Sources = make([]string, len(DefaultRSSSources))
 		copy(rss.Sources, DefaultRSSSources)
 	}
+	rss.cache = &rssFeedCache{sources: make(map[string]*rssSourceCache)}
+	rss.readItems = make(map[string]map[string]time.Time)
+	rss.mutex = new(sync.Mutex)
 	return nil
 }
 
@@ -70,10 +96,33 @@ func (rss *RSS) Trigger() Trigger {
 }
 
 func (rss *RSS) Execute(ctx context.Context, cmd Command) *Result {
-	// Input command looks like: skip# count#, find the two numeric parameters among the content
+	/*
+		Input command looks like: [new] [+include] [-exclude] skip# count#, the keywords may appear anywhere. Find the
+		numeric parameters among the remaining content.
+	*/
+	var unreadOnly bool
+	includes := append([]string{}, rss.IncludeKeywords...)
+	excludes := append([]string{}, rss.ExcludeKeywords...)
+	var remaining []string
+	for i, word := range strings.Fields(cmd.Content) {
+		switch {
+		case i == 0 && strings.ToLower(word) == RSSUnreadKeyword:
+			unreadOnly = true
+		case len(word) > 1 && word[0] == '+':
+			includes = append(includes, word[1:])
+		case len(word) > 1 && word[0] == '-':
+			excludes = append(excludes, word[1:])
+		default:
+			remaining = append(remaining, word)
+		}
+	}
 	var skip, count int
-	params := RegexTwoNumbers.FindStringSubmatch(cmd.Content)
-	if len(params) >= 3 {
+	numbers := strings.Join(remaining, " ")
+	if unreadOnly {
+		if params := RegexOneNumber.FindStringSubmatch(numbers); len(params) >= 2 {
+			count, _ = strconv.Atoi(params[1])
+		}
+	} else if params := RegexTwoNumbers.FindStringSubmatch(numbers); len(params) >= 3 {
 		var intErr error
 		skip, intErr = strconv.Atoi(params[1])
 		if intErr != nil {
@@ -88,10 +137,23 @@ func (rss *RSS) Execute(ctx context.Context, cmd Command) *Result {
 	if count == 0 {
 		count = 10
 	}
-	sortedItems, _ := DownloadRSSFeeds(ctx, cmd.TimeoutSec, rss.Sources...)
+	cache := rss.cache
+	if cache == nil {
+		cache = &rssFeedCache{sources: make(map[string]*rssSourceCache)}
+	}
+	sortedItems, _ := cache.download(ctx, cmd.TimeoutSec, rss.Sources...)
 	if len(sortedItems) == 0 {
 		return &Result{Error: errors.New("all RSS sources failed to respond or gave no response")}
 	}
+	sortedItems = FilterRSSItems(sortedItems, includes, excludes)
+	if unreadOnly {
+		// Unread items are read from the latest onward, the skip parameter does not apply.
+		if sortedItems = rss.takeUnreadItems(cmd.ClientTag, sortedItems, count); len(sortedItems) == 0 {
+			return &Result{Output: "no unread items"}
+		}
+	} else if len(sortedItems) == 0 {
+		return &Result{Output: "no matching items"}
+	}
 	// Skip and limit number of items, but make sure at least one feed will be returned.
 	begin := skip
 	end := skip + count
@@ -113,6 +175,106 @@ func (rss *RSS) Execute(ctx context.Context, cmd Command) *Result {
 	return &Result{Output: out.String()}
 }
 
+/*
+forgetReadItems forgets the items that were read a long time ago, as well as the client tags that no longer have read
+items. If there are still too many client tags, the client that has not read an item for the longest time is forgotten.
+Caller must hold the mutex.
+*/
+func (rss *RSS) forgetReadItems(now time.Time) {
+	for clientTag, readItems := range rss.readItems {
+		for key, readAt := range readItems {
+			if now.Sub(readAt) > RSSReadItemsExpirySec*time.Second {
+				delete(readItems, key)
+			}
+		}
+		if len(readItems) == 0 {
+			delete(rss.readItems, clientTag)
+		}
+	}
+	for len(rss.readItems) >= RSSMaxReadItemsClients {
+		var oldestClientTag string
+		oldestReadAt := now
+		for clientTag, readItems := range rss.readItems {
+			var latestReadAt time.Time
+			for _, readAt := range readItems {
+				if readAt.After(latestReadAt) {
+					latestReadAt = readAt
+				}
+			}
+			if !latestReadAt.After(oldestReadAt) {
+				oldestClientTag, oldestReadAt = clientTag, latestReadAt
+			}
+		}
+		delete(rss.readItems, oldestClientTag)
+	}
+}
+
+/*
+takeUnreadItems returns up to max items that have not been read by the client, and remembers them as read. Items that
+were read a long time ago are forgotten.
+*/
+func (rss *RSS) takeUnreadItems(clientTag string, items []RSSItem, max int) []RSSItem {
+	if rss.mutex == nil {
+		return items
+	}
+	rss.mutex.Lock()
+	defer rss.mutex.Unlock()
+	now := time.Now()
+	readItems, exists := rss.readItems[clientTag]
+	if !exists {
+		rss.forgetReadItems(now)
+		readItems = make(map[string]time.Time)
+		rss.readItems[clientTag] = readItems
+	}
+	for key, readAt := range readItems {
+		if now.Sub(readAt) > RSSReadItemsExpirySec*time.Second {
+			delete(readItems, key)
+		}
+	}
+	ret := make([]RSSItem, 0, max)
+	for _, item := range items {
+		if len(ret) >= max {
+			break
+		}
+		if _, read := readItems[item.Key()]; !read {
+			readItems[item.Key()] = now
+			ret = append(ret, item)
+		}
+	}
+	return ret
+}
+
+/*
+FilterRSSItems returns the items in which at least one of the include keywords (if any) and none of the exclude keywords
+appear in the title or description. The comparison is case insensitive.
+*/
+func FilterRSSItems(items []RSSItem, includes, excludes []string) []RSSItem {
+	if len(includes) == 0 && len(excludes) == 0 {
+		return items
+	}
+	ret := make([]RSSItem, 0, len(items))
+	for _, item := range items {
+		text := strings.ToLower(item.Title + " " + item.Description)
+		included := len(includes) == 0
+		for _, keyword := range includes {
+			if strings.Contains(text, strings.ToLower(keyword)) {
+				included = true
+				break
+			}
+		}
+		for _, keyword := range excludes {
+			if strings.Contains(text, strings.ToLower(keyword)) {
+				included = false
+				break
+			}
+		}
+		if included {
+			ret = append(ret, item)
+		}
+	}
+	return ret
+}
+
 // RSSRoot is the root element in an RSS XML document.
 type RSSRoot struct {
 	Channel RSSChannel `xml:"channel"`
@@ -123,11 +285,23 @@ type RSSChannel struct {
 	Items []RSSItem `xml:"item"`
 }
 
-// RSSItem represents a news item in RSS XML document.
+// RSSItem represents a news item in RSS XML document, items of Atom and JSON feeds are converted into it too.
 type RSSItem struct {
 	Title       string     `xml:"title"`
 	Description string     `xml:"description"`
 	PubDate     RSSPubDate `xml:"pubDate"`
+	Link        string     `xml:"link"`
+	GUID        string     `xml:"guid"`
+}
+
+// Key returns a text that identifies the item among others, it is the GUID, the link, or the title of the item.
+func (item *RSSItem) Key() string {
+	if item.GUID != "" {
+		return item.GUID
+	} else if item.Link != "" {
+		return item.Link
+	}
+	return item.Title
 }
 
 // RSSPubDate represents a publication date/time stamp in RSS XML document.
@@ -140,6 +314,17 @@ func (pubDate *RSSPubDate) UnmarshalXML(d *xml.Decoder, start xml.StartElement)
 	if err := d.DecodeElement(&pubDateStr, &start); err != nil {
 		return err
 	}
+	parsed, err := ParseRSSDate(pubDateStr)
+	if err != nil {
+		return fmt.Errorf("RSSPubDate.UnmarshalXML: %v", err)
+	}
+	*pubDate = RSSPubDate{parsed}
+	return nil
+}
+
+// ParseRSSDate parses the publication date of an RSS item, or the RFC 3339 date of an Atom or JSON feed item.
+func ParseRSSDate(dateStr string) (time.Time, error) {
+	dateStr = strings.TrimSpace(dateStr)
 	// There is one way to write publication date in the RSS standard, but there are many ways to write it in practice.
 	for _, format := range []string{
 		// Time zone in letters VS numerals, 2 VS 4 digit year
@@ -156,31 +341,203 @@ func (pubDate *RSSPubDate) UnmarshalXML(d *xml.Decoder, start xml.StartElement)
 
 		// With additional day of week & second
 		`Mon, 02 Jan 06 15:04:05 MST`, `Mon, 02 Jan 06 15:04:05 -0700`,
-		`Mon, 02 Jan 2006 15:04:05 MST`, `Mon, 02 Jan 2006 15:04:05 -0700`} {
-		if parsed, err := time.Parse(format, pubDateStr); err == nil {
-			*pubDate = RSSPubDate{parsed}
-			return nil
+		`Mon, 02 Jan 2006 15:04:05 MST`, `Mon, 02 Jan 2006 15:04:05 -0700`,
+
+		// Atom and JSON feeds use RFC 3339
+		time.RFC3339, time.RFC3339Nano} {
+		if parsed, err := time.Parse(format, dateStr); err == nil {
+			return parsed, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("failed to interpret publication date \"%s\"", dateStr)
+}
+
+// atomFeed is the root element of an Atom XML document.
+type atomFeed struct {
+	Entries []struct {
+		ID        string `xml:"id"`
+		Title     string `xml:"title"`
+		Summary   string `xml:"summary"`
+		Content   string `xml:"content"`
+		Published string `xml:"published"`
+		Updated   string `xml:"updated"`
+		Links     []struct {
+			Href string `xml:"href,attr"`
+			Rel  string `xml:"rel,attr"`
+		} `xml:"link"`
+	} `xml:"entry"`
+}
+
+// jsonFeed is the root object of a JSON Feed document (https://jsonfeed.org).
+type jsonFeed struct {
+	Items []struct {
+		ID            interface{} `json:"id"`
+		URL           string      `json:"url"`
+		Title         string      `json:"title"`
+		Summary       string      `json:"summary"`
+		ContentText   string      `json:"content_text"`
+		ContentHTML   string      `json:"content_html"`
+		DatePublished string      `json:"date_published"`
+		DateModified  string      `json:"date_modified"`
+	} `json:"items"`
+}
+
+// firstNonEmpty returns the first of the texts that is not empty after trimming spaces.
+func firstNonEmpty(texts ...string) string {
+	for _, text := range texts {
+		if trimmed := strings.TrimSpace(text); trimmed != "" {
+			return trimmed
 		}
 	}
-	return fmt.Errorf("RSSPubDate.UnmarshalXML: failed to interpret publication date \"%s\"", pubDateStr)
+	return ""
+}
+
+// deserialiseAtomItems deserialises the entries of an Atom feed into RSS items.
+func deserialiseAtomItems(input []byte) ([]RSSItem, error) {
+	var feed atomFeed
+	if err := xml.Unmarshal(input, &feed); err != nil {
+		return nil, err
+	}
+	items := make([]RSSItem, 0, len(feed.Entries))
+	for _, entry := range feed.Entries {
+		item := RSSItem{
+			Title:       strings.TrimSpace(entry.Title),
+			Description: firstNonEmpty(entry.Summary, entry.Content),
+			GUID:        strings.TrimSpace(entry.ID),
+		}
+		// Prefer the link to the alternate representation, which is the web page of the entry.
+		for _, link := range entry.Links {
+			if link.Rel == "" || link.Rel == "alternate" {
+				item.Link = link.Href
+				break
+			}
+		}
+		// An entry without a valid date is considered the oldest
+		if date, err := ParseRSSDate(firstNonEmpty(entry.Published, entry.Updated)); err == nil {
+			item.PubDate = RSSPubDate{date}
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// deserialiseJSONFeedItems deserialises the items of a JSON Feed into RSS items.
+func deserialiseJSONFeedItems(input []byte) ([]RSSItem, error) {
+	var feed jsonFeed
+	if err := json.Unmarshal(input, &feed); err != nil {
+		return nil, err
+	}
+	items := make([]RSSItem, 0, len(feed.Items))
+	for _, jsonItem := range feed.Items {
+		item := RSSItem{
+			Title:       strings.TrimSpace(jsonItem.Title),
+			Description: firstNonEmpty(jsonItem.Summary, jsonItem.ContentText, jsonItem.ContentHTML),
+			Link:        jsonItem.URL,
+		}
+		if jsonItem.ID != nil {
+			item.GUID = fmt.Sprint(jsonItem.ID)
+		}
+		if date, err := ParseRSSDate(firstNonEmpty(jsonItem.DatePublished, jsonItem.DateModified)); err == nil {
+			item.PubDate = RSSPubDate{date}
+		}
+		items = append(items, item)
+	}
+	return items, nil
 }
 
 /*
-DeserialiseRSSItems deserialises RSS feeds from input XML and returns news items among them in their original order.
-In case of an error, the error along with an empty array will be returned.
+DeserialiseRSSItems deserialises feed items from input RSS XML, Atom XML, or JSON Feed, and returns the items in their
+original order. In case of an error, the error along with an empty array will be returned.
 */
 func DeserialiseRSSItems(input []byte) (items []RSSItem, err error) {
-	var root RSSRoot
-	err = xml.Unmarshal(input, &root)
-	items = root.Channel.Items
-	if items == nil {
+	trimmed := bytes.TrimLeft(input, "\xef\xbb\xbf \t\r\n")
+	if bytes.HasPrefix(trimmed, []byte("{")) {
+		items, err = deserialiseJSONFeedItems(trimmed)
+	} else if isAtomFeed(input) {
+		items, err = deserialiseAtomItems(input)
+	} else {
+		var root RSSRoot
+		err = xml.Unmarshal(input, &root)
+		items = root.Channel.Items
+	}
+	if items == nil || err != nil {
 		items = []RSSItem{}
 	}
 	return
 }
 
-// DownloadRSSFeeds downloads RSS feed items from multiple URLs, then orders them from latest to oldest.
-func DownloadRSSFeeds(ctx context.Context, timeoutSec int, xmlURLs ...string) (items []RSSItem, err error) {
+// isAtomFeed returns true only if the root element of the XML document is an Atom feed.
+func isAtomFeed(input []byte) bool {
+	decoder := xml.NewDecoder(bytes.NewReader(input))
+	// The character set does not matter to the name of root element
+	decoder.CharsetReader = func(_ string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return false
+		}
+		if start, isStart := token.(xml.StartElement); isStart {
+			return start.Name.Local == "feed"
+		}
+	}
+}
+
+// rssSourceCache is the most recently downloaded feed of a source, along with its validators for conditional fetching.
+type rssSourceCache struct {
+	etag         string
+	lastModified string
+	items        []RSSItem
+}
+
+/*
+rssFeedCache remembers the most recently downloaded feed of each source, so that a source that supports conditional
+fetching (ETag or Last-Modified) is downloaded again only if it has changed.
+*/
+type rssFeedCache struct {
+	sources map[string]*rssSourceCache
+	mutex   sync.Mutex
+}
+
+// downloadSource downloads and deserialises the feed items of a source, or returns the cached items if they are current.
+func (cache *rssFeedCache) downloadSource(ctx context.Context, timeoutSec int, aURL string) ([]RSSItem, error) {
+	cache.mutex.Lock()
+	cached := cache.sources[aURL]
+	cache.mutex.Unlock()
+	header := http.Header{}
+	if cached != nil {
+		if cached.etag != "" {
+			header.Set("If-None-Match", cached.etag)
+		}
+		if cached.lastModified != "" {
+			header.Set("If-Modified-Since", cached.lastModified)
+		}
+	}
+	resp, err := inet.DoHTTP(ctx, inet.HTTPRequest{TimeoutSec: timeoutSec, Header: header}, strings.Replace(aURL, "%", "%%", -1))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		return cached.items, nil
+	}
+	if err := resp.Non2xxToError(); err != nil {
+		return nil, err
+	}
+	items, err := DeserialiseRSSItems(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if etag, lastModified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified"); etag != "" || lastModified != "" {
+		cache.mutex.Lock()
+		cache.sources[aURL] = &rssSourceCache{etag: etag, lastModified: lastModified, items: items}
+		cache.mutex.Unlock()
+	}
+	return items, nil
+}
+
+// download downloads feed items from multiple URLs, then orders them from latest to oldest.
+func (cache *rssFeedCache) download(ctx context.Context, timeoutSec int, xmlURLs ...string) (items []RSSItem, err error) {
 	// Memorise errors for each URL
 	errs := make(map[string]error)
 	items = make([]RSSItem, 0, 10)
@@ -191,17 +548,11 @@ func DownloadRSSFeeds(ctx context.Context, timeoutSec int, xmlURLs ...string) (i
 		wait.Add(1)
 		go func(aURL string) {
 			defer wait.Done()
-			// Download feeds XML and deserialise
-			resp, err := inet.DoHTTP(ctx, inet.HTTPRequest{TimeoutSec: timeoutSec}, strings.Replace(aURL, "%", "%%", -1))
+			feedItems, err := cache.downloadSource(ctx, timeoutSec, aURL)
 			resultMutex.Lock()
 			defer resultMutex.Unlock()
 			if err == nil {
-				feedItems, feedErr := DeserialiseRSSItems(resp.Body)
-				if feedErr == nil {
-					items = append(items, feedItems...)
-				} else {
-					errs[aURL] = err
-				}
+				items = append(items, feedItems...)
 			} else {
 				errs[aURL] = err
 			}
@@ -209,7 +560,7 @@ func DownloadRSSFeeds(ctx context.Context, timeoutSec int, xmlURLs ...string) (i
 	}
 	wait.Wait()
 	// Sort feeds latest to oldest according to publication date
-	sort.Slice(items, func(i1, i2 int) bool {
+	sort.SliceStable(items, func(i1, i2 int) bool {
 		return items[i1].PubDate.After(items[i2].PubDate.Time)
 	})
 	// Collect error information
@@ -222,3 +573,8 @@ func DownloadRSSFeeds(ctx context.Context, timeoutSec int, xmlURLs ...string) (i
 	}
 	return
 }
+
+// DownloadRSSFeeds downloads feed items from multiple URLs, then orders them from latest to oldest.
+func DownloadRSSFeeds(ctx context.Context, timeoutSec int, xmlURLs ...string) (items []RSSItem, err error) {
+	return (&rssFeedCache{sources: make(map[string]*rssSourceCache)}).download(ctx, timeoutSec, xmlURLs...)
+}
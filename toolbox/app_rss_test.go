@@ -2,7 +2,12 @@ package toolbox
 
 import (
 	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestDeserialiseRSSItems(t *testing.T) {
@@ -98,3 +103,172 @@ func TestRSS_Execute(t *testing.T) {
 		t.Fatal(ret)
 	}
 }
+
+const sampleAtomFeed = `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+  <title>Example Feed</title>
+  <updated>2021-03-02T18:30:02Z</updated>
+  <entry>
+    <title>Atom entry 1</title>
+    <link rel="self" href="http://example.org/self"/>
+    <link href="http://example.org/1"/>
+    <id>urn:uuid:1</id>
+    <updated>2021-03-01T18:30:02Z</updated>
+    <summary>Atom summary 1</summary>
+  </entry>
+  <entry>
+    <title>Atom entry 2</title>
+    <link rel="alternate" href="http://example.org/2"/>
+    <id>urn:uuid:2</id>
+    <published>2021-03-02T10:00:00+01:00</published>
+    <content type="html">Atom content 2</content>
+  </entry>
+</feed>`
+
+const sampleJSONFeed = `{
+  "version": "https://jsonfeed.org/version/1.1",
+  "title": "Example JSON Feed",
+  "items": [
+    {"id": "json-1", "url": "https://example.org/json-1", "title": "JSON item 1", "content_text": "JSON text 1", "date_published": "2021-03-02T12:00:00Z"},
+    {"id": 2, "title": "JSON item 2", "summary": "JSON summary 2", "content_html": "<p>html</p>", "date_modified": "2021-02-28T12:00:00Z"}
+  ]
+}`
+
+func TestDeserialiseRSSItems_AtomAndJSON(t *testing.T) {
+	items, err := DeserialiseRSSItems([]byte(sampleAtomFeed))
+	if err != nil || len(items) != 2 {
+		t.Fatal(err, items)
+	}
+	if items[0].Title != "Atom entry 1" || items[0].Description != "Atom summary 1" || items[0].Link != "http://example.org/1" ||
+		items[0].GUID != "urn:uuid:1" || !items[0].PubDate.Equal(time.Date(2021, 3, 1, 18, 30, 2, 0, time.UTC)) {
+		t.Fatalf("%+v", items[0])
+	}
+	if items[1].Description != "Atom content 2" || items[1].Link != "http://example.org/2" || !items[1].PubDate.Equal(time.Date(2021, 3, 2, 9, 0, 0, 0, time.UTC)) {
+		t.Fatalf("%+v", items[1])
+	}
+
+	items, err = DeserialiseRSSItems([]byte(sampleJSONFeed))
+	if err != nil || len(items) != 2 {
+		t.Fatal(err, items)
+	}
+	if items[0].Title != "JSON item 1" || items[0].Description != "JSON text 1" || items[0].Link != "https://example.org/json-1" ||
+		items[0].Key() != "json-1" || !items[0].PubDate.Equal(time.Date(2021, 3, 2, 12, 0, 0, 0, time.UTC)) {
+		t.Fatalf("%+v", items[0])
+	}
+	if items[1].Description != "JSON summary 2" || items[1].Key() != "2" || !items[1].PubDate.Equal(time.Date(2021, 2, 28, 12, 0, 0, 0, time.UTC)) {
+		t.Fatalf("%+v", items[1])
+	}
+
+	if items, err := DeserialiseRSSItems([]byte("{ malformed")); err == nil || len(items) != 0 {
+		t.Fatal(err, items)
+	}
+}
+
+func TestFilterRSSItems(t *testing.T) {
+	items := []RSSItem{{Title: "Bitcoin rallies"}, {Title: "Weather", Description: "Sunny with BITCOIN"}, {Title: "Sponsored: bitcoin"}, {Title: "Sports"}}
+	if filtered := FilterRSSItems(items, nil, nil); len(filtered) != 4 {
+		t.Fatal(filtered)
+	}
+	if filtered := FilterRSSItems(items, []string{"bitcoin"}, []string{"sponsored"}); len(filtered) != 2 || filtered[0].Title != "Bitcoin rallies" || filtered[1].Title != "Weather" {
+		t.Fatal(filtered)
+	}
+	if filtered := FilterRSSItems(items, nil, []string{"bitcoin"}); len(filtered) != 1 || filtered[0].Title != "Sports" {
+		t.Fatal(filtered)
+	}
+}
+
+func TestRSS_ConditionalFetchAndUnread(t *testing.T) {
+	var numDownloads, numNotModified int
+	mutex := new(sync.Mutex)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		switch r.URL.Path {
+		case "/atom":
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				numNotModified++
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			numDownloads++
+			w.Header().Set("ETag", `"v1"`)
+			_, _ = w.Write([]byte(sampleAtomFeed))
+		case "/json":
+			if r.Header.Get("If-Modified-Since") == "Tue, 02 Mar 2021 12:00:00 GMT" {
+				numNotModified++
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			numDownloads++
+			w.Header().Set("Last-Modified", "Tue, 02 Mar 2021 12:00:00 GMT")
+			_, _ = w.Write([]byte(sampleJSONFeed))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	rss := RSS{Sources: []string{server.URL + "/atom", server.URL + "/json"}, ExcludeKeywords: []string{"entry 2"}}
+	if err := rss.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	// Latest first, the excluded item is not among them
+	if ret := rss.Execute(context.Background(), Command{TimeoutSec: 10, Content: "0 10"}); ret.Error != nil ||
+		ret.Output != "JSON item 1-JSON text 1\nAtom entry 1-Atom summary 1\nJSON item 2-JSON summary 2\n" {
+		t.Fatalf("%+v", ret)
+	}
+	// Keywords in command
+	if ret := rss.Execute(context.Background(), Command{TimeoutSec: 10, Content: "+json -summary"}); ret.Error != nil || ret.Output != "JSON item 1-JSON text 1\n" {
+		t.Fatalf("%+v", ret)
+	}
+	if ret := rss.Execute(context.Background(), Command{TimeoutSec: 10, Content: "+does-not-exist"}); ret.Error != nil || ret.Output != "no matching items" {
+		t.Fatalf("%+v", ret)
+	}
+	// Unread items are tracked for each client
+	if ret := rss.Execute(context.Background(), Command{TimeoutSec: 10, ClientTag: "alice", Content: "new 2"}); ret.Error != nil ||
+		ret.Output != "JSON item 1-JSON text 1\nAtom entry 1-Atom summary 1\n" {
+		t.Fatalf("%+v", ret)
+	}
+	if ret := rss.Execute(context.Background(), Command{TimeoutSec: 10, ClientTag: "alice", Content: "new"}); ret.Error != nil ||
+		ret.Output != "JSON item 2-JSON summary 2\n" {
+		t.Fatalf("%+v", ret)
+	}
+	if ret := rss.Execute(context.Background(), Command{TimeoutSec: 10, ClientTag: "alice", Content: "new"}); ret.Error != nil || ret.Output != "no unread items" {
+		t.Fatalf("%+v", ret)
+	}
+	if ret := rss.Execute(context.Background(), Command{TimeoutSec: 10, ClientTag: "bob", Content: "new 1"}); ret.Error != nil || ret.Output != "JSON item 1-JSON text 1\n" {
+		t.Fatalf("%+v", ret)
+	}
+	// Each source was downloaded once, the rest were conditional requests.
+	mutex.Lock()
+	defer mutex.Unlock()
+	if numDownloads != 2 || numNotModified != 12 {
+		t.Fatal(numDownloads, numNotModified)
+	}
+}
+
+func TestRSS_ForgetReadItems(t *testing.T) {
+	rss := RSS{}
+	if err := rss.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	items := []RSSItem{{Title: "a"}, {Title: "b"}}
+	rss.readItems["stale"] = map[string]time.Time{"a": time.Now().Add(-(RSSReadItemsExpirySec + 1) * time.Second)}
+	// A new client gets the unread items, and the client whose read items have all expired is forgotten.
+	if ret := rss.takeUnreadItems("alice", items, 1); len(ret) != 1 || ret[0].Title != "a" {
+		t.Fatal(ret)
+	}
+	if _, exists := rss.readItems["stale"]; exists {
+		t.Fatal("did not forget the stale client")
+	}
+	// The client that has not read an item for the longest time is forgotten to make room for a new client
+	rss.readItems["alice"]["a"] = time.Now().Add(-time.Hour)
+	for i := 0; i < RSSMaxReadItemsClients; i++ {
+		if ret := rss.takeUnreadItems(strconv.Itoa(i), items, 1); len(ret) != 1 {
+			t.Fatal(ret)
+		}
+	}
+	if _, exists := rss.readItems["alice"]; exists || len(rss.readItems) != RSSMaxReadItemsClients {
+		t.Fatal(len(rss.readItems))
+	}
+}
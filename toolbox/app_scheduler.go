@@ -0,0 +1,513 @@
+package toolbox
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HouzuoGuo/laitos/inet"
+	"github.com/HouzuoGuo/laitos/lalog"
+	"github.com/HouzuoGuo/laitos/misc"
+)
+
+const (
+	// SchedulerTrigger is the trigger prefix string of Scheduler app.
+	SchedulerTrigger = ".k"
+	// MaxScheduledCommands is the maximum number of scheduled app commands.
+	MaxScheduledCommands = 100
+	// ScheduledCommandTimeoutSec is the timeout of each scheduled app command.
+	ScheduledCommandTimeoutSec = 10 * 60
+	// SchedulerCheckIntervalSec is the interval at which the scheduler looks for app commands that are due to run.
+	SchedulerCheckIntervalSec = 1
+	// ScheduleSinkMail is the name of the built-in result sink that delivers results via email.
+	ScheduleSinkMail = "mail"
+	// ScheduleSinkLog is the name of the built-in result sink that writes results into the program log.
+	ScheduleSinkLog = "log"
+)
+
+// ErrBadSchedulerChoice reminds user of the proper syntax to invoke Scheduler app.
+var ErrBadSchedulerChoice = errors.New(`(list) | del ID | add [to SINK[:DEST]] in 2h|at 07:30|every 1h|cron M H DoM Mon DoW  .app command`)
+
+// RegexScheduleAt matches the time of day in "at HH:MM" schedule.
+var RegexScheduleAt = regexp.MustCompile(`^([0-9]{1,2}):([0-9]{2})$`)
+
+/*
+ScheduleResultSink delivers the result of a scheduled app command to the destination, such as an email address or a
+chat ID. The title briefly describes the scheduled app command.
+*/
+type ScheduleResultSink func(destination, title, text string) error
+
+var scheduleResultSinks = map[string]ScheduleResultSink{}
+var scheduleResultSinksMutex = new(sync.Mutex)
+
+/*
+RegisterScheduleResultSink makes a result sink available for scheduled app commands, e.g. "telegram" is registered by
+the telegram bot daemon. Registering a sink of the same name again replaces the previous one.
+*/
+func RegisterScheduleResultSink(name string, sink ScheduleResultSink) {
+	scheduleResultSinksMutex.Lock()
+	defer scheduleResultSinksMutex.Unlock()
+	scheduleResultSinks[name] = sink
+}
+
+// getScheduleResultSink returns the registered result sink of the name, or nil if it is not registered.
+func getScheduleResultSink(name string) ScheduleResultSink {
+	scheduleResultSinksMutex.Lock()
+	defer scheduleResultSinksMutex.Unlock()
+	return scheduleResultSinks[name]
+}
+
+// ScheduledCommand is an app command that runs at a later time or repeatedly.
+type ScheduledCommand struct {
+	ID          int       `json:"ID"`          // ID uniquely identifies the scheduled command.
+	When        string    `json:"When"`        // When is the schedule - "every DURATION", "cron EXPRESSION", or empty for a one-off command.
+	Command     string    `json:"Command"`     // Command is the app command to run, without password.
+	Sink        string    `json:"Sink"`        // Sink is the name of result sink that receives the command result.
+	Destination string    `json:"Destination"` // Destination is the sink's recipient of the result, e.g. an email address.
+	NextRun     time.Time `json:"NextRun"`     // NextRun is the time at which the command will run next.
+
+	Scope      *PasswordScope `json:"Scope"`      // Scope is the scope of password that scheduled the command.
+	DaemonName string         `json:"DaemonName"` // DaemonName is the daemon that received the schedule request, the password scope may restrict it.
+	Owner      string         `json:"Owner"`      // Owner identifies the password that scheduled the command, only the same password may list or delete it.
+}
+
+// String returns a compact, human-readable description of the scheduled command, the command carrying a secret is hidden.
+func (sched *ScheduledCommand) String() string {
+	when := sched.When
+	if when == "" {
+		when = "once"
+	}
+	return fmt.Sprintf("#%d %s next %s to %s:%s \"%s\"", sched.ID, when, sched.NextRun.Format("2006-01-02 15:04:05"), sched.Sink, sched.Destination, concealSecretCommand(sched.Command))
+}
+
+// calculateNextRun returns the next time the recurring command will run, or zero time if the command does not recur.
+func (sched *ScheduledCommand) calculateNextRun(after time.Time) (time.Time, error) {
+	switch {
+	case sched.When == "":
+		return time.Time{}, nil
+	case strings.HasPrefix(sched.When, "every "):
+		interval, err := time.ParseDuration(strings.TrimPrefix(sched.When, "every "))
+		if err != nil || interval < time.Minute {
+			return time.Time{}, fmt.Errorf("the interval of \"%s\" must be at least 1m", sched.When)
+		}
+		return after.Add(interval), nil
+	case strings.HasPrefix(sched.When, "cron "):
+		cron, err := ParseCronSchedule(strings.TrimPrefix(sched.When, "cron "))
+		if err != nil {
+			return time.Time{}, err
+		}
+		next := cron.Next(after)
+		if next.IsZero() {
+			return next, fmt.Errorf("\"%s\" never runs", sched.When)
+		}
+		return next, nil
+	default:
+		return time.Time{}, fmt.Errorf("unknown schedule \"%s\"", sched.When)
+	}
+}
+
+// schedulerFile is the content of the encrypted file that keeps the scheduled commands.
+type schedulerFile struct {
+	LastID   int                 `json:"LastID"` // LastID is the ID of most recently scheduled command, IDs are never reused.
+	Commands []*ScheduledCommand `json:"Commands"`
+}
+
+/*
+Scheduler runs app commands at a later time or repeatedly, and delivers the results to a sink such as email. The
+schedules are kept in an encrypted file so that they survive program restarts.
+*/
+type Scheduler struct {
+	// FilePath is the location of encrypted file that keeps the scheduled commands.
+	FilePath string `json:"FilePath"`
+	// EncryptionPassword encrypts the file, it defaults to the password that decrypts program data (e.g. config file).
+	EncryptionPassword string `json:"EncryptionPassword"`
+	// DefaultSink is the result sink used when a scheduled command does not specify one, e.g. "mail" or "telegram".
+	DefaultSink string `json:"DefaultSink"`
+	// DefaultDestination is the recipient of the default sink, e.g. an email address or a telegram chat ID.
+	DefaultDestination string `json:"DefaultDestination"`
+
+	// CmdProcessor runs the scheduled app commands, their password was verified when they were scheduled.
+	CmdProcessor *CommandProcessor `json:"-"`
+	// MailClient delivers command results for the "mail" sink.
+	MailClient inet.MailClient `json:"-"`
+
+	commands []*ScheduledCommand
+	lastID   int
+	mutex    *sync.Mutex
+	stop     chan struct{}
+	logger   lalog.Logger
+}
+
+func (sched *Scheduler) IsConfigured() bool {
+	return sched.FilePath != ""
+}
+
+func (sched *Scheduler) SelfTest() error {
+	if !sched.IsConfigured() {
+		return ErrIncompleteConfig
+	}
+	sched.mutex.Lock()
+	defer sched.mutex.Unlock()
+	return sched.save()
+}
+
+// getFileKey returns the key that encrypts the file.
+func (sched *Scheduler) getFileKey() string {
+	password := sched.EncryptionPassword
+	if password == "" {
+		password = misc.ProgramDataDecryptionPassword
+	}
+	key := sha256.Sum256([]byte(password))
+	return string(key[:])
+}
+
+/*
+Initialise loads the scheduled commands from the encrypted file, and starts running them in the background. Calling
+the function again stops the previous background routine.
+*/
+func (sched *Scheduler) Initialise() error {
+	sched.logger = lalog.Logger{ComponentName: "Scheduler", ComponentID: []lalog.LoggerIDField{{Key: "Path", Value: sched.FilePath}}}
+	if sched.CmdProcessor == nil {
+		return errors.New("Scheduler.Initialise: CmdProcessor must not be nil")
+	}
+	if sched.EncryptionPassword == "" && misc.ProgramDataDecryptionPassword == "" {
+		return errors.New("Scheduler.Initialise: EncryptionPassword must not be empty unless the program data is encrypted")
+	}
+	if sched.DefaultSink == "" {
+		sched.DefaultSink = ScheduleSinkLog
+	}
+	if sched.stop != nil {
+		close(sched.stop)
+	}
+	sched.mutex = new(sync.Mutex)
+	sched.commands = make([]*ScheduledCommand, 0)
+	sched.lastID = 0
+	if _, err := os.Stat(sched.FilePath); err == nil {
+		content, err := misc.Decrypt(sched.FilePath, sched.getFileKey())
+		if err != nil {
+			return fmt.Errorf("Scheduler.Initialise: failed to decrypt %s - %v", sched.FilePath, err)
+		}
+		var file schedulerFile
+		if err := json.Unmarshal(content, &file); err != nil {
+			return fmt.Errorf("Scheduler.Initialise: failed to deserialise %s, the password may be incorrect - %v", sched.FilePath, err)
+		}
+		if file.Commands != nil {
+			sched.commands = file.Commands
+		}
+		sched.lastID = file.LastID
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("Scheduler.Initialise: failed to read %s - %v", sched.FilePath, err)
+	}
+	now := time.Now()
+	for _, cmd := range sched.commands {
+		if cmd.ID > sched.lastID {
+			sched.lastID = cmd.ID
+		}
+		// A recurring command that missed its runs while the program was not running will resume from now on
+		if cmd.When != "" && cmd.NextRun.Before(now) {
+			if next, err := cmd.calculateNextRun(now); err == nil {
+				cmd.NextRun = next
+			}
+		}
+	}
+	sched.stop = make(chan struct{})
+	go sched.runLoop(sched.stop)
+	return nil
+}
+
+func (sched *Scheduler) Trigger() Trigger {
+	return SchedulerTrigger
+}
+
+// save writes the scheduled commands into the encrypted file. Caller must hold the mutex.
+func (sched *Scheduler) save() error {
+	serialised, err := json.Marshal(schedulerFile{LastID: sched.lastID, Commands: sched.commands})
+	if err != nil {
+		return err
+	}
+	tmpPath := sched.FilePath + ".tmp"
+	if err := misc.EncryptToFile(tmpPath, serialised, []byte(sched.getFileKey())); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, sched.FilePath)
+}
+
+func (sched *Scheduler) Execute(ctx context.Context, cmd Command) *Result {
+	params := strings.Fields(cmd.Content)
+	switch {
+	case len(params) == 0 || len(params) == 1 && strings.ToLower(params[0]) == "list":
+		return sched.list(cmd.resultKey.principal())
+	case len(params) == 2 && strings.ToLower(params[0]) == "del":
+		id, err := strconv.Atoi(params[1])
+		if err != nil {
+			return &Result{Error: ErrBadSchedulerChoice}
+		}
+		return sched.delete(id, cmd.resultKey.principal())
+	case len(params) > 1 && strings.ToLower(params[0]) == "add":
+		newCmd, err := sched.parseAdd(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(cmd.Content), params[0])), time.Now())
+		if err != nil {
+			return &Result{Error: err}
+		}
+		newCmd.Scope = cmd.scope
+		newCmd.DaemonName = cmd.DaemonName
+		newCmd.Owner = cmd.resultKey.principal()
+		return sched.add(newCmd)
+	default:
+		return &Result{Error: ErrBadSchedulerChoice}
+	}
+}
+
+/*
+parseOneOffTime parses the time of a one-off schedule - "in DURATION", or "at HH:MM" that refers to the next occurrence
+of the time of day.
+*/
+func parseOneOffTime(keyword, value string, now time.Time) (time.Time, error) {
+	switch strings.ToLower(keyword) {
+	case "in":
+		delay, err := time.ParseDuration(value)
+		if err != nil || delay < time.Second {
+			return time.Time{}, fmt.Errorf("bad duration \"%s\", use for example 45s, 30m, or 2h30m", value)
+		}
+		return now.Add(delay), nil
+	case "at":
+		hourMin := RegexScheduleAt.FindStringSubmatch(value)
+		if hourMin == nil {
+			return time.Time{}, fmt.Errorf("bad time of day \"%s\", use for example 07:30", value)
+		}
+		hour, _ := strconv.Atoi(hourMin[1])
+		minute, _ := strconv.Atoi(hourMin[2])
+		if hour > 23 || minute > 59 {
+			return time.Time{}, fmt.Errorf("bad time of day \"%s\", use for example 07:30", value)
+		}
+		ret := time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, now.Location())
+		if !ret.After(now) {
+			ret = ret.AddDate(0, 0, 1)
+		}
+		return ret, nil
+	default:
+		return time.Time{}, fmt.Errorf("unknown schedule \"%s\"", keyword)
+	}
+}
+
+/*
+parseAdd parses the parameters of "add" command into a new scheduled command:
+[to SINK[:DEST]] in DURATION|at HH:MM|every DURATION|cron M H DoM Mon DoW  .app command
+*/
+func (sched *Scheduler) parseAdd(spec string, now time.Time) (*ScheduledCommand, error) {
+	ret := &ScheduledCommand{Sink: sched.DefaultSink, Destination: sched.DefaultDestination}
+	words := strings.Fields(spec)
+	if len(words) > 1 && strings.ToLower(words[0]) == "to" {
+		sinkDest := strings.SplitN(words[1], ":", 2)
+		ret.Sink, ret.Destination = strings.ToLower(sinkDest[0]), ""
+		if len(sinkDest) == 2 {
+			ret.Destination = sinkDest[1]
+		}
+		words = words[2:]
+	}
+	if len(words) < 3 {
+		return nil, ErrBadSchedulerChoice
+	}
+	var numWhenWords int
+	switch strings.ToLower(words[0]) {
+	case "in", "at":
+		next, err := parseOneOffTime(words[0], words[1], now)
+		if err != nil {
+			return nil, err
+		}
+		ret.NextRun = next
+		numWhenWords = 2
+	case "every":
+		ret.When = "every " + words[1]
+		numWhenWords = 2
+	case "cron":
+		if len(words) < 7 {
+			return nil, ErrBadSchedulerChoice
+		}
+		ret.When = "cron " + strings.Join(words[1:6], " ")
+		numWhenWords = 6
+	default:
+		return nil, ErrBadSchedulerChoice
+	}
+	if ret.When != "" {
+		next, err := ret.calculateNextRun(now)
+		if err != nil {
+			return nil, err
+		}
+		ret.NextRun = next
+	}
+	ret.Command = strings.Join(words[numWhenWords:], " ")
+	if ret.Command == "" {
+		return nil, ErrBadSchedulerChoice
+	}
+	// Validate the sink
+	switch ret.Sink {
+	case ScheduleSinkLog:
+	case ScheduleSinkMail:
+		if !sched.MailClient.IsConfigured() {
+			return nil, errors.New("mail sink is not available because MailClient is not configured")
+		}
+		if ret.Destination == "" {
+			return nil, errors.New("mail sink requires an email address, e.g. to mail:me@example.com")
+		}
+	default:
+		if getScheduleResultSink(ret.Sink) == nil {
+			return nil, fmt.Errorf("result sink \"%s\" is not available", ret.Sink)
+		}
+	}
+	return ret, nil
+}
+
+// add memorises the new scheduled command and saves all scheduled commands to file.
+func (sched *Scheduler) add(newCmd *ScheduledCommand) *Result {
+	sched.mutex.Lock()
+	defer sched.mutex.Unlock()
+	if len(sched.commands) >= MaxScheduledCommands {
+		return &Result{Error: fmt.Errorf("there may be at most %d scheduled commands", MaxScheduledCommands)}
+	}
+	sched.lastID++
+	newCmd.ID = sched.lastID
+	sched.commands = append(sched.commands, newCmd)
+	if err := sched.save(); err != nil {
+		sched.commands = sched.commands[:len(sched.commands)-1]
+		return &Result{Error: fmt.Errorf("failed to save schedule - %v", err)}
+	}
+	return &Result{Output: newCmd.String()}
+}
+
+/*
+delete removes the scheduled command of the owner and saves all scheduled commands to file. A command scheduled by
+another owner is treated as non-existent.
+*/
+func (sched *Scheduler) delete(id int, owner string) *Result {
+	sched.mutex.Lock()
+	defer sched.mutex.Unlock()
+	for i, cmd := range sched.commands {
+		if cmd.ID == id && cmd.Owner == owner {
+			sched.commands = append(sched.commands[:i], sched.commands[i+1:]...)
+			if err := sched.save(); err != nil {
+				return &Result{Error: fmt.Errorf("failed to save schedule - %v", err)}
+			}
+			return &Result{Output: "OK - deleted " + cmd.String()}
+		}
+	}
+	return &Result{Error: fmt.Errorf("scheduled command #%d does not exist", id)}
+}
+
+// list returns the commands scheduled by the owner, the one that runs the soonest comes first.
+func (sched *Scheduler) list(owner string) *Result {
+	sched.mutex.Lock()
+	defer sched.mutex.Unlock()
+	sorted := make([]*ScheduledCommand, 0, len(sched.commands))
+	for _, cmd := range sched.commands {
+		if cmd.Owner == owner {
+			sorted = append(sorted, cmd)
+		}
+	}
+	if len(sorted) == 0 {
+		return &Result{Output: "there are no scheduled commands"}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].NextRun.Before(sorted[j].NextRun)
+	})
+	lines := make([]string, 0, len(sorted))
+	for _, cmd := range sorted {
+		lines = append(lines, cmd.String())
+	}
+	return &Result{Output: strings.Join(lines, "\n")}
+}
+
+// runLoop periodically runs the scheduled commands that are due, until the stop channel is closed.
+func (sched *Scheduler) runLoop(stop chan struct{}) {
+	ticker := time.NewTicker(SchedulerCheckIntervalSec * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			for _, due := range sched.takeDueCommands(now) {
+				go sched.run(due)
+			}
+		}
+	}
+}
+
+/*
+takeDueCommands returns copies of the scheduled commands that are due to run. One-off commands are removed from the
+schedule, and recurring commands are rescheduled.
+*/
+func (sched *Scheduler) takeDueCommands(now time.Time) (due []ScheduledCommand) {
+	sched.mutex.Lock()
+	defer sched.mutex.Unlock()
+	remaining := make([]*ScheduledCommand, 0, len(sched.commands))
+	var removedOneOff bool
+	for _, cmd := range sched.commands {
+		if cmd.NextRun.After(now) {
+			remaining = append(remaining, cmd)
+			continue
+		}
+		due = append(due, *cmd)
+		if cmd.When == "" {
+			removedOneOff = true
+			continue
+		}
+		next, err := cmd.calculateNextRun(now)
+		if err != nil {
+			sched.logger.Warning("takeDueCommands", strconv.Itoa(cmd.ID), err, "removing the command as it cannot be rescheduled")
+			removedOneOff = true
+			continue
+		}
+		cmd.NextRun = next
+		remaining = append(remaining, cmd)
+	}
+	sched.commands = remaining
+	if removedOneOff {
+		if err := sched.save(); err != nil {
+			sched.logger.Warning("takeDueCommands", "", err, "failed to save schedule")
+		}
+	}
+	return
+}
+
+// run runs the scheduled command and delivers its result to the sink.
+func (sched *Scheduler) run(cmd ScheduledCommand) {
+	result := sched.CmdProcessor.Process(context.Background(), Command{
+		DaemonName: cmd.DaemonName,
+		ClientTag:  "",
+		TimeoutSec: ScheduledCommandTimeoutSec,
+		Content:    cmd.Command,
+		scope:      cmd.Scope,
+	}, false)
+	// Result filters are not used, the combined text is merely the error and output.
+	output := result.ResetCombinedText()
+	// The title does not carry the command content, which may have a secret that is hidden in the text.
+	title := fmt.Sprintf("scheduled #%d", cmd.ID)
+	text := fmt.Sprintf("%s %s: %s", title, concealSecretCommand(cmd.Command), output)
+	var err error
+	switch cmd.Sink {
+	case ScheduleSinkLog:
+		sched.logger.Info("run", strconv.Itoa(cmd.ID), nil, "%s", text)
+	case ScheduleSinkMail:
+		// Lengthy output is attached to the mail
+		body, attachments := mailCommandOutput(fmt.Sprintf("%s %s: ", title, concealSecretCommand(cmd.Command)), output)
+		err = sched.MailClient.SendWithAttachments(inet.OutgoingMailSubjectKeyword+"-"+title, body, attachments, cmd.Destination)
+	default:
+		if sink := getScheduleResultSink(cmd.Sink); sink == nil {
+			err = fmt.Errorf("result sink \"%s\" is not available", cmd.Sink)
+		} else {
+			err = sink(cmd.Destination, title, text)
+		}
+	}
+	if err != nil {
+		sched.logger.Warning("run", strconv.Itoa(cmd.ID), err, "failed to deliver result to %s:%s", cmd.Sink, cmd.Destination)
+	}
+}
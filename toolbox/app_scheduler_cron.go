@@ -0,0 +1,137 @@
+package toolbox
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronField describes the range of values permitted by a field of cron expression.
+type cronField struct {
+	name     string
+	min, max int
+}
+
+var cronFields = []cronField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12},
+	{name: "day of week", min: 0, max: 7},
+}
+
+/*
+CronSchedule is a parsed cron expression made of five fields - minute, hour, day of month, month, and day of week.
+Each field may be "*", a number, a range "a-b", a step "*\/n" or "a-b/n", or a comma separated list of them.
+Day of week 0 and 7 both mean Sunday. Like the classic cron, if both day of month and day of week are restricted, a
+time matches if either of them matches.
+*/
+type CronSchedule struct {
+	minute, hour, dayOfMonth, month, dayOfWeek uint64
+	dayOfMonthRestricted, dayOfWeekRestricted  bool
+}
+
+// parseCronField parses a single cron field into a bit set of permitted values.
+func parseCronField(expr string, field cronField) (bits uint64, restricted bool, err error) {
+	for _, part := range strings.Split(expr, ",") {
+		step := 1
+		if slash := strings.IndexRune(part, '/'); slash != -1 {
+			if step, err = strconv.Atoi(part[slash+1:]); err != nil || step < 1 {
+				return 0, false, fmt.Errorf("bad step in %s field \"%s\"", field.name, expr)
+			}
+			part = part[:slash]
+		}
+		low, high := field.min, field.max
+		if part == "*" {
+			if step > 1 {
+				restricted = true
+			}
+		} else {
+			restricted = true
+			if dash := strings.IndexRune(part, '-'); dash != -1 {
+				low, err = strconv.Atoi(part[:dash])
+				if err == nil {
+					high, err = strconv.Atoi(part[dash+1:])
+				}
+			} else {
+				low, err = strconv.Atoi(part)
+				high = low
+				if err == nil && step > 1 {
+					// "a/n" means from a to the maximum, every n
+					high = field.max
+				}
+			}
+			if err != nil || low < field.min || high > field.max || low > high {
+				return 0, false, fmt.Errorf("bad %s field \"%s\", the value must be within [%d, %d]", field.name, expr, field.min, field.max)
+			}
+		}
+		for i := low; i <= high; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+	return bits, restricted, nil
+}
+
+// ParseCronSchedule parses a cron expression made of five fields separated by spaces.
+func ParseCronSchedule(expr string) (*CronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("cron expression \"%s\" must have exactly %d fields", expr, len(cronFields))
+	}
+	sched := new(CronSchedule)
+	bits := make([]uint64, len(cronFields))
+	restricted := make([]bool, len(cronFields))
+	for i, field := range cronFields {
+		var err error
+		if bits[i], restricted[i], err = parseCronField(fields[i], field); err != nil {
+			return nil, err
+		}
+	}
+	sched.minute, sched.hour, sched.dayOfMonth, sched.month, sched.dayOfWeek = bits[0], bits[1], bits[2], bits[3], bits[4]
+	sched.dayOfMonthRestricted, sched.dayOfWeekRestricted = restricted[2], restricted[4]
+	// Sunday may be written as either 0 or 7
+	if sched.dayOfWeek&(1<<7) != 0 {
+		sched.dayOfWeek |= 1
+	}
+	return sched, nil
+}
+
+// matchesDay returns true if the date is permitted by the day of month and day of week fields.
+func (sched *CronSchedule) matchesDay(t time.Time) bool {
+	domMatch := sched.dayOfMonth&(1<<uint(t.Day())) != 0
+	dowMatch := sched.dayOfWeek&(1<<uint(t.Weekday())) != 0
+	if sched.dayOfMonthRestricted && sched.dayOfWeekRestricted {
+		return domMatch || dowMatch
+	}
+	return domMatch && dowMatch
+}
+
+/*
+Next returns the earliest time after the input time that matches the schedule, in the location of the input time.
+It returns zero time if the schedule does not match any time within the next five years, e.g. "0 0 31 2 *".
+*/
+func (sched *CronSchedule) Next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := after.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if sched.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !sched.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if sched.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if sched.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
@@ -0,0 +1,54 @@
+package toolbox
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronSchedule(t *testing.T) {
+	for _, bad := range []string{"", "* * * *", "* * * * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "5-1 * * * *", "*/0 * * * *", "a * * * *"} {
+		if _, err := ParseCronSchedule(bad); err == nil {
+			t.Fatal("should have failed", bad)
+		}
+	}
+	sched, err := ParseCronSchedule("0,30 9-17/2 * * 7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sched.minute != 1|1<<30 || sched.hour != 1<<9|1<<11|1<<13|1<<15|1<<17 || sched.dayOfWeek != 1|1<<7 ||
+		sched.dayOfMonthRestricted || !sched.dayOfWeekRestricted {
+		t.Fatalf("%+v", sched)
+	}
+}
+
+func TestCronSchedule_Next(t *testing.T) {
+	// Wednesday 2020-01-01 08:00:30
+	after := time.Date(2020, 1, 1, 8, 0, 30, 0, time.UTC)
+	for expr, expected := range map[string]time.Time{
+		"* * * * *":    time.Date(2020, 1, 1, 8, 1, 0, 0, time.UTC),
+		"*/15 * * * *": time.Date(2020, 1, 1, 8, 15, 0, 0, time.UTC),
+		"30 7 * * *":   time.Date(2020, 1, 2, 7, 30, 0, 0, time.UTC),
+		"0 9 * * 1-5":  time.Date(2020, 1, 1, 9, 0, 0, 0, time.UTC),
+		"0 9 * * 0":    time.Date(2020, 1, 5, 9, 0, 0, 0, time.UTC),
+		"0 0 1 */3 *":  time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC),
+		"0 0 29 2 *":   time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC),
+		// Either the 13th or a Friday
+		"0 0 13 * 5": time.Date(2020, 1, 3, 0, 0, 0, 0, time.UTC),
+	} {
+		sched, err := ParseCronSchedule(expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if next := sched.Next(after); !next.Equal(expected) {
+			t.Fatal(expr, next)
+		}
+	}
+	// 31st of February never comes
+	sched, err := ParseCronSchedule("0 0 31 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next := sched.Next(after); !next.IsZero() {
+		t.Fatal(next)
+	}
+}
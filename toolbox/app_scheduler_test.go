@@ -0,0 +1,203 @@
+package toolbox
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScheduler_Execute(t *testing.T) {
+	dir, err := ioutil.TempDir("", "laitos-TestScheduler_Execute")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	delivered := make(chan string, 10)
+	RegisterScheduleResultSink("test", func(destination, title, text string) error {
+		delivered <- destination + "|" + text
+		return nil
+	})
+
+	newScheduler := func(password string) *Scheduler {
+		return &Scheduler{
+			FilePath:           filepath.Join(dir, "schedule"),
+			EncryptionPassword: password,
+			DefaultSink:        "test",
+			DefaultDestination: "default-dest",
+			CmdProcessor: &CommandProcessor{
+				Features:       GetTestCommandProcessor().Features,
+				CommandFilters: []CommandFilter{},
+				ResultFilters:  []ResultFilter{},
+			},
+		}
+	}
+	sched := newScheduler("")
+	if !sched.IsConfigured() {
+		t.Fatal("not configured")
+	}
+	// Either the encryption password or program data decryption password must be present
+	if err := sched.Initialise(); err == nil {
+		t.Fatal("should have failed")
+	}
+	sched = newScheduler("password")
+	if err := sched.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sched.SelfTest(); err != nil {
+		t.Fatal(err)
+	}
+	// Bad input
+	for _, bad := range []string{"a", "del x", "add", "add in 1s", "add in abc .s echo", "add at 25:00 .s echo", "add every 1s .s echo",
+		"add cron * * * * .s echo", "add cron 99 * * * * .s echo", "add to nothing in 1s .s echo", "add to mail:a@example.com in 1s .s echo"} {
+		if result := sched.Execute(context.Background(), Command{Content: bad}); result.Error == nil {
+			t.Fatal("should have failed", bad)
+		}
+	}
+	if result := sched.Execute(context.Background(), Command{Content: "del 1"}); result.Error == nil {
+		t.Fatal("should have failed")
+	}
+	if result := sched.Execute(context.Background(), Command{}); result.Error != nil || result.Output != "there are no scheduled commands" {
+		t.Fatal(result)
+	}
+	// Schedule commands
+	if result := sched.Execute(context.Background(), Command{Content: "add in 1s .s echo one off"}); result.Error != nil || !strings.HasPrefix(result.Output, "#1 once") {
+		t.Fatal(result)
+	}
+	if result := sched.Execute(context.Background(), Command{Content: "add to test:other-dest every 2h .s echo every"}); result.Error != nil || !strings.HasPrefix(result.Output, "#2 every 2h") {
+		t.Fatal(result)
+	}
+	if result := sched.Execute(context.Background(), Command{Content: "add cron 30 7 * * 1-5 .s echo cron"}); result.Error != nil || !strings.HasPrefix(result.Output, "#3 cron 30 7 * * 1-5") {
+		t.Fatal(result)
+	}
+	if result := sched.Execute(context.Background(), Command{Content: "add at 07:30 .s echo at"}); result.Error != nil || !strings.HasPrefix(result.Output, "#4 once") {
+		t.Fatal(result)
+	}
+	// Out of password scope
+	scope := &PasswordScope{Triggers: []string{".e"}}
+	if result := sched.Execute(context.Background(), Command{Content: "add in 1s .s echo out of scope", scope: scope}); result.Error != nil {
+		t.Fatal(result)
+	}
+	// The soonest command comes first
+	if result := sched.Execute(context.Background(), Command{Content: "list"}); result.Error != nil || !strings.HasPrefix(result.Output, "#1 once") || len(strings.Split(result.Output, "\n")) != 5 {
+		t.Fatal(result)
+	}
+	// Wait for the one-off commands to run
+	var results []string
+	for i := 0; i < 2; i++ {
+		select {
+		case result := <-delivered:
+			results = append(results, result)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out", results)
+		}
+	}
+	if !strings.Contains(strings.Join(results, ","), "default-dest|scheduled #1 .s echo one off: one off") ||
+		!strings.Contains(strings.Join(results, ","), "default-dest|scheduled #5 .s echo out of scope: "+ErrCommandOutOfScope.Error()) {
+		t.Fatal(results)
+	}
+	// Commands scheduled by a password are not visible to other passwords
+	other := newResultEncryptionKey("another password")
+	if result := sched.Execute(context.Background(), Command{Content: "list", resultKey: other}); result.Error != nil || result.Output != "there are no scheduled commands" {
+		t.Fatal(result)
+	}
+	if result := sched.Execute(context.Background(), Command{Content: "del 4", resultKey: other}); result.Error == nil {
+		t.Fatal("should have failed")
+	}
+	if result := sched.Execute(context.Background(), Command{Content: "add in 1h .s echo other", resultKey: other}); result.Error != nil {
+		t.Fatal(result)
+	}
+	if result := sched.Execute(context.Background(), Command{Content: "list", resultKey: other}); result.Error != nil || !strings.HasPrefix(result.Output, "#6 once") || strings.Contains(result.Output, "\n") {
+		t.Fatal(result)
+	}
+	if result := sched.Execute(context.Background(), Command{Content: "del 6", resultKey: other}); result.Error != nil {
+		t.Fatal(result)
+	}
+	// One-off commands are gone after they ran
+	if result := sched.Execute(context.Background(), Command{Content: "del 1"}); result.Error == nil {
+		t.Fatal("should have failed")
+	}
+	if result := sched.Execute(context.Background(), Command{Content: "del 4"}); result.Error != nil {
+		t.Fatal(result)
+	}
+	// The schedule survives a restart
+	restarted := newScheduler("password")
+	if err := restarted.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	if result := restarted.Execute(context.Background(), Command{Content: "list"}); result.Error != nil ||
+		!strings.Contains(result.Output, "#3 cron 30 7") || !strings.Contains(result.Output, "#2 every 2h") || len(strings.Split(result.Output, "\n")) != 2 {
+		t.Fatal(result)
+	}
+	if result := restarted.Execute(context.Background(), Command{Content: "add in 1h .s echo"}); result.Error != nil || !strings.HasPrefix(result.Output, "#7 once") {
+		t.Fatal(result)
+	}
+	// The schedule cannot be read with an incorrect password
+	if err := newScheduler("incorrect").Initialise(); err == nil {
+		t.Fatal("should have failed")
+	}
+}
+
+func TestScheduler_parseAdd(t *testing.T) {
+	sched := &Scheduler{DefaultSink: ScheduleSinkLog}
+	now := time.Date(2020, 1, 1, 8, 0, 0, 0, time.Local)
+	cmd, err := sched.parseAdd("at 7:30 .s echo a b", now)
+	if err != nil || cmd.When != "" || !cmd.NextRun.Equal(time.Date(2020, 1, 2, 7, 30, 0, 0, time.Local)) || cmd.Command != ".s echo a b" || cmd.Sink != ScheduleSinkLog {
+		t.Fatalf("%+v %v", cmd, err)
+	}
+	cmd, err = sched.parseAdd("at 08:01 .s echo", now)
+	if err != nil || !cmd.NextRun.Equal(time.Date(2020, 1, 1, 8, 1, 0, 0, time.Local)) {
+		t.Fatalf("%+v %v", cmd, err)
+	}
+	cmd, err = sched.parseAdd("to log every 1h30m .s echo", now)
+	if err != nil || cmd.When != "every 1h30m" || !cmd.NextRun.Equal(now.Add(90*time.Minute)) {
+		t.Fatalf("%+v %v", cmd, err)
+	}
+	cmd, err = sched.parseAdd("cron 0 12 * * * .s echo", now)
+	if err != nil || cmd.When != "cron 0 12 * * *" || !cmd.NextRun.Equal(time.Date(2020, 1, 1, 12, 0, 0, 0, time.Local)) {
+		t.Fatalf("%+v %v", cmd, err)
+	}
+}
+
+func TestScheduler_ConcealSecret(t *testing.T) {
+	dir, err := ioutil.TempDir("", "laitos-TestScheduler_ConcealSecret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	delivered := make(chan string, 10)
+	RegisterScheduleResultSink("test-conceal", func(destination, title, text string) error {
+		delivered <- title + "|" + text
+		return nil
+	})
+	sched := &Scheduler{
+		FilePath:           filepath.Join(dir, "schedule"),
+		EncryptionPassword: "password",
+		DefaultSink:        "test-conceal",
+		CmdProcessor:       GetTestCommandProcessor(),
+	}
+	if err := sched.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	for _, content := range []string{"add in 1s .n get my-note-key", "add in 1s .e unlock my-unlock-secret"} {
+		if result := sched.Execute(context.Background(), Command{Content: content}); result.Error != nil || strings.Contains(result.Output, "my-") {
+			t.Fatal(result)
+		}
+	}
+	if result := sched.Execute(context.Background(), Command{Content: "list"}); result.Error != nil || strings.Contains(result.Output, "my-") || !strings.Contains(result.Output, HiddenCommandContent) {
+		t.Fatal(result)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-delivered:
+			if strings.Contains(msg, "my-") || !strings.HasPrefix(msg, "scheduled #") || !strings.Contains(msg, "|scheduled #") {
+				t.Fatal(msg)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out")
+		}
+	}
+}
@@ -2,36 +2,113 @@ package toolbox
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/HouzuoGuo/laitos/inet"
 	"github.com/HouzuoGuo/laitos/lalog"
 )
 
+const (
+	// SendMailMaxAttachmentSize is the maximum total size of files attached to a mail.
+	SendMailMaxAttachmentSize = 16 * 1048576
+	// MaxDelayedMails is the maximum number of mails waiting to be sent at a later time.
+	MaxDelayedMails = 100
+	// DelayedMailCheckIntervalSec is the interval at which the app looks for delayed mails that are due to be sent.
+	DelayedMailCheckIntervalSec = 1
+	// DelayedMailRetryIntervalSec is the number of seconds to wait before retrying a delayed mail that failed to send.
+	DelayedMailRetryIntervalSec = 5 * 60
+	// DelayedMailMaxAttempts is the number of attempts at sending a delayed mail before giving up.
+	DelayedMailMaxAttempts = 10
+	/*
+		MailMaxOutputInBody is the maximum length of command output carried in the body of a mail sent by other apps,
+		longer output is attached to the mail in its entirety.
+	*/
+	MailMaxOutputInBody = 4096
+)
+
 var (
-	// RegexMailCommand captures mail command (address@domain.tld "this is email subject" this is email body) into three string groups.
-	RegexMailCommand = regexp.MustCompile(`([a-zA-Z0-9!#$%&'*+-/=?_{|}~.^]+@[a-zA-Z0-9!#$%&'*+-/=?_{|}~.^]+.[a-zA-Z0-9!#$%&'*+-/=?_{|}~.^]+)\s*"(.*)"\s*(.*)`)
+	/*
+		RegexMailCommand captures mail command (address@domain.tld "this is email subject" this is email body) into three
+		string groups. The email body may span multiple lines, such as the output of an app command piped into it.
+	*/
+	RegexMailCommand = regexp.MustCompile(`([a-zA-Z0-9!#$%&'*+-/=?_{|}~.^]+@[a-zA-Z0-9!#$%&'*+-/=?_{|}~.^]+.[a-zA-Z0-9!#$%&'*+-/=?_{|}~.^]+)\s*"(.*)"\s*((?s:.*))`)
 	/*
 		SOSEmailRecipientMagic is the magic email recipient that corresponds to a built-in list of rescue coordinate
 		centre Emails.
 	*/
 	SOSEmailRecipientMagic = "sos@sos"
 	// ErrBadSendMailParam is the command execution result from an incomplete or malformed input command.
-	ErrBadSendMailParam = errors.New(`example: addr@dom.tld "subj" body (send SOS to sos@sos)`)
+	ErrBadSendMailParam = errors.New(`example: [at 07:30|in 2h] [attach f1,f2] [template T] addr@dom.tld "subj" body (send SOS to sos@sos)`)
+	// RegexMailTemplatePlaceholder finds the placeholders such as {name} in a mail body template.
+	RegexMailTemplatePlaceholder = regexp.MustCompile(`\{([a-zA-Z0-9_]+)\}`)
+	// FileUploadStorage is the directory in which the file upload web service keeps uploaded files for 24 hours.
+	FileUploadStorage = filepath.Join(os.TempDir(), "laitos-HandleFileUpload")
 )
 
+// DelayedMail is a mail to be sent at a later time.
+type DelayedMail struct {
+	ID      int       `json:"ID"`
+	SendAt  time.Time `json:"SendAt"`
+	To      string    `json:"To"`
+	Subject string    `json:"Subject"`
+	Body    string    `json:"Body"`
+	// AttachmentPaths are the files to attach, their content is read when the mail is sent.
+	AttachmentPaths []string `json:"AttachmentPaths"`
+	// Attempts is the number of failed attempts at sending the mail.
+	Attempts int `json:"Attempts"`
+}
+
+// String returns a brief description of the delayed mail.
+func (mail *DelayedMail) String() string {
+	ret := fmt.Sprintf("#%d at %s to %s \"%s\"", mail.ID, mail.SendAt.Format("2006-01-02 15:04"), mail.To, mail.Subject)
+	if len(mail.AttachmentPaths) > 0 {
+		ret += fmt.Sprintf(" +%d attachments", len(mail.AttachmentPaths))
+	}
+	return ret
+}
+
+// delayedMailFile is the content of the file that keeps the delayed mails.
+type delayedMailFile struct {
+	LastID int            `json:"LastID"` // LastID is the ID of most recently delayed mail, IDs are never reused.
+	Mails  []*DelayedMail `json:"Mails"`
+}
+
+// sendMailOptions are the optional parameters that precede the recipient address in a command.
+type sendMailOptions struct {
+	sendAt      time.Time
+	attachments []string
+	template    string
+}
+
 // SendMail is a toolbox feature for sending ordinary and SOS emails.
 type SendMail struct {
 	MailClient      inet.MailClient `json:"MailClient"`      // MailClient offers mail transport configuration for this toolbox feature.
 	SOSPersonalInfo string          `json:"SOSPersonalInfo"` // SOSPersonalInfo is a free-style human-readable text to be sent along with rest of SOS email body.
+	// AttachmentDir is the directory of files that may be attached to mails, in addition to the uploaded files.
+	AttachmentDir string `json:"AttachmentDir"`
+	// Templates are the named mail body templates, a template may contain placeholders such as {name}.
+	Templates map[string]string `json:"Templates"`
+	// DelayedMailFilePath is the location of file that keeps the mails to be sent at a later time.
+	DelayedMailFilePath string `json:"DelayedMailFilePath"`
 
+	delayedMails       []*DelayedMail
+	lastDelayedID      int
+	delayedMutex       *sync.Mutex
+	stopDelayed        chan struct{}
 	logger             lalog.Logger
-	sosTestCaseSendFun func(string, string, ...string) error // sosTestCaseSendFun is a substitue of email sending routine for SOS, to be used by test case.
+	sosTestCaseSendFun func(string, string, ...string) error                            // sosTestCaseSendFun is a substitue of email sending routine for SOS, to be used by test case.
+	testCaseSendFun    func(string, string, []inet.OutgoingAttachment, ...string) error // testCaseSendFun is a substitute of email sending routine, to be used by test case.
 }
 
 var TestSendMail = SendMail{} // Details are set by init_feature_test.go
@@ -50,8 +127,36 @@ func (email *SendMail) SelfTest() error {
 	return nil
 }
 
+/*
+Initialise loads the delayed mails from file, and starts sending them in the background when they are due. Calling the
+function again stops the previous background routine.
+*/
 func (email *SendMail) Initialise() error {
 	email.logger = lalog.Logger{ComponentName: "sendmail", ComponentID: []lalog.LoggerIDField{{Key: "From", Value: email.MailClient.MailFrom}}}
+	if email.stopDelayed != nil {
+		close(email.stopDelayed)
+		email.stopDelayed = nil
+	}
+	email.delayedMutex = new(sync.Mutex)
+	email.delayedMails = make([]*DelayedMail, 0)
+	email.lastDelayedID = 0
+	if email.DelayedMailFilePath == "" {
+		return nil
+	}
+	if content, err := ioutil.ReadFile(email.DelayedMailFilePath); err == nil {
+		var file delayedMailFile
+		if err := json.Unmarshal(content, &file); err != nil {
+			return fmt.Errorf("SendMail.Initialise: failed to deserialise %s - %v", email.DelayedMailFilePath, err)
+		}
+		if file.Mails != nil {
+			email.delayedMails = file.Mails
+		}
+		email.lastDelayedID = file.LastID
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("SendMail.Initialise: failed to read %s - %v", email.DelayedMailFilePath, err)
+	}
+	email.stopDelayed = make(chan struct{})
+	go email.delayedMailLoop(email.stopDelayed)
 	return nil
 }
 
@@ -63,8 +168,21 @@ func (email *SendMail) Execute(ctx context.Context, cmd Command) *Result {
 	if errResult := cmd.Trim(); errResult != nil {
 		return errResult
 	}
+	if words := strings.Fields(cmd.Content); len(words) == 1 && strings.ToLower(words[0]) == "list" {
+		return email.listDelayedMails()
+	} else if len(words) == 2 && strings.ToLower(words[0]) == "cancel" {
+		id, err := strconv.Atoi(words[1])
+		if err != nil {
+			return &Result{Error: ErrBadSendMailParam}
+		}
+		return email.cancelDelayedMail(id)
+	}
 
-	params := RegexMailCommand.FindStringSubmatch(cmd.Content)
+	opts, remaining, err := parseSendMailOptions(cmd.Content, time.Now())
+	if err != nil {
+		return &Result{Error: err}
+	}
+	params := RegexMailCommand.FindStringSubmatch(remaining)
 	if len(params) != 4 {
 		return &Result{Error: ErrBadSendMailParam}
 	}
@@ -72,29 +190,320 @@ func (email *SendMail) Execute(ctx context.Context, cmd Command) *Result {
 	mailSubject := params[2]
 	mailBody := params[3]
 	if strings.TrimSpace(strings.ToLower(mailTo)) == SOSEmailRecipientMagic {
+		if !opts.sendAt.IsZero() || len(opts.attachments) > 0 || opts.template != "" {
+			return &Result{Error: errors.New("SOS email must be sent right away without attachment or template")}
+		}
 		// SOS emails are sent in background
 		email.SendSOS(mailSubject, mailBody)
 		return &Result{Output: "Sending SOS"}
-	} else {
-		// Wait for Email to be sent in foreground, but inform user if it takes too long.
-		sendErrChan := make(chan error, 1)
-		go func() {
-			sendErrChan <- email.MailClient.Send(mailSubject, mailBody, mailTo)
-		}()
+	}
+	if opts.template != "" {
+		if mailBody, err = email.fillTemplate(opts.template, mailBody); err != nil {
+			return &Result{Error: err}
+		}
+	}
+	attachmentPaths, err := email.findAttachments(opts.attachments)
+	if err != nil {
+		return &Result{Error: err}
+	}
+	attachments, err := readAttachmentFiles(attachmentPaths)
+	if err != nil {
+		return &Result{Error: err}
+	}
+	if !opts.sendAt.IsZero() {
+		return email.addDelayedMail(&DelayedMail{SendAt: opts.sendAt, To: mailTo, Subject: mailSubject, Body: mailBody, AttachmentPaths: attachmentPaths})
+	}
+	// Wait for Email to be sent in foreground, but inform user if it takes too long.
+	sendErrChan := make(chan error, 1)
+	go func() {
+		sendErrChan <- email.send(mailSubject, mailBody, attachments, mailTo)
+	}()
+	select {
+	case <-time.After(time.Duration(cmd.TimeoutSec) * time.Second):
+		return &Result{Output: "Sending in background"}
+	case sendErr := <-sendErrChan:
+		if sendErr != nil {
+			return &Result{Error: sendErr}
+		}
+		// Normal result is the length of email body
+		if len(attachments) > 0 {
+			return &Result{Output: fmt.Sprintf("%d +%d attachments", len(mailBody), len(attachments))}
+		}
+		return &Result{Output: strconv.Itoa(len(mailBody))}
+	}
+}
+
+// send delivers a mail with optional attachments to the recipients.
+func (email *SendMail) send(subject, body string, attachments []inet.OutgoingAttachment, recipients ...string) error {
+	if email.testCaseSendFun != nil {
+		return email.testCaseSendFun(subject, body, attachments, recipients...)
+	}
+	return email.MailClient.SendWithAttachments(subject, body, attachments, recipients...)
+}
+
+/*
+parseSendMailOptions removes the optional parameters from the beginning of the command content and returns them along
+with the remaining content. The parameters are: at HH:MM, in DURATION, attach FILE1,FILE2, template NAME.
+*/
+func parseSendMailOptions(content string, now time.Time) (opts sendMailOptions, remaining string, err error) {
+	remaining = content
+	for {
+		words := strings.Fields(remaining)
+		if len(words) < 3 {
+			return
+		}
+		switch keyword, value := strings.ToLower(words[0]), words[1]; keyword {
+		case "at", "in":
+			if opts.sendAt, err = parseOneOffTime(keyword, value, now); err != nil {
+				return
+			}
+		case "attach":
+			opts.attachments = append(opts.attachments, strings.Split(value, ",")...)
+		case "template":
+			opts.template = value
+		default:
+			return
+		}
+		remaining = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(remaining), words[0]))
+		remaining = strings.TrimSpace(strings.TrimPrefix(remaining, words[1]))
+	}
+}
+
+/*
+fillTemplate returns the body template of the name, with its placeholders substituted by the values given in the
+format "key1=value1; key2=value2".
+*/
+func (email *SendMail) fillTemplate(name, values string) (string, error) {
+	template, found := email.Templates[name]
+	if !found {
+		return "", fmt.Errorf("mail template \"%s\" does not exist", name)
+	}
+	keyValues := make(map[string]string)
+	for _, pair := range strings.Split(values, ";") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		keyValue := strings.SplitN(pair, "=", 2)
+		if len(keyValue) != 2 {
+			return "", errors.New(`template values must look like "key1=value1; key2=value2"`)
+		}
+		keyValues[strings.TrimSpace(keyValue[0])] = strings.TrimSpace(keyValue[1])
+	}
+	var missing []string
+	ret := RegexMailTemplatePlaceholder.ReplaceAllStringFunc(template, func(placeholder string) string {
+		key := placeholder[1 : len(placeholder)-1]
+		if value, found := keyValues[key]; found {
+			return value
+		}
+		missing = append(missing, key)
+		return placeholder
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("mail template \"%s\" requires values of: %s", name, strings.Join(missing, ", "))
+	}
+	return ret, nil
+}
+
+/*
+findAttachments returns the paths of the files of the names, which are looked up in the attachment directory, or in
+the storage of uploaded files if the attachment directory does not have them.
+*/
+func (email *SendMail) findAttachments(names []string) ([]string, error) {
+	ret := make([]string, 0, len(names))
+	for _, name := range names {
+		// Prevent traversal attack
+		if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+			return nil, fmt.Errorf("bad attachment file name \"%s\"", name)
+		}
+		var found bool
+		for _, dir := range []string{email.AttachmentDir, FileUploadStorage} {
+			if dir == "" {
+				continue
+			}
+			filePath := filepath.Join(dir, name)
+			if info, err := os.Stat(filePath); err == nil && info.Mode().IsRegular() {
+				ret = append(ret, filePath)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("attachment \"%s\" does not exist", name)
+		}
+	}
+	return ret, nil
+}
+
+// readAttachmentFiles reads the files to be attached to a mail, their total size may not exceed SendMailMaxAttachmentSize.
+func readAttachmentFiles(paths []string) ([]inet.OutgoingAttachment, error) {
+	ret := make([]inet.OutgoingAttachment, 0, len(paths))
+	var totalSize int64
+	for _, filePath := range paths {
+		info, err := os.Stat(filePath)
+		if err != nil || !info.Mode().IsRegular() {
+			return nil, fmt.Errorf("attachment \"%s\" does not exist", filepath.Base(filePath))
+		}
+		if totalSize += info.Size(); totalSize > SendMailMaxAttachmentSize {
+			return nil, fmt.Errorf("attachments may not exceed %d MB in total", SendMailMaxAttachmentSize/1048576)
+		}
+		content, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read attachment \"%s\" - %v", filepath.Base(filePath), err)
+		}
+		ret = append(ret, inet.OutgoingAttachment{FileName: filepath.Base(filePath), Content: content})
+	}
+	return ret, nil
+}
+
+/*
+mailCommandOutput returns the mail body that begins with the prefix and carries the command output. If the output is
+longer than MailMaxOutputInBody, the body only carries the beginning of the output, and the complete output comes in
+the returned attachment.
+*/
+func mailCommandOutput(prefix, output string) (string, []inet.OutgoingAttachment) {
+	if len(output) <= MailMaxOutputInBody {
+		return prefix + output, nil
+	}
+	body := fmt.Sprintf("%s%s...\n(the complete output is attached)", prefix, strings.ToValidUTF8(output[:MailMaxOutputInBody], ""))
+	return body, []inet.OutgoingAttachment{{FileName: "output.txt", ContentType: "text/plain; charset=utf-8", Content: []byte(output)}}
+}
+
+// saveDelayedMails writes the delayed mails into file. Caller must hold the mutex.
+func (email *SendMail) saveDelayedMails() error {
+	serialised, err := json.Marshal(delayedMailFile{LastID: email.lastDelayedID, Mails: email.delayedMails})
+	if err != nil {
+		return err
+	}
+	tmpPath := email.DelayedMailFilePath + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, serialised, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, email.DelayedMailFilePath)
+}
+
+// addDelayedMail memorises the mail to be sent at a later time and saves all delayed mails to file.
+func (email *SendMail) addDelayedMail(mail *DelayedMail) *Result {
+	if email.DelayedMailFilePath == "" || email.delayedMutex == nil {
+		return &Result{Error: errors.New("delayed sending requires DelayedMailFilePath to be configured")}
+	}
+	email.delayedMutex.Lock()
+	defer email.delayedMutex.Unlock()
+	if len(email.delayedMails) >= MaxDelayedMails {
+		return &Result{Error: fmt.Errorf("there may be at most %d delayed mails", MaxDelayedMails)}
+	}
+	email.lastDelayedID++
+	mail.ID = email.lastDelayedID
+	email.delayedMails = append(email.delayedMails, mail)
+	if err := email.saveDelayedMails(); err != nil {
+		email.delayedMails = email.delayedMails[:len(email.delayedMails)-1]
+		return &Result{Error: fmt.Errorf("failed to save delayed mail - %v", err)}
+	}
+	return &Result{Output: mail.String()}
+}
+
+// cancelDelayedMail removes the delayed mail and saves all delayed mails to file.
+func (email *SendMail) cancelDelayedMail(id int) *Result {
+	if email.DelayedMailFilePath == "" || email.delayedMutex == nil {
+		return &Result{Error: errors.New("delayed sending requires DelayedMailFilePath to be configured")}
+	}
+	email.delayedMutex.Lock()
+	defer email.delayedMutex.Unlock()
+	for i, mail := range email.delayedMails {
+		if mail.ID == id {
+			email.delayedMails = append(email.delayedMails[:i], email.delayedMails[i+1:]...)
+			if err := email.saveDelayedMails(); err != nil {
+				return &Result{Error: fmt.Errorf("failed to save delayed mails - %v", err)}
+			}
+			return &Result{Output: "OK - cancelled " + mail.String()}
+		}
+	}
+	return &Result{Error: fmt.Errorf("delayed mail #%d does not exist", id)}
+}
+
+// listDelayedMails returns all delayed mails, the one to be sent the soonest comes first.
+func (email *SendMail) listDelayedMails() *Result {
+	if email.DelayedMailFilePath == "" || email.delayedMutex == nil {
+		return &Result{Error: errors.New("delayed sending requires DelayedMailFilePath to be configured")}
+	}
+	email.delayedMutex.Lock()
+	defer email.delayedMutex.Unlock()
+	if len(email.delayedMails) == 0 {
+		return &Result{Output: "there are no delayed mails"}
+	}
+	sorted := make([]*DelayedMail, len(email.delayedMails))
+	copy(sorted, email.delayedMails)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].SendAt.Before(sorted[j].SendAt)
+	})
+	lines := make([]string, 0, len(sorted))
+	for _, mail := range sorted {
+		lines = append(lines, mail.String())
+	}
+	return &Result{Output: strings.Join(lines, "\n")}
+}
+
+/*
+delayedMailLoop periodically sends the delayed mails that are due, until the stop channel is closed. A mail is removed
+only after it has been sent, or after it has failed to send too many times.
+*/
+func (email *SendMail) delayedMailLoop(stop chan struct{}) {
+	ticker := time.NewTicker(DelayedMailCheckIntervalSec * time.Second)
+	defer ticker.Stop()
+	for {
 		select {
-		case <-time.After(time.Duration(cmd.TimeoutSec) * time.Second):
-			return &Result{Output: "Sending in background"}
-		case sendErr := <-sendErrChan:
-			if sendErr == nil {
-				// Normal result is the length of email body
-				return &Result{Output: strconv.Itoa(len(mailBody))}
-			} else {
-				return &Result{Error: sendErr}
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			for _, due := range email.getDueDelayedMails(now) {
+				attachments, err := readAttachmentFiles(due.AttachmentPaths)
+				if err == nil {
+					err = email.send(due.Subject, due.Body, attachments, due.To)
+				}
+				email.logger.Info("delayedMailLoop", strconv.Itoa(due.ID), err, "sent delayed mail to %s", due.To)
+				email.finishDelayedMail(due.ID, err, now)
 			}
 		}
 	}
 }
 
+// getDueDelayedMails returns a copy of the delayed mails that are due to be sent.
+func (email *SendMail) getDueDelayedMails(now time.Time) (due []DelayedMail) {
+	email.delayedMutex.Lock()
+	defer email.delayedMutex.Unlock()
+	for _, mail := range email.delayedMails {
+		if !mail.SendAt.After(now) {
+			due = append(due, *mail)
+		}
+	}
+	return
+}
+
+/*
+finishDelayedMail removes the delayed mail that has been sent. If the mail failed to send, it is postponed for a retry,
+or removed if it has run out of attempts.
+*/
+func (email *SendMail) finishDelayedMail(id int, sendErr error, now time.Time) {
+	email.delayedMutex.Lock()
+	defer email.delayedMutex.Unlock()
+	for i, mail := range email.delayedMails {
+		if mail.ID != id {
+			continue
+		}
+		if sendErr != nil {
+			if mail.Attempts++; mail.Attempts < DelayedMailMaxAttempts {
+				mail.SendAt = now.Add(DelayedMailRetryIntervalSec * time.Second)
+				break
+			}
+			email.logger.Warning("finishDelayedMail", strconv.Itoa(id), sendErr, "giving up on the delayed mail after %d attempts", mail.Attempts)
+		}
+		email.delayedMails = append(email.delayedMails[:i], email.delayedMails[i+1:]...)
+		break
+	}
+	if err := email.saveDelayedMails(); err != nil {
+		email.logger.Warning("finishDelayedMail", "", err, "failed to save delayed mails")
+	}
+}
+
 // SendSOS delivers an SOS email to public search-and-rescue institutions.
 func (email *SendMail) SendSOS(subject, body string) {
 	// Prefix body text with some environment information
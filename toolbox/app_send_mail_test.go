@@ -2,7 +2,12 @@ package toolbox
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"sync"
 	"testing"
@@ -110,3 +115,208 @@ Additional info: laitos software test from TestSendMail_SendSOS`, inet.GetPublic
 		}
 	}
 }
+
+type sentMail struct {
+	subject     string
+	body        string
+	attachments []inet.OutgoingAttachment
+	recipients  []string
+}
+
+func TestSendMail_AttachmentsAndTemplates(t *testing.T) {
+	attachmentDir, err := ioutil.TempDir("", "laitos-TestSendMail_AttachmentsAndTemplates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(attachmentDir)
+	if err := ioutil.WriteFile(filepath.Join(attachmentDir, "a.txt"), []byte("content a"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// Files may also come from the storage of uploaded files
+	if err := os.MkdirAll(FileUploadStorage, 0700); err != nil {
+		t.Fatal(err)
+	}
+	uploadedPath := filepath.Join(FileUploadStorage, "laitos-TestSendMail_AttachmentsAndTemplates.txt")
+	if err := ioutil.WriteFile(uploadedPath, []byte("uploaded"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(uploadedPath)
+
+	sent := make(chan sentMail, 10)
+	sendMail := SendMail{
+		AttachmentDir: attachmentDir,
+		Templates:     map[string]string{"greet": "Hi {name}, see you on {day}."},
+		testCaseSendFun: func(subject, body string, attachments []inet.OutgoingAttachment, recipients ...string) error {
+			sent <- sentMail{subject: subject, body: body, attachments: attachments, recipients: recipients}
+			return nil
+		},
+	}
+	if err := sendMail.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	result := sendMail.Execute(context.Background(), Command{TimeoutSec: 10, Content: `attach a.txt,laitos-TestSendMail_AttachmentsAndTemplates.txt template greet a@example.com "hello" name=Alice; day=Monday`})
+	if result.Error != nil || result.Output != "28 +2 attachments" {
+		t.Fatal(result.Error, result.Output)
+	}
+	mail := <-sent
+	if mail.subject != "hello" || mail.body != "Hi Alice, see you on Monday." || !reflect.DeepEqual(mail.recipients, []string{"a@example.com"}) {
+		t.Fatalf("%+v", mail)
+	}
+	if !reflect.DeepEqual(mail.attachments, []inet.OutgoingAttachment{
+		{FileName: "a.txt", Content: []byte("content a")},
+		{FileName: "laitos-TestSendMail_AttachmentsAndTemplates.txt", Content: []byte("uploaded")},
+	}) {
+		t.Fatalf("%+v", mail.attachments)
+	}
+	// Bad attachments, templates, and SOS with options
+	for _, content := range []string{
+		`attach ../a.txt a@example.com "hello" body`,
+		`attach does-not-exist a@example.com "hello" body`,
+		`template does-not-exist a@example.com "hello" name=Alice`,
+		`template greet a@example.com "hello" name=Alice`,
+		`template greet a@example.com "hello" name`,
+		`attach a.txt sos@sos "hello" body`,
+	} {
+		if result := sendMail.Execute(context.Background(), Command{TimeoutSec: 10, Content: content}); result.Error == nil {
+			t.Fatal("did not error", content)
+		}
+	}
+	// Delayed sending requires a file
+	if result := sendMail.Execute(context.Background(), Command{TimeoutSec: 10, Content: `in 1h a@example.com "hello" body`}); result.Error == nil {
+		t.Fatal("did not error")
+	}
+	if len(sent) != 0 {
+		t.Fatal("should not have sent anything")
+	}
+}
+
+func TestSendMail_Delayed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "laitos-TestSendMail_Delayed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.txt"), []byte("content a"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	sent := make(chan sentMail, 10)
+	newSendMail := func() *SendMail {
+		sendMail := &SendMail{
+			AttachmentDir:       dir,
+			DelayedMailFilePath: filepath.Join(dir, "delayed.json"),
+			testCaseSendFun: func(subject, body string, attachments []inet.OutgoingAttachment, recipients ...string) error {
+				sent <- sentMail{subject: subject, body: body, attachments: attachments, recipients: recipients}
+				return nil
+			},
+		}
+		if err := sendMail.Initialise(); err != nil {
+			t.Fatal(err)
+		}
+		return sendMail
+	}
+	sendMail := newSendMail()
+	if result := sendMail.Execute(context.Background(), Command{TimeoutSec: 10, Content: "list"}); result.Error != nil || result.Output != "there are no delayed mails" {
+		t.Fatal(result.Error, result.Output)
+	}
+	if result := sendMail.Execute(context.Background(), Command{TimeoutSec: 10, Content: `in 1h a@example.com "later" body`}); result.Error != nil || !strings.HasPrefix(result.Output, "#1 at ") {
+		t.Fatal(result.Error, result.Output)
+	}
+	if result := sendMail.Execute(context.Background(), Command{TimeoutSec: 10, Content: `in 3s attach a.txt b@example.com "soon" body`}); result.Error != nil || !strings.HasSuffix(result.Output, ` to b@example.com "soon" +1 attachments`) {
+		t.Fatal(result.Error, result.Output)
+	}
+	// Delayed mails survive restart
+	close(sendMail.stopDelayed)
+	sendMail = newSendMail()
+	defer close(sendMail.stopDelayed)
+	if result := sendMail.Execute(context.Background(), Command{TimeoutSec: 10, Content: "list"}); result.Error != nil || !strings.HasPrefix(result.Output, "#2 at ") || !strings.Contains(result.Output, "\n#1 at ") {
+		t.Fatal(result.Error, result.Output)
+	}
+	select {
+	case mail := <-sent:
+		if mail.subject != "soon" || len(mail.attachments) != 1 || !reflect.DeepEqual(mail.recipients, []string{"b@example.com"}) {
+			t.Fatalf("%+v", mail)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("delayed mail was not sent")
+	}
+	// The mail is removed after it has been sent
+	for i := 0; ; i++ {
+		if result := sendMail.Execute(context.Background(), Command{TimeoutSec: 10, Content: "cancel 2"}); result.Error != nil {
+			break
+		} else if i > 0 {
+			t.Fatal("should not have cancelled a sent mail", result.Output)
+		}
+		time.Sleep(time.Second)
+	}
+	if result := sendMail.Execute(context.Background(), Command{TimeoutSec: 10, Content: "cancel 1"}); result.Error != nil || !strings.HasPrefix(result.Output, "OK - cancelled #1") {
+		t.Fatal(result.Error, result.Output)
+	}
+	if result := sendMail.Execute(context.Background(), Command{TimeoutSec: 10, Content: "list"}); result.Error != nil || result.Output != "there are no delayed mails" {
+		t.Fatal(result.Error, result.Output)
+	}
+}
+
+func TestSendMail_DelayedRetry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "laitos-TestSendMail_DelayedRetry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	attachmentPath := filepath.Join(dir, "a.txt")
+	if err := ioutil.WriteFile(attachmentPath, []byte("content a"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	sendErr := errors.New("test error")
+	sendMail := &SendMail{
+		AttachmentDir:       dir,
+		DelayedMailFilePath: filepath.Join(dir, "delayed.json"),
+		testCaseSendFun: func(subject, body string, attachments []inet.OutgoingAttachment, recipients ...string) error {
+			return sendErr
+		},
+	}
+	if err := sendMail.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	close(sendMail.stopDelayed)
+	if result := sendMail.Execute(context.Background(), Command{TimeoutSec: 10, Content: `in 1h attach a.txt a@example.com "later" body`}); result.Error != nil {
+		t.Fatal(result.Error, result.Output)
+	}
+	// The file keeps the path of the attachment rather than its content
+	if content, err := ioutil.ReadFile(sendMail.DelayedMailFilePath); err != nil || strings.Contains(string(content), "content a") || !strings.Contains(string(content), `"AttachmentPaths":["`+attachmentPath+`"]`) {
+		t.Fatal(string(content), err)
+	}
+	// A mail that failed to send is retried later
+	now := time.Now().Add(2 * time.Hour)
+	due := sendMail.getDueDelayedMails(now)
+	if len(due) != 1 {
+		t.Fatalf("%+v", due)
+	}
+	sendMail.finishDelayedMail(due[0].ID, sendErr, now)
+	if due := sendMail.getDueDelayedMails(now); len(due) != 0 {
+		t.Fatalf("%+v", due)
+	}
+	if due := sendMail.getDueDelayedMails(now.Add(DelayedMailRetryIntervalSec * time.Second)); len(due) != 1 || due[0].Attempts != 1 {
+		t.Fatalf("%+v", due)
+	}
+	// Give up after too many attempts
+	for i := 1; i < DelayedMailMaxAttempts; i++ {
+		sendMail.finishDelayedMail(due[0].ID, sendErr, now)
+	}
+	if result := sendMail.Execute(context.Background(), Command{TimeoutSec: 10, Content: "list"}); result.Error != nil || result.Output != "there are no delayed mails" {
+		t.Fatal(result.Error, result.Output)
+	}
+}
+
+func TestMailCommandOutput(t *testing.T) {
+	if body, attachments := mailCommandOutput("prefix: ", "short"); body != "prefix: short" || attachments != nil {
+		t.Fatal(body, attachments)
+	}
+	output := strings.Repeat("a", MailMaxOutputInBody-1) + "é" + "b"
+	body, attachments := mailCommandOutput("prefix: ", output)
+	if body != "prefix: "+strings.Repeat("a", MailMaxOutputInBody-1)+"...\n(the complete output is attached)" {
+		t.Fatal(body)
+	}
+	if len(attachments) != 1 || attachments[0].FileName != "output.txt" || string(attachments[0].Content) != output {
+		t.Fatalf("%+v", attachments)
+	}
+}
@@ -20,10 +20,14 @@ type FeatureSet struct {
 	BrowserPhantomJS   BrowserPhantomJS   `json:"BrowserPhantomJS"`
 	BrowserSlimerJS    BrowserSlimerJS    `json:"BrowserSlimerJS"`
 	PublicContact      PublicContact      `json:"PublicContact"`
+	DeadManSwitch      DeadManSwitch      `json:"DeadManSwitch"`
 	EnvControl         EnvControl         `json:"EnvControl"`
 	IMAPAccounts       IMAPAccounts       `json:"IMAPAccounts"`
+	JobControl         JobControl         `json:"-"`
 	Joke               Joke               `json:"Joke"`
+	NoteVault          NoteVault          `json:"NoteVault"`
 	RSS                RSS                `json:"RSS"`
+	Scheduler          Scheduler          `json:"Scheduler"`
 	SendMail           SendMail           `json:"SendMail"`
 	Shell              Shell              `json:"Shell"`
 	TextSearch         TextSearch         `json:"TextSearch"`
@@ -32,6 +36,8 @@ type FeatureSet struct {
 	TwoFACodeGenerator TwoFACodeGenerator `json:"TwoFACodeGenerator"`
 	WolframAlpha       WolframAlpha       `json:"WolframAlpha"`
 
+	Plugins []Plugin `json:"Plugins"` // Plugins are apps implemented by external programs, each comes with its own trigger.
+
 	MessageProcessor MessageProcessor `json:"MessageProcessor"`
 }
 
@@ -40,18 +46,27 @@ type FeatureSet struct {
 // Run initialisation routine on all features, and then populate lookup table for all configured features.
 func (fs *FeatureSet) Initialise() error {
 	fs.LookupByTrigger = map[Trigger]Feature{}
-	// Initialise the apps that do not reference this FeatureSet
+	// The environment control app may run self test on the apps
+	fs.EnvControl.FeatureSet = fs
+	/*
+		Initialise the apps that do not need this FeatureSet during initialisation. The environment control app only
+		uses the FeatureSet when it is executed, by which time the FeatureSet is fully initialised.
+	*/
 	apps := map[Trigger]Feature{
 		fs.AESDecrypt.Trigger():         &fs.AESDecrypt,         // a
 		fs.BrowserPhantomJS.Trigger():   &fs.BrowserPhantomJS,   // bp
 		fs.BrowserSlimerJS.Trigger():    &fs.BrowserSlimerJS,    // bs
 		fs.PublicContact.Trigger():      &fs.PublicContact,      // c
+		fs.DeadManSwitch.Trigger():      &fs.DeadManSwitch,      // d
 		fs.EnvControl.Trigger():         &fs.EnvControl,         // e
 		fs.TextSearch.Trigger():         &fs.TextSearch,         // g
 		fs.IMAPAccounts.Trigger():       &fs.IMAPAccounts,       // i
 		fs.Joke.Trigger():               &fs.Joke,               // j
+		fs.JobControl.Trigger():         &fs.JobControl,         // o
 		fs.RSS.Trigger():                &fs.RSS,                // r
+		fs.Scheduler.Trigger():          &fs.Scheduler,          // k
 		fs.SendMail.Trigger():           &fs.SendMail,           // m
+		fs.NoteVault.Trigger():          &fs.NoteVault,          // n
 		fs.Shell.Trigger():              &fs.Shell,              // s
 		fs.Twilio.Trigger():             &fs.Twilio,             // p
 		fs.Twitter.Trigger():            &fs.Twitter,            // t
@@ -59,6 +74,23 @@ func (fs *FeatureSet) Initialise() error {
 		fs.WolframAlpha.Trigger():       &fs.WolframAlpha,       // w
 	}
 	errs := make([]string, 0)
+	// Plugins may choose their own triggers, make sure the command processor can tell them apart from the built-in apps.
+	reservedPrefixes := []Trigger{fs.MessageProcessor.Trigger(), PrefixCommandPLT, PrefixCommandBackground, PrefixCommandChain, ChainSeparatorThen, ChainSeparatorPipe}
+	for trigger := range apps {
+		reservedPrefixes = append(reservedPrefixes, trigger)
+	}
+	for i := range fs.Plugins {
+		plugin := &fs.Plugins[i]
+		if !plugin.IsConfigured() {
+			continue
+		}
+		if err := checkTriggerConflict(plugin.Trigger(), reservedPrefixes); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		reservedPrefixes = append(reservedPrefixes, plugin.Trigger())
+		apps[plugin.Trigger()] = plugin
+	}
 	for appTriggerPrefix, app := range apps {
 		// Collect initialisation errors (if any) from all failed apps
 		if app.IsConfigured() {
@@ -70,7 +102,7 @@ func (fs *FeatureSet) Initialise() error {
 		}
 	}
 	/*
-		Initialise the one and only app that references this FeatureSet. If this app was placed
+		Initialise the one and only app that needs this FeatureSet during initialisation. If this app was placed
 		inside the triggers map, then its initialisation routine might fail when it validates
 		that the FeatureSet has at least one app in there.
 	*/
@@ -88,6 +120,20 @@ func (fs *FeatureSet) Initialise() error {
 	return nil
 }
 
+/*
+checkTriggerConflict returns an error if the trigger is identical to, begins with, or is the beginning of any of the
+existing prefixes. Command processor matches app command against triggers in no particular order, therefore one
+trigger may not be the prefix of another.
+*/
+func checkTriggerConflict(trigger Trigger, existingPrefixes []Trigger) error {
+	for _, existing := range existingPrefixes {
+		if strings.HasPrefix(string(trigger), string(existing)) || strings.HasPrefix(string(existing), string(trigger)) {
+			return fmt.Errorf("FeatureSet.Initialise: plugin trigger \"%s\" conflicts with \"%s\"", trigger, existing)
+		}
+	}
+	return nil
+}
+
 // Run self test of all configured features in parallel. Return test errors if any.
 func (fs *FeatureSet) SelfTest() error {
 	ret := make([]string, 0)
@@ -124,10 +170,13 @@ func (fs *FeatureSet) DeserialiseFromJSON(configJSON json.RawMessage) error {
 		"AESDecrypt":         &fs.AESDecrypt,
 		"BrowserPhantomJS":   &fs.BrowserPhantomJS,
 		"BrowserSlimerJS":    &fs.BrowserSlimerJS,
+		"DeadManSwitch":      &fs.DeadManSwitch,
 		"EnvControl":         &fs.EnvControl,
 		"IMAPAccounts":       &fs.IMAPAccounts,
 		"Joke":               &fs.Joke,
+		"NoteVault":          &fs.NoteVault,
 		"RSS":                &fs.RSS,
+		"Scheduler":          &fs.Scheduler,
 		"SendMail":           &fs.SendMail,
 		"Shell":              &fs.Shell,
 		"Twilio":             &fs.Twilio,
@@ -142,6 +191,11 @@ func (fs *FeatureSet) DeserialiseFromJSON(configJSON json.RawMessage) error {
 			}
 		}
 	}
+	if pluginsJSON, exists := configMap["Plugins"]; exists {
+		if err := json.Unmarshal(pluginsJSON, &fs.Plugins); err != nil {
+			return fmt.Errorf("FeatureSet.DeserialiseFromJSON: failed to deserialise JSON key Plugins - %v", err)
+		}
+	}
 	return nil
 }
 
@@ -25,11 +25,12 @@ func TestFeatureSet_InitSelfTest(t *testing.T) {
 	if err := apps.Initialise(); err != nil {
 		t.Fatal(err)
 	}
-	if len(apps.LookupByTrigger) != 6 ||
+	if len(apps.LookupByTrigger) != 7 ||
 		apps.LookupByTrigger[".0m"] == nil || // store&forward command processor
 		apps.LookupByTrigger[".c"] == nil || // public contacts
 		apps.LookupByTrigger[".e"] == nil || // environment control
 		apps.LookupByTrigger[".j"] == nil || // joke
+		apps.LookupByTrigger[".o"] == nil || // background job control
 		apps.LookupByTrigger[".r"] == nil || // RSS reader
 		apps.LookupByTrigger[".s"] == nil { // shell
 		t.Fatal(apps.LookupByTrigger)
@@ -39,14 +40,14 @@ func TestFeatureSet_InitSelfTest(t *testing.T) {
 	if err := apps.Initialise(); err != nil {
 		t.Fatal(err)
 	}
-	// 6 always-available apps + 2 newly configured features (AES + 2FA)
-	if len(apps.LookupByTrigger) != 8 {
+	// 7 always-available apps + 2 newly configured features (AES + 2FA)
+	if len(apps.LookupByTrigger) != 9 {
 		t.Fatal(apps.LookupByTrigger)
 	}
 	if err := apps.SelfTest(); err != nil {
 		t.Fatal(err)
 	}
-	if triggers := apps.GetTriggers(); !reflect.DeepEqual(triggers, []string{".0m", ".2", ".a", ".c", ".e", ".j", ".r", ".s"}) {
+	if triggers := apps.GetTriggers(); !reflect.DeepEqual(triggers, []string{".0m", ".2", ".a", ".c", ".e", ".j", ".o", ".r", ".s"}) {
 		t.Fatal(triggers)
 	}
 }
@@ -4,13 +4,63 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"regexp"
+	"strings"
+	"sync"
 
+	"github.com/HouzuoGuo/laitos/lalog"
 	"github.com/HouzuoGuo/laitos/platform"
 )
 
+// ShellControlCharacters are the characters refused by a shell profile that has an allow-list of commands.
+const ShellControlCharacters = "\r\n;&|$`<>(){}\\"
+
+/*
+ShellProfile restricts the shell commands run by the passwords that use the profile. The commands run in a sandbox, and
+if the profile has an allow-list, each command must match one of its patterns.
+*/
+type ShellProfile struct {
+	platform.ProcessSandbox
+	/*
+		AllowedCommands are regular expressions, one of which must match the entire shell command. If specified, the
+		command may not contain shell control characters either, so that it cannot sneak in another command.
+	*/
+	AllowedCommands []string `json:"AllowedCommands"`
+
+	allowedCommands []*regexp.Regexp
+}
+
+// Allows returns true only if the shell command is permitted by the profile's allow-list of commands.
+func (profile *ShellProfile) Allows(command string) bool {
+	if len(profile.allowedCommands) == 0 {
+		return true
+	}
+	if strings.ContainsAny(command, ShellControlCharacters) {
+		return false
+	}
+	for _, pattern := range profile.allowedCommands {
+		if pattern.MatchString(command) {
+			return true
+		}
+	}
+	return false
+}
+
 // Execute shell commands with a timeout limit.
 type Shell struct {
 	InterpreterPath string `json:"InterpreterPath"` // Path to *nix shell interpreter
+	// Profiles are the named execution profiles, a password scope chooses one of them by name.
+	Profiles map[string]*ShellProfile `json:"Profiles"`
+	// DefaultProfile is the optional name of the profile used by passwords that do not choose a profile.
+	DefaultProfile string `json:"DefaultProfile"`
+	// SessionIdleTimeoutSec is the number of seconds after which an unused shell session is closed.
+	SessionIdleTimeoutSec int `json:"SessionIdleTimeoutSec"`
+	// MaxSessions is the maximum number of shell sessions running at the same time.
+	MaxSessions int `json:"MaxSessions"`
+
+	sessions     map[string]*shellSession // sessions are the interactive shells keyed by shellSessionKey.
+	sessionMutex *sync.Mutex
+	logger       lalog.Logger
 }
 
 func (sh *Shell) IsConfigured() bool {
@@ -36,6 +86,23 @@ func (sh *Shell) Initialise() error {
 	if sh.InterpreterPath == "" {
 		return errors.New("Shell.Initialise: failed to find a working shell interpreter")
 	}
+	for name, profile := range sh.Profiles {
+		if profile == nil {
+			return fmt.Errorf("Shell.Initialise: profile \"%s\" is empty", name)
+		}
+		profile.allowedCommands = make([]*regexp.Regexp, 0, len(profile.AllowedCommands))
+		for _, pattern := range profile.AllowedCommands {
+			compiled, err := regexp.Compile(`^(?:` + pattern + `)$`)
+			if err != nil {
+				return fmt.Errorf("Shell.Initialise: profile \"%s\" has a malformed command pattern \"%s\" - %v", name, pattern, err)
+			}
+			profile.allowedCommands = append(profile.allowedCommands, compiled)
+		}
+	}
+	if sh.DefaultProfile != "" && sh.Profiles[sh.DefaultProfile] == nil {
+		return fmt.Errorf("Shell.Initialise: default profile \"%s\" does not exist", sh.DefaultProfile)
+	}
+	sh.initialiseSessions()
 	return nil
 }
 
@@ -43,10 +110,44 @@ func (sh *Shell) Trigger() Trigger {
 	return ".s"
 }
 
+/*
+getProfile returns the execution profile chosen by the password scope, or the default profile. It returns nil if the
+shell command may run without restriction.
+*/
+func (sh *Shell) getProfile(scope *PasswordScope) (*ShellProfile, error) {
+	name := sh.DefaultProfile
+	if scope != nil && scope.ShellProfile != "" {
+		name = scope.ShellProfile
+	}
+	if name == "" {
+		return nil, nil
+	}
+	profile, exists := sh.Profiles[name]
+	if !exists || profile == nil {
+		// Refuse to run the command without the restrictions intended for the password
+		return nil, fmt.Errorf("shell profile \"%s\" does not exist", name)
+	}
+	return profile, nil
+}
+
 func (sh *Shell) Execute(ctx context.Context, cmd Command) *Result {
 	if errResult := cmd.Trim(); errResult != nil {
 		return errResult
 	}
-	procOut, procErr := platform.InvokeShell(cmd.TimeoutSec, sh.InterpreterPath, cmd.Content)
+	profile, err := sh.getProfile(cmd.scope)
+	if err != nil {
+		return &Result{Error: err}
+	}
+	if ret := sh.executeInSession(ctx, cmd, profile != nil); ret != nil {
+		return ret
+	}
+	if profile == nil {
+		procOut, procErr := platform.InvokeShellContext(ctx, GetJobOutputWriter(ctx), cmd.TimeoutSec, sh.InterpreterPath, cmd.Content)
+		return &Result{Error: procErr, Output: procOut}
+	}
+	if !profile.Allows(cmd.Content) {
+		return &Result{Error: errors.New("the command is not allowed by shell profile")}
+	}
+	procOut, procErr := platform.InvokeProgramSandbox(ctx, GetJobOutputWriter(ctx), &profile.ProcessSandbox, cmd.TimeoutSec, sh.InterpreterPath, "-c", cmd.Content)
 	return &Result{Error: procErr, Output: procOut}
 }
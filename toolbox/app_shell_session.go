@@ -0,0 +1,244 @@
+package toolbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HouzuoGuo/laitos/lalog"
+	"github.com/HouzuoGuo/laitos/platform"
+)
+
+const (
+	// ShellSessionDefaultIdleTimeoutSec is the default number of seconds after which an unused shell session is closed.
+	ShellSessionDefaultIdleTimeoutSec = 30 * 60
+	// ShellSessionDefaultMaxSessions is the default maximum number of shell sessions running at the same time.
+	ShellSessionDefaultMaxSessions = 10
+	// ShellSessionMaxBufferedBytes is the maximum amount of output buffered between polls, older output is discarded.
+	ShellSessionMaxBufferedBytes = 64 * 1024
+	// ShellSessionQuietMillis is the duration of silence after which the session output is considered complete.
+	ShellSessionQuietMillis = 500
+
+	ShellSessionStart = ":start" // ShellSessionStart starts a shell session for the client.
+	ShellSessionRead  = ":read"  // ShellSessionRead retrieves the session output that arrived since the last retrieval.
+	ShellSessionSend  = ":send"  // ShellSessionSend sends a line of input to the session, even if it begins with a colon.
+	ShellSessionCtrlC = ":ctrlc" // ShellSessionCtrlC interrupts the foreground program of the session.
+	ShellSessionClose = ":close" // ShellSessionClose terminates the session.
+)
+
+// shellSession is a long-lived interactive shell running in a pseudo terminal.
+type shellSession struct {
+	terminal   *os.File
+	proc       *exec.Cmd
+	idleTimer  *time.Timer
+	buffer     []byte    // buffer is the output that has not yet been retrieved.
+	lastActive time.Time // lastActive is the time of the latest input or output.
+	exited     bool
+	closed     bool
+	mutex      *sync.Mutex
+}
+
+// collectOutput keeps reading the terminal output into the buffer until the shell exits.
+func (sess *shellSession) collectOutput() {
+	buf := make([]byte, 4096)
+	for {
+		n, err := sess.terminal.Read(buf)
+		sess.mutex.Lock()
+		if n > 0 {
+			sess.buffer = append(sess.buffer, buf[:n]...)
+			if len(sess.buffer) > ShellSessionMaxBufferedBytes {
+				sess.buffer = sess.buffer[len(sess.buffer)-ShellSessionMaxBufferedBytes:]
+			}
+			sess.lastActive = time.Now()
+		}
+		if err != nil {
+			sess.exited = true
+		}
+		sess.mutex.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// write sends the input to the shell.
+func (sess *shellSession) write(input string) error {
+	sess.mutex.Lock()
+	sess.lastActive = time.Now()
+	sess.mutex.Unlock()
+	_, err := sess.terminal.Write([]byte(input))
+	return err
+}
+
+/*
+waitOutput waits until the shell has been quiet for a short while, has exited, or the timeout elapses, then it returns
+the buffered output and whether the shell has exited.
+*/
+func (sess *shellSession) waitOutput(ctx context.Context, timeout time.Duration) (string, bool) {
+	deadline := time.Now().Add(timeout)
+	for {
+		sess.mutex.Lock()
+		quiet := time.Since(sess.lastActive) >= ShellSessionQuietMillis*time.Millisecond
+		exited := sess.exited
+		sess.mutex.Unlock()
+		if quiet || exited || time.Now().After(deadline) {
+			break
+		}
+		select {
+		case <-time.After(100 * time.Millisecond):
+		case <-ctx.Done():
+			return sess.takeOutput()
+		}
+	}
+	return sess.takeOutput()
+}
+
+// takeOutput returns and clears the buffered output, it also returns whether the shell has exited.
+func (sess *shellSession) takeOutput() (string, bool) {
+	sess.mutex.Lock()
+	defer sess.mutex.Unlock()
+	out := strings.Replace(string(sess.buffer), "\r", "", -1)
+	sess.buffer = nil
+	return out, sess.exited
+}
+
+/*
+shellSessionKey returns the key of the command's shell session. A session belongs to the combination of the daemon,
+client tag, and the password that authorised the command, so that a client cannot take over another client's session
+by sharing its tag (e.g. a NAT address) or by using a different password.
+*/
+func shellSessionKey(cmd Command) string {
+	return cmd.DaemonName + "\x00" + cmd.ClientTag + "\x00" + cmd.resultKey.principal()
+}
+
+// startSession starts a new shell session under the session key, or returns the existing one.
+func (sh *Shell) startSession(key, clientTag string) (*shellSession, error) {
+	sh.sessionMutex.Lock()
+	defer sh.sessionMutex.Unlock()
+	if sess, exists := sh.sessions[key]; exists {
+		return sess, nil
+	}
+	if len(sh.sessions) >= sh.MaxSessions {
+		return nil, fmt.Errorf("there are already %d shell sessions running", len(sh.sessions))
+	}
+	// Keep the prompt short and prevent programs from using colours and cursor movements.
+	terminal, proc, err := platform.StartProgramInPTY([]string{"HOME=" + os.Getenv("HOME"), "TERM=dumb", "PS1=$ ", "PAGER=cat"}, "", sh.InterpreterPath)
+	if err != nil {
+		return nil, err
+	}
+	sess := &shellSession{terminal: terminal, proc: proc, lastActive: time.Now(), mutex: new(sync.Mutex)}
+	sess.idleTimer = time.AfterFunc(time.Duration(sh.SessionIdleTimeoutSec)*time.Second, func() {
+		sh.logger.Info("startSession", clientTag, nil, "closing the shell session that has been idle for %d seconds", sh.SessionIdleTimeoutSec)
+		sh.closeSession(key, sess)
+	})
+	go sess.collectOutput()
+	// Reap the shell once it exits by itself or gets killed, so that it does not linger as a zombie.
+	go func() {
+		_ = proc.Wait()
+	}()
+	sh.sessions[key] = sess
+	sh.logger.Info("startSession", clientTag, nil, "started shell session in PID %d", proc.Process.Pid)
+	return sess, nil
+}
+
+// closeSession removes the session from the table and kills the shell.
+func (sh *Shell) closeSession(key string, sess *shellSession) {
+	sh.sessionMutex.Lock()
+	if sh.sessions[key] == sess {
+		delete(sh.sessions, key)
+	}
+	sh.sessionMutex.Unlock()
+	sess.idleTimer.Stop()
+	// The idle timer and the client may both close the session, only kill the shell once.
+	sess.mutex.Lock()
+	alreadyClosed := sess.closed
+	sess.closed = true
+	sess.mutex.Unlock()
+	if alreadyClosed {
+		return
+	}
+	platform.KillProcess(sess.proc.Process)
+	_ = sess.terminal.Close()
+}
+
+/*
+executeInSession handles the session commands and the input to an existing session of the client. It returns nil if
+the command is not meant for a session, in which case the command runs in a new shell.
+*/
+func (sh *Shell) executeInSession(ctx context.Context, cmd Command, restricted bool) *Result {
+	keyword := strings.Fields(cmd.Content)[0]
+	isSessionCmd := keyword == ShellSessionStart || keyword == ShellSessionRead || keyword == ShellSessionSend ||
+		keyword == ShellSessionCtrlC || keyword == ShellSessionClose
+	if restricted {
+		if isSessionCmd {
+			return &Result{Error: errors.New("shell sessions are not available to passwords restricted by a shell profile")}
+		}
+		return nil
+	}
+	key := shellSessionKey(cmd)
+	sh.sessionMutex.Lock()
+	sess, exists := sh.sessions[key]
+	sh.sessionMutex.Unlock()
+	if !isSessionCmd && !exists {
+		return nil
+	}
+	if cmd.ClientTag == "" {
+		return &Result{Error: errors.New("shell sessions are not available to anonymous clients")}
+	}
+	if !exists && keyword != ShellSessionStart {
+		return &Result{Error: errors.New("there is no shell session, start one with " + ShellSessionStart)}
+	}
+	timeout := time.Duration(cmd.TimeoutSec) * time.Second
+	var input string
+	switch keyword {
+	case ShellSessionStart:
+		var err error
+		if sess, err = sh.startSession(key, cmd.ClientTag); err != nil {
+			return &Result{Error: err}
+		}
+	case ShellSessionRead:
+		timeout = 0
+	case ShellSessionSend:
+		input = strings.TrimSpace(strings.TrimPrefix(cmd.Content, ShellSessionSend)) + "\n"
+	case ShellSessionCtrlC:
+		input = "\x03"
+	case ShellSessionClose:
+		sh.closeSession(key, sess)
+		out, _ := sess.takeOutput()
+		return &Result{Output: out + "(session closed)"}
+	default:
+		input = cmd.Content + "\n"
+	}
+	sess.idleTimer.Reset(time.Duration(sh.SessionIdleTimeoutSec) * time.Second)
+	if input != "" {
+		if err := sess.write(input); err != nil {
+			return &Result{Error: err}
+		}
+	}
+	out, exited := sess.waitOutput(ctx, timeout)
+	if exited {
+		sh.closeSession(key, sess)
+		out += "(session ended)"
+	}
+	return &Result{Output: out}
+}
+
+// initialiseSessions prepares the table of shell sessions, the sessions that are already running are kept.
+func (sh *Shell) initialiseSessions() {
+	if sh.SessionIdleTimeoutSec < 1 {
+		sh.SessionIdleTimeoutSec = ShellSessionDefaultIdleTimeoutSec
+	}
+	if sh.MaxSessions < 1 {
+		sh.MaxSessions = ShellSessionDefaultMaxSessions
+	}
+	if sh.sessions == nil {
+		sh.sessions = make(map[string]*shellSession)
+		sh.sessionMutex = new(sync.Mutex)
+	}
+	sh.logger = lalog.Logger{ComponentName: "Shell"}
+}
@@ -0,0 +1,160 @@
+package toolbox
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShell_Sessions(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("this test is only applicable on Linux")
+	}
+	sh := Shell{InterpreterPath: "/bin/sh", SessionIdleTimeoutSec: 3, MaxSessions: 1}
+	if err := sh.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	run := func(clientTag, content string) *Result {
+		return sh.Execute(context.Background(), Command{TimeoutSec: 5, ClientTag: clientTag, Content: content})
+	}
+	getSessionPID := func() int {
+		sh.sessionMutex.Lock()
+		defer sh.sessionMutex.Unlock()
+		for _, sess := range sh.sessions {
+			return sess.proc.Process.Pid
+		}
+		t.Fatal("there is no session")
+		return 0
+	}
+	// The shell of a closed session must not linger as a zombie
+	waitForReaping := func(pid int) {
+		for i := 0; i < 20; i++ {
+			if _, err := os.Stat(fmt.Sprintf("/proc/%d", pid)); os.IsNotExist(err) {
+				return
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		t.Fatalf("PID %d has not been reaped", pid)
+	}
+	// Session commands require a session and a client tag
+	if ret := run("client1", ShellSessionRead); ret.Error == nil {
+		t.Fatal("did not error")
+	}
+	if ret := run("", ShellSessionStart); ret.Error == nil {
+		t.Fatal("did not error")
+	}
+	// Without a session, the shell state is lost between commands
+	if ret := run("client1", "cd /tmp"); ret.Error != nil {
+		t.Fatal(ret.Error)
+	}
+	if ret := run("client1", "pwd"); ret.Error != nil || ret.Output == "/tmp\n" {
+		t.Fatal(ret.Error, ret.Output)
+	}
+	// Start a session and keep state between commands, the session does not inherit laitos' environment.
+	if err := os.Setenv("LAITOS_TEST_SHELL_SESSION_SECRET", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Unsetenv("LAITOS_TEST_SHELL_SESSION_SECRET")
+	if ret := run("client1", ShellSessionStart); ret.Error != nil {
+		t.Fatal(ret.Error)
+	}
+	if ret := run("client2", ShellSessionStart); ret.Error == nil {
+		t.Fatal("should have exceeded the maximum number of sessions")
+	}
+	if ret := run("client1", "cd /tmp && export GREETING=hello"); ret.Error != nil {
+		t.Fatal(ret.Error)
+	}
+	if ret := run("client1", "pwd; echo $GREETING"); ret.Error != nil || !strings.Contains(ret.Output, "/tmp\nhello\n") {
+		t.Fatalf("%v %q", ret.Error, ret.Output)
+	}
+	if ret := run("client1", `echo "[$LAITOS_TEST_SHELL_SESSION_SECRET]"`); ret.Error != nil || !strings.Contains(ret.Output, "[]\n") {
+		t.Fatalf("%v %q", ret.Error, ret.Output)
+	}
+	// Other clients are not affected by the session
+	if ret := run("client2", "echo $GREETING"); ret.Error != nil || ret.Output != "\n" {
+		t.Fatalf("%v %q", ret.Error, ret.Output)
+	}
+	// The same client tag coming from another daemon or authorised by another password does not share the session
+	otherDaemon := Command{TimeoutSec: 5, ClientTag: "client1", DaemonName: "smtpd", Content: "echo $GREETING"}
+	if ret := sh.Execute(context.Background(), otherDaemon); ret.Error != nil || ret.Output != "\n" {
+		t.Fatalf("%v %q", ret.Error, ret.Output)
+	}
+	otherPassword := Command{TimeoutSec: 5, ClientTag: "client1", Content: "echo $GREETING", resultKey: newResultEncryptionKey("another password")}
+	if ret := sh.Execute(context.Background(), otherPassword); ret.Error != nil || ret.Output != "\n" {
+		t.Fatalf("%v %q", ret.Error, ret.Output)
+	}
+	// Read incremental output of a background job
+	if ret := run("client1", ShellSessionSend+" sleep 1; echo done-sleeping"); ret.Error != nil || strings.Contains(ret.Output, "done-sleeping") {
+		t.Fatalf("%v %q", ret.Error, ret.Output)
+	}
+	time.Sleep(1500 * time.Millisecond)
+	if ret := run("client1", ShellSessionRead); ret.Error != nil || !strings.Contains(ret.Output, "done-sleeping") {
+		t.Fatalf("%v %q", ret.Error, ret.Output)
+	}
+	if ret := run("client1", ShellSessionRead); ret.Error != nil || strings.Contains(ret.Output, "done-sleeping") {
+		t.Fatalf("%v %q", ret.Error, ret.Output)
+	}
+	// Interrupt a long running program
+	if ret := run("client1", "sleep 100"); ret.Error != nil {
+		t.Fatal(ret.Error)
+	}
+	if ret := run("client1", ShellSessionCtrlC); ret.Error != nil {
+		t.Fatal(ret.Error)
+	}
+	if ret := run("client1", "echo interrupted"); ret.Error != nil || !strings.Contains(ret.Output, "interrupted\n") {
+		t.Fatalf("%v %q", ret.Error, ret.Output)
+	}
+	// Close the session
+	sessionPID := getSessionPID()
+	if ret := run("client1", ShellSessionClose); ret.Error != nil || !strings.Contains(ret.Output, "closed") {
+		t.Fatalf("%v %q", ret.Error, ret.Output)
+	}
+	waitForReaping(sessionPID)
+	if ret := run("client1", "pwd"); ret.Error != nil || ret.Output == "/tmp\n" {
+		t.Fatal(ret.Error, ret.Output)
+	}
+	// The session ends when the shell exits
+	if ret := run("client1", ShellSessionStart); ret.Error != nil {
+		t.Fatal(ret.Error)
+	}
+	if ret := run("client1", "exit"); ret.Error != nil || !strings.Contains(ret.Output, "ended") {
+		t.Fatalf("%v %q", ret.Error, ret.Output)
+	}
+	// The shell that exits by itself is reaped even before the client reads the session again
+	if ret := run("client1", ShellSessionStart); ret.Error != nil {
+		t.Fatal(ret.Error)
+	}
+	sessionPID = getSessionPID()
+	sh.sessionMutex.Lock()
+	for _, sess := range sh.sessions {
+		if err := sess.write("exit\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sh.sessionMutex.Unlock()
+	waitForReaping(sessionPID)
+	if ret := run("client1", ShellSessionRead); ret.Error != nil || !strings.Contains(ret.Output, "ended") {
+		t.Fatalf("%v %q", ret.Error, ret.Output)
+	}
+	// An idle session is closed automatically
+	if ret := run("client1", ShellSessionStart); ret.Error != nil {
+		t.Fatal(ret.Error)
+	}
+	time.Sleep(5 * time.Second)
+	sh.sessionMutex.Lock()
+	numSessions := len(sh.sessions)
+	sh.sessionMutex.Unlock()
+	if numSessions != 0 {
+		t.Fatal("idle session is still running")
+	}
+	// Passwords restricted by a shell profile cannot use sessions
+	sh.Profiles = map[string]*ShellProfile{"restricted": {}}
+	sh.DefaultProfile = "restricted"
+	if ret := run("client1", ShellSessionStart); ret.Error == nil {
+		t.Fatal("did not error")
+	}
+}
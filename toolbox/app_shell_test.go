@@ -4,6 +4,7 @@ import (
 	"context"
 	"io/ioutil"
 	"os"
+	"runtime"
 	"strings"
 	"testing"
 	"time"
@@ -119,3 +120,77 @@ func TestShell_NonWindowsExecute(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestShellProfile_Allows(t *testing.T) {
+	sh := Shell{Profiles: map[string]*ShellProfile{
+		"open":       {},
+		"restricted": {AllowedCommands: []string{`uptime`, `df( -h)?`, `tail -n [0-9]+ /var/log/[a-z]+\.log`}},
+	}}
+	if err := sh.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	if !sh.Profiles["open"].Allows("rm -rf /tmp/a; echo a") {
+		t.Fatal("profile without allow-list should allow all commands")
+	}
+	for _, allowed := range []string{"uptime", "df", "df -h", "tail -n 20 /var/log/syslog.log"} {
+		if !sh.Profiles["restricted"].Allows(allowed) {
+			t.Fatal("should have allowed", allowed)
+		}
+	}
+	for _, refused := range []string{"uptime2", "df -h /", "uptime; reboot", "df $(reboot)", "tail -n 20 /var/log/../../etc/shadow"} {
+		if sh.Profiles["restricted"].Allows(refused) {
+			t.Fatal("should have refused", refused)
+		}
+	}
+	// Bad configuration
+	if err := (&Shell{Profiles: map[string]*ShellProfile{"a": {AllowedCommands: []string{"("}}}}).Initialise(); err == nil {
+		t.Fatal("did not error")
+	}
+	if err := (&Shell{DefaultProfile: "does-not-exist"}).Initialise(); err == nil {
+		t.Fatal("did not error")
+	}
+}
+
+func TestShell_Profiles(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("this test is only applicable on Linux")
+	}
+	dir, err := ioutil.TempDir("", "laitos-TestShell_Profiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sh := Shell{Profiles: map[string]*ShellProfile{
+		"sandbox": {
+			ProcessSandbox:  platform.ProcessSandbox{WorkingDirectory: dir, Environment: []string{"GREETING=hi"}},
+			AllowedCommands: []string{`pwd`, `echo .*`},
+		},
+	}}
+	if err := sh.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	// Password without a scope or profile is not restricted
+	if ret := sh.Execute(context.Background(), Command{TimeoutSec: 3, Content: "cd / && pwd"}); ret.Error != nil || ret.Output != "/\n" {
+		t.Fatal(ret.Error, ret.Output)
+	}
+	// Password scope chooses the profile
+	scope := &PasswordScope{Triggers: []string{".s"}, ShellProfile: "sandbox"}
+	if ret := sh.Execute(context.Background(), Command{TimeoutSec: 3, Content: "pwd", scope: scope}); ret.Error != nil || ret.Output != dir+"\n" {
+		t.Fatal(ret.Error, ret.Output)
+	}
+	if ret := sh.Execute(context.Background(), Command{TimeoutSec: 3, Content: "cd / && pwd", scope: scope}); ret.Error == nil {
+		t.Fatal("should have refused", ret.Output)
+	}
+	// Profile that does not exist
+	if ret := sh.Execute(context.Background(), Command{TimeoutSec: 3, Content: "pwd", scope: &PasswordScope{ShellProfile: "does-not-exist"}}); ret.Error == nil {
+		t.Fatal("should have refused", ret.Output)
+	}
+	// The default profile applies to passwords that do not choose a profile
+	sh.DefaultProfile = "sandbox"
+	if ret := sh.Execute(context.Background(), Command{TimeoutSec: 3, Content: "echo hello"}); ret.Error != nil || ret.Output != "hello\n" {
+		t.Fatal(ret.Error, ret.Output)
+	}
+	if ret := sh.Execute(context.Background(), Command{TimeoutSec: 3, Content: "echo $GREETING"}); ret.Error == nil {
+		t.Fatal("should have refused", ret.Output)
+	}
+}
@@ -3,18 +3,21 @@ package toolbox
 import (
 	"context"
 	"fmt"
-	"net/http"
-	"net/url"
 	"regexp"
 	"strconv"
 	"strings"
 
-	"github.com/HouzuoGuo/laitos/inet"
+	"github.com/HouzuoGuo/laitos/lalog"
 )
 
 const (
 	TwilioMakeCall = "c" // Prefix string to trigger outgoing call
 	TwilioSendSMS  = "t" // Prefix string to trigger outgoing SMS
+
+	// TwilioSMSSinkName is the name of result sink that delivers scheduled command results and notifications via SMS.
+	TwilioSMSSinkName = "sms"
+	// TwilioMaxSMSLength is the maximum length of an SMS message body accepted by Twilio.
+	TwilioMaxSMSLength = 1600
 )
 
 var (
@@ -22,45 +25,63 @@ var (
 	ErrBadTwilioParam          = fmt.Errorf("example: %s|%s +##number message", TwilioMakeCall, TwilioSendSMS)
 )
 
+/*
+Twilio sends SMS and makes calls via the configured telephony provider - either Twilio, or an HTTP gateway such as a
+self-hosted SMS gateway running on an Android phone. The HTTP gateway takes precedence if both are configured.
+*/
 type Twilio struct {
 	PhoneNumber string `json:"PhoneNumber"` // Twilio telephone country code and number (the number you purchased from Twilio)
 	AccountSID  string `json:"AccountSID"`  // Twilio account SID ("Account Settings - LIVE Credentials - Account SID")
 	AuthToken   string `json:"AuthToken"`   // Twilio authentication secret token ("Account Settings - LIVE Credentials - Auth Token")
 
+	HTTPGateway *HTTPGatewayTelephony `json:"HTTPGateway"` // HTTPGateway is the alternative provider that sends SMS via an HTTP/JSON gateway.
+
 	TestPhoneNumber string `json:"-"` // Set by init_test.go for running test case, not a configuration.
+
+	provider TelephonyProvider
 }
 
 var TestTwilio = Twilio{} // API credentials are set by init_feature_test.go
 
+// TruncateSMS returns the text as-is if it fits in an SMS message, otherwise it cuts the text without splitting a character.
+func TruncateSMS(text string) string {
+	return lalog.TruncateUTF8(text, TwilioMaxSMSLength)
+}
+
+// getTwilioTelephony returns the Twilio provider made of the app configuration.
+func (twi *Twilio) getTwilioTelephony() *TwilioTelephony {
+	return &TwilioTelephony{PhoneNumber: twi.PhoneNumber, AccountSID: twi.AccountSID, AuthToken: twi.AuthToken}
+}
+
 func (twi *Twilio) IsConfigured() bool {
-	return twi.PhoneNumber != "" && twi.AccountSID != "" && twi.AuthToken != ""
+	return twi.HTTPGateway.IsConfigured() || twi.getTwilioTelephony().IsConfigured()
 }
 
 func (twi *Twilio) SelfTest() error {
 	if !twi.IsConfigured() {
 		return ErrIncompleteConfig
 	}
-	// Validate API credentials with a simple API call
-	resp, err := inet.DoHTTP(context.Background(), inet.HTTPRequest{
-		TimeoutSec: SelfTestTimeoutSec,
-		RequestFunc: func(req *http.Request) error {
-			req.SetBasicAuth(twi.AccountSID, twi.AuthToken)
-			return nil
-		},
-	}, "https://api.twilio.com/2010-04-01/Accounts/%s", twi.AccountSID)
-	if err != nil {
-		return fmt.Errorf("Twilio.SelfTest: API IO error - %v", err)
-	}
-	if err = resp.Non2xxToError(); err != nil {
-		return fmt.Errorf("Twilio.SelfTest: API response error - %v", err)
-	}
-	return nil
+	return twi.provider.SelfTest()
 }
 
 func (twi *Twilio) Initialise() error {
+	if twi.HTTPGateway.IsConfigured() {
+		twi.provider = twi.HTTPGateway
+	} else {
+		twi.provider = twi.getTwilioTelephony()
+	}
+	// Allow scheduled commands and new mail notifications to be delivered via SMS, the destination is a telephone number.
+	RegisterScheduleResultSink(TwilioSMSSinkName, func(destination, _, text string) error {
+		return twi.SendSMS(Command{TimeoutSec: SelfTestTimeoutSec, Content: destination + " " + TruncateSMS(text)}).Error
+	})
 	return nil
 }
 
+// GetProvider returns the telephony provider chosen by Initialise, it is nil if the app has not been initialised.
+func (twi *Twilio) GetProvider() TelephonyProvider {
+	return twi.provider
+}
+
 func (twi *Twilio) Trigger() Trigger {
 	return ".p"
 }
@@ -87,31 +108,8 @@ func (twi *Twilio) MakeCall(cmd Command) *Result {
 	}
 	toNumber := params[1]
 	message := params[2]
-	formParams := url.Values{
-		"From": {twi.PhoneNumber},
-		"To":   {toNumber},
-		"Url": {"http://twimlets.com/message?Message=" + url.QueryEscape(fmt.Sprintf(`%s.
-
-repeat again.
-
-%s.
-
-repeat again.
-
-%s.
-over.`, message, message, message))},
-	}
-	resp, err := inet.DoHTTP(context.Background(), inet.HTTPRequest{
-		TimeoutSec: cmd.TimeoutSec,
-		Method:     http.MethodPost,
-		Body:       strings.NewReader(formParams.Encode()),
-		RequestFunc: func(req *http.Request) error {
-			req.SetBasicAuth(twi.AccountSID, twi.AuthToken)
-			return nil
-		},
-	}, "https://api.twilio.com/2010-04-01/Accounts/%s/Calls.json", twi.AccountSID)
-	if errResult := HTTPErrorToResult(resp, err); errResult != nil {
-		return errResult
+	if err := twi.provider.MakeCall(context.Background(), cmd.TimeoutSec, toNumber, message); err != nil {
+		return &Result{Error: err}
 	}
 	// The OK output is simply the length of number + message
 	return &Result{Error: nil, Output: strconv.Itoa(len(toNumber) + len(message))}
@@ -124,23 +122,8 @@ func (twi *Twilio) SendSMS(cmd Command) *Result {
 	}
 	toNumber := params[1]
 	message := params[2]
-
-	formParams := url.Values{
-		"From": {twi.PhoneNumber},
-		"To":   {toNumber},
-		"Body": {message},
-	}
-	resp, err := inet.DoHTTP(context.Background(), inet.HTTPRequest{
-		TimeoutSec: cmd.TimeoutSec,
-		Method:     http.MethodPost,
-		Body:       strings.NewReader(formParams.Encode()),
-		RequestFunc: func(req *http.Request) error {
-			req.SetBasicAuth(twi.AccountSID, twi.AuthToken)
-			return nil
-		},
-	}, "https://api.twilio.com/2010-04-01/Accounts/%s/Messages.json", twi.AccountSID)
-	if errResult := HTTPErrorToResult(resp, err); errResult != nil {
-		return errResult
+	if err := twi.provider.SendSMS(context.Background(), cmd.TimeoutSec, toNumber, message); err != nil {
+		return &Result{Error: err}
 	}
 	// The OK output is simply the length of number + message
 	return &Result{Error: nil, Output: strconv.Itoa(len(toNumber) + len(message))}
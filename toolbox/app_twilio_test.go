@@ -3,7 +3,9 @@ package toolbox
 import (
 	"context"
 	"strconv"
+	"strings"
 	"testing"
+	"unicode/utf8"
 )
 
 func TestTwilio_Execute(t *testing.T) {
@@ -39,3 +41,17 @@ func TestTwilio_Execute(t *testing.T) {
 		t.Fatal(ret)
 	}
 }
+
+func TestTruncateSMS(t *testing.T) {
+	if text := TruncateSMS("hello"); text != "hello" {
+		t.Fatal(text)
+	}
+	// A multi-byte character that straddles the limit is removed entirely
+	text := TruncateSMS(strings.Repeat("a", TwilioMaxSMSLength-1) + "é")
+	if text != strings.Repeat("a", TwilioMaxSMSLength-1) || !utf8.ValidString(text) {
+		t.Fatal(len(text))
+	}
+	if text := TruncateSMS(strings.Repeat("é", TwilioMaxSMSLength)); len(text) != TwilioMaxSMSLength || !utf8.ValidString(text) {
+		t.Fatal(len(text))
+	}
+}
@@ -5,15 +5,21 @@ import (
 	"context"
 	"crypto/hmac"
 	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/base32"
+	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"hash"
 	"io/ioutil"
 	"math/big"
 	"os"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -29,35 +35,64 @@ specific time division using SHA1 method. The caller is responsible for linting
 The function is heavily inspired by Pierre Carrier's "gauth" (https://github.com/pcarrier/gauth).
 */
 func GetTwoFACodeForTimeDivision(secret string, time int64) (string, error) {
+	return GetHOTPCode(secret, uint64(time), TwoFAAlgorithmSHA1, 6)
+}
+
+// DecodeTwoFASecret decodes the base32 secret text, which is not case sensitive and may contain spaces.
+func DecodeTwoFASecret(secret string) ([]byte, error) {
 	secret = strings.ToUpper(strings.TrimSpace(strings.Replace(secret, " ", "", -1)))
+	secret = strings.TrimRight(secret, "=")
 	// Secret is linted and padded with = to nearest 8 bytes
 	paddingLength := 8 - (len(secret) % 8)
 	if paddingLength < 8 {
 		secret += strings.Repeat("=", paddingLength)
 	}
-	secretBin, err := base32.StdEncoding.DecodeString(secret)
+	return base32.StdEncoding.DecodeString(secret)
+}
+
+/*
+GetHOTPCode returns the HMAC-based one-time password (RFC 4226) calculated for the counter, using the hash algorithm
+(SHA1, SHA256, or SHA512) and number of digits. A time-based one-time password (RFC 6238) uses the number of time
+steps elapsed since unix epoch as the counter.
+*/
+func GetHOTPCode(secret string, counter uint64, algorithm string, digits int) (string, error) {
+	secretBin, err := DecodeTwoFASecret(secret)
 	if err != nil {
 		return "", err
 	}
-	shaHMAC := hmac.New(sha1.New, secretBin)
-	timeMessage := make([]byte, 8)
-	timeMessage[0] = (byte)(time >> (7 * 8) & 0xff)
-	timeMessage[1] = (byte)(time >> (6 * 8) & 0xff)
-	timeMessage[2] = (byte)(time >> (5 * 8) & 0xff)
-	timeMessage[3] = (byte)(time >> (4 * 8) & 0xff)
-	timeMessage[4] = (byte)(time >> (3 * 8) & 0xff)
-	timeMessage[5] = (byte)(time >> (2 * 8) & 0xff)
-	timeMessage[6] = (byte)(time >> (1 * 8) & 0xff)
-	timeMessage[7] = (byte)(time >> (0 * 8) & 0xff)
-	if _, err := shaHMAC.Write(timeMessage); err != nil {
+	return getHOTPCodeFromBinary(secretBin, counter, algorithm, digits)
+}
+
+// getHOTPCodeFromBinary returns the HMAC-based one-time password calculated from the binary secret.
+func getHOTPCodeFromBinary(secret []byte, counter uint64, algorithm string, digits int) (string, error) {
+	var hashFun func() hash.Hash
+	switch strings.ToUpper(algorithm) {
+	case "", TwoFAAlgorithmSHA1:
+		hashFun = sha1.New
+	case TwoFAAlgorithmSHA256:
+		hashFun = sha256.New
+	case TwoFAAlgorithmSHA512:
+		hashFun = sha512.New
+	default:
+		return "", fmt.Errorf("unsupported algorithm \"%s\"", algorithm)
+	}
+	if digits < 6 || digits > 8 {
+		return "", fmt.Errorf("number of digits must be between 6 and 8")
+	}
+	shaHMAC := hmac.New(hashFun, secret)
+	counterMessage := make([]byte, 8)
+	binary.BigEndian.PutUint64(counterMessage, counter)
+	if _, err := shaHMAC.Write(counterMessage); err != nil {
 		return "", err
 	}
 	hash := shaHMAC.Sum(nil)
-	offset := hash[19] & 0x0f
+	// Dynamic truncation uses the lowest 4 bits of the last byte as offset
+	offset := hash[len(hash)-1] & 0x0f
 	truncated := hash[offset : offset+4]
 	truncated[0] &= 0x7F
-	result := new(big.Int).Mod(new(big.Int).SetBytes(truncated), big.NewInt(1000000))
-	return fmt.Sprintf("%06d", result), nil
+	modulo := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(digits)), nil)
+	result := new(big.Int).Mod(new(big.Int).SetBytes(truncated), modulo)
+	return fmt.Sprintf("%0*d", digits, result), nil
 }
 
 /*
@@ -65,23 +100,52 @@ GetTwoFACodes calculates two factor authentication codes using system clock and
 as input. Return previous, current, and next authentication codes in strings.
 */
 func GetTwoFACodes(secret string) (previous, current, next string, err error) {
-	if previous, err = GetTwoFACodeForTimeDivision(secret, time.Now().Unix()/30-1); err != nil {
+	return GetTOTPCodes(secret, TwoFAAlgorithmSHA1, 6, 30)
+}
+
+/*
+GetTOTPCodes calculates time-based one-time passwords (RFC 6238) using system clock, the hash algorithm, number of digits,
+and time step in seconds. Return previous, current, and next authentication codes in strings.
+*/
+func GetTOTPCodes(secret string, algorithm string, digits int, periodSec int) (previous, current, next string, err error) {
+	secretBin, err := DecodeTwoFASecret(secret)
+	if err != nil {
 		return
-	} else if current, err = GetTwoFACodeForTimeDivision(secret, time.Now().Unix()/30); err != nil {
+	}
+	return getTOTPCodesFromBinary(secretBin, algorithm, digits, periodSec)
+}
+
+// getTOTPCodesFromBinary returns the previous, current, and next time-based one-time passwords of the binary secret.
+func getTOTPCodesFromBinary(secret []byte, algorithm string, digits int, periodSec int) (previous, current, next string, err error) {
+	if periodSec < 1 {
+		periodSec = 30
+	}
+	timeDivision := uint64(time.Now().Unix() / int64(periodSec))
+	if previous, err = getHOTPCodeFromBinary(secret, timeDivision-1, algorithm, digits); err != nil {
+		return
+	} else if current, err = getHOTPCodeFromBinary(secret, timeDivision, algorithm, digits); err != nil {
 		return
 	}
-	next, err = GetTwoFACodeForTimeDivision(secret, time.Now().Unix()/30+1)
+	next, err = getHOTPCodeFromBinary(secret, timeDivision+1, algorithm, digits)
 	return
 }
 
 const TwoFATrigger = ".2" // TwoFATrigger is the trigger prefix string of TwoFACodeGenerator feature.
 
 /*
-TwoFACodeGenerator generates two factor authentication codes upon request. The generator
-takes an AES encrypted secret seed file as input, that looks like "account_name: secret\n...".
+TwoFACodeGenerator generates two factor authentication codes upon request. The generator takes an AES encrypted secret
+seed file as input, each line of the file is either "account_name: secret", an otpauth:// URI, or an
+otpauth-migration:// URI exported from Google Authenticator.
 */
 type TwoFACodeGenerator struct {
 	SecretFile *AESEncryptedFile `json:"SecretFile"` // SecretFile has encrypted account name and 2fa secrets
+	/*
+		HOTPCounterFilePath is the path to a file that remembers the counter of each counter-based (HOTP) account, so that
+		a code is never generated twice. The file does not contain account secrets. It is required only by HOTP accounts.
+	*/
+	HOTPCounterFilePath string `json:"HOTPCounterFilePath"`
+
+	hotpCounterMutex *sync.Mutex
 }
 
 func (codegen *TwoFACodeGenerator) IsConfigured() bool {
@@ -99,6 +163,7 @@ func (codegen *TwoFACodeGenerator) SelfTest() error {
 }
 
 func (codegen *TwoFACodeGenerator) Initialise() error {
+	codegen.hotpCounterMutex = new(sync.Mutex)
 	if err := codegen.SecretFile.Initialise(); err != nil {
 		return fmt.Errorf("TwoFACodeGenerator: failed to initialise encrypted secret file - %w", err)
 	}
@@ -109,6 +174,47 @@ func (codegen *TwoFACodeGenerator) Trigger() Trigger {
 	return TwoFATrigger
 }
 
+/*
+nextHOTPCounter returns the counter to be used for the next code of the HOTP account and persists the counter after it.
+The counter never goes backwards, and it is never lower than the initial counter of the account.
+*/
+func (codegen *TwoFACodeGenerator) nextHOTPCounter(account TwoFAAccount) (uint64, error) {
+	if codegen.HOTPCounterFilePath == "" {
+		return 0, fmt.Errorf("HOTPCounterFilePath must be configured to generate code for counter-based account \"%s\"", account.Name)
+	}
+	codegen.hotpCounterMutex.Lock()
+	defer codegen.hotpCounterMutex.Unlock()
+	counters := make(map[string]uint64)
+	content, err := ioutil.ReadFile(codegen.HOTPCounterFilePath)
+	if err == nil {
+		if err := json.Unmarshal(content, &counters); err != nil {
+			return 0, fmt.Errorf("failed to read HOTP counter file - %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return 0, err
+	}
+	// Identify the account by its secret, so that renaming an account does not reset its counter.
+	secretHash := sha256.Sum256(account.Secret)
+	key := hex.EncodeToString(secretHash[:])
+	counter := counters[key]
+	if counter < account.Counter {
+		counter = account.Counter
+	}
+	// Persist the counter before revealing the code
+	counters[key] = counter + 1
+	if content, err = json.Marshal(counters); err != nil {
+		return 0, err
+	}
+	tmpPath := codegen.HOTPCounterFilePath + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, content, 0600); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmpPath, codegen.HOTPCounterFilePath); err != nil {
+		return 0, err
+	}
+	return counter, nil
+}
+
 func (codegen *TwoFACodeGenerator) Execute(ctx context.Context, cmd Command) (ret *Result) {
 	if errResult := cmd.Trim(); errResult != nil {
 		return errResult
@@ -117,38 +223,45 @@ func (codegen *TwoFACodeGenerator) Execute(ctx context.Context, cmd Command) (re
 	if len(params) != 3 {
 		return &Result{Error: ErrBadTwoFAParam}
 	}
-	hexKeySuffix := params[1]
-	accountName := params[2]
+	key := params[1]
+	accountName := strings.ToLower(params[2])
 	// Use combination of configured key and input suffix key to decrypt the account secret file
-	keySuffix, err := hex.DecodeString(hexKeySuffix)
-	if err != nil {
-		return &Result{Error: errors.New("Cannot decode hex key")}
-	}
-	plainContent, err := codegen.SecretFile.Decrypt(keySuffix)
+	plainContent, err := codegen.SecretFile.Decrypt(key)
 	if err != nil {
 		return &Result{Error: err}
 	}
+	// Malformed accounts do not prevent the other accounts from being used
+	accounts, parseErr := ParseTwoFAAccounts(string(plainContent))
 	var accountFound bool
 	var codeOutput bytes.Buffer
-	// Read the account name and secrets among the lines
-	for _, line := range strings.Split(string(plainContent), "\n") {
-		fields := strings.SplitN(line, ":", 2)
-		if len(fields) != 2 {
+	for _, account := range accounts {
+		// If requested word is among the entry's account name, calculate its code.
+		if !strings.Contains(strings.ToLower(account.Name), accountName) {
 			continue
 		}
-		entryName := strings.TrimSpace(fields[0])
-		secret := strings.TrimSpace(fields[1])
-		// If requested word is among the entry's account name, calculate its code.
-		if strings.Contains(entryName, accountName) {
-			accountFound = true
-			prev, current, next, err := GetTwoFACodes(secret)
+		accountFound = true
+		if account.Type == TwoFATypeHOTP {
+			counter, err := codegen.nextHOTPCounter(account)
 			if err != nil {
 				return &Result{Error: err}
 			}
-			codeOutput.WriteString(fmt.Sprintf("%s: %s %s %s\n", entryName, prev, current, next))
+			code, err := getHOTPCodeFromBinary(account.Secret, counter, account.Algorithm, account.Digits)
+			if err != nil {
+				return &Result{Error: err}
+			}
+			codeOutput.WriteString(fmt.Sprintf("%s: %s (counter %d)\n", account.Name, code, counter))
+		} else {
+			prev, current, next, err := getTOTPCodesFromBinary(account.Secret, account.Algorithm, account.Digits, account.PeriodSec)
+			if err != nil {
+				return &Result{Error: err}
+			}
+			codeOutput.WriteString(fmt.Sprintf("%s: %s %s %s\n", account.Name, prev, current, next))
 		}
 	}
 	if !accountFound {
+		if parseErr != nil {
+			return &Result{Error: fmt.Errorf("Cannot find the account, %v", parseErr)}
+		}
 		return &Result{Error: errors.New("Cannot find the account")}
 	}
 	// Calculate 2fa code and return
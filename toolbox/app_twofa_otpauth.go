@@ -0,0 +1,286 @@
+package toolbox
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	TwoFAAlgorithmSHA1   = "SHA1"   // TwoFAAlgorithmSHA1 is the default hash algorithm of one-time passwords.
+	TwoFAAlgorithmSHA256 = "SHA256" // TwoFAAlgorithmSHA256 is the SHA256 hash algorithm of one-time passwords.
+	TwoFAAlgorithmSHA512 = "SHA512" // TwoFAAlgorithmSHA512 is the SHA512 hash algorithm of one-time passwords.
+
+	TwoFATypeTOTP = "totp" // TwoFATypeTOTP is the time-based one-time password (RFC 6238).
+	TwoFATypeHOTP = "hotp" // TwoFATypeHOTP is the counter-based one-time password (RFC 4226).
+
+	OTPAuthURIPrefix          = "otpauth://"           // OTPAuthURIPrefix is the prefix of a key URI of a single account.
+	OTPAuthMigrationURIPrefix = "otpauth-migration://" // OTPAuthMigrationURIPrefix is the prefix of Google Authenticator export URI.
+)
+
+// TwoFAAccount is an account for which one-time passwords are generated.
+type TwoFAAccount struct {
+	Name      string // Name is the account name, it is prefixed by the issuer (e.g. "Google:me@example.com") if available.
+	Secret    []byte // Secret is the binary secret of the account.
+	Type      string // Type is either "totp" or "hotp".
+	Algorithm string // Algorithm is one of "SHA1", "SHA256", or "SHA512".
+	Digits    int    // Digits is the number of digits of the one-time password, either 6 or 8.
+	PeriodSec int    // PeriodSec is the time step of a time-based one-time password.
+	Counter   uint64 // Counter is the initial counter of a counter-based one-time password.
+}
+
+// newTwoFAAccount returns an account using the default parameters - SHA1, 6 digits, 30 seconds time step.
+func newTwoFAAccount(name string, secret []byte) TwoFAAccount {
+	return TwoFAAccount{
+		Name:      name,
+		Secret:    secret,
+		Type:      TwoFATypeTOTP,
+		Algorithm: TwoFAAlgorithmSHA1,
+		Digits:    6,
+		PeriodSec: 30,
+	}
+}
+
+/*
+ParseOTPAuthURI parses a key URI in the format used by authenticator apps, e.g.
+otpauth://totp/Example:alice@example.com?secret=JBSWY3DPEHPK3PXP&issuer=Example&algorithm=SHA256&digits=8&period=60
+otpauth://hotp/Example:alice@example.com?secret=JBSWY3DPEHPK3PXP&counter=10
+*/
+func ParseOTPAuthURI(uri string) (account TwoFAAccount, err error) {
+	parsed, err := url.Parse(strings.TrimSpace(uri))
+	if err != nil {
+		return
+	}
+	if parsed.Scheme != "otpauth" {
+		err = fmt.Errorf("\"%s\" is not an otpauth URI", parsed.Scheme)
+		return
+	}
+	query := parsed.Query()
+	secret, err := DecodeTwoFASecret(query.Get("secret"))
+	if err != nil || len(secret) == 0 {
+		err = errors.New("otpauth URI has a malformed secret")
+		return
+	}
+	// The label is "issuer:account" or just "account"
+	name := strings.TrimPrefix(parsed.Path, "/")
+	if issuer := query.Get("issuer"); issuer != "" && !strings.Contains(name, ":") {
+		name = issuer + ":" + name
+	}
+	account = newTwoFAAccount(name, secret)
+	account.Type = strings.ToLower(parsed.Host)
+	if account.Type != TwoFATypeTOTP && account.Type != TwoFATypeHOTP {
+		err = fmt.Errorf("otpauth URI has unsupported type \"%s\"", parsed.Host)
+		return
+	}
+	if algorithm := query.Get("algorithm"); algorithm != "" {
+		account.Algorithm = strings.ToUpper(algorithm)
+	}
+	if account.Algorithm != TwoFAAlgorithmSHA1 && account.Algorithm != TwoFAAlgorithmSHA256 && account.Algorithm != TwoFAAlgorithmSHA512 {
+		err = fmt.Errorf("otpauth URI has unsupported algorithm \"%s\"", account.Algorithm)
+		return
+	}
+	if digits := query.Get("digits"); digits != "" {
+		if account.Digits, err = strconv.Atoi(digits); err != nil || account.Digits < 6 || account.Digits > 8 {
+			err = fmt.Errorf("otpauth URI has unsupported number of digits \"%s\"", digits)
+			return
+		}
+	}
+	if period := query.Get("period"); period != "" {
+		if account.PeriodSec, err = strconv.Atoi(period); err != nil || account.PeriodSec < 1 {
+			err = fmt.Errorf("otpauth URI has malformed period \"%s\"", period)
+			return
+		}
+	}
+	if counter := query.Get("counter"); counter != "" {
+		if account.Counter, err = strconv.ParseUint(counter, 10, 64); err != nil {
+			err = fmt.Errorf("otpauth URI has malformed counter \"%s\"", counter)
+			return
+		}
+	}
+	return account, nil
+}
+
+// readProtobufField reads the next field of a protocol buffers message, it supports varint and length-delimited types.
+func readProtobufField(msg []byte) (fieldNum int, varint uint64, bytes []byte, remaining []byte, err error) {
+	key, n := decodeProtobufVarint(msg)
+	if n == 0 {
+		return 0, 0, nil, nil, errors.New("malformed protobuf field key")
+	}
+	msg = msg[n:]
+	fieldNum = int(key >> 3)
+	switch key & 7 {
+	case 0:
+		if varint, n = decodeProtobufVarint(msg); n == 0 {
+			return 0, 0, nil, nil, errors.New("malformed protobuf varint")
+		}
+		return fieldNum, varint, nil, msg[n:], nil
+	case 2:
+		length, n := decodeProtobufVarint(msg)
+		if n == 0 || uint64(len(msg)-n) < length {
+			return 0, 0, nil, nil, errors.New("malformed protobuf length-delimited field")
+		}
+		msg = msg[n:]
+		return fieldNum, 0, msg[:length], msg[length:], nil
+	default:
+		return 0, 0, nil, nil, fmt.Errorf("unsupported protobuf wire type %d", key&7)
+	}
+}
+
+// decodeProtobufVarint decodes a varint and returns the number of bytes it occupies, or 0 if the varint is malformed.
+func decodeProtobufVarint(buf []byte) (uint64, int) {
+	var x uint64
+	for i := 0; i < len(buf) && i < 10; i++ {
+		x |= uint64(buf[i]&0x7f) << (7 * uint(i))
+		if buf[i] < 0x80 {
+			return x, i + 1
+		}
+	}
+	return 0, 0
+}
+
+/*
+ParseOTPAuthMigrationURI parses the URI exported by Google Authenticator ("Transfer accounts"), which looks like
+otpauth-migration://offline?data=BASE64. The data is a protocol buffers message that carries any number of accounts.
+*/
+func ParseOTPAuthMigrationURI(uri string) ([]TwoFAAccount, error) {
+	parsed, err := url.Parse(strings.TrimSpace(uri))
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Scheme != "otpauth-migration" {
+		return nil, fmt.Errorf("\"%s\" is not an otpauth-migration URI", parsed.Scheme)
+	}
+	// The data is encoded in standard base64, whose "+" may have been turned into space by URL query decoding.
+	data := strings.Replace(parsed.Query().Get("data"), " ", "+", -1)
+	payload, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		if payload, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(data, "=")); err != nil {
+			return nil, fmt.Errorf("otpauth-migration URI has malformed data - %v", err)
+		}
+	}
+	ret := make([]TwoFAAccount, 0)
+	// MigrationPayload.otp_parameters is the repeated field number 1, the other fields are irrelevant.
+	for len(payload) > 0 {
+		fieldNum, _, otpParams, remaining, err := readProtobufField(payload)
+		if err != nil {
+			return nil, fmt.Errorf("otpauth-migration URI has malformed data - %v", err)
+		}
+		payload = remaining
+		if fieldNum != 1 || otpParams == nil {
+			continue
+		}
+		account, err := parseMigrationOTPParameters(otpParams)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, account)
+	}
+	return ret, nil
+}
+
+// parseMigrationOTPParameters parses an OtpParameters message of Google Authenticator migration payload.
+func parseMigrationOTPParameters(msg []byte) (account TwoFAAccount, err error) {
+	account = newTwoFAAccount("", nil)
+	var name, issuer string
+	for len(msg) > 0 {
+		var fieldNum int
+		var varint uint64
+		var bytes []byte
+		if fieldNum, varint, bytes, msg, err = readProtobufField(msg); err != nil {
+			return
+		}
+		switch fieldNum {
+		case 1:
+			account.Secret = bytes
+		case 2:
+			name = string(bytes)
+		case 3:
+			issuer = string(bytes)
+		case 4:
+			// 0 - unspecified, 1 - SHA1, 2 - SHA256, 3 - SHA512, 4 - MD5
+			switch varint {
+			case 0, 1:
+				account.Algorithm = TwoFAAlgorithmSHA1
+			case 2:
+				account.Algorithm = TwoFAAlgorithmSHA256
+			case 3:
+				account.Algorithm = TwoFAAlgorithmSHA512
+			default:
+				err = fmt.Errorf("account \"%s\" uses an unsupported algorithm", name)
+				return
+			}
+		case 5:
+			// 0 - unspecified, 1 - six, 2 - eight
+			if varint == 2 {
+				account.Digits = 8
+			}
+		case 6:
+			// 0 - unspecified, 1 - HOTP, 2 - TOTP
+			if varint == 1 {
+				account.Type = TwoFATypeHOTP
+			}
+		case 7:
+			account.Counter = varint
+		}
+	}
+	if len(account.Secret) == 0 {
+		err = fmt.Errorf("account \"%s\" does not have a secret", name)
+		return
+	}
+	account.Name = name
+	if issuer != "" && !strings.HasPrefix(name, issuer+":") {
+		account.Name = issuer + ":" + name
+	}
+	return account, nil
+}
+
+/*
+ParseTwoFAAccounts reads accounts from the plain text, one account per line. A line may be an otpauth URI, an
+otpauth-migration URI that carries several accounts, or "account_name: secret" that uses the default parameters.
+Other lines are ignored. A malformed URI does not prevent the other accounts from being read, the function returns all
+accounts that are successfully read, along with an error that tells the line numbers of the malformed URIs.
+*/
+func ParseTwoFAAccounts(content string) ([]TwoFAAccount, error) {
+	ret := make([]TwoFAAccount, 0)
+	var malformedLines []string
+	var lastErr error
+	for i, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, OTPAuthURIPrefix):
+			account, err := ParseOTPAuthURI(line)
+			if err != nil {
+				malformedLines = append(malformedLines, strconv.Itoa(i+1))
+				lastErr = err
+				continue
+			}
+			ret = append(ret, account)
+		case strings.HasPrefix(line, OTPAuthMigrationURIPrefix):
+			accounts, err := ParseOTPAuthMigrationURI(line)
+			if err != nil {
+				malformedLines = append(malformedLines, strconv.Itoa(i+1))
+				lastErr = err
+				continue
+			}
+			ret = append(ret, accounts...)
+		default:
+			fields := strings.SplitN(line, ":", 2)
+			if len(fields) != 2 {
+				continue
+			}
+			secret, err := DecodeTwoFASecret(fields[1])
+			if err != nil {
+				// Not an account, or decrypted with an incorrect key.
+				continue
+			}
+			ret = append(ret, newTwoFAAccount(strings.TrimSpace(fields[0]), secret))
+		}
+	}
+	if len(malformedLines) > 0 {
+		return ret, fmt.Errorf("skipped malformed accounts on line %s - %v", strings.Join(malformedLines, ", "), lastErr)
+	}
+	return ret, nil
+}
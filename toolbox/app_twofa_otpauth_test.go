@@ -0,0 +1,91 @@
+package toolbox
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseOTPAuthURI(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	account, err := ParseOTPAuthURI("otpauth://totp/alice@example.com?secret=gezdgnbvgy3tqojqgezdgnbvgy3tqojq&issuer=Example&algorithm=sha256&digits=8&period=60")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(account, TwoFAAccount{Name: "Example:alice@example.com", Secret: secret, Type: TwoFATypeTOTP, Algorithm: TwoFAAlgorithmSHA256, Digits: 8, PeriodSec: 60}) {
+		t.Fatalf("%+v", account)
+	}
+	account, err = ParseOTPAuthURI("otpauth://hotp/Example:bob?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ&issuer=Other&counter=10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(account, TwoFAAccount{Name: "Example:bob", Secret: secret, Type: TwoFATypeHOTP, Algorithm: TwoFAAlgorithmSHA1, Digits: 6, PeriodSec: 30, Counter: 10}) {
+		t.Fatalf("%+v", account)
+	}
+	for _, bad := range []string{
+		"",
+		"https://example.com",
+		"otpauth://totp/a",
+		"otpauth://totp/a?secret=1",
+		"otpauth://xotp/a?secret=GEZDGNBV",
+		"otpauth://totp/a?secret=GEZDGNBV&algorithm=MD5",
+		"otpauth://totp/a?secret=GEZDGNBV&digits=5",
+		"otpauth://totp/a?secret=GEZDGNBV&period=0",
+		"otpauth://hotp/a?secret=GEZDGNBV&counter=-1",
+	} {
+		if _, err := ParseOTPAuthURI(bad); err == nil {
+			t.Fatal("should have failed", bad)
+		}
+	}
+}
+
+func TestParseOTPAuthMigrationURI(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	accounts, err := ParseOTPAuthMigrationURI("otpauth-migration://offline?data=CjgKFDEyMzQ1Njc4OTAxMjM0NTY3ODkwEhFhbGljZUBleGFtcGxlLmNvbRoHRXhhbXBsZSACKAIwAgojChQxMjM0NTY3ODkwMTIzNDU2Nzg5MBIDYm9iIAEoATABOAUQARgBIAAouWA%3D")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(accounts, []TwoFAAccount{
+		{Name: "Example:alice@example.com", Secret: secret, Type: TwoFATypeTOTP, Algorithm: TwoFAAlgorithmSHA256, Digits: 8, PeriodSec: 30},
+		{Name: "bob", Secret: secret, Type: TwoFATypeHOTP, Algorithm: TwoFAAlgorithmSHA1, Digits: 6, PeriodSec: 30, Counter: 5},
+	}) {
+		t.Fatalf("%+v", accounts)
+	}
+	for _, bad := range []string{
+		"otpauth://offline?data=CgA%3D",
+		"otpauth-migration://offline?data=!!!",
+		// Truncated length-delimited field
+		"otpauth-migration://offline?data=CjgKFDEy",
+		// An account without secret
+		"otpauth-migration://offline?data=CgUSA2JvYg%3D%3D",
+	} {
+		if _, err := ParseOTPAuthMigrationURI(bad); err == nil {
+			t.Fatal("should have failed", bad)
+		}
+	}
+}
+
+func TestParseTwoFAAccounts(t *testing.T) {
+	accounts, err := ParseTwoFAAccounts(`
+not an account
+legacy account: gezd gnbv gy3t qojq gezd gnbv gy3t qojq
+otpauth://hotp/hotp%20account?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ
+otpauth-migration://offline?data=CjgKFDEyMzQ1Njc4OTAxMjM0NTY3ODkwEhFhbGljZUBleGFtcGxlLmNvbRoHRXhhbXBsZSACKAIwAgojChQxMjM0NTY3ODkwMTIzNDU2Nzg5MBIDYm9iIAEoATABOAUQARgBIAAouWA%3D
+bad secret: 1
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, account := range accounts {
+		names = append(names, account.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"legacy account", "hotp account", "Example:alice@example.com", "bob"}) {
+		t.Fatal(names)
+	}
+	// A malformed URI does not prevent the other accounts from being read
+	accounts, err = ParseTwoFAAccounts("otpauth://totp/a?secret=1\nlegacy account: gezd gnbv gy3t qojq gezd gnbv gy3t qojq\notpauth-migration://offline?data=!!!")
+	if err == nil || !strings.Contains(err.Error(), "line 1, 3") || len(accounts) != 1 || accounts[0].Name != "legacy account" {
+		t.Fatal(accounts, err)
+	}
+}
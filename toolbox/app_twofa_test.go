@@ -2,8 +2,13 @@ package toolbox
 
 import (
 	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/HouzuoGuo/laitos/misc"
 )
 
 func TestGetTwoFACodeForTimeDivision(t *testing.T) {
@@ -12,6 +17,32 @@ func TestGetTwoFACodeForTimeDivision(t *testing.T) {
 	}
 }
 
+func TestGetHOTPCode(t *testing.T) {
+	// Test vectors of RFC 4226 appendix D
+	for counter, expected := range []string{"755224", "287082", "359152"} {
+		if result, err := GetHOTPCode("GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", uint64(counter), TwoFAAlgorithmSHA1, 6); err != nil || result != expected {
+			t.Fatal(counter, result, err)
+		}
+	}
+	// Test vectors of RFC 6238 appendix B at T=59
+	if result, err := GetHOTPCode("GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", 1, TwoFAAlgorithmSHA1, 8); err != nil || result != "94287082" {
+		t.Fatal(result, err)
+	}
+	if result, err := GetHOTPCode("GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZA====", 1, TwoFAAlgorithmSHA256, 8); err != nil || result != "46119246" {
+		t.Fatal(result, err)
+	}
+	if result, err := GetHOTPCode("GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZDGNA=", 1, TwoFAAlgorithmSHA512, 8); err != nil || result != "90693936" {
+		t.Fatal(result, err)
+	}
+	// Bad parameters
+	if _, err := GetHOTPCode("GEZDGNBV", 1, "MD5", 6); err == nil {
+		t.Fatal("should have failed")
+	}
+	if _, err := GetHOTPCode("GEZDGNBV", 1, TwoFAAlgorithmSHA1, 9); err == nil {
+		t.Fatal("should have failed")
+	}
+}
+
 func TestTwoFACodeGenerator_Execute(t *testing.T) {
 	// Prepare feature using incorrect configuration should result in error
 	codegen := TwoFACodeGenerator{}
@@ -65,3 +96,54 @@ func TestTwoFACodeGenerator_Execute(t *testing.T) {
 		t.Fatal(ret)
 	}
 }
+
+func TestTwoFACodeGenerator_OTPAuthAccounts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "laitos-TestTwoFACodeGenerator_OTPAuthAccounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	accounts := `legacy account: gezdgnbvgy3tqojqgezdgnbvgy3tqojq
+otpauth://totp/Example:alice?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ&algorithm=SHA512&digits=8&period=60
+otpauth://hotp/Example:bob?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ&counter=1
+`
+	encrypted, err := misc.EncryptWithKeySlots([]byte(accounts), [][]byte{[]byte("secret123")}, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secretPath := filepath.Join(dir, "secrets")
+	if err := ioutil.WriteFile(secretPath, encrypted, 0600); err != nil {
+		t.Fatal(err)
+	}
+	codegen := TwoFACodeGenerator{SecretFile: &AESEncryptedFile{FilePath: secretPath, Format: AESFormatLaitosGCM}}
+	if err := codegen.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	// Time-based accounts
+	if ret := codegen.Execute(context.Background(), Command{TimeoutSec: 10, Content: "secret123 ACCOUNT"}); ret.Error != nil || len(ret.Output) != len("legacy account: 123456 123456 123456\n") {
+		t.Fatal(ret)
+	}
+	if ret := codegen.Execute(context.Background(), Command{TimeoutSec: 10, Content: "secret123 alice"}); ret.Error != nil || len(ret.Output) != len("Example:alice: 12345678 12345678 12345678\n") {
+		t.Fatal(ret)
+	}
+	// Counter-based account requires a counter file
+	if ret := codegen.Execute(context.Background(), Command{TimeoutSec: 10, Content: "secret123 bob"}); ret.Error == nil {
+		t.Fatal("should have failed")
+	}
+	codegen.HOTPCounterFilePath = filepath.Join(dir, "counters")
+	// The counter starts from the initial counter of the account and increases with each code
+	if ret := codegen.Execute(context.Background(), Command{TimeoutSec: 10, Content: "secret123 bob"}); ret.Error != nil || ret.Output != "Example:bob: 287082 (counter 1)\n" {
+		t.Fatal(ret)
+	}
+	if ret := codegen.Execute(context.Background(), Command{TimeoutSec: 10, Content: "secret123 bob"}); ret.Error != nil || ret.Output != "Example:bob: 359152 (counter 2)\n" {
+		t.Fatal(ret)
+	}
+	// The counter is persisted
+	codegen = TwoFACodeGenerator{SecretFile: &AESEncryptedFile{FilePath: secretPath, Format: AESFormatLaitosGCM}, HOTPCounterFilePath: codegen.HOTPCounterFilePath}
+	if err := codegen.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	if ret := codegen.Execute(context.Background(), Command{TimeoutSec: 10, Content: "secret123 bob"}); ret.Error != nil || ret.Output != "Example:bob: 969429 (counter 3)\n" {
+		t.Fatal(ret)
+	}
+}
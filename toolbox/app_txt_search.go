@@ -1,15 +1,16 @@
 package toolbox
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"errors"
 	"fmt"
-	"io"
-	"os"
+	"path/filepath"
 	"regexp"
-	"strings"
+	"sync"
+	"time"
+
+	"github.com/HouzuoGuo/laitos/lalog"
 )
 
 var (
@@ -18,11 +19,30 @@ var (
 	ErrBadTextSearchParam = errors.New(`example: shortcut text_to_search`)
 )
 
-const TextSearchTrigger = ".g" // TextSearchTrigger the trigger prefix string of TextSearch feature.
+const (
+	TextSearchTrigger           = ".g" // TextSearchTrigger the trigger prefix string of TextSearch feature.
+	TextSearchDefaultMaxResults = 3    // TextSearchDefaultMaxResults is the default number of best matches in a search result.
+	// TextSearchRefreshIntervalSec is the minimum interval between checks of whether an index is outdated.
+	TextSearchRefreshIntervalSec = 10
+)
 
-// TextSearch locates a string among lines in a text file.
+/*
+TextSearch locates lines that match all words of a query among text files. The files are indexed in memory, and the
+index is automatically rebuilt in the background of a search when the files change.
+*/
 type TextSearch struct {
-	FilePaths map[string]string `json:"FilePaths"` // FilePaths contains shortcut name VS text file path
+	/*
+		FilePaths contains shortcut name VS location of text files. A location is a text file, a directory of text files
+		(including files in its sub-directories), or a glob pattern such as "/root/dict/*.txt".
+	*/
+	FilePaths    map[string]string `json:"FilePaths"`
+	MaxResults   int               `json:"MaxResults"`   // MaxResults is the number of best matches to show in a search result.
+	ContextLines int               `json:"ContextLines"` // ContextLines is the number of lines to show before and after each match.
+
+	indexes   map[string]*TextSearchIndex
+	checkedAt map[string]time.Time // checkedAt is the time of the latest check of whether the index is outdated.
+	mutex     *sync.Mutex
+	logger    lalog.Logger
 }
 
 func (txt *TextSearch) IsConfigured() bool {
@@ -33,17 +53,34 @@ func (txt *TextSearch) SelfTest() error {
 	if !txt.IsConfigured() {
 		return ErrIncompleteConfig
 	}
-	for _, filePath := range txt.FilePaths {
-		if _, err := os.Stat(filePath); err != nil {
-			return fmt.Errorf("TextSearch.SelfTest: file \"%s\" is no longer readable - %v", filePath, err)
+	for _, location := range txt.FilePaths {
+		if _, err := resolveTextSearchLocation(location); err != nil {
+			return fmt.Errorf("TextSearch.SelfTest: file \"%s\" is no longer readable - %v", location, err)
 		}
 	}
 	return nil
 }
 
 func (txt *TextSearch) Initialise() error {
-	// Run a round of self test to discover configuration error during initialisation
-	return txt.SelfTest()
+	if txt.MaxResults < 1 {
+		txt.MaxResults = TextSearchDefaultMaxResults
+	}
+	if txt.ContextLines < 0 {
+		txt.ContextLines = 0
+	}
+	txt.logger = lalog.Logger{ComponentName: "TextSearch"}
+	txt.mutex = new(sync.Mutex)
+	txt.indexes = make(map[string]*TextSearchIndex)
+	txt.checkedAt = make(map[string]time.Time)
+	for shortcutName, location := range txt.FilePaths {
+		index := &TextSearchIndex{Location: location}
+		if err := index.Build(); err != nil {
+			return fmt.Errorf("TextSearch.Initialise: failed to index \"%s\" - %v", shortcutName, err)
+		}
+		txt.indexes[shortcutName] = index
+		txt.checkedAt[shortcutName] = time.Now()
+	}
+	return nil
 }
 
 func (txt *TextSearch) Trigger() Trigger {
@@ -61,35 +98,57 @@ func (txt *TextSearch) Execute(ctx context.Context, cmd Command) (ret *Result) {
 		return &Result{Error: ErrBadTextSearchParam}
 	}
 	shortcutName := params[1]
-	searchString := strings.ToLower(params[2])
-	filePath, found := txt.FilePaths[shortcutName]
+	searchString := params[2]
+	txt.mutex.Lock()
+	index, found := txt.indexes[shortcutName]
+	checkIndex := time.Since(txt.checkedAt[shortcutName]) >= TextSearchRefreshIntervalSec*time.Second
+	if checkIndex {
+		// Only one search at a time gets to check the index
+		txt.checkedAt[shortcutName] = time.Now()
+	}
+	txt.mutex.Unlock()
 	if !found {
 		return &Result{Error: errors.New("cannot find " + shortcutName)}
 	}
-
-	// Open the text file to read its fresh data
-	file, err := os.Open(filePath)
-	if err != nil {
-		return &Result{Error: fmt.Errorf("failed to read text file %s - %v", filePath, err)}
+	// Searches continue to use the existing index while it is being checked and rebuilt, the index is immutable.
+	if checkIndex {
+		go txt.refreshIndex(shortcutName, index)
 	}
-	defer file.Close()
-
-	// Collect matching lines
-	var numMatch int
-	var match bytes.Buffer
-	reader := bufio.NewReader(file)
-	for {
-		line, err := reader.ReadString('\n')
-		if strings.Contains(strings.ToLower(line), searchString) {
-			match.WriteString(line)
-			numMatch++
+	numMatch, matches := index.Search(searchString, txt.MaxResults, txt.ContextLines)
+	// Output is number of matched lines followed by content of the best matches
+	var out bytes.Buffer
+	for i, match := range matches {
+		if i > 0 {
+			if out.Bytes()[out.Len()-1] != '\n' {
+				out.WriteRune('\n')
+			}
+			if txt.ContextLines > 0 {
+				out.WriteString("--\n")
+			}
+		}
+		// Tell the file name if there are several files to search from
+		if index.NumFiles() > 1 {
+			out.WriteString(fmt.Sprintf("%s:%d: ", filepath.Base(match.FilePath), match.Line))
 		}
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			return &Result{Error: fmt.Errorf("failed to read text file %s - %v", filePath, err)}
+		for _, line := range match.Text {
+			out.WriteString(line)
 		}
 	}
-	// Output is number of matched lines followed by their content
-	return &Result{Output: fmt.Sprintf("%d %s", numMatch, match.String())}
+	return &Result{Output: fmt.Sprintf("%d %s", numMatch, out.String())}
+}
+
+// refreshIndex rebuilds the index of the shortcut if its files have been added, removed, or modified.
+func (txt *TextSearch) refreshIndex(shortcutName string, index *TextSearchIndex) {
+	if !index.IsOutdated() {
+		return
+	}
+	newIndex := &TextSearchIndex{Location: index.Location}
+	if err := newIndex.Build(); err != nil {
+		// Keep using the existing index, and try again at the next check.
+		txt.logger.Warning("refreshIndex", shortcutName, err, "failed to rebuild index")
+		return
+	}
+	txt.mutex.Lock()
+	txt.indexes[shortcutName] = newIndex
+	txt.mutex.Unlock()
 }
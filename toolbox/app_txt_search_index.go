@@ -0,0 +1,353 @@
+package toolbox
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	// TextSearchExactMatchWeight is the score weight of a query word that is identical to a word in the text.
+	TextSearchExactMatchWeight = 3.0
+	// TextSearchPrefixMatchWeight is the score weight of a query word that begins a word in the text.
+	TextSearchPrefixMatchWeight = 2.0
+	// TextSearchSubstringMatchWeight is the score weight of a query word that appears in the middle of a word in the text.
+	TextSearchSubstringMatchWeight = 1.5
+	// TextSearchFuzzyMatchWeight is the score weight of a query word that is a slight misspelling of a word in the text.
+	TextSearchFuzzyMatchWeight = 1.0
+	// TextSearchMaxFileSize is the maximum size of an individual file to be indexed.
+	TextSearchMaxFileSize = 256 * 1048576
+)
+
+// textSearchFoldTable maps lower case letters with diacritics to their plain latin equivalent.
+var textSearchFoldTable = make(map[rune]string)
+
+func init() {
+	for plain, variants := range map[string]string{
+		"a": "àáâãäåāăąǎǻ", "c": "çćĉċč", "d": "ďđð", "e": "èéêëēĕėęě", "g": "ĝğġģ", "h": "ĥħ",
+		"i": "ìíîïĩīĭįıǐ", "j": "ĵ", "k": "ķ", "l": "ĺļľŀł", "n": "ñńņňŉ", "o": "òóôõöøōŏőǒǿ", "r": "ŕŗř",
+		"s": "śŝşšș", "t": "ţťŧț", "u": "ùúûüũūŭůűųǔǖǘǚǜ", "w": "ŵ", "y": "ýÿŷ", "z": "źżž",
+		"ae": "æǽ", "oe": "œ", "ss": "ß", "th": "þ",
+	} {
+		for _, variant := range variants {
+			textSearchFoldTable[variant] = plain
+		}
+	}
+}
+
+// FoldTextForSearch turns the text into lower case and removes diacritics from latin letters, e.g. "Élan" becomes "elan".
+func FoldTextForSearch(text string) string {
+	var ret strings.Builder
+	ret.Grow(len(text))
+	for _, r := range text {
+		r = unicode.ToLower(r)
+		if unicode.Is(unicode.Mn, r) {
+			// Discard combining marks such as the accent of a decomposed "é"
+			continue
+		}
+		if plain, found := textSearchFoldTable[r]; found {
+			ret.WriteString(plain)
+		} else {
+			ret.WriteRune(r)
+		}
+	}
+	return ret.String()
+}
+
+// TokeniseTextForSearch returns the folded words of the text, words consist of letters and numbers.
+func TokeniseTextForSearch(text string) []string {
+	return strings.FieldsFunc(FoldTextForSearch(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// textSearchMaxEdits returns the maximum number of typos tolerated in a query word of the length.
+func textSearchMaxEdits(word string) int {
+	switch length := len([]rune(word)); {
+	case length >= 9:
+		return 2
+	case length >= 5:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// editDistanceWithin returns true only if the Levenshtein distance between the two words does not exceed max.
+func editDistanceWithin(a, b string, max int) bool {
+	ra, rb := []rune(a), []rune(b)
+	if diff := len(ra) - len(rb); diff > max || -diff > max {
+		return false
+	}
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		rowMin := curr[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = prev[j-1] + cost
+			if del := prev[j] + 1; del < curr[j] {
+				curr[j] = del
+			}
+			if ins := curr[j-1] + 1; ins < curr[j] {
+				curr[j] = ins
+			}
+			if curr[j] < rowMin {
+				rowMin = curr[j]
+			}
+		}
+		if rowMin > max {
+			return false
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)] <= max
+}
+
+// textSearchLineRef identifies a line among the indexed files.
+type textSearchLineRef struct {
+	file int32
+	line int32
+}
+
+// textSearchSource is the identity of an indexed file, it tells whether the file has changed since indexing.
+type textSearchSource struct {
+	size    int64
+	modTime time.Time
+}
+
+// TextSearchMatch is a line that matches all words of a search query.
+type TextSearchMatch struct {
+	FilePath string   // FilePath is the path of the file that contains the line.
+	Line     int      // Line is the line number, starting from 1.
+	Score    float64  // Score is higher for better matches.
+	Text     []string // Text is the matching line surrounded by the requested number of context lines, each retains its line break.
+}
+
+// TextSearchIndex is an in-memory inverted index of words among the lines of text files.
+type TextSearchIndex struct {
+	Location string // Location is a file path, directory path, or glob pattern of files to index.
+
+	sources  map[string]textSearchSource
+	files    []string
+	lines    [][]string
+	postings map[string][]textSearchLineRef
+	words    []string // words are the sorted keys of postings, used in partial and fuzzy matching.
+	numLines int
+}
+
+// resolveTextSearchLocation returns the regular files found at the location - a file, a directory, or a glob pattern.
+func resolveTextSearchLocation(location string) (map[string]textSearchSource, error) {
+	ret := make(map[string]textSearchSource)
+	paths, err := filepath.Glob(location)
+	if err != nil {
+		return nil, fmt.Errorf("malformed glob pattern \"%s\" - %v", location, err)
+	}
+	for _, path := range paths {
+		// Files underneath directories are indexed too
+		_ = filepath.Walk(path, func(thisPath string, info os.FileInfo, err error) error {
+			if err == nil && info.Mode().IsRegular() {
+				ret[thisPath] = textSearchSource{size: info.Size(), modTime: info.ModTime()}
+			}
+			return nil
+		})
+	}
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("cannot find any file at \"%s\"", location)
+	}
+	return ret, nil
+}
+
+// IsOutdated returns true if the files at the location have been added, removed, or modified since the last indexing.
+func (idx *TextSearchIndex) IsOutdated() bool {
+	sources, err := resolveTextSearchLocation(idx.Location)
+	if err != nil || len(sources) != len(idx.sources) {
+		return true
+	}
+	for path, source := range sources {
+		if indexed, found := idx.sources[path]; !found || indexed.size != source.size || !indexed.modTime.Equal(source.modTime) {
+			return true
+		}
+	}
+	return false
+}
+
+// Build reads all files at the location and (re)builds the index of their words.
+func (idx *TextSearchIndex) Build() error {
+	sources, err := resolveTextSearchLocation(idx.Location)
+	if err != nil {
+		return err
+	}
+	files := make([]string, 0, len(sources))
+	for path := range sources {
+		files = append(files, path)
+	}
+	sort.Strings(files)
+	lines := make([][]string, len(files))
+	postings := make(map[string][]textSearchLineRef)
+	numLines := 0
+	for fileIndex, path := range files {
+		if sources[path].size > TextSearchMaxFileSize {
+			return fmt.Errorf("file \"%s\" is too large to be indexed", path)
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read text file %s - %v", path, err)
+		}
+		// Lines retain their line break so that search results reproduce the text faithfully
+		for _, line := range strings.SplitAfter(string(content), "\n") {
+			if line == "" {
+				continue
+			}
+			if strings.HasSuffix(line, "\r\n") {
+				line = strings.TrimSuffix(line, "\r\n") + "\n"
+			}
+			ref := textSearchLineRef{file: int32(fileIndex), line: int32(len(lines[fileIndex]))}
+			lines[fileIndex] = append(lines[fileIndex], line)
+			numLines++
+			seen := make(map[string]struct{})
+			for _, word := range TokeniseTextForSearch(line) {
+				if _, dup := seen[word]; dup {
+					continue
+				}
+				seen[word] = struct{}{}
+				postings[word] = append(postings[word], ref)
+			}
+		}
+	}
+	words := make([]string, 0, len(postings))
+	for word := range postings {
+		words = append(words, word)
+	}
+	sort.Strings(words)
+	idx.sources = sources
+	idx.files = files
+	idx.lines = lines
+	idx.postings = postings
+	idx.words = words
+	idx.numLines = numLines
+	return nil
+}
+
+/*
+scoreQueryWord returns the score of each line that contains the query word, either as an entire word, at the beginning
+of a word, in the middle of a word, or with a slight misspelling. An exact match scores higher than the others, and a
+rare query word scores higher than a common one.
+*/
+func (idx *TextSearchIndex) scoreQueryWord(queryWord string) map[textSearchLineRef]float64 {
+	ret := make(map[textSearchLineRef]float64)
+	maxEdits := textSearchMaxEdits(queryWord)
+	for _, word := range idx.words {
+		var weight float64
+		switch {
+		case word == queryWord:
+			weight = TextSearchExactMatchWeight
+		case strings.HasPrefix(word, queryWord):
+			weight = TextSearchPrefixMatchWeight
+		case strings.Contains(word, queryWord):
+			weight = TextSearchSubstringMatchWeight
+		case maxEdits > 0 && editDistanceWithin(queryWord, word, maxEdits):
+			weight = TextSearchFuzzyMatchWeight
+		default:
+			continue
+		}
+		for _, ref := range idx.postings[word] {
+			if weight > ret[ref] {
+				ret[ref] = weight
+			}
+		}
+	}
+	idf := math.Log(1 + float64(idx.numLines)/float64(len(ret)))
+	for ref, weight := range ret {
+		ret[ref] = weight * idf
+	}
+	return ret
+}
+
+/*
+Search finds the lines that match all words of the query, and returns the total number of matching lines and up to
+maxResults best matches, each of which comes with up to contextLines lines before and after the matching line.
+*/
+func (idx *TextSearchIndex) Search(query string, maxResults, contextLines int) (int, []TextSearchMatch) {
+	queryWords := TokeniseTextForSearch(query)
+	if len(queryWords) == 0 {
+		return 0, nil
+	}
+	var scores map[textSearchLineRef]float64
+	for _, queryWord := range queryWords {
+		wordScores := idx.scoreQueryWord(queryWord)
+		if scores == nil {
+			scores = wordScores
+			continue
+		}
+		// A line must match all query words
+		for ref, score := range scores {
+			if wordScore, found := wordScores[ref]; found {
+				scores[ref] = score + wordScore
+			} else {
+				delete(scores, ref)
+			}
+		}
+	}
+	// Lines that contain the query words next to each other are better matches
+	if len(queryWords) > 1 {
+		phrase := " " + strings.Join(queryWords, " ") + " "
+		for ref, score := range scores {
+			if strings.Contains(" "+strings.Join(TokeniseTextForSearch(idx.lines[ref.file][ref.line]), " ")+" ", phrase) {
+				scores[ref] = score * 1.5
+			}
+		}
+	}
+	refs := make([]textSearchLineRef, 0, len(scores))
+	for ref := range scores {
+		refs = append(refs, ref)
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		if scores[refs[i]] != scores[refs[j]] {
+			return scores[refs[i]] > scores[refs[j]]
+		}
+		if refs[i].file != refs[j].file {
+			return refs[i].file < refs[j].file
+		}
+		return refs[i].line < refs[j].line
+	})
+	if maxResults > 0 && len(refs) > maxResults {
+		refs = refs[:maxResults]
+	}
+	matches := make([]TextSearchMatch, 0, len(refs))
+	for _, ref := range refs {
+		fileLines := idx.lines[ref.file]
+		from, to := int(ref.line)-contextLines, int(ref.line)+contextLines+1
+		if from < 0 {
+			from = 0
+		}
+		if to > len(fileLines) {
+			to = len(fileLines)
+		}
+		matches = append(matches, TextSearchMatch{
+			FilePath: idx.files[ref.file],
+			Line:     int(ref.line) + 1,
+			Score:    scores[ref],
+			Text:     fileLines[from:to],
+		})
+	}
+	return len(scores), matches
+}
+
+// NumFiles returns the number of indexed files.
+func (idx *TextSearchIndex) NumFiles() int {
+	return len(idx.files)
+}
@@ -0,0 +1,37 @@
+package toolbox
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTokeniseTextForSearch(t *testing.T) {
+	if words := TokeniseTextForSearch("Crème Brûlée, smörgåsbord & STRAẞE - Łódź 123"); !reflect.DeepEqual(words, []string{"creme", "brulee", "smorgasbord", "strasse", "lodz", "123"}) {
+		t.Fatal(words)
+	}
+	// A decomposed "é" is an "e" followed by a combining accent
+	if folded := FoldTextForSearch("Café"); folded != "cafe" {
+		t.Fatal(folded)
+	}
+}
+
+func TestEditDistanceWithin(t *testing.T) {
+	for _, c := range []struct {
+		a, b     string
+		max      int
+		expected bool
+	}{
+		{"kitten", "kitten", 0, true},
+		{"kitten", "sitten", 1, true},
+		{"kitten", "sitting", 1, false},
+		{"kitten", "sitting", 2, false},
+		{"kitten", "sitting", 3, true},
+		{"surface", "surfase", 1, true},
+		{"abc", "abcdef", 2, false},
+		{"", "ab", 2, true},
+	} {
+		if result := editDistanceWithin(c.a, c.b, c.max); result != c.expected {
+			t.Fatal(c, result)
+		}
+	}
+}
@@ -4,8 +4,10 @@ import (
 	"context"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestTextSearch(t *testing.T) {
@@ -63,4 +65,68 @@ Where is the USB type C port`)
 	if ret := txt.Execute(context.Background(), Command{Content: "intro where"}); ret.Error != nil || ret.Output != "2 where The volume button is\nWhere is the USB type C port" {
 		t.Fatal(ret)
 	}
+	// Matching is insensitive to diacritics, all words of the query must match, and typos are tolerated
+	if ret := txt.Execute(context.Background(), Command{Content: "intro WHÉRE pōrt"}); ret.Error != nil || ret.Output != "1 Where is the USB type C port" {
+		t.Fatal(ret)
+	}
+	if ret := txt.Execute(context.Background(), Command{Content: "intro surfase"}); ret.Error != nil || ret.Output != "1 and How to get help With the New surface\n" {
+		t.Fatal(ret)
+	}
+	// A query word may appear in the middle of a word
+	if ret := txt.Execute(context.Background(), Command{Content: "intro olum"}); ret.Error != nil || ret.Output != "1 where The volume button is\n" {
+		t.Fatal(ret)
+	}
+	if ret := txt.Execute(context.Background(), Command{Content: "intro where nothing"}); ret.Error != nil || ret.Output != "0 " {
+		t.Fatal(ret)
+	}
+	// The index is rebuilt when the file changes
+	if err := ioutil.WriteFile(tmpTxt.Name(), []byte("a new surface\nthe hinge"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	txt.checkedAt["intro"] = time.Time{}
+	_ = txt.Execute(context.Background(), Command{Content: "intro hinge"})
+	for i := 0; ; i++ {
+		if ret := txt.Execute(context.Background(), Command{Content: "intro hinge"}); ret.Error == nil && ret.Output == "1 the hinge" {
+			break
+		} else if i > 100 {
+			t.Fatal(ret)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func TestTextSearch_DirectoryRankAndContext(t *testing.T) {
+	dir, err := ioutil.TempDir("", "laitos-TestTextSearch_DirectoryRankAndContext")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(filepath.Join(dir, "sub"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.txt"), []byte("heading\nred apple pie\nfooter"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "sub", "b.txt"), []byte("apple\npie with red apples\nred pie and an apple"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	txt := TextSearch{FilePaths: map[string]string{"dir": dir, "glob": filepath.Join(dir, "*.txt")}, MaxResults: 2, ContextLines: 1}
+	if err := txt.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	if err := txt.SelfTest(); err != nil {
+		t.Fatal(err)
+	}
+	// The exact phrase ranks first, followed by exact words, and then word prefix.
+	if ret := txt.Execute(context.Background(), Command{Content: "dir red apple"}); ret.Error != nil || ret.Output != "3 a.txt:2: heading\nred apple pie\nfooter\n--\nb.txt:3: pie with red apples\nred pie and an apple" {
+		t.Fatal(ret)
+	}
+	// Glob pattern does not include the sub-directory, file name is omitted because there is only one file.
+	if ret := txt.Execute(context.Background(), Command{Content: "glob apple"}); ret.Error != nil || ret.Output != "1 heading\nred apple pie\nfooter" {
+		t.Fatal(ret)
+	}
+	txt = TextSearch{FilePaths: map[string]string{"nothing": filepath.Join(dir, "*.doc")}}
+	if err := txt.Initialise(); err == nil {
+		t.Fatal("should have failed")
+	}
 }
@@ -30,6 +30,19 @@ type Command struct {
 	TimeoutSec int
 	// Content is the app command input.
 	Content string
+
+	// scope is the set of permissions granted by the password PIN that authorised this command, it is assigned by
+	// PINAndShortcuts filter. A nil scope grants unrestricted access to all apps.
+	scope *PasswordScope
+	// resultKey is derived from the password PIN that authorised this command, it is assigned by PINAndShortcuts
+	// filter. It is nil if the command was authorised by a shortcut or macro alone.
+	resultKey *resultEncryptionKey
+	// authenticated is true if SignedCommand filter has verified the command signature, PINAndShortcuts filter then
+	// lets the command through.
+	authenticated bool
+	// signatureRequired is true if SignedCommand filter only accepts signed commands, PINAndShortcuts filter then
+	// refuses to authenticate the command by password or TOTP.
+	signatureRequired bool
 }
 
 // Modify command content to remove leading and trailing white spaces. Return error result if command becomes empty afterwards.
@@ -72,6 +85,19 @@ type Result struct {
 	Error          error   // Result error if there is any
 	Output         string  // Human readable normal output excluding error text
 	CombinedOutput string  // Human readable error text + normal output. This is set when calling SetCombinedText() function.
+
+	resultKey *resultEncryptionKey // resultKey is used by EncryptResult filter, it comes from the command.
+
+	// The following attributes describe how the command was processed, they are assigned by CommandProcessor and result
+	// filters, and are used to construct a ResultEnvelope.
+	trigger            string // trigger is the app trigger, or the triggers of a command chain, matched by the command.
+	durationMS         int64  // durationMS is the number of milliseconds spent on processing the command.
+	pltPosition        int    // pltPosition is the PLT position override, it is 0 if PLT was not used.
+	pltLength          int    // pltLength is the PLT length override, it is 0 if PLT was not used.
+	pltTimeoutSec      int    // pltTimeoutSec is the PLT timeout override, it is 0 if PLT was not used.
+	truncatedBeginning bool   // truncatedBeginning is true if LintText cut characters from the beginning of the combined output.
+	truncatedEnd       bool   // truncatedEnd is true if LintText cut characters from the end of the combined output.
+	encrypted          bool   // encrypted is true if EncryptResult has encrypted the combined output.
 }
 
 // Return error text or empty string if error is absent.
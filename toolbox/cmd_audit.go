@@ -0,0 +1,303 @@
+package toolbox
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HouzuoGuo/laitos/lalog"
+	"github.com/HouzuoGuo/laitos/misc"
+)
+
+const (
+	// AuditGenesisHash is the "previous hash" of the very first record in an audit journal.
+	AuditGenesisHash = "0000000000000000000000000000000000000000000000000000000000000000"
+	// AuditHeadFileSuffix is appended to the journal file path to make the path of the file that anchors the latest record.
+	AuditHeadFileSuffix = ".head"
+	// auditTailChunkSize is the number of bytes read at a time when reading records backward from the end of the journal.
+	auditTailChunkSize = 64 * 1024
+)
+
+// ErrAuditChainBroken is returned by audit journal verification when a record has been modified, removed, or reordered.
+var ErrAuditChainBroken = errors.New("audit journal hash chain is broken")
+
+// AuditRecord describes a single app command processed by a command processor.
+type AuditRecord struct {
+	Seq          uint64 `json:"Seq"`          // Seq is the sequence number of the record, beginning at 1.
+	TimeUnixNano int64  `json:"TimeUnixNano"` // TimeUnixNano is the time at which the command processing completed.
+	DaemonName   string `json:"DaemonName"`   // DaemonName is the name of daemon that received the command.
+	ClientTag    string `json:"ClientTag"`    // ClientTag identifies the origin of the command, such as an IP address or phone number.
+	Trigger      string `json:"Trigger"`      // Trigger is the app trigger matched by the command, it is empty if no app was triggered.
+	Input        string `json:"Input"`        // Input is the app command input, it never contains the password PIN.
+	OutputLength int    `json:"OutputLength"` // OutputLength is the length of combined result output prior to result filters.
+	Error        string `json:"Error"`        // Error is the command execution error text, it is empty if there was no error.
+	DurationMS   int64  `json:"DurationMS"`   // DurationMS is the number of milliseconds spent on processing the command.
+	PrevHash     string `json:"PrevHash"`     // PrevHash is the Hash of the previous record, or AuditGenesisHash for the first record.
+	Hash         string `json:"Hash"`         // Hash is the hex-encoded HMAC-SHA256 digest of all other attributes of this record.
+}
+
+// calculateHash returns the hex-encoded HMAC-SHA256 digest of all record attributes except the Hash itself.
+func (rec AuditRecord) calculateHash(key []byte) string {
+	rec.Hash = ""
+	serialised, err := json.Marshal(rec)
+	if err != nil {
+		// The record consists of only strings and integers, this should not happen.
+		panic(err)
+	}
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write(serialised)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// String returns a compact, human-readable description of the record.
+func (rec AuditRecord) String() string {
+	ret := fmt.Sprintf("#%d %s %s-%s %s \"%s\" out=%d %dms",
+		rec.Seq, time.Unix(0, rec.TimeUnixNano).UTC().Format(time.RFC3339), rec.DaemonName, rec.ClientTag, rec.Trigger, rec.Input, rec.OutputLength, rec.DurationMS)
+	if rec.Error != "" {
+		ret += " err=" + rec.Error
+	}
+	return ret
+}
+
+// auditHead is the content of the file that anchors the latest record of the journal.
+type auditHead struct {
+	Seq  uint64 `json:"Seq"`
+	Hash string `json:"Hash"`
+}
+
+/*
+AuditJournal is an append-only file on disk that keeps one record for each app command processed by command processors.
+The records are chained together by their HMAC, so that modification or removal of a record in the middle of the
+journal will be detected by verification. The latest record is also anchored in a separate file, so that removal of
+records from the end of the journal will be detected too.
+*/
+type AuditJournal struct {
+	FilePath string `json:"FilePath"` // FilePath is the location of journal file, it is created if it does not yet exist.
+	// Secret is the HMAC key of the record hash chain, it defaults to the password that decrypts program data (e.g. config file).
+	Secret string `json:"Secret"`
+
+	key      []byte
+	lastSeq  uint64
+	lastHash string
+	mutex    *sync.Mutex
+	logger   lalog.Logger
+}
+
+// IsConfigured returns true only if the journal file location is given.
+func (journal *AuditJournal) IsConfigured() bool {
+	return journal != nil && journal.FilePath != ""
+}
+
+/*
+Initialise verifies the hash chain of existing records (if any), and prepares the journal to append new records.
+A broken chain is logged as a warning, and new records will be chained onto the anchored latest record regardless,
+which means records removed from the end of the journal will keep the chain broken.
+*/
+func (journal *AuditJournal) Initialise() error {
+	journal.logger = lalog.Logger{ComponentName: "AuditJournal", ComponentID: []lalog.LoggerIDField{{Key: "Path", Value: journal.FilePath}}}
+	journal.mutex = new(sync.Mutex)
+	if journal.FilePath == "" {
+		return fmt.Errorf("AuditJournal.Initialise: %w", ErrIncompleteConfig)
+	}
+	journal.key = []byte(journal.Secret)
+	if journal.Secret == "" {
+		if misc.ProgramDataDecryptionPassword == "" {
+			return errors.New("AuditJournal.Initialise: Secret must not be empty unless the program data is encrypted")
+		}
+		journal.key = []byte(misc.ProgramDataDecryptionPassword)
+	}
+	numRecords, lastSeq, lastHash, verifyErr := journal.verify()
+	journal.lastSeq = lastSeq
+	journal.lastHash = lastHash
+	if verifyErr != nil && !os.IsNotExist(verifyErr) {
+		journal.logger.Warning("Initialise", "", verifyErr, "the journal failed verification")
+	}
+	// A journal that ends before the anchored latest record has lost records from its end
+	head, err := journal.readHead()
+	if err == nil {
+		if head.Seq > lastSeq || head.Seq == lastSeq && head.Hash != lastHash {
+			journal.logger.Warning("Initialise", "", ErrAuditChainBroken, "the journal ends at seq %d but the latest record was seq %d", lastSeq, head.Seq)
+			journal.lastSeq = head.Seq
+			journal.lastHash = head.Hash
+		}
+	} else if numRecords > 0 || !os.IsNotExist(err) {
+		journal.logger.Warning("Initialise", "", err, "failed to read the latest record anchor")
+	}
+	return nil
+}
+
+// readHead reads the anchor of the latest record from the head file.
+func (journal *AuditJournal) readHead() (head auditHead, err error) {
+	content, err := ioutil.ReadFile(journal.FilePath + AuditHeadFileSuffix)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(content, &head)
+	return
+}
+
+// writeHead anchors the latest record in the head file. Caller must hold the mutex.
+func (journal *AuditJournal) writeHead(seq uint64, hash string) error {
+	serialised, err := json.Marshal(auditHead{Seq: seq, Hash: hash})
+	if err != nil {
+		return err
+	}
+	tmpPath := journal.FilePath + AuditHeadFileSuffix + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, serialised, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, journal.FilePath+AuditHeadFileSuffix)
+}
+
+/*
+verify reads the journal file record by record and verifies their hash chain along the way, without keeping the records
+in memory. It returns the number of records as well as the sequence number and hash of the last record. If the chain is
+broken, the function still reads all records and returns an error describing the first broken link.
+*/
+func (journal *AuditJournal) verify() (numRecords int, lastSeq uint64, lastHash string, err error) {
+	lastHash = AuditGenesisHash
+	fh, err := os.Open(journal.FilePath)
+	if err != nil {
+		return
+	}
+	defer fh.Close()
+	scanner := bufio.NewScanner(fh)
+	scanner.Buffer(make([]byte, 64*1024), 4*MaxCmdLength)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var rec AuditRecord
+		if jsonErr := json.Unmarshal([]byte(line), &rec); jsonErr != nil {
+			if err == nil {
+				err = fmt.Errorf("%w: malformed record after seq %d - %v", ErrAuditChainBroken, lastSeq, jsonErr)
+			}
+			continue
+		}
+		if err == nil {
+			if rec.Seq != lastSeq+1 || rec.PrevHash != lastHash || !hmac.Equal([]byte(rec.Hash), []byte(rec.calculateHash(journal.key))) {
+				err = fmt.Errorf("%w: at seq %d", ErrAuditChainBroken, rec.Seq)
+			}
+		}
+		numRecords++
+		lastSeq = rec.Seq
+		lastHash = rec.Hash
+	}
+	if scanErr := scanner.Err(); scanErr != nil && err == nil {
+		err = scanErr
+	}
+	return
+}
+
+/*
+readTail reads up to N records backward from the end of the journal file without verifying them, the latest record
+comes first. Malformed records are skipped.
+*/
+func (journal *AuditJournal) readTail(n int) ([]AuditRecord, error) {
+	ret := make([]AuditRecord, 0)
+	fh, err := os.Open(journal.FilePath)
+	if err != nil {
+		return ret, err
+	}
+	defer fh.Close()
+	pos, err := fh.Seek(0, io.SeekEnd)
+	if err != nil {
+		return ret, err
+	}
+	// Read until the tail has at least N complete lines, the first line of the tail may be incomplete.
+	var tail []byte
+	for pos > 0 && bytes.Count(tail, []byte{'\n'}) <= n {
+		chunkSize := int64(auditTailChunkSize)
+		if chunkSize > pos {
+			chunkSize = pos
+		}
+		pos -= chunkSize
+		chunk := make([]byte, chunkSize)
+		if _, err := fh.ReadAt(chunk, pos); err != nil {
+			return ret, err
+		}
+		tail = append(chunk, tail...)
+	}
+	lines := strings.Split(string(tail), "\n")
+	if pos > 0 {
+		lines = lines[1:]
+	}
+	for i := len(lines) - 1; i >= 0 && len(ret) < n; i-- {
+		line := strings.TrimSpace(lines[i])
+		if line == "" {
+			continue
+		}
+		var rec AuditRecord
+		if err := json.Unmarshal([]byte(line), &rec); err == nil {
+			ret = append(ret, rec)
+		}
+	}
+	return ret, nil
+}
+
+// Append chains the record onto the latest record in the journal and writes it to the journal file.
+func (journal *AuditJournal) Append(rec AuditRecord) error {
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+	rec.Seq = journal.lastSeq + 1
+	rec.PrevHash = journal.lastHash
+	rec.Hash = rec.calculateHash(journal.key)
+	serialised, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	fh, err := os.OpenFile(journal.FilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := fh.Write(append(serialised, '\n')); err != nil {
+		_ = fh.Close()
+		return err
+	}
+	if err := fh.Close(); err != nil {
+		return err
+	}
+	journal.lastSeq = rec.Seq
+	journal.lastHash = rec.Hash
+	return journal.writeHead(rec.Seq, rec.Hash)
+}
+
+/*
+Verify reads the entire journal and validates its hash chain, as well as whether the journal still ends at the latest
+record. It returns the number of records read from the journal.
+*/
+func (journal *AuditJournal) Verify() (numRecords int, err error) {
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+	numRecords, lastSeq, lastHash, err := journal.verify()
+	if err != nil && !os.IsNotExist(err) {
+		return
+	}
+	if lastSeq != journal.lastSeq || lastHash != journal.lastHash {
+		return numRecords, fmt.Errorf("%w: the journal ends at seq %d but the latest record was seq %d", ErrAuditChainBroken, lastSeq, journal.lastSeq)
+	}
+	return numRecords, nil
+}
+
+// GetLatest returns up to N latest records from the journal, the latest record comes first. The records are not verified.
+func (journal *AuditJournal) GetLatest(n int) ([]AuditRecord, error) {
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+	records, err := journal.readTail(n)
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	return records, err
+}
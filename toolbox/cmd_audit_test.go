@@ -0,0 +1,187 @@
+package toolbox
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAuditJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "laitos-TestAuditJournal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	journal := &AuditJournal{}
+	if journal.IsConfigured() {
+		t.Fatal("should not be configured")
+	}
+	if err := journal.Initialise(); !errors.Is(err, ErrIncompleteConfig) {
+		t.Fatal(err)
+	}
+	journal.FilePath = filepath.Join(dir, "audit.log")
+	if err := journal.Initialise(); err == nil {
+		t.Fatal("should have failed without a secret")
+	}
+	journal.Secret = "secret"
+	if err := journal.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	// Empty journal
+	if n, err := journal.Verify(); n != 0 || err != nil {
+		t.Fatal(n, err)
+	}
+	if records, err := journal.GetLatest(10); len(records) != 0 || err != nil {
+		t.Fatal(records, err)
+	}
+	// Append several records
+	for _, input := range []string{"a", "b", "c"} {
+		if err := journal.Append(AuditRecord{DaemonName: "d", ClientTag: "c", Trigger: ".s", Input: input}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, err := journal.Verify(); n != 3 || err != nil {
+		t.Fatal(n, err)
+	}
+	records, err := journal.GetLatest(2)
+	if err != nil || len(records) != 2 || records[0].Input != "c" || records[0].Seq != 3 || records[1].Input != "b" || records[0].PrevHash != records[1].Hash {
+		t.Fatal(records, err)
+	}
+	// Re-initialise and continue chaining from the last record
+	if err := journal.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	if err := journal.Append(AuditRecord{Input: "d"}); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := journal.Verify(); n != 4 || err != nil {
+		t.Fatal(n, err)
+	}
+	// The hash chain cannot be verified without the secret
+	wrongSecret := &AuditJournal{FilePath: journal.FilePath, Secret: "another secret"}
+	if err := wrongSecret.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := wrongSecret.Verify(); n != 4 || !errors.Is(err, ErrAuditChainBroken) {
+		t.Fatal(n, err)
+	}
+	// Remove the latest record
+	content, err := ioutil.ReadFile(journal.FilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(string(content), "\n")
+	if err := ioutil.WriteFile(journal.FilePath, []byte(strings.Join(lines[:3], "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := journal.Verify(); n != 3 || !errors.Is(err, ErrAuditChainBroken) {
+		t.Fatal(n, err)
+	}
+	// The removal is detected after a restart, and the chain remains broken after appending more records.
+	if err := journal.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := journal.Verify(); n != 3 || !errors.Is(err, ErrAuditChainBroken) {
+		t.Fatal(n, err)
+	}
+	if err := journal.Append(AuditRecord{Input: "e"}); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := journal.Verify(); n != 4 || !errors.Is(err, ErrAuditChainBroken) {
+		t.Fatal(n, err)
+	}
+	if records, err := journal.GetLatest(1); len(records) != 1 || records[0].Seq != 5 || err != nil {
+		t.Fatal(records, err)
+	}
+	// Restore the journal along with its latest record anchor
+	if err := ioutil.WriteFile(journal.FilePath, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(journal.FilePath + AuditHeadFileSuffix); err != nil {
+		t.Fatal(err)
+	}
+	if err := journal.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := journal.Verify(); n != 4 || err != nil {
+		t.Fatal(n, err)
+	}
+	// Modify a record
+	if err := ioutil.WriteFile(journal.FilePath, []byte(strings.Replace(string(content), `"Input":"b"`, `"Input":"x"`, 1)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := journal.Verify(); n != 4 || !errors.Is(err, ErrAuditChainBroken) {
+		t.Fatal(n, err)
+	}
+	// Remove a record
+	if err := ioutil.WriteFile(journal.FilePath, []byte(strings.Join(append(lines[:1], lines[2:]...), "\n")), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := journal.Verify(); n != 3 || !errors.Is(err, ErrAuditChainBroken) {
+		t.Fatal(n, err)
+	}
+	// Records remain readable despite the broken chain
+	if records, err := journal.GetLatest(10); len(records) != 3 || err != nil {
+		t.Fatal(records, err)
+	}
+	// Read the latest records that span several chunks from the end of a large journal
+	large := &AuditJournal{FilePath: filepath.Join(dir, "large.log"), Secret: "secret"}
+	if err := large.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		if err := large.Append(AuditRecord{Input: strings.Repeat("a", 200)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	records, err = large.GetLatest(600)
+	if err != nil || len(records) != 600 {
+		t.Fatal(len(records), err)
+	}
+	for i, rec := range records {
+		if rec.Seq != uint64(1000-i) {
+			t.Fatalf("%+v", rec)
+		}
+	}
+}
+
+func TestCommandProcessor_AuditJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "laitos-TestCommandProcessor_AuditJournal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	journal := &AuditJournal{FilePath: filepath.Join(dir, "audit.log"), Secret: "secret"}
+	if err := journal.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	proc := GetTestCommandProcessor()
+	proc.AuditJournal = journal
+	proc.Features.EnvControl.AuditJournal = journal
+	proc.Process(context.Background(), Command{DaemonName: "d", ClientTag: "c", Content: "badpin .s echo hi", TimeoutSec: 10}, true)
+	proc.Process(context.Background(), Command{DaemonName: "d", ClientTag: "c", Content: TestCommandProcessorPIN + " .s echo hi", TimeoutSec: 10}, true)
+	records, err := journal.GetLatest(10)
+	if err != nil || len(records) != 2 {
+		t.Fatal(records, err)
+	}
+	// The password PIN must not appear in the records
+	if rec := records[0]; rec.Trigger != ".s" || rec.Input != ".s echo hi" || rec.Error != "" || rec.OutputLength != 3 || rec.DaemonName != "d" || rec.ClientTag != "c" {
+		t.Fatalf("%+v", rec)
+	}
+	// The record of a failed password attempt still identifies the client
+	if rec := records[1]; rec.Trigger != "" || rec.Input != "" || rec.Error != ErrPINAndShortcutNotFound.Error() || rec.DaemonName != "d" || rec.ClientTag != "c" {
+		t.Fatalf("%+v", rec)
+	}
+	// Read the records back via app command
+	result := proc.Features.EnvControl.Execute(context.Background(), Command{Content: "audit 1"})
+	if result.Error != nil || !strings.Contains(result.Output, "2 records, chain OK") || !strings.Contains(result.Output, "#2") || strings.Contains(result.Output, "#1") {
+		t.Fatal(result)
+	}
+	if result := proc.Features.EnvControl.Execute(context.Background(), Command{Content: "audit abc"}); result.Error != ErrBadEnvInfoChoice {
+		t.Fatal(result)
+	}
+}
@@ -0,0 +1,138 @@
+package toolbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	/*
+		PrefixCommandChain is the magic string to prefix command input, in order to run a chain of app commands. Without
+		the prefix, the chain separators are ordinary text that belongs to the app command, e.g. the body of an Email.
+	*/
+	PrefixCommandChain = ".chain"
+	// ChainSeparatorThen is the magic word that separates two app commands, the latter runs after the former succeeds.
+	ChainSeparatorThen = ".then"
+	/*
+		ChainSeparatorPipe is the magic word that separates two app commands, the latter runs after the former succeeds,
+		and the output of the former is appended to the content of the latter.
+	*/
+	ChainSeparatorPipe = ".pipe"
+	// MaxChainLength is the maximum number of app commands allowed in a single chain.
+	MaxChainLength = 8
+)
+
+// RegexChainSeparator matches a chain separator that is surrounded by white spaces.
+var RegexChainSeparator = regexp.MustCompile(`\s+(` + regexp.QuoteMeta(ChainSeparatorThen) + `|` + regexp.QuoteMeta(ChainSeparatorPipe) + `)\s+`)
+
+// ErrChainTooLong is a command execution error indicating that the command chain has too many app commands.
+var ErrChainTooLong = fmt.Errorf("a command chain may have up to %d app commands", MaxChainLength)
+
+/*
+ErrChainPipeIntoShell is a command execution error indicating that the command chain attempts to pipe output into a shell
+command. The output may come from an untrusted source, such as a news feed or an Email, and must not run as shell code.
+*/
+var ErrChainPipeIntoShell = errors.New("the output of an app command may not be piped into a shell command")
+
+// chainLink is an app command among a command chain.
+type chainLink struct {
+	content      string // content is the app command content, including app trigger prefix.
+	pipeFromPrev bool   // pipeFromPrev is true if the output of the previous app command shall be appended to the content.
+}
+
+/*
+splitCommandChain splits the command content (without PrefixCommandChain) into app commands separated by chain
+separators. If the content does not contain any separator, the returned slice will contain exactly one link - the
+content itself.
+*/
+func splitCommandChain(content string) []chainLink {
+	locations := RegexChainSeparator.FindAllStringSubmatchIndex(content, -1)
+	if len(locations) == 0 {
+		return []chainLink{{content: content}}
+	}
+	ret := make([]chainLink, 0, len(locations)+1)
+	linkBegin := 0
+	pipeFromPrev := false
+	for _, loc := range locations {
+		ret = append(ret, chainLink{content: strings.TrimSpace(content[linkBegin:loc[0]]), pipeFromPrev: pipeFromPrev})
+		pipeFromPrev = content[loc[2]:loc[3]] == ChainSeparatorPipe
+		linkBegin = loc[1]
+	}
+	ret = append(ret, chainLink{content: strings.TrimSpace(content[linkBegin:]), pipeFromPrev: pipeFromPrev})
+	return ret
+}
+
+/*
+runChain runs each app command from the chain one after another, and stops at the first app command that results in an
+error. An app command may receive the output of its predecessor via a pipe. The app commands share the command timeout.
+The combined result carries the output of app commands that did not pipe their output, as well as the first error.
+*/
+func (proc *CommandProcessor) runChain(ctx context.Context, cmd Command, chain []chainLink, isTimeoutOverridden, runResultFilters bool) (ret *Result, matchedTriggers string, logCommandContent string) {
+	if len(chain) > MaxChainLength {
+		return &Result{Error: ErrChainTooLong}, "", cmd.Content
+	}
+	// Refuse the entire chain before running any of its app commands
+	for _, link := range chain {
+		if link.pipeFromPrev && isShellCommand(link.content) {
+			return &Result{Error: ErrChainPipeIntoShell}, "", cmd.Content
+		}
+	}
+	triggers := make([]string, 0, len(chain))
+	logContents := make([]string, 0, len(chain))
+	outputs := make([]string, 0, len(chain))
+	deadline := time.Now().Add(time.Duration(cmd.TimeoutSec) * time.Second)
+	var chainErr error
+	var prevOutput string
+	for i, link := range chain {
+		linkCmd := cmd
+		linkCmd.Content = link.content
+		if link.pipeFromPrev {
+			linkCmd.Content += " " + strings.TrimSpace(prevOutput)
+			if len(linkCmd.Content) > MaxCmdLength {
+				chainErr = ErrCommandTooLong
+				break
+			}
+		}
+		// Each app command gets the remainder of the time budget, but always at least a second.
+		if linkCmd.TimeoutSec = int(time.Until(deadline).Seconds()); linkCmd.TimeoutSec < 1 {
+			linkCmd.TimeoutSec = 1
+		}
+		linkResult, trigger, linkLogContent := proc.runApp(ctx, linkCmd, isTimeoutOverridden, runResultFilters)
+		triggers = append(triggers, string(trigger))
+		if i > 0 {
+			if link.pipeFromPrev {
+				logContents = append(logContents, ChainSeparatorPipe)
+			} else {
+				logContents = append(logContents, ChainSeparatorThen)
+			}
+		}
+		// Piped output is not logged, unless the app already conceals the command content.
+		if link.pipeFromPrev && linkLogContent == linkCmd.Content {
+			linkLogContent = link.content
+		}
+		logContents = append(logContents, linkLogContent)
+		prevOutput = linkResult.Output
+		// The output piped into the next app command does not appear in the combined output
+		if i == len(chain)-1 || !chain[i+1].pipeFromPrev || linkResult.Error != nil {
+			if linkResult.Output != "" {
+				outputs = append(outputs, linkResult.Output)
+			}
+		}
+		if linkResult.Error != nil {
+			chainErr = linkResult.Error
+			break
+		}
+	}
+	return &Result{Error: chainErr, Output: strings.Join(outputs, "\n")}, strings.Join(triggers, ","), strings.Join(logContents, " ")
+}
+
+// isShellCommand returns true if the app command content (including the optional background job prefix) runs the shell app.
+func isShellCommand(content string) bool {
+	cmd := Command{Content: content}
+	cmd.FindAndRemovePrefix(PrefixCommandBackground)
+	return cmd.FindAndRemovePrefix(string((&Shell{}).Trigger()))
+}
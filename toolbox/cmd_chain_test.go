@@ -0,0 +1,116 @@
+package toolbox
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitCommandChain(t *testing.T) {
+	if chain := splitCommandChain(" .s echo abc.then "); !reflect.DeepEqual(chain, []chainLink{{content: " .s echo abc.then "}}) {
+		t.Fatal(chain)
+	}
+	chain := splitCommandChain(".s echo a .then .s echo b\n.pipe .m a@b.c \"subj\"   .then .e info")
+	expected := []chainLink{
+		{content: ".s echo a"},
+		{content: ".s echo b"},
+		{content: `.m a@b.c "subj"`, pipeFromPrev: true},
+		{content: ".e info"},
+	}
+	if !reflect.DeepEqual(chain, expected) {
+		t.Fatalf("%+v", chain)
+	}
+}
+
+func TestCommandProcessor_Chain(t *testing.T) {
+	proc := GetTestCommandProcessor()
+	// Run two app commands one after another
+	result := proc.Process(context.Background(), Command{Content: TestCommandProcessorPIN + " .chain .s echo a .then .s echo alpha", TimeoutSec: 10}, false)
+	if result.Error != nil || result.Output != "a\n\nbeta\n" || result.Command.Content != ".chain .s echo a .then .s echo beta" {
+		t.Fatalf("%+v", result)
+	}
+	// Pipe output of the first app command into the second
+	proc.Features.LookupByTrigger[".m"] = &echoFeature{trigger: ".m"}
+	result = proc.Process(context.Background(), Command{Content: TestCommandProcessorPIN + " .chain .s echo 123 .pipe .m abc", TimeoutSec: 10}, false)
+	if result.Error != nil || result.Output != "abc 123" || result.Command.Content != ".chain .s echo 123 .pipe .m abc" {
+		t.Fatalf("%+v", result)
+	}
+	// Output may not be piped into a shell command, the output may come from an untrusted source.
+	for _, content := range []string{".m $(false) .pipe .s echo", ".m a .pipe .bg .s echo"} {
+		result = proc.Process(context.Background(), Command{Content: TestCommandProcessorPIN + " .chain " + content, TimeoutSec: 10}, false)
+		if result.Error != ErrChainPipeIntoShell || result.Output != "" {
+			t.Fatalf("%+v", result)
+		}
+	}
+	// The piped command is subject to the maximum command length
+	result = proc.Process(context.Background(), Command{Content: TestCommandProcessorPIN + " .chain .s printf '%020000d' 0 .pipe .m a", TimeoutSec: 10}, false)
+	if result.Error != ErrCommandTooLong {
+		t.Fatalf("%+v", result)
+	}
+	// Stop at the first error
+	result = proc.Process(context.Background(), Command{Content: TestCommandProcessorPIN + " .chain .s echo a .then .s echo b; false .pipe .m c .then .s echo d", TimeoutSec: 10}, false)
+	if result.Error == nil || result.Output != "a\n\nb\n" {
+		t.Fatalf("%+v", result)
+	}
+	result = proc.Process(context.Background(), Command{Content: TestCommandProcessorPIN + " .chain .s echo a .then .doesnotexist", TimeoutSec: 10}, false)
+	if result.Error != ErrBadPrefix || result.Output != "a\n" {
+		t.Fatalf("%+v", result)
+	}
+	// The combined result goes through result filters
+	result = proc.Process(context.Background(), Command{Content: TestCommandProcessorPIN + " .chain .s echo a .then .s echo b", TimeoutSec: 10}, true)
+	if result.Error != nil || result.CombinedOutput != "a\n\nb" {
+		t.Fatalf("%+v", result)
+	}
+	// Each app command is subject to the password scope
+	proc.CommandFilters[0] = &PINAndShortcuts{
+		Passwords:      []string{TestCommandProcessorPIN},
+		PasswordScopes: map[string]PasswordScope{TestCommandProcessorPIN: {Triggers: []string{".e"}}},
+	}
+	result = proc.Process(context.Background(), Command{Content: TestCommandProcessorPIN + " .chain .e log .then .s echo a", TimeoutSec: 10}, false)
+	if result.Error != ErrCommandOutOfScope || result.Output == "" {
+		t.Fatalf("%+v", result)
+	}
+	// Chain length is limited
+	result = proc.Process(context.Background(), Command{Content: TestCommandProcessorPIN + " .chain" + strings.Repeat(" .e log .then", MaxChainLength) + " .e log", TimeoutSec: 10}, false)
+	if result.Error != ErrChainTooLong {
+		t.Fatalf("%+v", result)
+	}
+}
+
+// echoFeature is an app that responds with the command content it receives.
+type echoFeature struct {
+	trigger Trigger
+}
+
+func (echo *echoFeature) IsConfigured() bool { return true }
+func (echo *echoFeature) SelfTest() error    { return nil }
+func (echo *echoFeature) Initialise() error  { return nil }
+func (echo *echoFeature) Trigger() Trigger   { return echo.trigger }
+func (echo *echoFeature) Execute(_ context.Context, cmd Command) *Result {
+	if cmd.Content == "" {
+		return &Result{Error: errors.New("empty")}
+	}
+	return &Result{Output: cmd.Content}
+}
+
+func TestCommandProcessor_NoChainWithoutPrefix(t *testing.T) {
+	proc := GetTestCommandProcessor()
+	proc.Features.LookupByTrigger[".m"] = &echoFeature{trigger: ".m"}
+	proc.Features.LookupByTrigger[".t"] = &echoFeature{trigger: ".t"}
+	// Chain separators in free text belong to the app command, unless the command asks for a chain.
+	for _, content := range []string{
+		`.m me@example.com "plans" finish the report .then go home`,
+		".t post a photo .pipe .then a caption",
+		".s echo a .then .s echo b",
+	} {
+		result := proc.Process(context.Background(), Command{Content: TestCommandProcessorPIN + " " + content, TimeoutSec: 10}, false)
+		if result.Error != nil || result.Command.Content != content || strings.Contains(result.trigger, ",") {
+			t.Fatalf("%+v", result)
+		}
+		if content[:2] != ".s" && result.Output != strings.TrimSpace(content[2:]) {
+			t.Fatalf("%+v", result)
+		}
+	}
+}
@@ -4,6 +4,7 @@ package toolbox
 import (
 	"crypto/subtle"
 	"errors"
+	"fmt"
 	"strings"
 	"sync"
 
@@ -57,15 +58,92 @@ func canExecuteCommandUsingTOTP(commandContent, totp, password string) bool {
 	}
 }
 
+/*
+PasswordScope restricts a password PIN, as well as the TOTP derived from it, to invoke a subset of app triggers, and
+optionally only via a subset of daemons.
+*/
+type PasswordScope struct {
+	// Triggers is the list of app triggers (e.g. ".r", ".i") that the password may invoke.
+	Triggers []string `json:"Triggers"`
+	// Daemons is an optional list of daemon names (e.g. "dnsd", "smtpd") that may accept the password. Empty means all daemons.
+	Daemons []string `json:"Daemons"`
+	// ShellProfile is the optional name of the Shell app's execution profile that restricts the password's shell commands.
+	ShellProfile string `json:"ShellProfile"`
+}
+
+// check returns configuration errors found in the scope, or an empty slice if it looks OK.
+func (scope *PasswordScope) check() (errs []error) {
+	errs = make([]error, 0)
+	if len(scope.Triggers) == 0 {
+		errs = append(errs, errors.New(ErrBadProcessorConfig+"Each password scope must allow at least one app trigger"))
+	}
+	for _, trigger := range scope.Triggers {
+		if !strings.HasPrefix(trigger, ".") || len(trigger) < 2 {
+			errs = append(errs, fmt.Errorf(ErrBadProcessorConfig+"Password scope trigger \"%s\" must look like \".x\"", trigger))
+		}
+	}
+	for _, daemonName := range scope.Daemons {
+		if strings.TrimSpace(daemonName) == "" {
+			errs = append(errs, errors.New(ErrBadProcessorConfig+"Password scope daemon name must not be empty"))
+		}
+	}
+	return
+}
+
+// Allows returns true only if the scope permits the app trigger to be invoked via the daemon.
+func (scope *PasswordScope) Allows(trigger Trigger, daemonName string) bool {
+	if scope == nil {
+		return true
+	}
+	triggerAllowed := false
+	for _, allowedTrigger := range scope.Triggers {
+		if allowedTrigger == string(trigger) {
+			triggerAllowed = true
+			break
+		}
+	}
+	if !triggerAllowed {
+		return false
+	}
+	if len(scope.Daemons) == 0 {
+		return true
+	}
+	for _, allowedDaemon := range scope.Daemons {
+		if allowedDaemon == daemonName {
+			return true
+		}
+	}
+	return false
+}
+
 /*
 PINAndShortcuts looks for:
 - Any of the recognised password PIN found at the beginning of any of the input lines.
 - Any of the recognised shortcut strings that matches the entirety of any of the input lines.
+- Any of the recognised macro names that matches the first word of any of the input lines.
 The filter's Transform function will return an error if nothing is found.
+A macro name found after a password PIN is expanded as well.
 */
 type PINAndShortcuts struct {
 	Passwords []string          `json:"Passwords"`
 	Shortcuts map[string]string `json:"Shortcuts"`
+	/*
+		PasswordScopes optionally restricts individual passwords to a subset of apps and daemons. The map key is a
+		password that must also be present in Passwords. Passwords absent from the map may invoke all apps.
+	*/
+	PasswordScopes map[string]PasswordScope `json:"PasswordScopes"`
+	/*
+		Macros are shortcuts that take arguments. The map key is the macro name, and the value is a command template in
+		which $1 to $9 are replaced by the arguments following the name, and $* by all of the arguments. The template
+		may run several app commands by beginning with the chain prefix and joining them with chain separators.
+	*/
+	Macros map[string]string `json:"Macros"`
+	/*
+		MacroScopes lets individual macros be invoked without a password. The map key is a macro name, and the value
+		restricts the apps and daemons that the macro's app commands may use. A macro absent from the map may only be
+		invoked after a password, in which case the password's scope applies.
+	*/
+	MacroScopes map[string]PasswordScope `json:"MacroScopes"`
 }
 
 var ErrPINAndShortcutNotFound = errors.New("invalid password PIN or shortcut")
@@ -118,12 +196,43 @@ func getTOTP(password string) (ret map[string]bool) {
 	return
 }
 
+// checkPasswordScopes returns configuration errors found among password scopes, or an empty slice if they look OK.
+func (pin *PINAndShortcuts) checkPasswordScopes() (errs []error) {
+	errs = make([]error, 0)
+	for password, scope := range pin.PasswordScopes {
+		knownPassword := false
+		for _, candidate := range pin.Passwords {
+			if candidate == password {
+				knownPassword = true
+				break
+			}
+		}
+		if !knownPassword {
+			errs = append(errs, errors.New(ErrBadProcessorConfig+"Each password in PasswordScopes must also be defined in Passwords"))
+		}
+		errs = append(errs, scope.check()...)
+	}
+	return
+}
+
+// getScope returns the permission scope of the password, or nil if the password may invoke all apps.
+func (pin *PINAndShortcuts) getScope(password string) *PasswordScope {
+	if scope, exists := pin.PasswordScopes[password]; exists {
+		return &scope
+	}
+	return nil
+}
+
 func (pin *PINAndShortcuts) Transform(cmd Command) (Command, error) {
-	if len(pin.Passwords) == 0 && len(pin.Shortcuts) == 0 {
-		return Command{}, errors.New("PINAndShortcut must define security password(s), shortcut(s), or both.")
+	if len(pin.Passwords) == 0 && len(pin.Shortcuts) == 0 && len(pin.Macros) == 0 {
+		return Command{}, errors.New("PINAndShortcut must define security password(s), shortcut(s), macro(s), or any combination.")
+	}
+	// The command signed by a password has already been verified and transformed by SignedCommand filter
+	if cmd.authenticated {
+		return cmd, nil
 	}
 
-	// Among the input lines, look for a shortcut match, password PIN match, or TOTP code match, and leave command alone for further processing.
+	// Among the input lines, look for a shortcut match, macro match, password PIN match, or TOTP code match, and leave command alone for further processing.
 	for _, line := range cmd.Lines() {
 		line = strings.TrimSpace(line)
 		// Look for shortcut match
@@ -135,14 +244,31 @@ func (pin *PINAndShortcuts) Transform(cmd Command) (Command, error) {
 				return ret, nil
 			}
 		}
-		// Look for a password PIN match
+		// Look for a macro that may be invoked without a password
+		if name, expanded, found, err := pin.findAndExpandMacro(line); found {
+			if scope, exists := pin.MacroScopes[name]; exists {
+				ret := cmd
+				ret.Content = expanded
+				ret.scope = &scope
+				return ret, err
+			}
+		}
+		/*
+			Look for a password PIN match, unless SignedCommand filter only accepts signed commands. The error is the
+			same as an incorrect password, so that the response does not tell whether the password is correct.
+		*/
+		if cmd.signatureRequired {
+			continue
+		}
 		for _, password := range pin.Passwords {
 			// Calculate password-derived TOTP codes that can be used in place of password PIN
 			if len(line) > len(password) && subtle.ConstantTimeCompare([]byte(line[:len(password)]), []byte(password)) == 1 {
 				ret := cmd
 				// Remove matched password from the input, leave the app command in-place.
 				ret.Content = line[len(password):]
-				return ret, nil
+				ret.scope = pin.getScope(password)
+				ret.resultKey = newResultEncryptionKey(password)
+				return pin.expandMacroAfterPassword(ret)
 			}
 			// Look for a TOTP code match. The code is made of two TOTP numbers with six digits each.
 			if len(line) > 12 {
@@ -156,7 +282,9 @@ func (pin *PINAndShortcuts) Transform(cmd Command) (Command, error) {
 					ret := cmd
 					// Remove matched TOTP from the input, leave the toolbox command in-place.
 					ret.Content = line[12:]
-					return ret, nil
+					ret.scope = pin.getScope(password)
+					ret.resultKey = newResultEncryptionKey(password)
+					return pin.expandMacroAfterPassword(ret)
 				}
 			}
 		}
@@ -165,6 +293,15 @@ func (pin *PINAndShortcuts) Transform(cmd Command) (Command, error) {
 	return cmd, ErrPINAndShortcutNotFound
 }
 
+// expandMacroAfterPassword expands the macro named by the first word of an authenticated command, if there is one.
+func (pin *PINAndShortcuts) expandMacroAfterPassword(cmd Command) (Command, error) {
+	_, expanded, found, err := pin.findAndExpandMacro(cmd.Content)
+	if found {
+		cmd.Content = expanded
+	}
+	return cmd, err
+}
+
 // Translate character sequences to something different.
 type TranslateSequences struct {
 	Sequences [][]string `json:"Sequences"`
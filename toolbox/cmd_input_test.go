@@ -2,6 +2,7 @@ package toolbox
 
 import (
 	"fmt"
+	"reflect"
 	"testing"
 )
 
@@ -114,6 +115,65 @@ func TestPINAndShortcuts_Transform(t *testing.T) {
 	}
 }
 
+func TestPasswordScope_Allows(t *testing.T) {
+	var unrestricted *PasswordScope
+	if !unrestricted.Allows(".s", "dnsd") {
+		t.Fatal("nil scope should allow everything")
+	}
+	scope := &PasswordScope{Triggers: []string{".r", ".i"}}
+	if !scope.Allows(".r", "dnsd") || !scope.Allows(".i", "") || scope.Allows(".s", "dnsd") {
+		t.Fatal("wrong trigger restriction")
+	}
+	scope.Daemons = []string{"dnsd", "smtpd"}
+	if !scope.Allows(".r", "dnsd") || !scope.Allows(".i", "smtpd") || scope.Allows(".r", "httpd") || scope.Allows(".s", "smtpd") {
+		t.Fatal("wrong daemon restriction")
+	}
+}
+
+func TestPINAndShortcuts_PasswordScopes(t *testing.T) {
+	pin := PINAndShortcuts{
+		Passwords:      []string{"lowtrust", "hightrust"},
+		PasswordScopes: map[string]PasswordScope{"lowtrust": {Triggers: []string{".r"}}},
+		Shortcuts:      map[string]string{"abc": ".s echo abc"},
+	}
+	if errs := pin.checkPasswordScopes(); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	// Scoped password
+	out, err := pin.Transform(Command{Content: "lowtrust.r"})
+	if err != nil || out.Content != ".r" || out.scope == nil || !reflect.DeepEqual(out.scope.Triggers, []string{".r"}) {
+		t.Fatal(out, err)
+	}
+	// Scoped password used via TOTP
+	_, current1, _, err := GetTwoFACodes("lowtrust")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, current2, _, err := GetTwoFACodes("tsurtwol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, err := pin.Transform(Command{Content: current1 + current2 + ".r"}); err != nil || out.Content != ".r" || out.scope == nil {
+		t.Fatal(out, err)
+	}
+	// Unscoped password and shortcut
+	if out, err := pin.Transform(Command{Content: "hightrust.s"}); err != nil || out.Content != ".s" || out.scope != nil {
+		t.Fatal(out, err)
+	}
+	if out, err := pin.Transform(Command{Content: "abc"}); err != nil || out.Content != ".s echo abc" || out.scope != nil {
+		t.Fatal(out, err)
+	}
+	// Bad scope configuration
+	pin.PasswordScopes = map[string]PasswordScope{
+		"unknown":   {Triggers: []string{".r"}},
+		"lowtrust":  {Triggers: []string{"r"}, Daemons: []string{""}},
+		"hightrust": {},
+	}
+	if errs := pin.checkPasswordScopes(); len(errs) != 4 {
+		t.Fatal(errs)
+	}
+}
+
 func TestTranslateSequences_Transform(t *testing.T) {
 	tr := TranslateSequences{}
 	if out, err := tr.Transform(Command{Content: "abc"}); err != nil || out.Content != "abc" {
@@ -0,0 +1,246 @@
+package toolbox
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/HouzuoGuo/laitos/lalog"
+)
+
+const (
+	/*
+		PrefixCommandBackground is the magic string to prefix command input, in order to run the app command as a
+		background job. The command processor responds with the job ID right away, and the job output may be retrieved
+		later via JobControl app.
+	*/
+	PrefixCommandBackground = ".bg"
+	// BackgroundJobTimeoutSec is the default timeout of a background job, unless PLT magic overrides it.
+	BackgroundJobTimeoutSec = 30 * 60
+	// MaxBackgroundJobs is the maximum number of background jobs (running and completed) to keep track of.
+	MaxBackgroundJobs = 32
+	// MaxBackgroundJobPartialOutputBytes is the maximum number of bytes of partial output to keep for a running job.
+	MaxBackgroundJobPartialOutputBytes = 64 * 1024
+)
+
+/*
+ErrBackgroundJobWithoutPassword is a command execution error indicating that the app command did not come with a
+password, such as a shortcut. A job is only visible to the password that started it, hence it must have a password.
+*/
+var ErrBackgroundJobWithoutPassword = errors.New("only an app command that comes with a password may run as a background job")
+
+// ErrTooManyBackgroundJobs is a command execution error indicating that too many background jobs are still running.
+var ErrTooManyBackgroundJobs = fmt.Errorf("there are already %d background jobs running", MaxBackgroundJobs)
+
+// BackgroundJobs is the table of background jobs shared among all command processors, so that a job started via one daemon may be inspected via another.
+var BackgroundJobs = NewBackgroundJobTable(MaxBackgroundJobs)
+
+// contextKeyJobOutput is the context value key of the writer that collects partial output of a background job.
+type contextKeyJobOutput struct{}
+
+/*
+GetJobOutputWriter returns the writer that collects partial output of the background job executing an app command. Apps
+may write their progress into the writer. If the app command is not running as a background job, the function returns
+a writer that discards everything.
+*/
+func GetJobOutputWriter(ctx context.Context) io.Writer {
+	if writer, ok := ctx.Value(contextKeyJobOutput{}).(io.Writer); ok {
+		return writer
+	}
+	return ioutil.Discard
+}
+
+// BackgroundJob is an app command that runs asynchronously, its result is kept for later retrieval.
+type BackgroundJob struct {
+	ID         string    // ID is a short string of digits that identifies the job.
+	Command    Command   // Command is the app command (without password PIN) that is being executed by the job.
+	Trigger    Trigger   // Trigger is the app trigger that the command has matched.
+	StartTime  time.Time // StartTime is the time at which the job started running.
+	EndTime    time.Time // EndTime is the time at which the job finished, it is zero if the job is still running.
+	Cancelled  bool      // Cancelled is true if the job has been requested to cancel.
+	owner      string    // owner identifies the password that started the job, only the same password may inspect the job.
+	result     *Result
+	partialOut *lalog.ByteLogWriter
+	cancel     context.CancelFunc
+}
+
+// IsRunning returns true only if the job has not yet finished.
+func (job *BackgroundJob) IsRunning() bool {
+	return job.result == nil
+}
+
+// Status returns a single line of text that describes the job ID, state, app trigger, and elapsed time.
+func (job *BackgroundJob) Status() string {
+	state := "done"
+	elapsed := job.EndTime.Sub(job.StartTime)
+	if job.IsRunning() {
+		state = "running"
+		elapsed = time.Since(job.StartTime)
+	} else if job.result.Error != nil {
+		state = "failed"
+	}
+	if job.Cancelled {
+		state += "(cancelled)"
+	}
+	return fmt.Sprintf("%s %s %s %ds", job.ID, state, job.Trigger, int(elapsed.Seconds()))
+}
+
+// BackgroundJobTable keeps track of a bounded number of recent background jobs.
+type BackgroundJobTable struct {
+	maxJobs int
+	jobs    map[string]*BackgroundJob
+	mutex   *sync.Mutex
+	logger  lalog.Logger
+}
+
+// NewBackgroundJobTable returns an initialised background job table that keeps up to maxJobs of recent jobs.
+func NewBackgroundJobTable(maxJobs int) *BackgroundJobTable {
+	if maxJobs < 1 {
+		panic("NewBackgroundJobTable: maxJobs must be greater than 0")
+	}
+	return &BackgroundJobTable{
+		maxJobs: maxJobs,
+		jobs:    make(map[string]*BackgroundJob),
+		mutex:   new(sync.Mutex),
+		logger:  lalog.Logger{ComponentName: "BackgroundJobTable"},
+	}
+}
+
+/*
+Start runs the app command asynchronously and returns the new job. If the table is full, the oldest completed job will
+be evicted to make room; if all jobs are still running, the function returns ErrTooManyBackgroundJobs.
+*/
+func (table *BackgroundJobTable) Start(feature Feature, trigger Trigger, cmd Command, logCommandContent string) (*BackgroundJob, error) {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+	if len(table.jobs) >= table.maxJobs {
+		var oldest *BackgroundJob
+		for _, job := range table.jobs {
+			if !job.IsRunning() && (oldest == nil || job.StartTime.Before(oldest.StartTime)) {
+				oldest = job
+			}
+		}
+		if oldest == nil {
+			return nil, ErrTooManyBackgroundJobs
+		}
+		delete(table.jobs, oldest.ID)
+	}
+	// Generate a short ID made of digits, they are easy to enter on a phone keypad.
+	var id string
+	for {
+		randNum, err := rand.Int(rand.Reader, big.NewInt(10000))
+		if err != nil {
+			return nil, err
+		}
+		if id = fmt.Sprintf("%04d", randNum.Int64()); table.jobs[id] == nil {
+			break
+		}
+	}
+	logCommand := cmd
+	logCommand.Content = logCommandContent
+	job := &BackgroundJob{
+		ID:         id,
+		Command:    logCommand,
+		Trigger:    trigger,
+		StartTime:  time.Now(),
+		owner:      cmd.resultKey.principal(),
+		partialOut: lalog.NewByteLogWriter(ioutil.Discard, MaxBackgroundJobPartialOutputBytes),
+	}
+	/*
+		The job must not be tied to the context of the daemon that started it, because the daemon's context usually ends
+		as soon as the job ID has been sent back to the user.
+	*/
+	var ctx context.Context
+	ctx, job.cancel = context.WithCancel(context.Background())
+	ctx = context.WithValue(ctx, contextKeyJobOutput{}, job.partialOut)
+	table.jobs[id] = job
+	go func() {
+		table.logger.Info("Start", id, nil, "running \"%s\" from %s-%s", logCommandContent, cmd.DaemonName, cmd.ClientTag)
+		result := feature.Execute(ctx, cmd)
+		table.mutex.Lock()
+		job.result = result
+		job.EndTime = time.Now()
+		table.mutex.Unlock()
+		job.cancel()
+		table.logger.Info("Start", id, result.Error, "completed \"%s\" in %d seconds", logCommandContent, int(job.EndTime.Sub(job.StartTime).Seconds()))
+	}()
+	return job, nil
+}
+
+/*
+getJob returns the job of the ID started by the owner. A job started by another owner is treated as non-existent. The
+caller must hold the table mutex.
+*/
+func (table *BackgroundJobTable) getJob(id, owner string) (*BackgroundJob, error) {
+	job, exists := table.jobs[id]
+	if !exists || job.owner != owner {
+		return nil, fmt.Errorf("job %s does not exist", id)
+	}
+	return job, nil
+}
+
+/*
+GetResult returns the execution result of a job started by the owner. If the job is still running, the returned result
+carries the partial output of the job and an error saying that the job is still running.
+*/
+func (table *BackgroundJobTable) GetResult(id, owner string) (*Result, error) {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+	job, err := table.getJob(id, owner)
+	if err != nil {
+		return nil, err
+	}
+	if job.IsRunning() {
+		return &Result{
+			Error:  fmt.Errorf("job %s is still running after %d seconds", id, int(time.Since(job.StartTime).Seconds())),
+			Output: string(job.partialOut.Retrieve(false)),
+		}, nil
+	}
+	return &Result{Error: job.result.Error, Output: job.result.Output}, nil
+}
+
+/*
+Cancel asks a running job started by the owner to stop by cancelling its context. It is up to the app to honour the
+cancellation.
+*/
+func (table *BackgroundJobTable) Cancel(id, owner string) error {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+	job, err := table.getJob(id, owner)
+	if err != nil {
+		return err
+	}
+	if !job.IsRunning() {
+		return errors.New("the job has already completed")
+	}
+	job.Cancelled = true
+	job.cancel()
+	return nil
+}
+
+// GetStatus returns one line of status text for each of the jobs started by the owner, the most recent job comes first.
+func (table *BackgroundJobTable) GetStatus(owner string) []string {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+	jobs := make([]*BackgroundJob, 0, len(table.jobs))
+	for _, job := range table.jobs {
+		if job.owner == owner {
+			jobs = append(jobs, job)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].StartTime.After(jobs[j].StartTime)
+	})
+	ret := make([]string, 0, len(jobs))
+	for _, job := range jobs {
+		ret = append(ret, job.Status())
+	}
+	return ret
+}
@@ -0,0 +1,285 @@
+package toolbox
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HouzuoGuo/laitos/lalog"
+)
+
+const (
+	// DefaultLockoutMaxFailures is the default number of consecutive authentication failures that lock out a client.
+	DefaultLockoutMaxFailures = 5
+	// DefaultLockoutBaseSec is the default duration of the first lockout, each subsequent lockout lasts twice as long.
+	DefaultLockoutBaseSec = 60
+	// DefaultLockoutMaxSec is the default upper limit of lockout duration.
+	DefaultLockoutMaxSec = 24 * 3600
+	// LockoutAttemptsDuringLockout is the number of attempts a locked-out client may make, evenly spread over the lockout.
+	LockoutAttemptsDuringLockout = 2
+	// LockoutNetworkPrefixFailuresMultiplier is the default multiplier of MaxFailures that locks out an entire network prefix.
+	LockoutNetworkPrefixFailuresMultiplier = 4
+	// MaxLockoutLedgerEntries is the maximum number of clients and network prefixes tracked by a lockout ledger.
+	MaxLockoutLedgerEntries = 10000
+)
+
+// ErrClientLockedOut is a command execution error indicating that the client has failed to authenticate too many times.
+var ErrClientLockedOut = errors.New("too many failed attempts, try again later")
+
+// isAuthFailure returns true if the command filter error indicates that the command failed to authenticate.
+func isAuthFailure(err error) bool {
+	return err == ErrPINAndShortcutNotFound || err == ErrSignedCommandReplayed
+}
+
+// lockoutEntry keeps track of the authentication failures of a client or network prefix.
+type lockoutEntry struct {
+	failures    int           // failures is the number of failures since the last lockout.
+	level       int           // level is the number of times the entry has been locked out.
+	lastFailure time.Time     // lastFailure is the time of the latest failure.
+	lockedUntil time.Time     // lockedUntil is the time at which the latest lockout ends.
+	duration    time.Duration // duration is the length of the latest lockout.
+	lastAttempt time.Time     // lastAttempt is the time of the latest attempt allowed during lockout.
+}
+
+// LockoutStatus describes the authentication failures of a client or network prefix tracked by a lockout ledger.
+type LockoutStatus struct {
+	Key         string    // Key is the client tag or network prefix (e.g. 192.0.2.0/24).
+	Failures    int       // Failures is the number of failures since the last lockout.
+	Level       int       // Level is the number of times the client or network prefix has been locked out.
+	LockedUntil time.Time // LockedUntil is the time at which the lockout ends, it is in the past if the lockout has ended.
+}
+
+// String returns a compact, human-readable description of the status.
+func (status LockoutStatus) String() string {
+	if remaining := time.Until(status.LockedUntil); remaining > 0 {
+		return fmt.Sprintf("%s locked for %s (level %d)", status.Key, remaining.Round(time.Second), status.Level)
+	}
+	return fmt.Sprintf("%s %d failures (level %d)", status.Key, status.Failures, status.Level)
+}
+
+/*
+LockoutLedger keeps track of authentication failures of app commands by the client tag (e.g. IP address or phone
+number), and optionally by the client's IPv4 /24 or IPv6 /64 network prefix, regardless of the daemon that received the
+command. After a number of consecutive failures the client is locked out for a while, and each subsequent lockout lasts
+twice as long. This stops slow password guessing that stays under the rate limit of daemons and command processor.
+During a lockout the client may still make LockoutAttemptsDuringLockout attempts evenly spread over the lockout, so that
+the owner who shares the IP address or network with a password guesser gets a chance to enter the correct password,
+which lifts the client's lockout. The allowance shrinks as the lockouts grow longer.
+*/
+type LockoutLedger struct {
+	Enabled bool `json:"Enabled"`
+	// MaxFailures is the number of consecutive authentication failures that lock out a client.
+	MaxFailures int `json:"MaxFailures"`
+	// BaseLockoutSec is the duration of the first lockout.
+	BaseLockoutSec int `json:"BaseLockoutSec"`
+	// MaxLockoutSec is the upper limit of lockout duration. A client is forgotten after not failing for this long.
+	MaxLockoutSec int `json:"MaxLockoutSec"`
+	// LockNetworkPrefix locks out the entire IPv4 /24 or IPv6 /64 network prefix of failing clients.
+	LockNetworkPrefix bool `json:"LockNetworkPrefix"`
+	// NetworkPrefixMaxFailures is the number of consecutive authentication failures that lock out a network prefix.
+	NetworkPrefixMaxFailures int `json:"NetworkPrefixMaxFailures"`
+
+	entries map[string]*lockoutEntry
+	mutex   *sync.Mutex
+	logger  lalog.Logger
+}
+
+// IsConfigured returns true only if the ledger is enabled.
+func (ledger *LockoutLedger) IsConfigured() bool {
+	return ledger != nil && ledger.Enabled
+}
+
+// Initialise sets default values for unspecified parameters and prepares the ledger for tracking failures.
+func (ledger *LockoutLedger) Initialise() error {
+	ledger.logger = lalog.Logger{ComponentName: "LockoutLedger"}
+	ledger.mutex = new(sync.Mutex)
+	ledger.entries = make(map[string]*lockoutEntry)
+	if ledger.MaxFailures < 1 {
+		ledger.MaxFailures = DefaultLockoutMaxFailures
+	}
+	if ledger.BaseLockoutSec < 1 {
+		ledger.BaseLockoutSec = DefaultLockoutBaseSec
+	}
+	if ledger.MaxLockoutSec < 1 {
+		ledger.MaxLockoutSec = DefaultLockoutMaxSec
+	}
+	if ledger.NetworkPrefixMaxFailures < 1 {
+		ledger.NetworkPrefixMaxFailures = ledger.MaxFailures * LockoutNetworkPrefixFailuresMultiplier
+	}
+	if ledger.MaxLockoutSec < ledger.BaseLockoutSec {
+		return fmt.Errorf("LockoutLedger.Initialise: MaxLockoutSec must not be less than BaseLockoutSec")
+	}
+	return nil
+}
+
+// getNetworkPrefix returns the IPv4 /24 or IPv6 /64 network prefix of the client, or an empty string if the client tag is not an IP address.
+func getNetworkPrefix(clientTag string) string {
+	host := clientTag
+	if h, _, err := net.SplitHostPort(clientTag); err == nil {
+		host = h
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return ""
+	}
+	if ipv4 := ip.To4(); ipv4 != nil {
+		return (&net.IPNet{IP: ipv4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+	return (&net.IPNet{IP: ip.Mask(net.CIDRMask(64, 128)), Mask: net.CIDRMask(64, 128)}).String()
+}
+
+// getKeys returns the ledger keys under which the client's failures are tracked, the first of which is the client's own.
+func (ledger *LockoutLedger) getKeys(clientTag string) []string {
+	keys := []string{clientTag}
+	if ledger.LockNetworkPrefix {
+		if prefix := getNetworkPrefix(clientTag); prefix != "" {
+			keys = append(keys, prefix)
+		}
+	}
+	return keys
+}
+
+// isForgettable returns true if the entry is not locked out and has not failed for a long time.
+func (ledger *LockoutLedger) isForgettable(entry *lockoutEntry, now time.Time) bool {
+	return now.After(entry.lockedUntil) && now.Sub(entry.lastFailure) > time.Duration(ledger.MaxLockoutSec)*time.Second
+}
+
+// IsLockedOut returns true if the client or its network prefix is currently locked out.
+func (ledger *LockoutLedger) IsLockedOut(clientTag string) bool {
+	ledger.mutex.Lock()
+	defer ledger.mutex.Unlock()
+	now := time.Now()
+	for _, key := range ledger.getKeys(clientTag) {
+		if entry, exists := ledger.entries[key]; exists && now.Before(entry.lockedUntil) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+AllowAttempt returns true if the client may attempt to authenticate. A client that is not locked out may always attempt,
+and a locked-out client may attempt LockoutAttemptsDuringLockout times evenly spread over the lockout, e.g. once every
+30 minutes during a lockout of an hour.
+*/
+func (ledger *LockoutLedger) AllowAttempt(clientTag string) bool {
+	ledger.mutex.Lock()
+	defer ledger.mutex.Unlock()
+	now := time.Now()
+	var lockedEntries []*lockoutEntry
+	for _, key := range ledger.getKeys(clientTag) {
+		if entry, exists := ledger.entries[key]; exists && now.Before(entry.lockedUntil) {
+			if now.Sub(entry.lastAttempt) < entry.duration/LockoutAttemptsDuringLockout {
+				return false
+			}
+			lockedEntries = append(lockedEntries, entry)
+		}
+	}
+	for _, entry := range lockedEntries {
+		entry.lastAttempt = now
+	}
+	return true
+}
+
+/*
+RecordFailure records an authentication failure of the client and its network prefix. It returns the human-readable
+descriptions of lockouts that begin due to this failure.
+*/
+func (ledger *LockoutLedger) RecordFailure(clientTag string) (newLockouts []string) {
+	ledger.mutex.Lock()
+	defer ledger.mutex.Unlock()
+	now := time.Now()
+	if len(ledger.entries) >= MaxLockoutLedgerEntries {
+		for key, entry := range ledger.entries {
+			if ledger.isForgettable(entry, now) {
+				delete(ledger.entries, key)
+			}
+		}
+	}
+	for i, key := range ledger.getKeys(clientTag) {
+		maxFailures := ledger.MaxFailures
+		if i > 0 {
+			maxFailures = ledger.NetworkPrefixMaxFailures
+		}
+		entry, exists := ledger.entries[key]
+		if exists && ledger.isForgettable(entry, now) {
+			// Start afresh after a long period without failure
+			*entry = lockoutEntry{}
+		} else if !exists {
+			if len(ledger.entries) >= MaxLockoutLedgerEntries {
+				ledger.logger.Warning("RecordFailure", key, nil, "ledger is full, the failure is not recorded")
+				continue
+			}
+			entry = &lockoutEntry{}
+			ledger.entries[key] = entry
+		}
+		entry.failures++
+		entry.lastFailure = now
+		if entry.failures >= maxFailures {
+			// Each lockout lasts twice as long as the previous one
+			maxDuration := time.Duration(ledger.MaxLockoutSec) * time.Second
+			duration := time.Duration(ledger.BaseLockoutSec) * time.Second
+			for lvl := 0; lvl < entry.level && duration < maxDuration; lvl++ {
+				duration *= 2
+			}
+			if duration > maxDuration {
+				duration = maxDuration
+			}
+			entry.level++
+			entry.failures = 0
+			entry.lockedUntil = now.Add(duration)
+			entry.duration = duration
+			entry.lastAttempt = now
+			desc := fmt.Sprintf("%s is locked out for %s after %d consecutive failures (level %d)", key, duration, maxFailures, entry.level)
+			ledger.logger.Warning("RecordFailure", key, nil, desc)
+			newLockouts = append(newLockouts, desc)
+		}
+	}
+	return
+}
+
+// RecordSuccess forgets the failures and lifts the lockout of the client after it has successfully authenticated. Its network prefix is not affected.
+func (ledger *LockoutLedger) RecordSuccess(clientTag string) {
+	ledger.mutex.Lock()
+	defer ledger.mutex.Unlock()
+	delete(ledger.entries, clientTag)
+}
+
+// GetStatus returns the status of all clients and network prefixes that have recently failed to authenticate, sorted by key.
+func (ledger *LockoutLedger) GetStatus() []LockoutStatus {
+	ledger.mutex.Lock()
+	defer ledger.mutex.Unlock()
+	now := time.Now()
+	ret := make([]LockoutStatus, 0, len(ledger.entries))
+	for key, entry := range ledger.entries {
+		if ledger.isForgettable(entry, now) {
+			continue
+		}
+		ret = append(ret, LockoutStatus{Key: key, Failures: entry.failures, Level: entry.level, LockedUntil: entry.lockedUntil})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Key < ret[j].Key
+	})
+	return ret
+}
+
+// Clear forgets the failures and lockouts of the client or network prefix. An empty key clears all of them. It returns the number of entries cleared.
+func (ledger *LockoutLedger) Clear(key string) int {
+	ledger.mutex.Lock()
+	defer ledger.mutex.Unlock()
+	key = strings.TrimSpace(key)
+	if key == "" {
+		num := len(ledger.entries)
+		ledger.entries = make(map[string]*lockoutEntry)
+		return num
+	}
+	if _, exists := ledger.entries[key]; exists {
+		delete(ledger.entries, key)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,180 @@
+package toolbox
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetNetworkPrefix(t *testing.T) {
+	tests := map[string]string{
+		"192.0.2.123":              "192.0.2.0/24",
+		"192.0.2.123:5678":         "192.0.2.0/24",
+		"2001:db8:1:2:3:4:5:6":     "2001:db8:1:2::/64",
+		"[2001:db8:1:2::5]:80":     "2001:db8:1:2::/64",
+		"+123456789":               "",
+		"someone@example.com":      "",
+		"":                         "",
+		"not an ip address at all": "",
+	}
+	for tag, expected := range tests {
+		if prefix := getNetworkPrefix(tag); prefix != expected {
+			t.Fatal(tag, prefix)
+		}
+	}
+}
+
+func TestLockoutLedger(t *testing.T) {
+	ledger := &LockoutLedger{Enabled: true, MaxFailures: 2, BaseLockoutSec: 1, MaxLockoutSec: 3}
+	if err := ledger.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	if ledger.NetworkPrefixMaxFailures != 2*LockoutNetworkPrefixFailuresMultiplier {
+		t.Fatal(ledger.NetworkPrefixMaxFailures)
+	}
+	if lockouts := ledger.RecordFailure("a"); len(lockouts) != 0 || ledger.IsLockedOut("a") {
+		t.Fatal(lockouts)
+	}
+	// The second failure locks out the client for a second
+	if lockouts := ledger.RecordFailure("a"); len(lockouts) != 1 || !ledger.IsLockedOut("a") || ledger.IsLockedOut("b") {
+		t.Fatal(lockouts)
+	}
+	if ledger.AllowAttempt("a") || !ledger.AllowAttempt("b") {
+		t.Fatal("wrong lockout")
+	}
+	time.Sleep(1100 * time.Millisecond)
+	if ledger.IsLockedOut("a") {
+		t.Fatal("lockout did not expire")
+	}
+	// The next lockout lasts twice as long
+	ledger.RecordFailure("a")
+	if lockouts := ledger.RecordFailure("a"); len(lockouts) != 1 || !strings.Contains(lockouts[0], "2s") {
+		t.Fatal(lockouts)
+	}
+	// During the lockout of two seconds the client may still attempt once a second
+	if ledger.AllowAttempt("a") {
+		t.Fatal("should not have allowed attempt")
+	}
+	time.Sleep(600 * time.Millisecond)
+	if ledger.AllowAttempt("a") {
+		t.Fatal("should not have allowed attempt")
+	}
+	time.Sleep(500 * time.Millisecond)
+	if !ledger.IsLockedOut("a") || !ledger.AllowAttempt("a") || ledger.AllowAttempt("a") {
+		t.Fatal("should have allowed one attempt")
+	}
+	// Success clears the failures
+	ledger.RecordSuccess("a")
+	if ledger.IsLockedOut("a") || !ledger.AllowAttempt("a") || len(ledger.GetStatus()) != 0 {
+		t.Fatal(ledger.GetStatus())
+	}
+	// The lockout duration does not exceed the maximum
+	for i := 0; i < 10; i++ {
+		ledger.RecordFailure("a")
+	}
+	if status := ledger.GetStatus(); len(status) != 1 || status[0].Key != "a" || status[0].Level != 5 || time.Until(status[0].LockedUntil) > 3*time.Second {
+		t.Fatal(status)
+	}
+	if n := ledger.Clear("b"); n != 0 || !ledger.IsLockedOut("a") {
+		t.Fatal(n)
+	}
+	if n := ledger.Clear("a"); n != 1 || ledger.IsLockedOut("a") {
+		t.Fatal(n)
+	}
+}
+
+func TestLockoutLedger_NetworkPrefix(t *testing.T) {
+	ledger := &LockoutLedger{Enabled: true, MaxFailures: 3, LockNetworkPrefix: true, NetworkPrefixMaxFailures: 4}
+	if err := ledger.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	// Failures from several addresses of the same network add up
+	for i, tag := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"} {
+		if lockouts := ledger.RecordFailure(tag); len(lockouts) != 0 {
+			t.Fatal(i, lockouts)
+		}
+	}
+	if lockouts := ledger.RecordFailure("192.0.2.4"); len(lockouts) != 1 || !strings.Contains(lockouts[0], "192.0.2.0/24") {
+		t.Fatal(lockouts)
+	}
+	if !ledger.IsLockedOut("192.0.2.200") || ledger.IsLockedOut("192.0.3.1") || ledger.IsLockedOut("+123456789") {
+		t.Fatal("wrong lockout")
+	}
+	// Success of one client does not clear the network prefix
+	ledger.RecordSuccess("192.0.2.1")
+	if !ledger.IsLockedOut("192.0.2.1") {
+		t.Fatal("should still be locked out")
+	}
+	if n := ledger.Clear(""); n != 4 || ledger.IsLockedOut("192.0.2.1") {
+		t.Fatal(n)
+	}
+	if err := (&LockoutLedger{BaseLockoutSec: 10, MaxLockoutSec: 5}).Initialise(); err == nil {
+		t.Fatal("did not error")
+	}
+}
+
+func TestCommandProcessor_LockoutLedger(t *testing.T) {
+	ledger := &LockoutLedger{Enabled: true, MaxFailures: 2}
+	if err := ledger.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	proc := GetTestCommandProcessor()
+	proc.LockoutLedger = ledger
+	proc.Features.EnvControl.LockoutLedger = ledger
+	// A successful attempt clears the previous failure
+	if result := proc.Process(context.Background(), Command{DaemonName: "d", ClientTag: "c", Content: "badpin .s echo hi", TimeoutSec: 10}, true); result.Error != ErrPINAndShortcutNotFound {
+		t.Fatal(result)
+	}
+	if result := proc.Process(context.Background(), Command{DaemonName: "d", ClientTag: "c", Content: TestCommandProcessorPIN + " .s echo hi", TimeoutSec: 10}, true); result.Error != nil {
+		t.Fatal(result)
+	}
+	if result := proc.Process(context.Background(), Command{DaemonName: "d", ClientTag: "c", Content: "badpin .s echo hi", TimeoutSec: 10}, true); result.Error != ErrPINAndShortcutNotFound {
+		t.Fatal(result)
+	}
+	// Two consecutive failures lock out the client, even if it knows the password.
+	if result := proc.Process(context.Background(), Command{DaemonName: "d", ClientTag: "c", Content: "badpin .s echo hi", TimeoutSec: 10}, true); result.Error != ErrPINAndShortcutNotFound {
+		t.Fatal(result)
+	}
+	if result := proc.Process(context.Background(), Command{DaemonName: "d", ClientTag: "c", Content: TestCommandProcessorPIN + " .s echo hi", TimeoutSec: 10}, true); result.Error != ErrClientLockedOut {
+		t.Fatal(result)
+	}
+	// The client is locked out of other daemons too
+	if result := proc.Process(context.Background(), Command{DaemonName: "e", ClientTag: "c", Content: TestCommandProcessorPIN + " .s echo hi", TimeoutSec: 10}, true); result.Error != ErrClientLockedOut {
+		t.Fatal(result)
+	}
+	// Other clients are not affected
+	if result := proc.Process(context.Background(), Command{DaemonName: "d", ClientTag: "d", Content: TestCommandProcessorPIN + " .s echo hi", TimeoutSec: 10}, true); result.Error != nil {
+		t.Fatal(result)
+	}
+	// List and clear lockouts via app command
+	if result := proc.Features.EnvControl.Execute(context.Background(), Command{Content: "lockout"}); result.Error != nil || !strings.Contains(result.Output, "c locked for") {
+		t.Fatal(result)
+	}
+	if result := proc.Features.EnvControl.Execute(context.Background(), Command{Content: "lockout clear c"}); result.Error != nil || result.Output != "cleared 1" {
+		t.Fatal(result)
+	}
+	if result := proc.Features.EnvControl.Execute(context.Background(), Command{Content: "lockout wrong"}); result.Error != ErrBadEnvInfoChoice {
+		t.Fatal(result)
+	}
+	if result := proc.Process(context.Background(), Command{DaemonName: "d", ClientTag: "c", Content: TestCommandProcessorPIN + " .s echo hi", TimeoutSec: 10}, true); result.Error != nil {
+		t.Fatal(result)
+	}
+	// A locked-out client who knows the password gets in after waiting for half of the lockout
+	ledger.BaseLockoutSec = 1
+	for i := 0; i < 2; i++ {
+		if result := proc.Process(context.Background(), Command{DaemonName: "d", ClientTag: "c", Content: "badpin .s echo hi", TimeoutSec: 10}, true); result.Error != ErrPINAndShortcutNotFound {
+			t.Fatal(result)
+		}
+	}
+	if !ledger.IsLockedOut("c") {
+		t.Fatal("should have been locked out")
+	}
+	time.Sleep(1100 * time.Millisecond)
+	if result := proc.Process(context.Background(), Command{DaemonName: "d", ClientTag: "c", Content: TestCommandProcessorPIN + " .s echo hi", TimeoutSec: 10}, true); result.Error != nil {
+		t.Fatal(result)
+	}
+	if ledger.IsLockedOut("c") {
+		t.Fatal("lockout should have been lifted")
+	}
+}
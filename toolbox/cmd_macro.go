@@ -0,0 +1,107 @@
+package toolbox
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// MinMacroNameLength is the minimum length of a macro name.
+const MinMacroNameLength = 3
+
+// RegexMacroPlaceholder matches an argument placeholder in a macro template - $1 to $9 for individual arguments and $* for all arguments.
+var RegexMacroPlaceholder = regexp.MustCompile(`\$([1-9*])`)
+
+/*
+RegexMacroSafeArgument matches an argument that does not require quoting. The argument may not begin with a dot, so that
+it cannot be mistaken for an app trigger or chain separator.
+*/
+var RegexMacroSafeArgument = regexp.MustCompile(`^[a-zA-Z0-9_,:/@%+=-][a-zA-Z0-9_.,:/@%+=-]*$`)
+
+/*
+quoteMacroArgument returns the argument as-is if it consists only of harmless characters, or otherwise wraps it in a
+pair of single quotes so that a shell will not interpret the special characters.
+*/
+func quoteMacroArgument(arg string) string {
+	if RegexMacroSafeArgument.MatchString(arg) {
+		return arg
+	}
+	return "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+}
+
+/*
+expandMacro substitutes the argument placeholders in the macro template with the arguments. $1 to $9 are replaced by the
+individual arguments, and $* is replaced by all arguments separated by a space. Each argument is quoted if necessary.
+The function returns an error if the template refers to an argument that is not given.
+*/
+func expandMacro(name, template string, args []string) (string, error) {
+	var missingArg error
+	expanded := RegexMacroPlaceholder.ReplaceAllStringFunc(template, func(placeholder string) string {
+		if placeholder == "$*" {
+			quotedArgs := make([]string, len(args))
+			for i, arg := range args {
+				quotedArgs[i] = quoteMacroArgument(arg)
+			}
+			return strings.Join(quotedArgs, " ")
+		}
+		index, _ := strconv.Atoi(placeholder[1:])
+		if index > len(args) {
+			missingArg = fmt.Errorf("macro \"%s\" requires at least %d arguments", name, index)
+			return ""
+		}
+		return quoteMacroArgument(args[index-1])
+	})
+	return expanded, missingArg
+}
+
+/*
+findAndExpandMacro looks for a macro named by the first word of the input, and expands the macro using the remaining
+words as its arguments. If the input does not begin with a macro name, the function returns false.
+*/
+func (pin *PINAndShortcuts) findAndExpandMacro(input string) (name, expanded string, found bool, err error) {
+	words := strings.Fields(input)
+	if len(words) == 0 {
+		return
+	}
+	name = words[0]
+	template, found := pin.Macros[name]
+	if !found {
+		return
+	}
+	expanded, err = expandMacro(name, template, words[1:])
+	return
+}
+
+// checkMacros returns configuration errors found among macros and their scopes, or an empty slice if they look OK.
+func (pin *PINAndShortcuts) checkMacros() (errs []error) {
+	errs = make([]error, 0)
+	for name, template := range pin.Macros {
+		if len(name) < MinMacroNameLength || strings.ContainsAny(name, " \t\r\n") {
+			errs = append(errs, fmt.Errorf(ErrBadProcessorConfig+"Macro name \"%s\" must be a single word of at least %d characters", name, MinMacroNameLength))
+		}
+		// A name that begins with a dot could be mistaken for an app trigger or a magic prefix
+		if strings.HasPrefix(name, ".") {
+			errs = append(errs, fmt.Errorf(ErrBadProcessorConfig+"Macro name \"%s\" must not begin with a dot", name))
+		}
+		if _, exists := pin.Shortcuts[name]; exists {
+			errs = append(errs, fmt.Errorf(ErrBadProcessorConfig+"Macro name \"%s\" must not be identical to a shortcut", name))
+		}
+		if strings.TrimSpace(template) == "" {
+			errs = append(errs, fmt.Errorf(ErrBadProcessorConfig+"Macro \"%s\" must not be empty", name))
+		}
+		for _, password := range pin.Passwords {
+			if strings.HasPrefix(name, password) {
+				errs = append(errs, errors.New(ErrBadProcessorConfig+"Macro name must not begin with a password"))
+			}
+		}
+	}
+	for name, scope := range pin.MacroScopes {
+		if _, exists := pin.Macros[name]; !exists {
+			errs = append(errs, fmt.Errorf(ErrBadProcessorConfig+"Macro \"%s\" in MacroScopes must also be defined in Macros", name))
+		}
+		errs = append(errs, scope.check()...)
+	}
+	return
+}
@@ -0,0 +1,130 @@
+package toolbox
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestExpandMacro(t *testing.T) {
+	tests := []struct {
+		template string
+		args     []string
+		expected string
+		isErr    bool
+	}{
+		{".s echo hi", nil, ".s echo hi", false},
+		{".s echo $1", []string{"zurich"}, ".s echo zurich", false},
+		{".s echo $2 $1", []string{"a", "b"}, ".s echo b a", false},
+		{".s echo $*", []string{"new", "york"}, ".s echo new york", false},
+		{".s echo $*", nil, ".s echo ", false},
+		{".s echo $2", []string{"a"}, "", true},
+		// Arguments with special characters are quoted
+		{".s echo $1", []string{"a;rm"}, ".s echo 'a;rm'", false},
+		{".s echo $1", []string{"it's"}, `.s echo 'it'\''s'`, false},
+		{".s echo $*", []string{"$(id)", "`id`"}, ".s echo '$(id)' '`id`'", false},
+		// Arguments cannot smuggle in chain separators
+		{".s echo $*", []string{"a", ".then", ".s", "id"}, ".s echo a '.then' '.s' id", false},
+		// Other dollar signs are left alone
+		{".s echo $HOME $0", []string{"a"}, ".s echo $HOME $0", false},
+	}
+	for _, test := range tests {
+		expanded, err := expandMacro("m", test.template, test.args)
+		if test.isErr {
+			if err == nil {
+				t.Fatalf("%+v: did not error", test)
+			}
+			continue
+		}
+		if err != nil || expanded != test.expected {
+			t.Fatalf("%+v: got %q, %v", test, expanded, err)
+		}
+	}
+	if split := splitCommandChain(".s echo a '.then' id"); len(split) != 1 {
+		t.Fatal(split)
+	}
+}
+
+func TestPINAndShortcuts_Macros(t *testing.T) {
+	pin := &PINAndShortcuts{
+		Passwords:   []string{"mypin"},
+		Shortcuts:   map[string]string{"wx": ".s echo shortcut"},
+		Macros:      map[string]string{"wx": ".s echo $1", "greet": ".s echo hello $*", "secret": ".s echo secret $1"},
+		MacroScopes: map[string]PasswordScope{"wx": {Triggers: []string{".s"}}, "greet": {Triggers: []string{".s"}, Daemons: []string{"smtpd"}}},
+	}
+	// An exact shortcut match takes precedence
+	if cmd, err := pin.Transform(Command{Content: "wx"}); err != nil || cmd.Content != ".s echo shortcut" {
+		t.Fatal(cmd, err)
+	}
+	// Macro without password runs in the macro's scope
+	if cmd, err := pin.Transform(Command{Content: "  wx zurich  "}); err != nil || cmd.Content != ".s echo zurich" || cmd.scope == nil || cmd.scope.Triggers[0] != ".s" {
+		t.Fatal(cmd, err)
+	}
+	if cmd, err := pin.Transform(Command{Content: "greet"}); err != nil || cmd.Content != ".s echo hello " || cmd.scope.Daemons[0] != "smtpd" {
+		t.Fatal(cmd, err)
+	}
+	// Macro without a scope requires a password
+	if _, err := pin.Transform(Command{Content: "secret a"}); err != ErrPINAndShortcutNotFound {
+		t.Fatal(err)
+	}
+	if cmd, err := pin.Transform(Command{Content: "mypin secret a"}); err != nil || cmd.Content != ".s echo secret a" || cmd.scope != nil {
+		t.Fatal(cmd, err)
+	}
+	// Macro after password
+	if cmd, err := pin.Transform(Command{Content: "mypin greet new york"}); err != nil || cmd.Content != ".s echo hello new york" {
+		t.Fatal(cmd, err)
+	}
+	// Password without macro
+	if cmd, err := pin.Transform(Command{Content: "mypin .s echo greet"}); err != nil || cmd.Content != " .s echo greet" {
+		t.Fatal(cmd, err)
+	}
+	// Missing argument
+	pin.Shortcuts = nil
+	if _, err := pin.Transform(Command{Content: "wx"}); err == nil || !strings.Contains(err.Error(), "requires at least 1 arguments") {
+		t.Fatal(err)
+	}
+	// Unknown macro
+	if _, err := pin.Transform(Command{Content: "doesnotexist a"}); err != ErrPINAndShortcutNotFound {
+		t.Fatal(err)
+	}
+	// Macros alone are sufficient for the filter to work
+	pin = &PINAndShortcuts{Macros: map[string]string{"wx": ".s echo $1"}, MacroScopes: map[string]PasswordScope{"wx": {Triggers: []string{".s"}}}}
+	if cmd, err := pin.Transform(Command{Content: "wx zurich"}); err != nil || cmd.Content != ".s echo zurich" {
+		t.Fatal(cmd, err)
+	}
+}
+
+func TestCommandProcessor_Macro(t *testing.T) {
+	proc := GetTestCommandProcessor()
+	pin := &PINAndShortcuts{
+		Passwords:   []string{TestCommandProcessorPIN},
+		Macros:      map[string]string{"twice": ".chain .s echo first $1 .then .s echo second $1", "mixed": ".chain .s echo a .then .e info"},
+		MacroScopes: map[string]PasswordScope{"twice": {Triggers: []string{".s"}}, "mixed": {Triggers: []string{".s"}}},
+	}
+	proc.CommandFilters[0] = pin
+	if errs := proc.IsSaneForInternet(); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	// The macro expands into several app commands
+	result := proc.Process(context.Background(), Command{Content: "twice a;b", TimeoutSec: 10}, true)
+	if result.Error != nil || !strings.Contains(result.Output, "first a;b") || !strings.Contains(result.Output, "second a;b") {
+		t.Fatalf("%+v", result)
+	}
+	// Each of the macro's app commands is subject to the macro's scope
+	result = proc.Process(context.Background(), Command{Content: "mixed", TimeoutSec: 10}, true)
+	if result.Error != ErrCommandOutOfScope || !strings.Contains(result.Output, "a") {
+		t.Fatalf("%+v", result)
+	}
+	// Bad macro names and scopes are not sane
+	pin.Shortcuts = map[string]string{"shortcut": ".s echo"}
+	pin.Macros[TestCommandProcessorPIN+"x"] = ".s echo"
+	pin.Macros["two words"] = ".s echo"
+	pin.Macros["ab"] = ".s echo"
+	pin.Macros[".s"] = ".s echo"
+	pin.Macros["shortcut"] = ".s echo"
+	pin.Macros["empty"] = ""
+	pin.MacroScopes["doesnotexist"] = PasswordScope{Triggers: []string{"s"}}
+	if errs := proc.IsSaneForInternet(); len(errs) != 9 {
+		t.Fatal(len(errs), errs)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"regexp"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -28,6 +29,9 @@ const (
 	// TestCommandProcessorPIN is the PIN secret used in test command processor, as returned by GetTestCommandProcessor.
 	TestCommandProcessorPIN = "verysecret"
 
+	// HiddenCommandContent replaces the content of an app command that carries an encryption key or secret in logs.
+	HiddenCommandContent = "<hidden due to AESDecryptTrigger, TwoFATrigger, NoteVaultTrigger, or unlock secret>"
+
 	// MaxCmdPerSecHardLimit is the hard uppper limit of the approximate maximum number of commands a command processor will process in a second.
 	MaxCmdPerSecHardLimit = 1000
 	// MaxCmdLength is the maximum length of a single command (including password PIN and other prefixes) that the command processor will accept.
@@ -43,20 +47,35 @@ var ErrBadPLT = errors.New(PrefixCommandPLT + " P L T command")
 // ErrCommandTooLong is a command execution error indicating that the input is too long and cannot be accepted.
 var ErrCommandTooLong = fmt.Errorf("command input exceeds the maximum length of %d characters", MaxCmdLength)
 
+// ErrCommandOutOfScope is a command execution error indicating that the password PIN is not permitted to invoke the app via the daemon.
+var ErrCommandOutOfScope = errors.New("the password is not permitted to use this app")
+
 // ErrRateLimitExceeded is a command execution error indicating that the internal command processing rate limit has been exceeded
 var ErrRateLimitExceeded = errors.New("command processor internal rate limit has been exceeded")
 
 // RegexCommandWithPLT parses PLT magic parameters position, length, and timeout, all of which are integers.
 var RegexCommandWithPLT = regexp.MustCompile(`[^\d]*(\d+)[^\d]+(\d+)[^\d]*(\d+)(.*)`)
 
+/*
+RegexCommandWithSecret matches an app command that carries an encryption key or secret among its parameters - AES
+decryption, 2FA code generator, note vault, and the command that lifts lock-down - anywhere in the command content.
+*/
+var RegexCommandWithSecret = regexp.MustCompile(`(?:^|\s)(?:` + regexp.QuoteMeta(AESDecryptTrigger) + `|` + regexp.QuoteMeta(TwoFATrigger) + `|` +
+	regexp.QuoteMeta(NoteVaultTrigger) + `|` + regexp.QuoteMeta(EnvControlTrigger) + `\s*(?i:unlock))`)
+
 // RegexSubjectReportUsing2FA matches a message processor's subject report app command invoked via 2FA.
 var RegexSubjectReportUsing2FA = regexp.MustCompile(`[\d]{12}[\s]*\` + StoreAndForwardMessageProcessorTrigger)
 
+// RegexSubjectReportUsingSignature matches a message processor's subject report app command signed by SignCommand.
+var RegexSubjectReportUsingSignature = regexp.MustCompile(`^\s*[0-9]{10}[0-9a-z]{` + strconv.Itoa(SignedCommandNonceLen) + `}[0-9a-f]{` + strconv.Itoa(SignedCommandMACBytes*2) + `}[\s]*\` + StoreAndForwardMessageProcessorTrigger)
+
 // Pre-configured environment and configuration for processing feature commands.
 type CommandProcessor struct {
 	Features       *FeatureSet     // Features is the aggregation of initialised toolbox feature routines.
 	CommandFilters []CommandFilter // CommandFilters are applied one by one to alter input command content and/or timeout.
 	ResultFilters  []ResultFilter  // ResultFilters are applied one by one to alter command execution result.
+	AuditJournal   *AuditJournal   // AuditJournal optionally keeps a persistent record of each command that went through the command filters.
+	LockoutLedger  *LockoutLedger  // LockoutLedger optionally locks out clients that repeatedly fail to authenticate.
 
 	/*
 		MaxCmdPerSec is the approximate maximum number of commands allowed to be processed per second.
@@ -139,8 +158,8 @@ func (proc *CommandProcessor) IsSaneForInternet() (errs []error) {
 		seenPIN := false
 		for _, cmdBridge := range proc.CommandFilters {
 			if pin, yes := cmdBridge.(*PINAndShortcuts); yes {
-				if len(pin.Passwords) == 0 && (pin.Shortcuts == nil || len(pin.Shortcuts) == 0) {
-					errs = append(errs, errors.New(ErrBadProcessorConfig+"Defined in PINAndShortcuts there has to be password PIN, command shortcuts, or both."))
+				if len(pin.Passwords) == 0 && len(pin.Shortcuts) == 0 && len(pin.Macros) == 0 {
+					errs = append(errs, errors.New(ErrBadProcessorConfig+"Defined in PINAndShortcuts there has to be password PIN, command shortcuts, macros, or any combination."))
 				}
 				for _, password := range pin.Passwords {
 					if len(password) < 7 {
@@ -148,10 +167,20 @@ func (proc *CommandProcessor) IsSaneForInternet() (errs []error) {
 						break
 					}
 				}
+				errs = append(errs, pin.checkPasswordScopes()...)
+				errs = append(errs, pin.checkMacros()...)
 				seenPIN = true
 				break
 			}
 		}
+		for _, cmdBridge := range proc.CommandFilters {
+			if sig, yes := cmdBridge.(*SignedCommand); yes && sig.Enabled && sig.PINAndShortcuts == nil {
+				errs = append(errs, errors.New(ErrBadProcessorConfig+"SignedCommand filter must refer to PINAndShortcuts filter for passwords"))
+			}
+			if sig, yes := cmdBridge.(*SignedCommand); yes && sig.RequireSignature && !sig.Enabled {
+				errs = append(errs, errors.New(ErrBadProcessorConfig+"SignedCommand filter must be enabled to require signature"))
+			}
+		}
 		if !seenPIN {
 			errs = append(errs, errors.New(ErrBadProcessorConfig+"\"PINAndShortcuts\" filter must be defined to set up password PIN protection or command shortcuts"))
 		}
@@ -173,6 +202,11 @@ func (proc *CommandProcessor) IsSaneForInternet() (errs []error) {
 		if !seenLinter {
 			errs = append(errs, errors.New(ErrBadProcessorConfig+"\"LintText\" filter must be defined to restrict command output length"))
 		}
+		for _, resultBridge := range proc.ResultFilters {
+			if enc, yes := resultBridge.(*EncryptResult); yes && enc.Enabled && enc.MaxLength != 0 && enc.MaxLength < MinEncryptResultMaxLength {
+				errs = append(errs, fmt.Errorf(ErrBadProcessorConfig+"Maximum output length for EncryptResult must be 0 or at least %d", MinEncryptResultMaxLength))
+			}
+		}
 	}
 	return
 }
@@ -185,14 +219,16 @@ settings, and it may optionally discard a number of characters from the beginnin
 */
 func (proc *CommandProcessor) Process(ctx context.Context, cmd Command, runResultFilters bool) (ret *Result) {
 	proc.initialiseOnce()
-	// Refuse to execute a command if global lock down has been triggered
-	if misc.EmergencyLockDown {
-		return &Result{Error: misc.ErrEmergencyLockDown}
-	}
+	// Filters may not retain the client's identity when they disapprove the command, the audit record still needs it.
+	clientTag, daemonName := cmd.ClientTag, cmd.DaemonName
 	// Refuse to execute a command if the internal rate limit has been reached
 	if !proc.rateLimit.Add("instance", true) {
 		return &Result{Error: ErrRateLimitExceeded}
 	}
+	// Refuse to execute a command if the client has failed to authenticate too many times
+	if proc.LockoutLedger.IsConfigured() && clientTag != "" && !proc.LockoutLedger.AllowAttempt(clientTag) {
+		return &Result{Error: ErrClientLockedOut}
+	}
 	// Refuse to execute a command if it is exceedingly long
 	if len(cmd.Content) > MaxCmdLength {
 		return &Result{Error: ErrCommandTooLong}
@@ -201,14 +237,14 @@ func (proc *CommandProcessor) Process(ctx context.Context, cmd Command, runResul
 		Hacky workaround - do not run result filter for the store&forward message processor, which runs an app command
 		with its own command processor and its own result filters.
 	*/
-	if RegexSubjectReportUsing2FA.MatchString(cmd.Content) {
+	if RegexSubjectReportUsing2FA.MatchString(cmd.Content) || RegexSubjectReportUsingSignature.MatchString(cmd.Content) {
 		runResultFilters = false
 	}
 
 	// Put execution duration into statistics
 	beginTimeNano := time.Now().UnixNano()
 	var filterDisapproval error
-	var matchedFeature Feature
+	var matchedTriggers string
 	var overrideLintText LintText
 	var hasOverrideLintText bool
 	var logCommandContent string
@@ -216,10 +252,23 @@ func (proc *CommandProcessor) Process(ctx context.Context, cmd Command, runResul
 	for _, cmdBridge := range proc.CommandFilters {
 		cmd, filterDisapproval = cmdBridge.Transform(cmd)
 		if filterDisapproval != nil {
+			if isAuthFailure(filterDisapproval) {
+				proc.recordAuthFailure(clientTag, daemonName)
+			}
 			ret = &Result{Error: filterDisapproval}
 			goto result
 		}
 	}
+	// A command authenticated by password clears the client's past failures
+	if proc.LockoutLedger.IsConfigured() && clientTag != "" && cmd.resultKey != nil {
+		proc.LockoutLedger.RecordSuccess(clientTag)
+	}
+	// A command authenticated by password is also the operator's check-in with the dead man's switch
+	if cmd.resultKey != nil && proc.Features != nil {
+		if dms, isDMS := proc.Features.LookupByTrigger[DeadManSwitchTrigger].(*DeadManSwitch); isDMS {
+			dms.CheckIn()
+		}
+	}
 	// If filters approve, then the command execution is to be tracked in stats.
 	defer func() {
 		misc.CommandStats.Trigger(float64(time.Now().UnixNano() - beginTimeNano))
@@ -228,6 +277,16 @@ func (proc *CommandProcessor) Process(ctx context.Context, cmd Command, runResul
 	if ret = cmd.Trim(); ret != nil {
 		goto result
 	}
+	/*
+		Refuse to execute a command if global lock down has been triggered, unless the command lifts the lock-down and
+		does nothing else. The check takes place after filters, so that the command no longer carries password PIN. The
+		refused command does not go through result filters, which would otherwise send notifications during lock-down.
+	*/
+	if misc.EmergencyLockDown && !RegexEnvControlUnlock.MatchString(cmd.Content) {
+		ret = &Result{Error: misc.ErrEmergencyLockDown}
+		ret.ResetCombinedText()
+		return
+	}
 	// Look for PLT (position, length, timeout) override, it is going to affect LintText filter.
 	if cmd.FindAndRemovePrefix(PrefixCommandPLT) {
 		// Find the configured LintText bridge
@@ -267,37 +326,20 @@ func (proc *CommandProcessor) Process(ctx context.Context, cmd Command, runResul
 			goto result
 		}
 	}
-	/*
-		Now the command has gone through modifications made by command filters. Keep a copy of its content for logging
-		purpose before it is further manipulated by individual feature's routine that may add or remove bits from the
-		content.
-	*/
-	logCommandContent = cmd.Content
-	// Look for command's prefix among configured features
-	for prefix, configuredFeature := range proc.Features.LookupByTrigger {
-		if cmd.FindAndRemovePrefix(string(prefix)) {
-			// Hacky workaround - do not log content of AES decryption commands as they can reveal encryption key
-			if prefix == AESDecryptTrigger || prefix == TwoFATrigger {
-				logCommandContent = "<hidden due to AESDecryptTrigger or TwoFATrigger>"
-			}
-			matchedFeature = configuredFeature
-			break
-		}
-	}
-	// Unknown command prefix or the requested feature is not configured
-	if matchedFeature == nil {
-		ret = &Result{Error: ErrBadPrefix}
-		goto result
+	// The command may be a chain of app commands that run one after another
+	if cmd.FindAndRemovePrefix(PrefixCommandChain) {
+		ret, matchedTriggers, logCommandContent = proc.runChain(ctx, cmd, splitCommandChain(cmd.Content), hasOverrideLintText, runResultFilters)
+		logCommandContent = PrefixCommandChain + " " + logCommandContent
+	} else {
+		var matchedTrigger Trigger
+		ret, matchedTrigger, logCommandContent = proc.runApp(ctx, cmd, hasOverrideLintText, runResultFilters)
+		matchedTriggers = string(matchedTrigger)
 	}
-	// Run the feature
-	proc.logger.Info("Process", fmt.Sprintf("%s-%s", cmd.DaemonName, cmd.ClientTag), nil, "running \"%s\" (post-process result? %v)", logCommandContent, runResultFilters)
-	defer func() {
-		proc.logger.Info("Process", fmt.Sprintf("%s-%s", cmd.DaemonName, cmd.ClientTag), nil, "completed \"%s\" (ok? %v post-process reslt? %v)", logCommandContent, ret.Error == nil, runResultFilters)
-	}()
-	ret = matchedFeature.Execute(ctx, cmd)
 result:
 	// Command in the result structure is mainly used for logging purpose
 	ret.Command = cmd
+	// Only the result filters need the key derived from password, there is no need to carry it along with the command.
+	ret.resultKey, ret.Command.resultKey = cmd.resultKey, nil
 	/*
 		Features may have modified command in-place to remove certain content and it's OK to do that.
 		But to make log messages more meaningful, it is better to restore command content to the modified one
@@ -306,6 +348,27 @@ result:
 	ret.Command.Content = logCommandContent
 	// Set combined text for easier retrieval of result+error in one text string
 	ret.ResetCombinedText()
+	ret.trigger = matchedTriggers
+	ret.durationMS = (time.Now().UnixNano() - beginTimeNano) / 1000000
+	if hasOverrideLintText {
+		ret.pltPosition, ret.pltLength, ret.pltTimeoutSec = overrideLintText.BeginPosition, overrideLintText.MaxLength, cmd.TimeoutSec
+	}
+	// Keep a persistent record of the command, by now the command content no longer carries the password PIN.
+	if proc.AuditJournal.IsConfigured() {
+		rec := AuditRecord{
+			TimeUnixNano: time.Now().UnixNano(),
+			DaemonName:   daemonName,
+			ClientTag:    clientTag,
+			Trigger:      matchedTriggers,
+			Input:        ret.Command.Content,
+			OutputLength: len(ret.CombinedOutput),
+			Error:        ret.ErrText(),
+			DurationMS:   ret.durationMS,
+		}
+		if err := proc.AuditJournal.Append(rec); err != nil {
+			proc.logger.Warning("Process", fmt.Sprintf("%s-%s", daemonName, clientTag), err, "failed to append to audit journal")
+		}
+	}
 	// Walk through result filters
 	if runResultFilters {
 		for _, resultFilter := range proc.ResultFilters {
@@ -321,6 +384,103 @@ result:
 	return
 }
 
+/*
+recordAuthFailure records the authentication failure in the lockout ledger, and notifies the recipients of
+NotifyViaEmail filter when the client or its network prefix becomes locked out.
+*/
+func (proc *CommandProcessor) recordAuthFailure(clientTag, daemonName string) {
+	if !proc.LockoutLedger.IsConfigured() || clientTag == "" {
+		return
+	}
+	for _, lockout := range proc.LockoutLedger.RecordFailure(clientTag) {
+		for _, resultFilter := range proc.ResultFilters {
+			if notify, isNotify := resultFilter.(*NotifyViaEmail); isNotify {
+				notify.sendAsync("lockout-"+clientTag, fmt.Sprintf("Daemon %s: %s", daemonName, lockout))
+			}
+		}
+	}
+}
+
+/*
+runApp finds the app matching the command's trigger prefix and then runs the app command, either right away or as a
+background job. The command must have already been approved by command filters. It returns the app execution result,
+the matched trigger, and the command content for logging.
+*/
+func (proc *CommandProcessor) runApp(ctx context.Context, cmd Command, isTimeoutOverridden, runResultFilters bool) (ret *Result, matchedTrigger Trigger, logCommandContent string) {
+	var matchedFeature Feature
+	// Look for background job prefix, the app command will run asynchronously.
+	isBackgroundJob := cmd.FindAndRemovePrefix(PrefixCommandBackground)
+	if isBackgroundJob && !isTimeoutOverridden {
+		// Unless PLT magic says otherwise, the background job gets a generous timeout independent from the daemon.
+		cmd.TimeoutSec = BackgroundJobTimeoutSec
+	}
+	/*
+		Now the command has gone through modifications made by command filters. Keep a copy of its content for logging
+		purpose before it is further manipulated by individual feature's routine that may add or remove bits from the
+		content.
+	*/
+	logCommandContent = cmd.Content
+	// Look for command's prefix among configured features
+	for prefix, configuredFeature := range proc.Features.LookupByTrigger {
+		if cmd.FindAndRemovePrefix(string(prefix)) {
+			matchedFeature = configuredFeature
+			matchedTrigger = prefix
+			break
+		}
+	}
+	// Do not log content of AES decryption, note vault, and unlock commands as they can reveal encryption key
+	logCommandContent = concealSecretCommand(logCommandContent)
+	// Unknown command prefix or the requested feature is not configured
+	if matchedFeature == nil {
+		ret = &Result{Error: ErrBadPrefix}
+		return
+	}
+	// The password PIN may have been restricted to use a subset of apps and daemons
+	if !cmd.scope.Allows(matchedTrigger, cmd.DaemonName) {
+		proc.logger.Warning("Process", fmt.Sprintf("%s-%s", cmd.DaemonName, cmd.ClientTag), nil, "refuse to run \"%s\" as it is out of the password's scope", logCommandContent)
+		ret = &Result{Error: ErrCommandOutOfScope}
+		return
+	}
+	// The app may have been locked down, though the lock-down can always be lifted.
+	isUnlock := matchedTrigger == EnvControlTrigger && strings.HasPrefix(strings.ToLower(cmd.Content), "unlock ")
+	if !isUnlock && misc.IsTriggerLockedDown(string(matchedTrigger)) {
+		ret = &Result{Error: misc.ErrEmergencyLockDown}
+		return
+	}
+	// Start the background job and respond with its ID
+	if isBackgroundJob {
+		if cmd.resultKey == nil {
+			ret = &Result{Error: ErrBackgroundJobWithoutPassword}
+			return
+		}
+		if job, err := BackgroundJobs.Start(matchedFeature, matchedTrigger, cmd, logCommandContent); err != nil {
+			ret = &Result{Error: err}
+		} else {
+			ret = &Result{Output: job.ID}
+		}
+		return
+	}
+	// Run the feature
+	proc.logger.Info("Process", fmt.Sprintf("%s-%s", cmd.DaemonName, cmd.ClientTag), nil, "running \"%s\" (post-process result? %v)", logCommandContent, runResultFilters)
+	defer func() {
+		proc.logger.Info("Process", fmt.Sprintf("%s-%s", cmd.DaemonName, cmd.ClientTag), nil, "completed \"%s\" (ok? %v post-process reslt? %v)", logCommandContent, ret.Error == nil, runResultFilters)
+	}()
+	ret = matchedFeature.Execute(ctx, cmd)
+	return
+}
+
+/*
+concealSecretCommand returns the app command content suitable for logs, audit journal, and notifications. The content
+of app commands that carry an encryption key or secret among their parameters is hidden. The content must have already
+been stripped of password PIN.
+*/
+func concealSecretCommand(content string) string {
+	if RegexCommandWithSecret.MatchString(content) {
+		return HiddenCommandContent
+	}
+	return content
+}
+
 // Return a realistic command processor for test cases. The only feature made available and initialised is shell execution.
 func GetTestCommandProcessor() *CommandProcessor {
 	/*
@@ -104,6 +104,97 @@ func TestCommandProcessor_NonWindows(t *testing.T) {
 	misc.EmergencyLockDown = false
 }
 
+func TestCommandProcessor_PasswordScope(t *testing.T) {
+	proc := GetTestCommandProcessor()
+	proc.CommandFilters[0] = &PINAndShortcuts{
+		Passwords:      []string{TestCommandProcessorPIN, "lowtrustpin"},
+		PasswordScopes: map[string]PasswordScope{"lowtrustpin": {Triggers: []string{".e"}, Daemons: []string{"dnsd"}}},
+	}
+	if errs := proc.IsSaneForInternet(); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	// The scoped password may invoke the permitted app via the permitted daemon
+	if result := proc.Process(context.Background(), Command{DaemonName: "dnsd", Content: "lowtrustpin .elog", TimeoutSec: 10}, true); result.Error != nil {
+		t.Fatal(result)
+	}
+	// The scoped password may not invoke other apps or use other daemons
+	if result := proc.Process(context.Background(), Command{DaemonName: "dnsd", Content: "lowtrustpin .s echo hi", TimeoutSec: 10}, true); result.Error != ErrCommandOutOfScope {
+		t.Fatal(result)
+	}
+	if result := proc.Process(context.Background(), Command{DaemonName: "httpd", Content: "lowtrustpin .elog", TimeoutSec: 10}, true); result.Error != ErrCommandOutOfScope {
+		t.Fatal(result)
+	}
+	// The unscoped password may invoke all apps
+	if result := proc.Process(context.Background(), Command{DaemonName: "httpd", Content: TestCommandProcessorPIN + " .s echo hi", TimeoutSec: 10}, true); result.Error != nil {
+		t.Fatal(result)
+	}
+	// A scope that refers to an unknown password is not sane
+	proc.CommandFilters[0].(*PINAndShortcuts).PasswordScopes["doesnotexist"] = PasswordScope{Triggers: []string{".e"}}
+	if errs := proc.IsSaneForInternet(); len(errs) != 1 {
+		t.Fatal(errs)
+	}
+}
+
+func TestCommandProcessor_LockDown(t *testing.T) {
+	proc := GetTestCommandProcessor()
+	if err := (&misc.LockDownConfig{UnlockSecret: "unlock-secret"}).Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = (&misc.LockDownConfig{}).Initialise()
+	}()
+	// Scoped lock-down disables the listed apps only
+	if err := misc.TriggerScopedLockDown(nil, []string{".s"}); err != nil {
+		t.Fatal(err)
+	}
+	if result := proc.Process(context.Background(), Command{Content: TestCommandProcessorPIN + " .s echo hi", TimeoutSec: 10}, true); result.Error != misc.ErrEmergencyLockDown {
+		t.Fatal(result)
+	}
+	if result := proc.Process(context.Background(), Command{Content: TestCommandProcessorPIN + " .elog", TimeoutSec: 10}, true); result.Error != nil {
+		t.Fatal(result)
+	}
+	// Full lock-down disables all apps, except for the command that lifts lock-down.
+	misc.TriggerEmergencyLockDown()
+	if result := proc.Process(context.Background(), Command{Content: TestCommandProcessorPIN + " .elog", TimeoutSec: 10}, true); result.Error != misc.ErrEmergencyLockDown {
+		t.Fatal(result)
+	}
+	if result := proc.Process(context.Background(), Command{Content: TestCommandProcessorPIN + " .e unlock incorrect", TimeoutSec: 10}, true); result.Error != misc.ErrBadUnlockSecret {
+		t.Fatal(result)
+	}
+	// The refused command does not go through result filters, which may for example send notification Emails.
+	resultFilters := proc.ResultFilters
+	proc.ResultFilters = []ResultFilter{&LintText{MaxLength: 1}}
+	if result := proc.Process(context.Background(), Command{Content: TestCommandProcessorPIN + " .elog", TimeoutSec: 10}, true); result.Error != misc.ErrEmergencyLockDown || result.CombinedOutput != misc.ErrEmergencyLockDown.Error() {
+		t.Fatal(result)
+	}
+	proc.ResultFilters = resultFilters
+	// The exemption only applies to a command that lifts lock-down and does nothing else
+	for _, content := range []string{
+		" .s echo .e unlock unlock-secret",
+		" .e unlock unlock-secret .then .s echo hi",
+		" .elog .e unlock unlock-secret",
+		" .e unlock",
+	} {
+		if result := proc.Process(context.Background(), Command{Content: TestCommandProcessorPIN + content, TimeoutSec: 10}, true); result.Error != misc.ErrEmergencyLockDown {
+			t.Fatal(content, result)
+		}
+	}
+	if !misc.EmergencyLockDown {
+		t.Fatal("lock-down should still be in effect")
+	}
+	// The unlock command still requires a correct password
+	if result := proc.Process(context.Background(), Command{Content: "badpin .e unlock unlock-secret", TimeoutSec: 10}, true); result.Error == nil {
+		t.Fatal(result)
+	}
+	result := proc.Process(context.Background(), Command{Content: TestCommandProcessorPIN + " .e unlock unlock-secret", TimeoutSec: 10}, true)
+	if result.Error != nil || strings.Contains(result.Command.Content, "unlock-secret") {
+		t.Fatal(result)
+	}
+	if result := proc.Process(context.Background(), Command{Content: TestCommandProcessorPIN + " .s echo hi", TimeoutSec: 10}, true); result.Error != nil {
+		t.Fatal(result)
+	}
+}
+
 func TestCommandProcessor_LengthLimit(t *testing.T) {
 	proc := GetTestCommandProcessor()
 
@@ -245,7 +336,7 @@ func TestConcealedLogMessages(t *testing.T) {
 	}
 	proc.Process(context.Background(), Command{Content: "verysecret .a does not matter", TimeoutSec: 10}, true)
 	proc.Process(context.Background(), Command{Content: "verysecret .2 does not matter", TimeoutSec: 10}, true)
-	t.Log("Please observe <hidden due to AESDecryptTrigger or TwoFATrigger> from log output, otherwise consider this test is failed")
+	t.Log("Please observe " + HiddenCommandContent + " from log output, otherwise consider this test is failed")
 }
 
 func TestGetEmptyCommandProcessor(t *testing.T) {
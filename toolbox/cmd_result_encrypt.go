@@ -0,0 +1,158 @@
+package toolbox
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/HouzuoGuo/laitos/lalog"
+	"github.com/HouzuoGuo/laitos/misc"
+)
+
+const (
+	// ResultEncryptionSaltBytes is the length of random salt that derives the key of each encrypted result from the password.
+	ResultEncryptionSaltBytes = 8
+	// ResultEncryptionKDFIterations is the number of PBKDF2 iterations that derive the key of each encrypted result.
+	ResultEncryptionKDFIterations = 100000
+	/*
+		ResultEncryptionOverheadBytes is the number of bytes added to each encrypted result - an 8 bytes salt, a 12 bytes
+		nonce, and a 16 bytes authentication tag.
+	*/
+	ResultEncryptionOverheadBytes = ResultEncryptionSaltBytes + 12 + 16
+	// MinEncryptResultMaxLength is the smallest length budget that leaves room for at least 8 bytes of command result.
+	MinEncryptResultMaxLength = ((ResultEncryptionOverheadBytes+8)*4 + 2) / 3
+)
+
+// ErrMalformedEncryptedResult is a decryption error indicating that the text is not an encrypted command result.
+var ErrMalformedEncryptedResult = errors.New("the text is not an encrypted command result")
+
+/*
+resultEncryptionKey is the password that authenticated a command, each encrypted result derives its AES-256 key from the
+password and a random salt. It is always referred to by pointer, so that formatting a command or result for logging does
+not print the password.
+*/
+type resultEncryptionKey struct {
+	password []byte
+}
+
+// newResultEncryptionKey returns the key material of the password for encrypting command results.
+func newResultEncryptionKey(password string) *resultEncryptionKey {
+	return &resultEncryptionKey{password: []byte(password)}
+}
+
+/*
+principal returns an identifier of the password that authorised the command, without revealing the password itself. It
+returns an empty string if the command was not authorised by a password.
+*/
+func (key *resultEncryptionKey) principal() string {
+	if key == nil {
+		return ""
+	}
+	sum := sha256.Sum256(key.password)
+	return hex.EncodeToString(sum[:])
+}
+
+// newGCM returns the AES-GCM cipher of the key derived from the password and salt.
+func (key *resultEncryptionKey) newGCM(salt []byte) (cipher.AEAD, error) {
+	keyCipher, err := aes.NewCipher(misc.PBKDF2SHA256(key.password, salt, ResultEncryptionKDFIterations, 32))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(keyCipher)
+}
+
+/*
+EncryptResultText encrypts the text using AES-GCM with a key derived from the password and a random salt using PBKDF2,
+and returns the salt and cipher text encoded in URL-safe base64 alphabet. If maxLength is greater than 0, the text will be truncated so that the encoded
+cipher text does not exceed maxLength characters.
+*/
+func EncryptResultText(password, text string, maxLength int) (string, error) {
+	return encryptResultText(newResultEncryptionKey(password), text, maxLength)
+}
+
+// encryptResultText encrypts the text using the key, it works the same way as EncryptResultText.
+func encryptResultText(key *resultEncryptionKey, text string, maxLength int) (string, error) {
+	if maxLength > 0 {
+		maxTextLen := maxLength*3/4 - ResultEncryptionOverheadBytes
+		if maxTextLen < 0 {
+			return "", fmt.Errorf("length budget of encrypted result must be at least %d", MinEncryptResultMaxLength)
+		}
+		// Do not split a multi-byte character, the decrypted text must remain valid UTF-8.
+		text = lalog.TruncateUTF8(text, maxTextLen)
+	}
+	salt := make([]byte, ResultEncryptionSaltBytes)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	gcm, err := key.newGCM(salt)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	// The encrypted result is made of salt, nonce, and then the sealed text.
+	sealed := gcm.Seal(append(salt, nonce...), nonce, []byte(text), nil)
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptResultText decrypts a command result encrypted by EncryptResultText.
+func DecryptResultText(password, encoded string) (string, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(sealed) < ResultEncryptionOverheadBytes {
+		return "", ErrMalformedEncryptedResult
+	}
+	salt, sealed := sealed[:ResultEncryptionSaltBytes], sealed[ResultEncryptionSaltBytes:]
+	gcm, err := newResultEncryptionKey(password).newGCM(salt)
+	if err != nil {
+		return "", err
+	}
+	nonceSize := gcm.NonceSize()
+	text, err := gcm.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt, the password may be incorrect - %v", err)
+	}
+	return string(text), nil
+}
+
+/*
+EncryptResult encrypts the combined output of a command result using the password that authenticated the command, so
+that intermediate hops of the communication channel cannot read it. The result of a command that was not authenticated
+by a password (e.g. a shortcut), or that failed to authenticate, is left alone.
+Place this filter after LintText and SayEmptyOutput, so that the cipher text is not altered afterwards.
+*/
+type EncryptResult struct {
+	Enabled bool `json:"Enabled"`
+	// MaxLength is the maximum length of the encoded cipher text, the command result is truncated to fit in. 0 means unlimited.
+	MaxLength int `json:"MaxLength"`
+
+	logger lalog.Logger
+}
+
+func (enc *EncryptResult) Transform(result *Result) error {
+	if !enc.Enabled || result.resultKey == nil {
+		return nil
+	}
+	encrypted, err := encryptResultText(result.resultKey, result.CombinedOutput, enc.MaxLength)
+	if err != nil {
+		// Never reveal the plain text result
+		enc.logger.Warning("Transform", "", err, "failed to encrypt result of command \"%s\"", result.Command.Content)
+		result.CombinedOutput = "failed to encrypt result"
+		result.encrypted = true
+		return nil
+	}
+	result.CombinedOutput = encrypted
+	result.encrypted = true
+	return nil
+}
+
+func (enc *EncryptResult) SetLogger(logger lalog.Logger) {
+	enc.logger = logger
+}
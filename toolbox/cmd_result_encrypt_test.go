@@ -0,0 +1,132 @@
+package toolbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"regexp"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestEncryptResultText(t *testing.T) {
+	encrypted, err := EncryptResultText("pass", "hello world", 0)
+	if err != nil || !regexp.MustCompile(`^[a-zA-Z0-9_-]+$`).MatchString(encrypted) || strings.Contains(encrypted, "hello") {
+		t.Fatal(encrypted, err)
+	}
+	// The same text encrypts differently each time, using a different salt.
+	encrypted2, err := EncryptResultText("pass", "hello world", 0)
+	if err != nil || encrypted2 == encrypted {
+		t.Fatal(encrypted2, err)
+	}
+	sealed, _ := base64.RawURLEncoding.DecodeString(encrypted)
+	sealed2, _ := base64.RawURLEncoding.DecodeString(encrypted2)
+	if len(sealed) != ResultEncryptionOverheadBytes+len("hello world") || bytes.Equal(sealed[:ResultEncryptionSaltBytes], sealed2[:ResultEncryptionSaltBytes]) {
+		t.Fatal(sealed, sealed2)
+	}
+	if text, err := DecryptResultText("pass", encrypted); err != nil || text != "hello world" {
+		t.Fatal(text, err)
+	}
+	if _, err := DecryptResultText("wrong pass", encrypted); err == nil {
+		t.Fatal("did not error")
+	}
+	tampered := []byte(encrypted)
+	if tampered[len(tampered)/2] == 'A' {
+		tampered[len(tampered)/2] = 'B'
+	} else {
+		tampered[len(tampered)/2] = 'A'
+	}
+	if _, err := DecryptResultText("pass", string(tampered)); err == nil {
+		t.Fatal("did not error")
+	}
+	if _, err := DecryptResultText("pass", "abc"); err != ErrMalformedEncryptedResult {
+		t.Fatal(err)
+	}
+	if _, err := DecryptResultText("pass", "!@#$"); err != ErrMalformedEncryptedResult {
+		t.Fatal(err)
+	}
+	// The encoded text fits in the length budget
+	for _, maxLength := range []int{MinEncryptResultMaxLength, 100, 160, 161, 162, 163} {
+		encrypted, err := EncryptResultText("pass", strings.Repeat("a", 1000), maxLength)
+		if err != nil || len(encrypted) > maxLength || len(encrypted) < maxLength-3 {
+			t.Fatal(maxLength, len(encrypted), err)
+		}
+		if text, err := DecryptResultText("pass", encrypted); err != nil || text != strings.Repeat("a", len(text)) || len(text) < 8 {
+			t.Fatal(text, err)
+		}
+	}
+	// A multi-byte character is not split by the length budget
+	for _, maxLength := range []int{100, 101, 102, 103} {
+		encrypted, err := EncryptResultText("pass", strings.Repeat("é", 1000), maxLength)
+		if err != nil || len(encrypted) > maxLength {
+			t.Fatal(maxLength, len(encrypted), err)
+		}
+		if text, err := DecryptResultText("pass", encrypted); err != nil || !utf8.ValidString(text) || text != strings.Repeat("é", len(text)/2) {
+			t.Fatal(text, err)
+		}
+	}
+	if _, err := EncryptResultText("pass", "a", 10); err == nil {
+		t.Fatal("did not error")
+	}
+}
+
+func TestEncryptResult_Transform(t *testing.T) {
+	enc := &EncryptResult{MaxLength: 100}
+	// Disabled filter does nothing
+	result := &Result{resultKey: newResultEncryptionKey("pass"), CombinedOutput: "hello"}
+	if err := enc.Transform(result); err != nil || result.CombinedOutput != "hello" {
+		t.Fatal(result, err)
+	}
+	// Command not authorised by password is left alone
+	enc.Enabled = true
+	result = &Result{CombinedOutput: "hello"}
+	if err := enc.Transform(result); err != nil || result.CombinedOutput != "hello" {
+		t.Fatal(result, err)
+	}
+	result = &Result{resultKey: newResultEncryptionKey("pass"), CombinedOutput: "hello"}
+	if err := enc.Transform(result); err != nil || result.CombinedOutput == "hello" {
+		t.Fatal(result, err)
+	}
+	if text, err := DecryptResultText("pass", result.CombinedOutput); err != nil || text != "hello" {
+		t.Fatal(text, err)
+	}
+	// Failure to encrypt must not reveal the result
+	enc.MaxLength = 10
+	result = &Result{resultKey: newResultEncryptionKey("pass"), CombinedOutput: "hello"}
+	if err := enc.Transform(result); err != nil || strings.Contains(result.CombinedOutput, "hello") {
+		t.Fatal(result, err)
+	}
+}
+
+func TestCommandProcessor_EncryptResult(t *testing.T) {
+	proc := GetTestCommandProcessor()
+	proc.CommandFilters[0] = &PINAndShortcuts{
+		Passwords: []string{TestCommandProcessorPIN},
+		Shortcuts: map[string]string{"hi": ".s echo hi"},
+	}
+	proc.ResultFilters = append(proc.ResultFilters, &EncryptResult{Enabled: true, MaxLength: 60})
+	if errs := proc.IsSaneForInternet(); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	// Result of password-authorised command is encrypted
+	result := proc.Process(context.Background(), Command{Content: TestCommandProcessorPIN + " .s echo hello", TimeoutSec: 10}, true)
+	if result.Error != nil || len(result.CombinedOutput) > 60 || strings.Contains(result.CombinedOutput, "hello") {
+		t.Fatalf("%+v", result)
+	}
+	if text, err := DecryptResultText(TestCommandProcessorPIN, result.CombinedOutput); err != nil || text != "hello" {
+		t.Fatal(text, err)
+	}
+	// Result of shortcut and bad password is not encrypted
+	if result := proc.Process(context.Background(), Command{Content: "hi", TimeoutSec: 10}, true); result.CombinedOutput != "hi" {
+		t.Fatalf("%+v", result)
+	}
+	if result := proc.Process(context.Background(), Command{Content: "badpin .s echo hello", TimeoutSec: 10}, true); result.CombinedOutput != ErrPINAndShortcutNotFound.Error() {
+		t.Fatalf("%+v", result)
+	}
+	// The length budget must leave room for the result
+	proc.ResultFilters[len(proc.ResultFilters)-1].(*EncryptResult).MaxLength = 20
+	if errs := proc.IsSaneForInternet(); len(errs) != 1 {
+		t.Fatal(errs)
+	}
+}
@@ -0,0 +1,93 @@
+package toolbox
+
+import (
+	"encoding/json"
+	"errors"
+	"os/exec"
+	"strings"
+)
+
+/*
+PrefixResultEnvelope is the magic string to prefix command input (ahead of password PIN), in order to ask the daemon to
+respond with a JSON ResultEnvelope instead of the human readable combined output.
+*/
+const PrefixResultEnvelope = ".json"
+
+/*
+ResultEnvelope is a machine-readable rendition of a command result, it is meant for scripts and API clients that would
+otherwise have to parse the human readable combined output.
+*/
+type ResultEnvelope struct {
+	// Trigger is the app trigger matched by the command, triggers of a command chain are separated by comma.
+	Trigger string `json:"Trigger"`
+	// ExitStatus is 0 if the app command succeeded, the exit status of the external program if it failed, or 1 otherwise.
+	ExitStatus int `json:"ExitStatus"`
+	// Error is the error text, it is empty if the app command succeeded.
+	Error string `json:"Error"`
+	// Output is the complete app output prior to LintText, it excludes the error text.
+	Output string `json:"Output"`
+	// CombinedOutput is the error text and output altered by result filters, as would be seen by a human.
+	CombinedOutput string `json:"CombinedOutput"`
+	// Encrypted is true if the combined output has been encrypted, in which case the error text and output are left empty.
+	Encrypted bool `json:"Encrypted"`
+	// TruncatedBeginning is true if LintText cut characters from the beginning of the combined output.
+	TruncatedBeginning bool `json:"TruncatedBeginning"`
+	// TruncatedEnd is true if LintText cut characters from the end of the combined output.
+	TruncatedEnd bool `json:"TruncatedEnd"`
+	// DurationMS is the number of milliseconds spent on processing the command.
+	DurationMS int64 `json:"DurationMS"`
+	// PLTPosition, PLTLength, and PLTTimeoutSec are the PLT overrides, they are 0 if PLT was not used.
+	PLTPosition   int `json:"PLTPosition"`
+	PLTLength     int `json:"PLTLength"`
+	PLTTimeoutSec int `json:"PLTTimeoutSec"`
+}
+
+/*
+FindAndRemoveEnvelopePrefix removes the result envelope prefix from the command input. It returns the remaining command
+input, and true only if the prefix was found and removed.
+*/
+func FindAndRemoveEnvelopePrefix(content string) (string, bool) {
+	trimmed := strings.TrimSpace(content)
+	if !strings.HasPrefix(trimmed, PrefixResultEnvelope) {
+		return content, false
+	}
+	return strings.TrimSpace(strings.TrimPrefix(trimmed, PrefixResultEnvelope)), true
+}
+
+// Envelope returns a machine-readable rendition of the result.
+func (result *Result) Envelope() ResultEnvelope {
+	ret := ResultEnvelope{
+		Trigger:            result.trigger,
+		CombinedOutput:     result.CombinedOutput,
+		Encrypted:          result.encrypted,
+		TruncatedBeginning: result.truncatedBeginning,
+		TruncatedEnd:       result.truncatedEnd,
+		DurationMS:         result.durationMS,
+		PLTPosition:        result.pltPosition,
+		PLTLength:          result.pltLength,
+		PLTTimeoutSec:      result.pltTimeoutSec,
+	}
+	if result.Error != nil {
+		ret.ExitStatus = 1
+		var exitErr *exec.ExitError
+		if errors.As(result.Error, &exitErr) && exitErr.ExitCode() > 0 {
+			ret.ExitStatus = exitErr.ExitCode()
+		}
+	}
+	// Never reveal the plain text of an encrypted result
+	if !result.encrypted {
+		ret.Error = result.ErrText()
+		ret.Output = result.Output
+	}
+	return ret
+}
+
+// EnvelopeJSON returns the result envelope serialised into a single line of JSON text.
+func (result *Result) EnvelopeJSON() string {
+	serialised, err := json.Marshal(result.Envelope())
+	if err != nil {
+		// The envelope consists of only strings, integers, and booleans, this should not happen.
+		panic(err)
+	}
+	return string(serialised)
+}
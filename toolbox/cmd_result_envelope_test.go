@@ -0,0 +1,59 @@
+package toolbox
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestFindAndRemoveEnvelopePrefix(t *testing.T) {
+	if content, found := FindAndRemoveEnvelopePrefix("  .json pin .s echo hi"); !found || content != "pin .s echo hi" {
+		t.Fatal(content, found)
+	}
+	if content, found := FindAndRemoveEnvelopePrefix("pin .json"); found || content != "pin .json" {
+		t.Fatal(content, found)
+	}
+}
+
+func TestResult_Envelope(t *testing.T) {
+	proc := GetTestCommandProcessor()
+	// Successful command with truncated output
+	result := proc.Process(context.Background(), Command{Content: TestCommandProcessorPIN + ".s echo " + strings.Repeat("a", 100), TimeoutSec: 10}, true)
+	var envelope ResultEnvelope
+	if err := json.Unmarshal([]byte(result.EnvelopeJSON()), &envelope); err != nil {
+		t.Fatal(err)
+	}
+	if envelope.Trigger != ".s" || envelope.ExitStatus != 0 || envelope.Error != "" || envelope.Output != strings.Repeat("a", 100)+"\n" ||
+		envelope.CombinedOutput != result.CombinedOutput || len(envelope.CombinedOutput) != 35 || envelope.TruncatedBeginning || !envelope.TruncatedEnd ||
+		envelope.Encrypted || envelope.DurationMS < 0 || envelope.PLTPosition != 0 || envelope.PLTLength != 0 || envelope.PLTTimeoutSec != 0 {
+		t.Fatalf("%+v", envelope)
+	}
+	// The human readable output stays the same
+	if result.CombinedOutput != strings.Repeat("a", 35) {
+		t.Fatal(result.CombinedOutput)
+	}
+	// PLT overrides and exit status of external program
+	result = proc.Process(context.Background(), Command{Content: TestCommandProcessorPIN + ".plt 2, 3, 4 .s echo abcdef; exit 3", TimeoutSec: 10}, true)
+	envelope = result.Envelope()
+	if envelope.ExitStatus != 3 || envelope.Error != "exit status 3" || envelope.Output != "abcdef\n" || envelope.CombinedOutput != "it " ||
+		!envelope.TruncatedBeginning || !envelope.TruncatedEnd || envelope.PLTPosition != 2 || envelope.PLTLength != 3 || envelope.PLTTimeoutSec != 4 {
+		t.Fatalf("%+v", envelope)
+	}
+	// Command chain
+	result = proc.Process(context.Background(), Command{Content: TestCommandProcessorPIN + ".chain .s echo a .then .s echo b", TimeoutSec: 10}, true)
+	if envelope = result.Envelope(); envelope.Trigger != ".s,.s" || envelope.ExitStatus != 0 {
+		t.Fatalf("%+v", envelope)
+	}
+	// Failure that is not related to an external program
+	result = proc.Process(context.Background(), Command{Content: "badpin .s echo a", TimeoutSec: 10}, true)
+	if envelope = result.Envelope(); envelope.Trigger != "" || envelope.ExitStatus != 1 || envelope.Error != ErrPINAndShortcutNotFound.Error() {
+		t.Fatalf("%+v", envelope)
+	}
+	// Encrypted result does not reveal the plain text
+	proc.ResultFilters = append(proc.ResultFilters, &EncryptResult{Enabled: true})
+	result = proc.Process(context.Background(), Command{Content: TestCommandProcessorPIN + ".s echo hello", TimeoutSec: 10}, true)
+	if envelope = result.Envelope(); !envelope.Encrypted || envelope.Output != "" || envelope.Error != "" || strings.Contains(envelope.CombinedOutput, "hello") {
+		t.Fatalf("%+v", envelope)
+	}
+}
@@ -70,6 +70,7 @@ func (lint *LintText) Transform(result *Result) error {
 	}
 	// Cut leading characters
 	if lint.BeginPosition > 0 {
+		result.truncatedBeginning = ret != ""
 		if len(ret) > lint.BeginPosition {
 			ret = ret[lint.BeginPosition:]
 		} else {
@@ -80,6 +81,7 @@ func (lint *LintText) Transform(result *Result) error {
 	if lint.MaxLength > 0 {
 		if len(ret) > lint.MaxLength {
 			ret = ret[0:lint.MaxLength]
+			result.truncatedEnd = true
 		}
 	}
 	result.CombinedOutput = ret
@@ -103,17 +105,25 @@ func (notify *NotifyViaEmail) IsConfigured() bool {
 }
 
 func (notify *NotifyViaEmail) Transform(result *Result) error {
-	if notify.IsConfigured() && result.Error != ErrPINAndShortcutNotFound {
-		go func() {
-			subject := inet.OutgoingMailSubjectKeyword + "-notify-" + result.Command.Content
-			if err := notify.MailClient.Send(subject, result.CombinedOutput, notify.Recipients...); err != nil {
-				notify.logger.Warning("Transform", "", err, "failed to send notification for command \"%s\"", result.Command.Content)
-			}
-		}()
+	if result.Error != ErrPINAndShortcutNotFound && result.Error != ErrClientLockedOut {
+		notify.sendAsync(result.Command.Content, result.CombinedOutput)
 	}
 	return nil
 }
 
+// sendAsync sends a notification email in the background, if the filter is configured.
+func (notify *NotifyViaEmail) sendAsync(subjectSuffix, body string) {
+	if !notify.IsConfigured() {
+		return
+	}
+	go func() {
+		subject := inet.OutgoingMailSubjectKeyword + "-notify-" + subjectSuffix
+		if err := notify.MailClient.Send(subject, body, notify.Recipients...); err != nil {
+			notify.logger.Warning("Transform", "", err, "failed to send notification for \"%s\"", subjectSuffix)
+		}
+	}()
+}
+
 func (notify *NotifyViaEmail) SetLogger(logger lalog.Logger) {
 	notify.logger = logger
 }
@@ -0,0 +1,142 @@
+package toolbox
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HouzuoGuo/laitos/lalog"
+	"github.com/HouzuoGuo/laitos/misc"
+)
+
+const (
+	// SignedCommandNonceLen is the number of characters of the random nonce in a command signature.
+	SignedCommandNonceLen = 8
+	// SignedCommandNonceChars are the characters that make up a nonce.
+	SignedCommandNonceChars = "0123456789abcdefghijklmnopqrstuvwxyz"
+	// SignedCommandMACBytes is the number of leading bytes from HMAC-SHA256 to keep in a command signature.
+	SignedCommandMACBytes = 16
+	// DefaultSignedCommandMaxClockSkewSec is the default maximum difference in seconds between the signature timestamp and the server clock.
+	DefaultSignedCommandMaxClockSkewSec = 5 * 60
+	// SignedCommandReplayCacheSize is the maximum number of recently used nonces to remember for each password.
+	SignedCommandReplayCacheSize = 1000
+)
+
+/*
+RegexSignedCommand matches a signed app command. The signature made of a 10 digits unix timestamp, a nonce, and a
+truncated HMAC in hex, it comes right before the app command.
+*/
+var RegexSignedCommand = regexp.MustCompile(`^\s*([0-9]{10})([0-9a-z]{` + strconv.Itoa(SignedCommandNonceLen) + `})([0-9a-f]{` + strconv.Itoa(SignedCommandMACBytes*2) + `})((?s:.*))$`)
+
+var ErrSignedCommandExpired = errors.New("the command signature has expired or its timestamp is in the future")
+var ErrSignedCommandReplayed = errors.New("the command signature has already been used")
+
+/*
+signedCommandNonces is a mapping between a password and the timestamp+nonce combinations that have recently been used
+in command signatures made with the password. It is shared among all daemons, so that a signed command intercepted on
+one communication channel cannot be replayed on another.
+*/
+var signedCommandNonces = map[string]*lalog.LeastRecentlyUsedBuffer{}
+var signedCommandNoncesMutex = new(sync.Mutex)
+
+// useSignedCommandNonce returns true only if the timestamp+nonce combination has not yet been used with the password.
+func useSignedCommandNonce(password, timestampAndNonce string) bool {
+	signedCommandNoncesMutex.Lock()
+	defer signedCommandNoncesMutex.Unlock()
+	nonces, exists := signedCommandNonces[password]
+	if !exists {
+		nonces = lalog.NewLeastRecentlyUsedBuffer(SignedCommandReplayCacheSize)
+		signedCommandNonces[password] = nonces
+	}
+	alreadyPresent, _ := nonces.Add(timestampAndNonce)
+	return !alreadyPresent
+}
+
+// calculateCommandMAC returns the truncated HMAC of the signature timestamp, nonce, and app command, in hex.
+func calculateCommandMAC(password, timestamp, nonce, content string) string {
+	mac := hmac.New(sha256.New, []byte(password))
+	_, _ = mac.Write([]byte(timestamp + nonce + content))
+	return hex.EncodeToString(mac.Sum(nil)[:SignedCommandMACBytes])
+}
+
+/*
+SignCommand returns the app command prefixed with a signature made by the password. The signed command may be used in
+place of a password-prefixed command, as long as the daemon receiving it uses SignedCommand filter.
+*/
+func SignCommand(password, content string, now time.Time) (string, error) {
+	nonce := make([]byte, SignedCommandNonceLen)
+	for i := range nonce {
+		randNum, err := rand.Int(rand.Reader, big.NewInt(int64(len(SignedCommandNonceChars))))
+		if err != nil {
+			return "", err
+		}
+		nonce[i] = SignedCommandNonceChars[randNum.Int64()]
+	}
+	timestamp := fmt.Sprintf("%010d", now.Unix())
+	content = strings.TrimSpace(content)
+	return timestamp + string(nonce) + calculateCommandMAC(password, timestamp, string(nonce), content) + content, nil
+}
+
+/*
+SignedCommand authenticates app commands signed by SignCommand, using the passwords of PINAndShortcuts filter. Unlike a
+password PIN or TOTP, each signature may only be used once, and only for the app command that it was made for.
+The filter leaves alone the commands that are not signed, or not signed by a known password, for PINAndShortcuts
+filter to look at.
+The nonces of used signatures are only remembered in memory, therefore the signatures made before the program started
+are not accepted, so that a program restart does not allow the signatures used earlier to be replayed.
+*/
+type SignedCommand struct {
+	Enabled bool `json:"Enabled"`
+	// MaxClockSkewSec is the maximum difference in seconds between the signature timestamp and the server clock.
+	MaxClockSkewSec int `json:"MaxClockSkewSec"`
+	// RequireSignature stops PINAndShortcuts from accepting password and TOTP, only signed commands and shortcuts are accepted.
+	RequireSignature bool `json:"RequireSignature"`
+
+	// PINAndShortcuts provides the passwords that sign app commands, as well as their permission scopes.
+	PINAndShortcuts *PINAndShortcuts `json:"-"`
+}
+
+func (sig *SignedCommand) Transform(cmd Command) (Command, error) {
+	if !sig.Enabled || sig.PINAndShortcuts == nil {
+		return cmd, nil
+	}
+	// Unless the command is properly signed, PINAndShortcuts shall refuse its password.
+	cmd.signatureRequired = sig.RequireSignature
+	match := RegexSignedCommand.FindStringSubmatch(cmd.Content)
+	if match == nil {
+		return cmd, nil
+	}
+	timestamp, nonce, mac, content := match[1], match[2], match[3], strings.TrimSpace(match[4])
+	for _, password := range sig.PINAndShortcuts.Passwords {
+		if !hmac.Equal([]byte(calculateCommandMAC(password, timestamp, nonce, content)), []byte(mac)) {
+			continue
+		}
+		maxSkew := sig.MaxClockSkewSec
+		if maxSkew < 1 {
+			maxSkew = DefaultSignedCommandMaxClockSkewSec
+		}
+		unixSec, _ := strconv.ParseInt(timestamp, 10, 64)
+		if skew := time.Now().Unix() - unixSec; skew > int64(maxSkew) || skew < -int64(maxSkew) || unixSec < misc.StartupTime.Unix() {
+			return cmd, ErrSignedCommandExpired
+		}
+		if !useSignedCommandNonce(password, timestamp+nonce) {
+			return cmd, ErrSignedCommandReplayed
+		}
+		ret := cmd
+		ret.Content = content
+		ret.scope = sig.PINAndShortcuts.getScope(password)
+		ret.resultKey = newResultEncryptionKey(password)
+		ret.authenticated = true
+		return sig.PINAndShortcuts.expandMacroAfterPassword(ret)
+	}
+	return cmd, nil
+}
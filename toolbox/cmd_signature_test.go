@@ -0,0 +1,140 @@
+package toolbox
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HouzuoGuo/laitos/misc"
+)
+
+func TestSignCommand(t *testing.T) {
+	signed, err := SignCommand("pass", "  .s echo hi  ", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	match := RegexSignedCommand.FindStringSubmatch(signed)
+	if match == nil || match[4] != ".s echo hi" {
+		t.Fatal(signed)
+	}
+	// Each signature comes with a different nonce
+	if signed2, err := SignCommand("pass", ".s echo hi", time.Now()); err != nil || signed2 == signed {
+		t.Fatal(signed2, err)
+	}
+}
+
+func TestSignedCommand_Transform(t *testing.T) {
+	pin := &PINAndShortcuts{
+		Passwords:      []string{"pass1", "pass2"},
+		PasswordScopes: map[string]PasswordScope{"pass2": {Triggers: []string{".e"}}},
+		Macros:         map[string]string{"greet": ".s echo hello $1"},
+	}
+	sig := &SignedCommand{PINAndShortcuts: pin}
+	signed, err := SignCommand("pass1", ".s echo hi", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Disabled filter leaves the command alone
+	if cmd, err := sig.Transform(Command{Content: signed}); err != nil || cmd.Content != signed || cmd.authenticated {
+		t.Fatal(cmd, err)
+	}
+	sig.Enabled = true
+	// Unsigned command is left alone
+	if cmd, err := sig.Transform(Command{Content: "pass1 .s echo hi"}); err != nil || cmd.Content != "pass1 .s echo hi" || cmd.authenticated {
+		t.Fatal(cmd, err)
+	}
+	// Signed command is verified
+	cmd, err := sig.Transform(Command{Content: signed})
+	if err != nil || cmd.Content != ".s echo hi" || !cmd.authenticated || cmd.scope != nil || cmd.resultKey == nil {
+		t.Fatal(cmd, err)
+	}
+	// PINAndShortcuts lets the verified command through
+	if cmd, err := pin.Transform(cmd); err != nil || cmd.Content != ".s echo hi" {
+		t.Fatal(cmd, err)
+	}
+	// The signature cannot be used again
+	if _, err := sig.Transform(Command{Content: signed}); err != ErrSignedCommandReplayed {
+		t.Fatal(err)
+	}
+	// The signature does not work for a different command, nor can it be made by an unknown password.
+	tampered := strings.Replace(signed, "echo hi", "echo ha", 1)
+	if cmd, err := sig.Transform(Command{Content: tampered}); err != nil || cmd.authenticated {
+		t.Fatal(cmd, err)
+	}
+	if _, err := pin.Transform(Command{Content: tampered}); err != ErrPINAndShortcutNotFound {
+		t.Fatal(err)
+	}
+	signed, _ = SignCommand("pass3", ".s echo hi", time.Now())
+	if cmd, err := sig.Transform(Command{Content: signed}); err != nil || cmd.authenticated {
+		t.Fatal(cmd, err)
+	}
+	// Expired signature and signature from the future
+	for _, signTime := range []time.Time{time.Now().Add(-10 * time.Minute), time.Now().Add(10 * time.Minute)} {
+		signed, _ = SignCommand("pass1", ".s echo hi", signTime)
+		if _, err := sig.Transform(Command{Content: signed}); err != ErrSignedCommandExpired {
+			t.Fatal(err)
+		}
+	}
+	sig.MaxClockSkewSec = 3600
+	if _, err := sig.Transform(Command{Content: signed}); err != nil {
+		t.Fatal(err)
+	}
+	// A signature made before the program started is not accepted, as the nonces used earlier are forgotten.
+	signed, _ = SignCommand("pass1", ".s echo hi", misc.StartupTime.Add(-time.Minute))
+	if _, err := sig.Transform(Command{Content: signed}); err != ErrSignedCommandExpired {
+		t.Fatal(err)
+	}
+	// Password scope and macros apply to signed command too
+	signed, _ = SignCommand("pass2", "greet world", time.Now())
+	if cmd, err := sig.Transform(Command{Content: signed}); err != nil || cmd.Content != ".s echo hello world" || cmd.scope == nil {
+		t.Fatal(cmd, err)
+	}
+}
+
+func TestCommandProcessor_SignedCommand(t *testing.T) {
+	proc := GetTestCommandProcessor()
+	pin := proc.CommandFilters[0].(*PINAndShortcuts)
+	proc.CommandFilters = append([]CommandFilter{&SignedCommand{Enabled: true}}, proc.CommandFilters...)
+	if errs := proc.IsSaneForInternet(); len(errs) != 1 {
+		t.Fatal(errs)
+	}
+	proc.CommandFilters[0].(*SignedCommand).PINAndShortcuts = pin
+	if errs := proc.IsSaneForInternet(); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	signed, err := SignCommand(TestCommandProcessorPIN, ".s echo hi", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result := proc.Process(context.Background(), Command{Content: signed, TimeoutSec: 10}, true); result.Error != nil || result.CombinedOutput != "hi" {
+		t.Fatalf("%+v", result)
+	}
+	if result := proc.Process(context.Background(), Command{Content: signed, TimeoutSec: 10}, true); result.Error != ErrSignedCommandReplayed {
+		t.Fatalf("%+v", result)
+	}
+	// Password PIN continues to work
+	if result := proc.Process(context.Background(), Command{Content: TestCommandProcessorPIN + ".s echo hi", TimeoutSec: 10}, true); result.Error != nil || result.CombinedOutput != "hi" {
+		t.Fatalf("%+v", result)
+	}
+	// Password PIN is refused if signature is required, the error is the same as an incorrect password.
+	sig := proc.CommandFilters[0].(*SignedCommand)
+	sig.RequireSignature = true
+	if result := proc.Process(context.Background(), Command{Content: TestCommandProcessorPIN + ".s echo hi", TimeoutSec: 10}, true); result.Error != ErrPINAndShortcutNotFound {
+		t.Fatalf("%+v", result)
+	}
+	signed, _ = SignCommand(TestCommandProcessorPIN, ".s echo hi", time.Now())
+	if result := proc.Process(context.Background(), Command{Content: signed, TimeoutSec: 10}, true); result.Error != nil || result.CombinedOutput != "hi" {
+		t.Fatalf("%+v", result)
+	}
+	sig.Enabled = false
+	if errs := proc.IsSaneForInternet(); len(errs) != 1 {
+		t.Fatal(errs)
+	}
+	sig.Enabled = true
+	// Signed subject report skips result filters just like the one authenticated by 2FA
+	signed, _ = SignCommand(TestCommandProcessorPIN, StoreAndForwardMessageProcessorTrigger+"{}", time.Now())
+	if !RegexSubjectReportUsingSignature.MatchString(signed) {
+		t.Fatal(signed)
+	}
+}
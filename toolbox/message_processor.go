@@ -244,7 +244,7 @@ func (proc *MessageProcessor) processCommandRequest(ctx context.Context, request
 			resp = AppCommandResponse{
 				Command:        prevCmd.Request.CommandRequest.Command,
 				ReceivedAt:     prevCmd.Request.ServerTime,
-				Result:         prevCmd.Result.CombinedOutput,
+				Result:         formatCommandResponseResult(prevCmd.Request.CommandRequest.Command, &prevCmd.Result),
 				RunDurationSec: prevCmd.RunDurationSec,
 			}
 		}
@@ -262,10 +262,12 @@ func (proc *MessageProcessor) processCommandRequest(ctx context.Context, request
 				"will not run a recursive store&forward command - %s", appCmd)
 			return
 		}
+		// The requester may ask for a machine-readable result
+		content, _ := FindAndRemoveEnvelopePrefix(appCmd)
 		cmd := Command{
 			DaemonName: daemonName,
 			ClientTag:  clientTag,
-			Content:    appCmd,
+			Content:    content,
 			TimeoutSec: CommandResponseRetentionSec,
 		}
 		proc.mutex.Lock()
@@ -291,7 +293,7 @@ func (proc *MessageProcessor) processCommandRequest(ctx context.Context, request
 		resp = AppCommandResponse{
 			Command:        appCmd,
 			ReceivedAt:     request.ServerTime,
-			Result:         result.CombinedOutput,
+			Result:         formatCommandResponseResult(appCmd, result),
 			RunDurationSec: int(durationSec),
 		}
 		proc.logger.Info("processCommandRequest", fmt.Sprintf("%s-%s", request.SubjectHostName, clientTag), result.Error, "command completed in %d seconds", durationSec)
@@ -299,6 +301,14 @@ func (proc *MessageProcessor) processCommandRequest(ctx context.Context, request
 	return
 }
 
+// formatCommandResponseResult returns the combined output of the result, or its JSON envelope if the app command asked for it.
+func formatCommandResponseResult(appCmd string, result *Result) string {
+	if _, wantEnvelope := FindAndRemoveEnvelopePrefix(appCmd); wantEnvelope {
+		return result.EnvelopeJSON()
+	}
+	return result.CombinedOutput
+}
+
 /*
 GetLatestReportsFromSubject returns the latest subject reports sent by the specified host name.
 The maximum number of reports to retrieve must be a positive integer.
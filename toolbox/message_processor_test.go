@@ -252,6 +252,27 @@ func TestMessageProcessor_processCommandRequest_QuickCommand(t *testing.T) {
 	}
 }
 
+func TestMessageProcessor_processCommandRequest_Envelope(t *testing.T) {
+	proc := &MessageProcessor{CmdProcessor: GetTestCommandProcessor(), MaxReportsPerHostName: 100}
+	if err := proc.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	// The subject asks for the result in a JSON envelope
+	cmd := PrefixResultEnvelope + TestCommandProcessorPIN + ".s echo 123"
+	for i := 0; i < 2; i++ {
+		// The memorised result of the same command is also enveloped
+		resp := proc.StoreReport(context.Background(), SubjectReportRequest{
+			SubjectHostName: "subject-host-name1",
+			CommandRequest:  AppCommandRequest{Command: cmd},
+		}, "ip", "daemon")
+		var envelope ResultEnvelope
+		if err := json.Unmarshal([]byte(resp.CommandResponse.Result), &envelope); err != nil || resp.CommandResponse.Command != cmd ||
+			envelope.Trigger != ".s" || envelope.Output != "123\n" || envelope.CombinedOutput != "123" {
+			t.Fatalf("%d: %+v", i, resp)
+		}
+	}
+}
+
 func TestMessageProcessor_processCommandRequest_RecursiveCommand(t *testing.T) {
 	proc := &MessageProcessor{CmdProcessor: GetTestCommandProcessor(), MaxReportsPerHostName: 100}
 	if err := proc.Initialise(); err != nil {
@@ -0,0 +1,210 @@
+package toolbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/HouzuoGuo/laitos/inet"
+)
+
+// TwilioAPIURL is the base URL of Twilio REST API.
+const TwilioAPIURL = "https://api.twilio.com"
+
+// ErrCallNotSupported is returned by telephony providers that can send SMS but cannot make calls.
+var ErrCallNotSupported = errors.New("the telephony provider does not support calls")
+
+/*
+TelephonyProvider sends SMS and makes voice calls via a telecommunication service, such as Twilio or an SMS gateway
+running on an Android phone.
+*/
+type TelephonyProvider interface {
+	// SendSMS sends the text message to the telephone number.
+	SendSMS(ctx context.Context, timeoutSec int, toNumber, text string) error
+	// MakeCall calls the telephone number and speaks the message.
+	MakeCall(ctx context.Context, timeoutSec int, toNumber, message string) error
+	// SelfTest validates the provider configuration and connectivity.
+	SelfTest() error
+}
+
+// TwilioTelephony sends SMS and makes calls via Twilio REST API.
+type TwilioTelephony struct {
+	PhoneNumber string // Twilio telephone country code and number (the number you purchased from Twilio)
+	AccountSID  string // Twilio account SID ("Account Settings - LIVE Credentials - Account SID")
+	AuthToken   string // Twilio authentication secret token ("Account Settings - LIVE Credentials - Auth Token")
+	APIURL      string // APIURL is the base URL of Twilio REST API, it defaults to TwilioAPIURL.
+}
+
+// IsConfigured returns true only if the number and API credentials are present.
+func (twi *TwilioTelephony) IsConfigured() bool {
+	return twi.PhoneNumber != "" && twi.AccountSID != "" && twi.AuthToken != ""
+}
+
+// getAPIURL returns the base URL of Twilio REST API.
+func (twi *TwilioTelephony) getAPIURL() string {
+	if twi.APIURL == "" {
+		return TwilioAPIURL
+	}
+	return strings.TrimSuffix(twi.APIURL, "/")
+}
+
+// post sends the form to the Twilio API resource of the account, e.g. "Messages.json".
+func (twi *TwilioTelephony) post(ctx context.Context, timeoutSec int, resource string, form url.Values) error {
+	resp, err := inet.DoHTTP(ctx, inet.HTTPRequest{
+		TimeoutSec: timeoutSec,
+		Method:     http.MethodPost,
+		Body:       strings.NewReader(form.Encode()),
+		RequestFunc: func(req *http.Request) error {
+			req.SetBasicAuth(twi.AccountSID, twi.AuthToken)
+			return nil
+		},
+	}, twi.getAPIURL()+"/2010-04-01/Accounts/%s/"+resource, twi.AccountSID)
+	if errResult := HTTPErrorToResult(resp, err); errResult != nil {
+		return errResult.Error
+	}
+	return nil
+}
+
+func (twi *TwilioTelephony) SendSMS(ctx context.Context, timeoutSec int, toNumber, text string) error {
+	return twi.post(ctx, timeoutSec, "Messages.json", url.Values{
+		"From": {twi.PhoneNumber},
+		"To":   {toNumber},
+		"Body": {text},
+	})
+}
+
+func (twi *TwilioTelephony) MakeCall(ctx context.Context, timeoutSec int, toNumber, message string) error {
+	return twi.post(ctx, timeoutSec, "Calls.json", url.Values{
+		"From": {twi.PhoneNumber},
+		"To":   {toNumber},
+		"Url": {"http://twimlets.com/message?Message=" + url.QueryEscape(fmt.Sprintf(`%s.
+
+repeat again.
+
+%s.
+
+repeat again.
+
+%s.
+over.`, message, message, message))},
+	})
+}
+
+func (twi *TwilioTelephony) SelfTest() error {
+	// Validate API credentials with a simple API call
+	resp, err := inet.DoHTTP(context.Background(), inet.HTTPRequest{
+		TimeoutSec: SelfTestTimeoutSec,
+		RequestFunc: func(req *http.Request) error {
+			req.SetBasicAuth(twi.AccountSID, twi.AuthToken)
+			return nil
+		},
+	}, twi.getAPIURL()+"/2010-04-01/Accounts/%s", twi.AccountSID)
+	if err != nil {
+		return fmt.Errorf("TwilioTelephony.SelfTest: API IO error - %v", err)
+	}
+	if err = resp.Non2xxToError(); err != nil {
+		return fmt.Errorf("TwilioTelephony.SelfTest: API response error - %v", err)
+	}
+	return nil
+}
+
+/*
+HTTPGatewayTelephony sends SMS and makes calls by posting a JSON object to an HTTP gateway, such as the self-hosted
+SMS gateway apps running on an Android phone. The JSON object carries the telephone number and the message under
+configurable keys.
+*/
+type HTTPGatewayTelephony struct {
+	SMSURL    string            `json:"SMSURL"`    // SMSURL receives the JSON object that sends an SMS.
+	CallURL   string            `json:"CallURL"`   // CallURL receives the JSON object that makes a call, leave empty if the gateway cannot make calls.
+	HealthURL string            `json:"HealthURL"` // HealthURL responds with a 2xx status when the gateway is healthy, it is optional.
+	Username  string            `json:"Username"`  // Username authenticates with the gateway via HTTP basic authentication, it is optional.
+	Password  string            `json:"Password"`  // Password authenticates with the gateway via HTTP basic authentication, it is optional.
+	Headers   map[string]string `json:"Headers"`   // Headers are the additional request headers, such as an API key.
+
+	NumberKey     string `json:"NumberKey"`     // NumberKey is the JSON key of telephone number, it defaults to "to".
+	NumberAsArray bool   `json:"NumberAsArray"` // NumberAsArray places the telephone number in an array, e.g. {"phoneNumbers": ["+123"]}.
+	MessageKey    string `json:"MessageKey"`    // MessageKey is the JSON key of message text, it defaults to "message".
+}
+
+// IsConfigured returns true only if the SMS URL is present.
+func (gw *HTTPGatewayTelephony) IsConfigured() bool {
+	return gw != nil && gw.SMSURL != ""
+}
+
+// post sends the telephone number and message in a JSON object to the URL.
+func (gw *HTTPGatewayTelephony) post(ctx context.Context, timeoutSec int, gatewayURL, toNumber, message string) error {
+	numberKey, messageKey := gw.NumberKey, gw.MessageKey
+	if numberKey == "" {
+		numberKey = "to"
+	}
+	if messageKey == "" {
+		messageKey = "message"
+	}
+	body := map[string]interface{}{numberKey: toNumber, messageKey: message}
+	if gw.NumberAsArray {
+		body[numberKey] = []string{toNumber}
+	}
+	serialised, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	resp, err := inet.DoHTTP(ctx, inet.HTTPRequest{
+		TimeoutSec:  timeoutSec,
+		Method:      http.MethodPost,
+		ContentType: "application/json",
+		Body:        strings.NewReader(string(serialised)),
+		RequestFunc: gw.authenticate,
+	}, urlTemplate(gatewayURL))
+	if errResult := HTTPErrorToResult(resp, err); errResult != nil {
+		return errResult.Error
+	}
+	return nil
+}
+
+// urlTemplate turns the URL into a template for DoHTTP, the template does not have placeholders.
+func urlTemplate(fullURL string) string {
+	return strings.ReplaceAll(fullURL, "%", "%%")
+}
+
+// authenticate places the basic authentication and additional headers into the request.
+func (gw *HTTPGatewayTelephony) authenticate(req *http.Request) error {
+	if gw.Username != "" || gw.Password != "" {
+		req.SetBasicAuth(gw.Username, gw.Password)
+	}
+	for name, value := range gw.Headers {
+		req.Header.Set(name, value)
+	}
+	return nil
+}
+
+func (gw *HTTPGatewayTelephony) SendSMS(ctx context.Context, timeoutSec int, toNumber, text string) error {
+	return gw.post(ctx, timeoutSec, gw.SMSURL, toNumber, text)
+}
+
+func (gw *HTTPGatewayTelephony) MakeCall(ctx context.Context, timeoutSec int, toNumber, message string) error {
+	if gw.CallURL == "" {
+		return ErrCallNotSupported
+	}
+	return gw.post(ctx, timeoutSec, gw.CallURL, toNumber, message)
+}
+
+func (gw *HTTPGatewayTelephony) SelfTest() error {
+	if gw.HealthURL == "" {
+		return nil
+	}
+	resp, err := inet.DoHTTP(context.Background(), inet.HTTPRequest{
+		TimeoutSec:  SelfTestTimeoutSec,
+		RequestFunc: gw.authenticate,
+	}, urlTemplate(gw.HealthURL))
+	if err != nil {
+		return fmt.Errorf("HTTPGatewayTelephony.SelfTest: IO error - %v", err)
+	}
+	if err = resp.Non2xxToError(); err != nil {
+		return fmt.Errorf("HTTPGatewayTelephony.SelfTest: response error - %v", err)
+	}
+	return nil
+}
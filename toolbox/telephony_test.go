@@ -0,0 +1,154 @@
+package toolbox
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestTwilioTelephony(t *testing.T) {
+	var lastPath string
+	var lastForm map[string][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); user != "sid" || pass != "token" {
+			http.Error(w, "unauthorised", http.StatusUnauthorized)
+			return
+		}
+		_ = r.ParseForm()
+		lastPath, lastForm = r.URL.Path, r.PostForm
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	twi := &TwilioTelephony{PhoneNumber: "+100", AccountSID: "sid", AuthToken: "token", APIURL: server.URL + "/"}
+	if !twi.IsConfigured() {
+		t.Fatal("not configured")
+	}
+	if err := twi.SelfTest(); err != nil || lastPath != "/2010-04-01/Accounts/sid" {
+		t.Fatal(err, lastPath)
+	}
+	if err := twi.SendSMS(context.Background(), 3, "+200", "hi there"); err != nil {
+		t.Fatal(err)
+	}
+	if lastPath != "/2010-04-01/Accounts/sid/Messages.json" || lastForm["From"][0] != "+100" || lastForm["To"][0] != "+200" || lastForm["Body"][0] != "hi there" {
+		t.Fatal(lastPath, lastForm)
+	}
+	if err := twi.MakeCall(context.Background(), 3, "+200", "hi there"); err != nil {
+		t.Fatal(err)
+	}
+	if lastPath != "/2010-04-01/Accounts/sid/Calls.json" || lastForm["To"][0] != "+200" || len(lastForm["Url"]) != 1 {
+		t.Fatal(lastPath, lastForm)
+	}
+	// Bad credentials
+	twi.AuthToken = "bad"
+	if err := twi.SelfTest(); err == nil {
+		t.Fatal("did not error")
+	}
+	if err := twi.SendSMS(context.Background(), 3, "+200", "hi there"); err == nil {
+		t.Fatal("did not error")
+	}
+}
+
+func TestHTTPGatewayTelephony(t *testing.T) {
+	received := make(chan map[string]interface{}, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); user != "user" || pass != "pass" || r.Header.Get("X-Api-Key") != "key" {
+			http.Error(w, "unauthorised", http.StatusUnauthorized)
+			return
+		}
+		if r.Method == http.MethodGet {
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		var obj map[string]interface{}
+		if err := json.Unmarshal(body, &obj); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		obj["path"] = r.URL.Path
+		received <- obj
+	}))
+	defer server.Close()
+
+	var gw *HTTPGatewayTelephony
+	if gw.IsConfigured() {
+		t.Fatal("should not be configured")
+	}
+	gw = &HTTPGatewayTelephony{
+		SMSURL:    server.URL + "/sms",
+		HealthURL: server.URL + "/health",
+		Username:  "user",
+		Password:  "pass",
+		Headers:   map[string]string{"X-Api-Key": "key"},
+	}
+	if !gw.IsConfigured() {
+		t.Fatal("not configured")
+	}
+	if err := gw.SelfTest(); err != nil {
+		t.Fatal(err)
+	}
+	// Default JSON keys
+	if err := gw.SendSMS(context.Background(), 3, "+200", "hi there"); err != nil {
+		t.Fatal(err)
+	}
+	if obj := <-received; obj["path"] != "/sms" || obj["to"] != "+200" || obj["message"] != "hi there" {
+		t.Fatal(obj)
+	}
+	// The gateway cannot make calls without a call URL
+	if err := gw.MakeCall(context.Background(), 3, "+200", "hi there"); err != ErrCallNotSupported {
+		t.Fatal(err)
+	}
+	// Custom JSON keys and number in an array
+	gw.CallURL = server.URL + "/call"
+	gw.NumberKey = "phoneNumbers"
+	gw.NumberAsArray = true
+	gw.MessageKey = "text"
+	if err := gw.MakeCall(context.Background(), 3, "+200", "hi there"); err != nil {
+		t.Fatal(err)
+	}
+	if obj := <-received; obj["path"] != "/call" || obj["text"] != "hi there" || len(obj["phoneNumbers"].([]interface{})) != 1 || obj["phoneNumbers"].([]interface{})[0] != "+200" {
+		t.Fatal(obj)
+	}
+	// Bad credentials
+	gw.Password = "bad"
+	if err := gw.SelfTest(); err == nil {
+		t.Fatal("did not error")
+	}
+	if err := gw.SendSMS(context.Background(), 3, "+200", "hi there"); err == nil {
+		t.Fatal("did not error")
+	}
+	gw.Password = "pass"
+
+	// The app uses the gateway in preference to Twilio
+	app := &Twilio{PhoneNumber: "+100", AccountSID: "sid", AuthToken: "token", HTTPGateway: gw}
+	if !app.IsConfigured() {
+		t.Fatal("not configured")
+	}
+	if err := app.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	if _, isGateway := app.GetProvider().(*HTTPGatewayTelephony); !isGateway {
+		t.Fatalf("%+v", app.GetProvider())
+	}
+	if err := app.SelfTest(); err != nil {
+		t.Fatal(err)
+	}
+	message := "laitos gateway test"
+	if ret := app.Execute(context.Background(), Command{TimeoutSec: 3, Content: TwilioSendSMS + "+200," + message}); ret.Error != nil || ret.Output != strconv.Itoa(len("+200")+len(message)) {
+		t.Fatal(ret)
+	}
+	if obj := <-received; obj["path"] != "/sms" || obj["text"] != message {
+		t.Fatal(obj)
+	}
+	// The SMS result sink uses the gateway too
+	if err := getScheduleResultSink(TwilioSMSSinkName)("+200", "title", "from the sink"); err != nil {
+		t.Fatal(err)
+	}
+	if obj := <-received; obj["text"] != "from the sink" {
+		t.Fatal(obj)
+	}
+}